@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"quota-manager/internal/auth"
 	"quota-manager/internal/config"
 	"quota-manager/internal/database"
 	"quota-manager/internal/handlers"
@@ -133,12 +134,21 @@ func main() {
 	quotaCheckPermissionService := services.NewQuotaCheckPermissionService(db, &cfg.AiGateway, &cfg.EmployeeSync, gateway)
 	unifiedPermissionService := services.NewUnifiedPermissionService(permissionService, starCheckPermissionService, quotaCheckPermissionService, nil) // employeeSyncService will be set later
 	employeeSyncService := services.NewEmployeeSyncService(db, configManager, permissionService, starCheckPermissionService, quotaCheckPermissionService)
+	employeeSyncService.SetStrategyService(strategyService)
 
 	// Update unified permission service with employee sync service
 	unifiedPermissionService = services.NewUnifiedPermissionService(permissionService, starCheckPermissionService, quotaCheckPermissionService, employeeSyncService)
 
 	schedulerService := services.NewSchedulerService(quotaService, strategyService, employeeSyncService, cfg)
 
+	// Elect the instance running scheduled jobs, every instance serves the API
+	leaderElector := services.NewLeaderElector(db, &cfg.Scheduler)
+	leaderElector.Start()
+	defer leaderElector.Stop()
+	schedulerService.SetLeaderElector(leaderElector)
+	scanJobService := services.NewScanJobService(db, leaderElector.InstanceID())
+	schedulerService.SetScanJobService(scanJobService)
+
 	// Start scheduler service (includes strategy scan and employee sync)
 	if err := schedulerService.Start(); err != nil {
 		logger.Error("Failed to start scheduler service", zap.Error(err))
@@ -152,9 +162,19 @@ func main() {
 		// Log error but don't exit, let the service continue running
 	}
 
+	// Initialize JWT verifier for user tokens
+	tokenVerifier, err := auth.NewVerifier(&cfg.Server.Auth)
+	if err != nil {
+		logger.Error("Failed to initialize token verifier", zap.Error(err))
+		os.Exit(1)
+	}
+
+	apiKeyService := services.NewAPIKeyService(db)
+	authorizer := auth.NewAuthorizer(tokenVerifier, apiKeyService, &cfg.Server)
+
 	// Initialize HTTP handlers
 	strategyHandler := handlers.NewStrategyHandler(strategyService)
-	quotaHandler := handlers.NewQuotaHandler(quotaService, &cfg.Server)
+	quotaHandler := handlers.NewQuotaHandler(quotaService, &cfg.Server, tokenVerifier)
 	modelPermissionHandler := handlers.NewModelPermissionHandler(permissionService)
 	starCheckPermissionHandler := handlers.NewStarCheckPermissionHandler(starCheckPermissionService)
 	quotaCheckPermissionHandler := handlers.NewQuotaCheckPermissionHandler(quotaCheckPermissionService)
@@ -162,7 +182,9 @@ func main() {
 	// AiGateway passthrough admin
 	aigatewayAdminService := services.NewAiGatewayAdminService(gateway)
 	aigatewayAdminHandler := handlers.NewAiGatewayAdminHandler(aigatewayAdminService)
-	scanHandler := handlers.NewScanHandler(strategyService, unifiedPermissionService, schedulerService, quotaService)
+	scanHandler := handlers.NewScanHandler(strategyService, unifiedPermissionService, schedulerService, quotaService, scanJobService)
+	apiKeyHandler := handlers.NewAPIKeyHandler(apiKeyService)
+	schedulerHandler := handlers.NewSchedulerHandler(leaderElector, schedulerService.Jobs())
 
 	// Set Gin mode
 	gin.SetMode(cfg.Server.Mode)
@@ -205,9 +227,11 @@ func main() {
 		v1 := quotaManager.Group("/api/v1")
 		{
 			// Strategy management API
-			strategies := v1.Group("/strategies")
+			strategies := v1.Group("/strategies", authorizer.RequireAccess(auth.RoleOperator, auth.ScopeStrategyWrite))
 			{
 				strategies.POST("", strategyHandler.CreateStrategy)
+				strategies.POST("/preview", strategyHandler.PreviewStrategy)
+				strategies.POST("/cron-preview", strategyHandler.PreviewCron)
 				strategies.GET("", strategyHandler.GetStrategies)
 				strategies.GET("/:id", strategyHandler.GetStrategy)
 				strategies.PUT("/:id", strategyHandler.UpdateStrategy)
@@ -219,13 +243,28 @@ func main() {
 
 				// Strategy execution records
 				strategies.GET("/:id/executions", strategyHandler.GetStrategyExecuteRecords)
+				strategies.POST("/:id/executions/retry", strategyHandler.RetryStrategyExecutions)
+				strategies.GET("/:id/versions", strategyHandler.GetStrategyVersions)
+				strategies.GET("/:id/versions/diff", strategyHandler.DiffStrategyVersions)
+				strategies.POST("/:id/versions/:version/rollback", strategyHandler.RollbackStrategy)
+				strategies.GET("/:id/runs", strategyHandler.GetStrategyRuns)
+				strategies.GET("/:id/runs/:batch", strategyHandler.GetStrategyRun)
 			}
 
 			// Quota management API
-			handlers.RegisterQuotaRoutes(v1, quotaHandler)
+			handlers.RegisterQuotaRoutes(v1, quotaHandler,
+				authorizer.RequireAccess(auth.RoleViewer, auth.ScopeQuotaRead),
+				authorizer.RequireAccess(auth.RoleOperator, auth.ScopeQuotaDeduct))
+
+			// Manual quota grants and adjustments
+			adminQuota := v1.Group("/admin/quota", authorizer.RequireRole(auth.RoleAdmin))
+			{
+				adminQuota.POST("/grant", quotaHandler.AdminGrantQuota)
+				adminQuota.POST("/adjust", quotaHandler.AdminAdjustQuota)
+			}
 
 			// Model permissions management
-			modelPermissions := v1.Group("/model-permissions")
+			modelPermissions := v1.Group("/model-permissions", authorizer.RequireRole(auth.RoleOperator))
 			{
 				modelPermissions.POST("/user", modelPermissionHandler.SetUserWhitelist)
 				modelPermissions.POST("/department", modelPermissionHandler.SetDepartmentWhitelist)
@@ -234,7 +273,7 @@ func main() {
 			}
 
 			// Star check permissions management
-			starCheckPermissions := v1.Group("/star-check-permissions")
+			starCheckPermissions := v1.Group("/star-check-permissions", authorizer.RequireRole(auth.RoleOperator))
 			{
 				starCheckPermissions.POST("/user", starCheckPermissionHandler.SetUserStarCheckSetting)
 				starCheckPermissions.POST("/department", starCheckPermissionHandler.SetDepartmentStarCheckSetting)
@@ -243,7 +282,7 @@ func main() {
 			}
 
 			// Quota check permissions management
-			quotaCheckPermissions := v1.Group("/quota-check-permissions")
+			quotaCheckPermissions := v1.Group("/quota-check-permissions", authorizer.RequireRole(auth.RoleOperator))
 			{
 				quotaCheckPermissions.POST("/user", quotaCheckPermissionHandler.SetUserQuotaCheckSetting)
 				quotaCheckPermissions.POST("/department", quotaCheckPermissionHandler.SetDepartmentQuotaCheckSetting)
@@ -254,11 +293,41 @@ func main() {
 			// Unified query and sync interfaces
 			v1.GET("/effective-permissions", unifiedPermissionHandler.GetEffectivePermissions)
 
-			// Unified scan interface
-			v1.POST("/scan", scanHandler.TriggerScan)
+			// Unified scan interface, scans run as background jobs
+			v1.POST("/scan", authorizer.RequireAccess(auth.RoleOperator, auth.ScopeScanTrigger), scanHandler.TriggerScan)
+			scanJobs := v1.Group("/jobs", authorizer.RequireAccess(auth.RoleOperator, auth.ScopeScanTrigger))
+			{
+				scanJobs.GET("", scanHandler.ListJobs)
+				scanJobs.GET("/:id", scanHandler.GetJob)
+			}
+
+			// Inbound user events evaluating the strategies they trigger
+			v1.POST("/events", authorizer.RequireAccess(auth.RoleOperator, auth.ScopeEventPublish), strategyHandler.HandleEvent)
+
+			// Service API key management
+			apiKeys := v1.Group("/api-keys", authorizer.RequireRole(auth.RoleAdmin))
+			{
+				apiKeys.POST("", apiKeyHandler.CreateAPIKey)
+				apiKeys.GET("", apiKeyHandler.ListAPIKeys)
+				apiKeys.DELETE("/:id", apiKeyHandler.RevokeAPIKey)
+			}
+
+			// Scheduler administration
+			adminScheduler := v1.Group("/admin/scheduler", authorizer.RequireRole(auth.RoleAdmin))
+			{
+				adminScheduler.GET("/locks", schedulerHandler.GetLocks)
+			}
+
+			// Scheduled jobs of all schedulers
+			schedulerJobs := v1.Group("/scheduler/jobs", authorizer.RequireRole(auth.RoleAdmin))
+			{
+				schedulerJobs.GET("", schedulerHandler.ListJobs)
+				schedulerJobs.POST("/:name/pause", schedulerHandler.PauseJob)
+				schedulerJobs.POST("/:name/resume", schedulerHandler.ResumeJob)
+			}
 
 			// AiGateway passthrough admin APIs
-			aigw := v1.Group("/aigateway")
+			aigw := v1.Group("/aigateway", authorizer.RequireRole(auth.RoleAdmin))
 			{
 				// total quota
 				aigw.GET("/quota", aigatewayAdminHandler.QueryQuota)
@@ -0,0 +1,286 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"quota-manager/pkg/logger"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultJWKSRefreshInterval is used when jwks_refresh_interval is not configured
+	defaultJWKSRefreshInterval = time.Hour
+	// minJWKSRefetchInterval limits refetches triggered by unknown key IDs
+	minJWKSRefetchInterval = time.Minute
+)
+
+// jsonWebKey represents a single key of a JWKS document
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// verificationKey is a parsed public key with its JWKS metadata
+type verificationKey struct {
+	kid string
+	alg string
+	key crypto.PublicKey
+}
+
+// KeySet holds public keys loaded from a JWKS file or URL
+type KeySet struct {
+	url             string
+	refreshInterval time.Duration
+	httpClient      *http.Client
+
+	mu          sync.RWMutex
+	keys        []verificationKey
+	fetchedAt   time.Time
+	lastAttempt time.Time
+}
+
+// LoadKeySetFile loads a static key set from a JWKS file
+func LoadKeySetFile(path string) (*KeySet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWKS file %s: %w", path, err)
+	}
+
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS file %s: %w", path, err)
+	}
+
+	return &KeySet{keys: keys, fetchedAt: time.Now()}, nil
+}
+
+// NewRemoteKeySet creates a key set that is fetched from a JWKS URL and refreshed periodically
+func NewRemoteKeySet(url string, refreshInterval time.Duration) *KeySet {
+	if refreshInterval <= 0 {
+		refreshInterval = defaultJWKSRefreshInterval
+	}
+
+	ks := &KeySet{
+		url:             url,
+		refreshInterval: refreshInterval,
+		httpClient:      &http.Client{Timeout: 10 * time.Second},
+	}
+
+	// Initial fetch is best effort, keys are fetched again on first use if it fails
+	if err := ks.refresh(); err != nil {
+		logger.Warn("Failed to fetch JWKS, will retry on first token verification",
+			zap.String("url", url),
+			zap.Error(err))
+	}
+
+	return ks
+}
+
+// Lookup finds the key for the given key ID and algorithm
+func (ks *KeySet) Lookup(kid, alg string) (crypto.PublicKey, error) {
+	if ks.url != "" {
+		ks.mu.RLock()
+		stale := time.Since(ks.fetchedAt) > ks.refreshInterval
+		ks.mu.RUnlock()
+		if stale {
+			ks.tryRefresh()
+		}
+	}
+
+	if key, ok := ks.find(kid, alg); ok {
+		return key, nil
+	}
+
+	// The signing key may have been rotated, refetch once and retry
+	if ks.url != "" && ks.tryRefresh() {
+		if key, ok := ks.find(kid, alg); ok {
+			return key, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no JWKS key found for kid %q and algorithm %s", kid, alg)
+}
+
+// find searches the loaded keys, a token without kid matches only a single candidate key
+func (ks *KeySet) find(kid, alg string) (crypto.PublicKey, bool) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	var candidates []verificationKey
+	for _, k := range ks.keys {
+		if k.alg != "" && k.alg != alg {
+			continue
+		}
+		if kid != "" && k.kid != kid {
+			continue
+		}
+		candidates = append(candidates, k)
+	}
+
+	if len(candidates) != 1 {
+		return nil, false
+	}
+	return candidates[0].key, true
+}
+
+// tryRefresh refetches remote keys unless a fetch was attempted very recently
+func (ks *KeySet) tryRefresh() bool {
+	ks.mu.RLock()
+	recent := time.Since(ks.lastAttempt) < minJWKSRefetchInterval
+	ks.mu.RUnlock()
+	if recent {
+		return false
+	}
+
+	if err := ks.refresh(); err != nil {
+		logger.Warn("Failed to refresh JWKS", zap.String("url", ks.url), zap.Error(err))
+		return false
+	}
+	return true
+}
+
+// refresh fetches the JWKS document from the configured URL
+func (ks *KeySet) refresh() error {
+	ks.mu.Lock()
+	ks.lastAttempt = time.Now()
+	ks.mu.Unlock()
+
+	resp, err := ks.httpClient.Get(ks.url)
+	if err != nil {
+		return fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("JWKS endpoint returned status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read JWKS response: %w", err)
+	}
+
+	keys, err := parseJWKS(body)
+	if err != nil {
+		return err
+	}
+
+	ks.mu.Lock()
+	ks.keys = keys
+	ks.fetchedAt = time.Now()
+	ks.mu.Unlock()
+
+	logger.Info("JWKS loaded", zap.String("url", ks.url), zap.Int("keys", len(keys)))
+	return nil
+}
+
+// parseJWKS parses the RSA and EC signing keys of a JWKS document
+func parseJWKS(data []byte) ([]verificationKey, error) {
+	var doc struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal JWKS: %w", err)
+	}
+
+	keys := make([]verificationKey, 0, len(doc.Keys))
+	for _, jwk := range doc.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		var key crypto.PublicKey
+		var err error
+		switch jwk.Kty {
+		case "RSA":
+			key, err = parseRSAKey(&jwk)
+		case "EC":
+			key, err = parseECKey(&jwk)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q: %w", jwk.Kid, err)
+		}
+
+		keys = append(keys, verificationKey{kid: jwk.Kid, alg: jwk.Alg, key: key})
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("JWKS contains no usable signing keys")
+	}
+	return keys, nil
+}
+
+// parseRSAKey builds an RSA public key from its modulus and exponent
+func parseRSAKey(jwk *jsonWebKey) (*rsa.PublicKey, error) {
+	n, err := decodeSegment(jwk.N)
+	if err != nil {
+		return nil, fmt.Errorf("invalid modulus: %w", err)
+	}
+	e, err := decodeSegment(jwk.E)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exponent: %w", err)
+	}
+	if len(n) == 0 || len(e) == 0 || len(e) > 4 {
+		return nil, fmt.Errorf("invalid RSA key parameters")
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}, nil
+}
+
+// parseECKey builds an EC public key from its curve and coordinates
+func parseECKey(jwk *jsonWebKey) (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	switch jwk.Crv {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	case "P-521":
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+	}
+
+	x, err := decodeSegment(jwk.X)
+	if err != nil {
+		return nil, fmt.Errorf("invalid x coordinate: %w", err)
+	}
+	y, err := decodeSegment(jwk.Y)
+	if err != nil {
+		return nil, fmt.Errorf("invalid y coordinate: %w", err)
+	}
+
+	pub := &ecdsa.PublicKey{
+		Curve: curve,
+		X:     new(big.Int).SetBytes(x),
+		Y:     new(big.Int).SetBytes(y),
+	}
+	if !curve.IsOnCurve(pub.X, pub.Y) {
+		return nil, fmt.Errorf("point is not on curve %s", jwk.Crv)
+	}
+	return pub, nil
+}
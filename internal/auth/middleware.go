@@ -0,0 +1,279 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"quota-manager/internal/config"
+	"quota-manager/internal/models"
+	"quota-manager/internal/response"
+	"quota-manager/pkg/logger"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Role is a built-in authorization role, higher roles include the lower ones
+type Role string
+
+const (
+	RoleViewer   Role = "viewer"
+	RoleOperator Role = "operator"
+	RoleAdmin    Role = "admin"
+)
+
+// roleRank orders roles so that admin > operator > viewer
+var roleRank = map[Role]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+// Scopes granted to service API keys
+const (
+	ScopeQuotaRead     = "quota:read"
+	ScopeQuotaDeduct   = "quota:deduct"
+	ScopeStrategyWrite = "strategy:write"
+	ScopeScanTrigger   = "scan:trigger"
+	ScopeEventPublish  = "event:publish"
+)
+
+const (
+	// defaultRolesClaim is used when roles_claim is not configured
+	defaultRolesClaim = "roles"
+	// defaultAPIKeyHeader is used when api_key_header is not configured
+	defaultAPIKeyHeader = "x-api-key"
+)
+
+// principalContextKey is the gin context key of the authenticated principal
+const principalContextKey = "auth.principal"
+
+// APIKeyAuthenticator resolves service API keys
+type APIKeyAuthenticator interface {
+	AuthenticateAPIKey(rawKey string) (*models.APIKey, error)
+}
+
+// Principal is the authenticated caller of a request, either a user token or a service API key
+type Principal struct {
+	UserID   string   `json:"user_id,omitempty"`
+	Name     string   `json:"name"`
+	Roles    []Role   `json:"roles,omitempty"`
+	APIKeyID *int     `json:"api_key_id,omitempty"`
+	Scopes   []string `json:"scopes,omitempty"`
+}
+
+// IsAPIKey checks whether the principal authenticated with a service API key
+func (p *Principal) IsAPIKey() bool {
+	return p.APIKeyID != nil
+}
+
+// HasScope checks whether the API key principal was granted the scope
+func (p *Principal) HasScope(scope string) bool {
+	for _, granted := range p.Scopes {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Identity returns a printable identity of the principal for logs and audit records
+func (p *Principal) Identity() string {
+	if p.IsAPIKey() {
+		return fmt.Sprintf("api-key:%d", *p.APIKeyID)
+	}
+	return p.UserID
+}
+
+// HasRole checks whether the principal holds the role or a higher one
+func (p *Principal) HasRole(required Role) bool {
+	for _, role := range p.Roles {
+		if roleRank[role] >= roleRank[required] {
+			return true
+		}
+	}
+	return false
+}
+
+// Authorizer authenticates callers and enforces role requirements on routes
+type Authorizer struct {
+	verifier     *Verifier
+	apiKeys      APIKeyAuthenticator
+	tokenHeader  string
+	apiKeyHeader string
+	rolesClaim   string
+	adminUsers   map[string]bool
+}
+
+// NewAuthorizer creates an authorizer using the given token verifier, API key store and server configuration
+func NewAuthorizer(verifier *Verifier, apiKeys APIKeyAuthenticator, serverConfig *config.ServerConfig) *Authorizer {
+	tokenHeader := serverConfig.TokenHeader
+	if tokenHeader == "" {
+		tokenHeader = "authorization"
+	}
+
+	apiKeyHeader := serverConfig.Auth.APIKeyHeader
+	if apiKeyHeader == "" {
+		apiKeyHeader = defaultAPIKeyHeader
+	}
+
+	rolesClaim := serverConfig.Auth.RolesClaim
+	if rolesClaim == "" {
+		rolesClaim = defaultRolesClaim
+	}
+
+	adminUsers := make(map[string]bool, len(serverConfig.Auth.AdminUsers))
+	for _, userID := range serverConfig.Auth.AdminUsers {
+		if userID = strings.TrimSpace(userID); userID != "" {
+			adminUsers[userID] = true
+		}
+	}
+
+	return &Authorizer{
+		verifier:     verifier,
+		apiKeys:      apiKeys,
+		tokenHeader:  tokenHeader,
+		apiKeyHeader: apiKeyHeader,
+		rolesClaim:   rolesClaim,
+		adminUsers:   adminUsers,
+	}
+}
+
+// RequireRole returns a middleware that only lets user tokens holding the role through
+func (a *Authorizer) RequireRole(required Role) gin.HandlerFunc {
+	return a.RequireAccess(required, "")
+}
+
+// RequireAccess returns a middleware that lets user tokens holding the role, or API keys
+// granted the scope, through. An empty scope rejects all API keys.
+func (a *Authorizer) RequireAccess(required Role, scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, err := a.authenticate(c)
+		if err != nil {
+			a.deny(c, http.StatusUnauthorized, required, scope, nil, err.Error())
+			return
+		}
+
+		if principal.IsAPIKey() {
+			if scope == "" || !principal.HasScope(scope) {
+				reason := "API keys are not accepted for this endpoint"
+				if scope != "" {
+					reason = fmt.Sprintf("scope %s is required", scope)
+				}
+				a.deny(c, http.StatusForbidden, required, scope, principal, reason)
+				return
+			}
+		} else if !principal.HasRole(required) {
+			a.deny(c, http.StatusForbidden, required, scope, principal,
+				fmt.Sprintf("role %s is required", required))
+			return
+		}
+
+		c.Set(principalContextKey, principal)
+		c.Next()
+	}
+}
+
+// authenticate resolves the caller from the API key header or the user token
+func (a *Authorizer) authenticate(c *gin.Context) (*Principal, error) {
+	if rawKey := c.GetHeader(a.apiKeyHeader); rawKey != "" {
+		return a.authenticateAPIKey(rawKey)
+	}
+
+	token := c.GetHeader(a.tokenHeader)
+	if token == "" {
+		return nil, fmt.Errorf("missing token in header: %s", a.tokenHeader)
+	}
+
+	claims, err := a.verifier.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+
+	userID, _ := claims["universal_id"].(string)
+	if userID == "" {
+		return nil, fmt.Errorf("user ID not found in JWT token")
+	}
+
+	principal := &Principal{UserID: userID}
+	principal.Name, _ = claims["name"].(string)
+	principal.Roles = rolesFromClaim(claims[a.rolesClaim])
+	if a.adminUsers[userID] {
+		principal.Roles = append(principal.Roles, RoleAdmin)
+	}
+
+	return principal, nil
+}
+
+// authenticateAPIKey resolves a service API key principal
+func (a *Authorizer) authenticateAPIKey(rawKey string) (*Principal, error) {
+	if a.apiKeys == nil {
+		return nil, fmt.Errorf("API keys are not enabled")
+	}
+
+	apiKey, err := a.apiKeys.AuthenticateAPIKey(rawKey)
+	if err != nil {
+		return nil, err
+	}
+
+	keyID := apiKey.ID
+	return &Principal{
+		Name:     apiKey.Name,
+		APIKeyID: &keyID,
+		Scopes:   apiKey.ScopeList(),
+	}, nil
+}
+
+// deny aborts the request with UnauthorizedCode and logs the denial
+func (a *Authorizer) deny(c *gin.Context, status int, required Role, scope string, principal *Principal, reason string) {
+	fields := []zap.Field{
+		zap.String("method", c.Request.Method),
+		zap.String("path", c.FullPath()),
+		zap.String("required_role", string(required)),
+		zap.String("required_scope", scope),
+		zap.String("client_ip", c.ClientIP()),
+		zap.String("reason", reason),
+	}
+	if principal != nil {
+		fields = append(fields, zap.String("principal", principal.Identity()), zap.Any("roles", principal.Roles))
+	}
+	logger.Warn("Authorization denied", fields...)
+
+	c.AbortWithStatusJSON(status, response.NewErrorResponse(response.UnauthorizedCode,
+		"Authorization denied: "+reason))
+}
+
+// PrincipalFromContext returns the principal set by RequireAccess, or nil if the route is not protected
+func PrincipalFromContext(c *gin.Context) *Principal {
+	value, exists := c.Get(principalContextKey)
+	if !exists {
+		return nil
+	}
+	principal, _ := value.(*Principal)
+	return principal
+}
+
+// rolesFromClaim parses a roles claim given as a string, a space or comma separated list, or an array
+func rolesFromClaim(claim interface{}) []Role {
+	var names []string
+	switch value := claim.(type) {
+	case string:
+		names = strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' })
+	case []interface{}:
+		for _, item := range value {
+			if name, ok := item.(string); ok {
+				names = append(names, name)
+			}
+		}
+	}
+
+	var roles []Role
+	for _, name := range names {
+		role := Role(strings.ToLower(strings.TrimSpace(name)))
+		if _, known := roleRank[role]; known {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
@@ -0,0 +1,241 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"quota-manager/internal/config"
+)
+
+// Supported signing algorithms
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgES256 = "ES256"
+)
+
+// Verifier verifies JWT signatures and registered claims
+type Verifier struct {
+	hmacKey  []byte
+	keySet   *KeySet
+	issuer   string
+	audience string
+	leeway   time.Duration
+	now      func() time.Time
+}
+
+// tokenHeader represents the JOSE header of a JWT
+type tokenHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ"`
+}
+
+// NewVerifier creates a token verifier from the server.auth configuration
+func NewVerifier(cfg *config.AuthConfig) (*Verifier, error) {
+	if cfg.HS256Secret == "" && cfg.JWKSFile == "" && cfg.JWKSURL == "" {
+		return nil, fmt.Errorf("server.auth requires at least one of hs256_secret, jwks_file or jwks_url")
+	}
+	if cfg.JWKSFile != "" && cfg.JWKSURL != "" {
+		return nil, fmt.Errorf("server.auth jwks_file and jwks_url are mutually exclusive")
+	}
+
+	v := &Verifier{
+		issuer:   cfg.Issuer,
+		audience: cfg.Audience,
+		leeway:   cfg.Leeway,
+		now:      time.Now,
+	}
+
+	if cfg.HS256Secret != "" {
+		v.hmacKey = []byte(cfg.HS256Secret)
+	}
+
+	switch {
+	case cfg.JWKSFile != "":
+		keySet, err := LoadKeySetFile(cfg.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		v.keySet = keySet
+	case cfg.JWKSURL != "":
+		v.keySet = NewRemoteKeySet(cfg.JWKSURL, cfg.JWKSRefreshInterval)
+	}
+
+	return v, nil
+}
+
+// Verify checks the token signature and claims, returning the verified claims
+func (v *Verifier) Verify(token string) (map[string]interface{}, error) {
+	// Remove "Bearer " prefix if present
+	token = strings.TrimSpace(token)
+	if strings.HasPrefix(token, "Bearer ") {
+		token = strings.TrimSpace(token[7:])
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid JWT token format")
+	}
+
+	headerBytes, err := decodeSegment(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode JWT header: %w", err)
+	}
+	var header tokenHeader
+	if err := json.Unmarshal(headerBytes, &header); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal JWT header: %w", err)
+	}
+
+	signature, err := decodeSegment(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode JWT signature: %w", err)
+	}
+
+	if err := v.verifySignature(&header, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	payloadBytes, err := decodeSegment(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode JWT payload: %w", err)
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payloadBytes, &claims); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal JWT claims: %w", err)
+	}
+
+	if err := v.validateClaims(claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// verifySignature verifies the signature using the key material allowed for the header algorithm
+func (v *Verifier) verifySignature(header *tokenHeader, signingInput string, signature []byte) error {
+	switch header.Alg {
+	case AlgHS256:
+		if v.hmacKey == nil {
+			return fmt.Errorf("JWT algorithm %s is not accepted", header.Alg)
+		}
+		mac := hmac.New(sha256.New, v.hmacKey)
+		mac.Write([]byte(signingInput))
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return fmt.Errorf("invalid JWT signature")
+		}
+		return nil
+
+	case AlgRS256, AlgES256:
+		if v.keySet == nil {
+			return fmt.Errorf("JWT algorithm %s is not accepted", header.Alg)
+		}
+		key, err := v.keySet.Lookup(header.Kid, header.Alg)
+		if err != nil {
+			return err
+		}
+		digest := sha256.Sum256([]byte(signingInput))
+
+		if header.Alg == AlgRS256 {
+			pub, ok := key.(*rsa.PublicKey)
+			if !ok {
+				return fmt.Errorf("key %q is not an RSA key", header.Kid)
+			}
+			if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature); err != nil {
+				return fmt.Errorf("invalid JWT signature")
+			}
+			return nil
+		}
+
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || pub.Curve.Params().BitSize != 256 {
+			return fmt.Errorf("key %q is not a P-256 EC key", header.Kid)
+		}
+		// ES256 signatures are the raw concatenation of R and S
+		if len(signature) != 64 {
+			return fmt.Errorf("invalid JWT signature")
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(pub, digest[:], r, s) {
+			return fmt.Errorf("invalid JWT signature")
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unsupported JWT algorithm: %q", header.Alg)
+	}
+}
+
+// validateClaims checks the exp, nbf, iss and aud claims
+func (v *Verifier) validateClaims(claims map[string]interface{}) error {
+	now := v.now()
+
+	exp, ok := numericClaim(claims, "exp")
+	if !ok {
+		return fmt.Errorf("JWT token has no valid exp claim")
+	}
+	if now.After(time.Unix(exp, 0).Add(v.leeway)) {
+		return fmt.Errorf("JWT token has expired")
+	}
+
+	if _, exists := claims["nbf"]; exists {
+		nbf, ok := numericClaim(claims, "nbf")
+		if !ok {
+			return fmt.Errorf("JWT token has invalid nbf claim")
+		}
+		if now.Add(v.leeway).Before(time.Unix(nbf, 0)) {
+			return fmt.Errorf("JWT token is not valid yet")
+		}
+	}
+
+	if v.issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != v.issuer {
+			return fmt.Errorf("JWT token issuer %q is not accepted", iss)
+		}
+	}
+
+	if v.audience != "" && !audienceContains(claims["aud"], v.audience) {
+		return fmt.Errorf("JWT token audience is not accepted")
+	}
+
+	return nil
+}
+
+// numericClaim reads a NumericDate claim as unix seconds
+func numericClaim(claims map[string]interface{}, name string) (int64, bool) {
+	value, ok := claims[name].(float64)
+	if !ok {
+		return 0, false
+	}
+	return int64(value), true
+}
+
+// audienceContains checks whether the aud claim (string or array) contains the expected audience
+func audienceContains(aud interface{}, expected string) bool {
+	switch value := aud.(type) {
+	case string:
+		return value == expected
+	case []interface{}:
+		for _, item := range value {
+			if s, ok := item.(string); ok && s == expected {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// decodeSegment decodes a base64url JWT segment, tolerating padding
+func decodeSegment(segment string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(segment, "="))
+}
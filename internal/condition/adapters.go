@@ -12,7 +12,17 @@ func NewAiGatewayQuotaQuerier(client *aigateway.Client) QuotaQuerier {
 	return &AiGatewayQuotaQuerier{client: client}
 }
 
+// NewAiGatewayUsedQuotaQuerier creates a new adapter for aigateway.Client querying used quota
+func NewAiGatewayUsedQuotaQuerier(client *aigateway.Client) UsedQuotaQuerier {
+	return &AiGatewayQuotaQuerier{client: client}
+}
+
 // QueryQuota implements QuotaQuerier interface
 func (a *AiGatewayQuotaQuerier) QueryQuota(userID string) (float64, error) {
 	return a.client.QueryQuotaValue(userID)
 }
+
+// QueryUsedQuota implements UsedQuotaQuerier interface
+func (a *AiGatewayQuotaQuerier) QueryUsedQuota(userID string) (float64, error) {
+	return a.client.QueryUsedQuotaValue(userID)
+}
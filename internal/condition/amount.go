@@ -0,0 +1,461 @@
+package condition
+
+import (
+	"fmt"
+	"math"
+	"quota-manager/internal/models"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// AmountEvaluator computes the amount a strategy grants to a user
+type AmountEvaluator interface {
+	EvaluateAmount(user *models.UserInfo, ctx *EvaluationContext) (float64, error)
+}
+
+// NumberExpr numeric literal
+type NumberExpr struct {
+	Value float64
+}
+
+func (n *NumberExpr) EvaluateAmount(user *models.UserInfo, ctx *EvaluationContext) (float64, error) {
+	return n.Value, nil
+}
+
+// UserFieldExpr numeric user field
+type UserFieldExpr struct {
+	Field string
+}
+
+func (u *UserFieldExpr) EvaluateAmount(user *models.UserInfo, ctx *EvaluationContext) (float64, error) {
+	switch u.Field {
+	case "vip":
+		return float64(user.VIP), nil
+	case "register-days":
+		return math.Floor(time.Since(user.CreatedAt).Hours() / 24), nil
+	case "access-days":
+		return math.Floor(time.Since(user.AccessTime).Hours() / 24), nil
+	}
+	return 0, fmt.Errorf("unknown user field: %s", u.Field)
+}
+
+// ArithmeticExpr binary arithmetic expression
+type ArithmeticExpr struct {
+	Op          string
+	Left, Right AmountEvaluator
+}
+
+func (a *ArithmeticExpr) EvaluateAmount(user *models.UserInfo, ctx *EvaluationContext) (float64, error) {
+	left, err := a.Left.EvaluateAmount(user, ctx)
+	if err != nil {
+		return 0, err
+	}
+	right, err := a.Right.EvaluateAmount(user, ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	switch a.Op {
+	case "+":
+		return left + right, nil
+	case "-":
+		return left - right, nil
+	case "*":
+		return left * right, nil
+	case "/":
+		if right == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		return left / right, nil
+	}
+	return 0, fmt.Errorf("unknown operator: %s", a.Op)
+}
+
+// NegateExpr unary minus expression
+type NegateExpr struct {
+	Expr AmountEvaluator
+}
+
+func (n *NegateExpr) EvaluateAmount(user *models.UserInfo, ctx *EvaluationContext) (float64, error) {
+	value, err := n.Expr.EvaluateAmount(user, ctx)
+	return -value, err
+}
+
+// NumericFuncExpr min, max, round, floor and ceil functions
+type NumericFuncExpr struct {
+	Name string
+	Args []AmountEvaluator
+}
+
+func (f *NumericFuncExpr) EvaluateAmount(user *models.UserInfo, ctx *EvaluationContext) (float64, error) {
+	values := make([]float64, len(f.Args))
+	for i, arg := range f.Args {
+		value, err := arg.EvaluateAmount(user, ctx)
+		if err != nil {
+			return 0, err
+		}
+		values[i] = value
+	}
+
+	switch f.Name {
+	case "min":
+		result := values[0]
+		for _, value := range values[1:] {
+			result = math.Min(result, value)
+		}
+		return result, nil
+	case "max":
+		result := values[0]
+		for _, value := range values[1:] {
+			result = math.Max(result, value)
+		}
+		return result, nil
+	case "round":
+		return math.Round(values[0]), nil
+	case "floor":
+		return math.Floor(values[0]), nil
+	case "ceil":
+		return math.Ceil(values[0]), nil
+	}
+	return 0, fmt.Errorf("unknown function: %s", f.Name)
+}
+
+// QuotaValueExpr remaining quota of the user, of a model when Model is set
+type QuotaValueExpr struct {
+	Model string
+}
+
+func (q *QuotaValueExpr) EvaluateAmount(user *models.UserInfo, ctx *EvaluationContext) (float64, error) {
+	if q.Model != "" {
+		if ctx.ModelQuotaQuerier == nil {
+			return 0, fmt.Errorf("model quota querier not available")
+		}
+		return ctx.ModelQuotaQuerier.QueryModelQuota(user.ID, q.Model)
+	}
+
+	if ctx.QuotaQuerier == nil {
+		return 0, fmt.Errorf("quota querier not available")
+	}
+	return ctx.QuotaQuerier.QueryQuota(user.ID)
+}
+
+// MonthlyUsageExpr quota used by the user in a past month, as recorded in monthly_quota_usage
+type MonthlyUsageExpr struct {
+	MonthsAgo int
+}
+
+func (m *MonthlyUsageExpr) EvaluateAmount(user *models.UserInfo, ctx *EvaluationContext) (float64, error) {
+	if ctx.UsageQuerier == nil {
+		return 0, fmt.Errorf("usage querier not available")
+	}
+	return ctx.UsageQuerier.QueryMonthlyUsage(user.ID, m.MonthsAgo)
+}
+
+// stringArg is a quoted function argument, only accepted where a function expects a name
+type stringArg struct {
+	Value string
+}
+
+func (s *stringArg) EvaluateAmount(user *models.UserInfo, ctx *EvaluationContext) (float64, error) {
+	return 0, fmt.Errorf("unexpected string %q in amount expression", s.Value)
+}
+
+// amountParser parses amount expressions with the usual operator precedence:
+//
+//	expr    := term (("+" | "-") term)*
+//	term    := unary (("*" | "/") unary)*
+//	unary   := "-" unary | primary
+//	primary := number | field | function "(" args ")" | "(" expr ")"
+type amountParser struct {
+	tokens []string
+	pos    int
+}
+
+// tokenizeAmount splits an amount expression into numbers, quoted strings, names, operators and parentheses.
+// A '-' belongs to a name only when a letter follows it, so subtraction of names needs spaces around it
+func tokenizeAmount(expr string) ([]string, error) {
+	var tokens []string
+	runes := []rune(expr)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case strings.ContainsRune("+-*/(),", r):
+			tokens = append(tokens, string(r))
+			i++
+		case r == '"':
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			if end >= len(runes) {
+				return nil, fmt.Errorf("unterminated string in amount expression")
+			}
+			tokens = append(tokens, string(runes[i:end+1]))
+			i = end + 1
+		case unicode.IsDigit(r) || r == '.':
+			start := i
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, string(runes[start:i]))
+		case unicode.IsLetter(r):
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) ||
+				(runes[i] == '-' && i+1 < len(runes) && unicode.IsLetter(runes[i+1]))) {
+				i++
+			}
+			tokens = append(tokens, string(runes[start:i]))
+		default:
+			return nil, fmt.Errorf("unexpected character %q in amount expression", r)
+		}
+	}
+	return tokens, nil
+}
+
+func (p *amountParser) parseExpr() (AmountEvaluator, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	for p.pos < len(p.tokens) && (p.tokens[p.pos] == "+" || p.tokens[p.pos] == "-") {
+		op := p.tokens[p.pos]
+		p.pos++ // consume operator
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		if err := checkOperands(op, left, right); err != nil {
+			return nil, err
+		}
+		left = &ArithmeticExpr{Op: op, Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *amountParser) parseTerm() (AmountEvaluator, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.pos < len(p.tokens) && (p.tokens[p.pos] == "*" || p.tokens[p.pos] == "/") {
+		op := p.tokens[p.pos]
+		p.pos++ // consume operator
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if err := checkOperands(op, left, right); err != nil {
+			return nil, err
+		}
+		left = &ArithmeticExpr{Op: op, Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *amountParser) parseUnary() (AmountEvaluator, error) {
+	if p.pos < len(p.tokens) && p.tokens[p.pos] == "-" {
+		p.pos++ // consume '-'
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if err := checkOperands("-", expr); err != nil {
+			return nil, err
+		}
+		return &NegateExpr{Expr: expr}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *amountParser) parsePrimary() (AmountEvaluator, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	token := p.tokens[p.pos]
+	p.pos++ // consume token
+
+	switch {
+	case token == "(":
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if p.pos >= len(p.tokens) || p.tokens[p.pos] != ")" {
+			return nil, fmt.Errorf("expected ')' but got %s", p.currentToken())
+		}
+		p.pos++ // consume ')'
+		return expr, nil
+
+	case strings.HasPrefix(token, "\""):
+		return &stringArg{Value: strings.Trim(token, "\"")}, nil
+
+	case unicode.IsDigit(rune(token[0])) || token[0] == '.':
+		value, err := strconv.ParseFloat(token, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number: %s", token)
+		}
+		return &NumberExpr{Value: value}, nil
+
+	case unicode.IsLetter(rune(token[0])):
+		if p.pos < len(p.tokens) && p.tokens[p.pos] == "(" {
+			p.pos++ // consume '('
+			args, err := p.parseArgs()
+			if err != nil {
+				return nil, err
+			}
+			return buildAmountFunction(token, args)
+		}
+		switch token {
+		case "vip", "register-days", "access-days":
+			return &UserFieldExpr{Field: token}, nil
+		}
+		return nil, fmt.Errorf("unknown user field: %s", token)
+	}
+
+	return nil, fmt.Errorf("unexpected token: %s", token)
+}
+
+// parseArgs parses the comma separated arguments of a function up to and including ')'
+func (p *amountParser) parseArgs() ([]AmountEvaluator, error) {
+	var args []AmountEvaluator
+	if p.pos < len(p.tokens) && p.tokens[p.pos] == ")" {
+		p.pos++ // consume ')'
+		return args, nil
+	}
+	for {
+		arg, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+
+		if p.pos >= len(p.tokens) {
+			return nil, fmt.Errorf("expected ')' to close function")
+		}
+		switch p.tokens[p.pos] {
+		case ",":
+			p.pos++ // consume ','
+		case ")":
+			p.pos++ // consume ')'
+			return args, nil
+		default:
+			return nil, fmt.Errorf("expected ',' or ')' but got %s", p.tokens[p.pos])
+		}
+	}
+}
+
+// checkOperands rejects strings as operands of arithmetic operators
+func checkOperands(op string, operands ...AmountEvaluator) error {
+	for _, operand := range operands {
+		if s, ok := operand.(*stringArg); ok {
+			return fmt.Errorf("operator %s does not accept the string %q", op, s.Value)
+		}
+	}
+	return nil
+}
+
+func buildAmountFunction(funcName string, args []AmountEvaluator) (AmountEvaluator, error) {
+	for _, arg := range args {
+		if s, ok := arg.(*stringArg); ok && funcName != "model-quota" {
+			return nil, fmt.Errorf("%s does not accept the string %q", funcName, s.Value)
+		}
+	}
+
+	switch funcName {
+	case "min", "max":
+		if len(args) == 0 {
+			return nil, fmt.Errorf("%s expects at least 1 argument", funcName)
+		}
+		return &NumericFuncExpr{Name: funcName, Args: args}, nil
+
+	case "round", "floor", "ceil":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s expects 1 argument, got %d", funcName, len(args))
+		}
+		return &NumericFuncExpr{Name: funcName, Args: args}, nil
+
+	case "quota":
+		if len(args) != 0 {
+			return nil, fmt.Errorf("quota expects 0 arguments, got %d", len(args))
+		}
+		return &QuotaValueExpr{}, nil
+
+	case "model-quota":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("model-quota expects 1 argument, got %d", len(args))
+		}
+		model, ok := args[0].(*stringArg)
+		if !ok || model.Value == "" {
+			return nil, fmt.Errorf("model-quota expects a quoted model name")
+		}
+		return &QuotaValueExpr{Model: model.Value}, nil
+
+	case "monthly-usage":
+		// Without an argument the usage of the previous month
+		monthsAgo := 1
+		if len(args) > 1 {
+			return nil, fmt.Errorf("monthly-usage expects at most 1 argument, got %d", len(args))
+		}
+		if len(args) == 1 {
+			number, ok := args[0].(*NumberExpr)
+			if !ok || number.Value < 0 || number.Value != math.Trunc(number.Value) {
+				return nil, fmt.Errorf("monthly-usage expects a whole number of months")
+			}
+			monthsAgo = int(number.Value)
+		}
+		return &MonthlyUsageExpr{MonthsAgo: monthsAgo}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown function: %s", funcName)
+	}
+}
+
+func (p *amountParser) currentToken() string {
+	if p.pos >= len(p.tokens) {
+		return "EOF"
+	}
+	return p.tokens[p.pos]
+}
+
+// ParseAmount parses an amount expression once so that it can be evaluated for many users
+func ParseAmount(expr string) (AmountEvaluator, error) {
+	tokens, err := tokenizeAmount(expr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse amount expression: %w", err)
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty amount expression")
+	}
+
+	parser := &amountParser{tokens: tokens}
+	evaluator, err := parser.parseExpr()
+	if err == nil && parser.pos < len(tokens) {
+		err = fmt.Errorf("unexpected token: %s", parser.currentToken())
+	}
+	if err == nil {
+		if s, ok := evaluator.(*stringArg); ok {
+			err = fmt.Errorf("unexpected string %q", s.Value)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse amount expression: %w", err)
+	}
+	return evaluator, nil
+}
+
+// CalcAmount evaluates an amount for a user, rounded to two decimals. Results that are not
+// finite numbers are errors
+func CalcAmount(evaluator AmountEvaluator, user *models.UserInfo, ctx *EvaluationContext) (float64, error) {
+	amount, err := evaluator.EvaluateAmount(user, ctx)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(amount) || math.IsInf(amount, 0) {
+		return 0, fmt.Errorf("amount expression is not a finite number")
+	}
+	return math.Round(amount*100) / 100, nil
+}
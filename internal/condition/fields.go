@@ -0,0 +1,287 @@
+package condition
+
+import (
+	"encoding/json"
+	"fmt"
+	"quota-manager/internal/models"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// userField is a string field of a user that field predicates can check
+type userField struct {
+	value    func(user *models.UserInfo) string
+	foldCase bool // Values are compared lower-cased
+}
+
+// userFields lists the fields of field-eq, field-in, field-regex and field-empty
+var userFields = map[string]userField{
+	"company":     {value: func(user *models.UserInfo) string { return user.Company }},
+	"location":    {value: func(user *models.UserInfo) string { return user.Location }},
+	"github-name": {value: func(user *models.UserInfo) string { return user.GithubName }},
+	"email-domain": {
+		value: func(user *models.UserInfo) string {
+			at := strings.LastIndex(user.Email, "@")
+			if at < 0 {
+				return ""
+			}
+			return strings.ToLower(strings.TrimSpace(user.Email[at+1:]))
+		},
+		foldCase: true,
+	},
+}
+
+// lookupUserField returns the field with the given name of a condition argument
+func lookupUserField(arg string) (string, userField, error) {
+	name := strings.Trim(arg, "\"")
+	field, ok := userFields[name]
+	if !ok {
+		names := make([]string, 0, len(userFields))
+		for known := range userFields {
+			names = append(names, known)
+		}
+		sort.Strings(names)
+		return "", userField{}, fmt.Errorf("unknown user field %q, expected one of %s", name, strings.Join(names, ", "))
+	}
+	return name, field, nil
+}
+
+// fieldValueArg returns a value argument to compare with the field
+func fieldValueArg(field userField, arg string) string {
+	value := strings.Trim(arg, "\"")
+	if field.foldCase {
+		value = strings.ToLower(value)
+	}
+	return value
+}
+
+// FieldEqExpr user field equals expression
+type FieldEqExpr struct {
+	Field string
+	Value string
+}
+
+func (f *FieldEqExpr) Evaluate(user *models.UserInfo, ctx *EvaluationContext) (bool, error) {
+	return userFields[f.Field].value(user) == f.Value, nil
+}
+
+// FieldInExpr user field in list expression
+type FieldInExpr struct {
+	Field  string
+	Values []string
+}
+
+func (f *FieldInExpr) Evaluate(user *models.UserInfo, ctx *EvaluationContext) (bool, error) {
+	value := userFields[f.Field].value(user)
+	for _, candidate := range f.Values {
+		if value == candidate {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// FieldRegexExpr user field matches regular expression
+type FieldRegexExpr struct {
+	Field   string
+	Pattern *regexp.Regexp
+}
+
+func (f *FieldRegexExpr) Evaluate(user *models.UserInfo, ctx *EvaluationContext) (bool, error) {
+	return f.Pattern.MatchString(userFields[f.Field].value(user)), nil
+}
+
+// FieldEmptyExpr user field empty expression
+type FieldEmptyExpr struct {
+	Field string
+}
+
+func (f *FieldEmptyExpr) Evaluate(user *models.UserInfo, ctx *EvaluationContext) (bool, error) {
+	return strings.TrimSpace(userFields[f.Field].value(user)) == "", nil
+}
+
+// RegisteredWithinExpr registration within a period before now expression
+type RegisteredWithinExpr struct {
+	Period time.Duration
+}
+
+func (r *RegisteredWithinExpr) Evaluate(user *models.UserInfo, ctx *EvaluationContext) (bool, error) {
+	return user.CreatedAt.After(time.Now().Add(-r.Period)), nil
+}
+
+// InactiveForExpr no access within a period before now expression, users that never accessed are inactive
+type InactiveForExpr struct {
+	Period time.Duration
+}
+
+func (i *InactiveForExpr) Evaluate(user *models.UserInfo, ctx *EvaluationContext) (bool, error) {
+	return !user.AccessTime.After(time.Now().Add(-i.Period)), nil
+}
+
+// parseRelativePeriod parses a period such as "12h", "30d" or "2w"
+func parseRelativePeriod(arg string) (time.Duration, error) {
+	period := strings.Trim(arg, "\"")
+	if len(period) < 2 {
+		return 0, fmt.Errorf("invalid period %q, expected a number followed by h, d or w", period)
+	}
+
+	count, err := strconv.Atoi(period[:len(period)-1])
+	if err != nil || count <= 0 {
+		return 0, fmt.Errorf("invalid period %q, expected a number followed by h, d or w", period)
+	}
+	switch period[len(period)-1] {
+	case 'h':
+		return time.Duration(count) * time.Hour, nil
+	case 'd':
+		return time.Duration(count) * 24 * time.Hour, nil
+	case 'w':
+		return time.Duration(count) * 7 * 24 * time.Hour, nil
+	}
+	return 0, fmt.Errorf("invalid period %q, expected a number followed by h, d or w", period)
+}
+
+// jsonPathStep is one step of a JSON path: an object key, an array index or a wildcard
+type jsonPathStep struct {
+	Key      string
+	Index    int
+	IsIndex  bool
+	Wildcard bool // Every element of an array or value of an object
+}
+
+// parseJSONPath parses a path such as "$.vscode.version", "$[0].os" or "$[*].os", the leading "$" is optional
+func parseJSONPath(arg string) ([]jsonPathStep, error) {
+	raw := strings.Trim(arg, "\"")
+	path := strings.TrimPrefix(raw, "$")
+	var steps []jsonPathStep
+	for i := 0; i < len(path); {
+		switch {
+		case path[i] == '[':
+			end := strings.IndexByte(path[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid JSON path %q: missing ']'", raw)
+			}
+			inner := path[i+1 : i+end]
+			if inner == "*" {
+				steps = append(steps, jsonPathStep{Wildcard: true})
+			} else {
+				index, err := strconv.Atoi(inner)
+				if err != nil || index < 0 {
+					return nil, fmt.Errorf("invalid JSON path %q: bad index %q", raw, inner)
+				}
+				steps = append(steps, jsonPathStep{Index: index, IsIndex: true})
+			}
+			i += end + 1
+		default:
+			if path[i] == '.' {
+				i++
+			}
+			end := strings.IndexAny(path[i:], ".[")
+			if end < 0 {
+				end = len(path) - i
+			}
+			key := path[i : i+end]
+			if key == "" {
+				return nil, fmt.Errorf("invalid JSON path %q: empty key", raw)
+			}
+			if key == "*" {
+				steps = append(steps, jsonPathStep{Wildcard: true})
+			} else {
+				steps = append(steps, jsonPathStep{Key: key})
+			}
+			i += end
+		}
+	}
+	return steps, nil
+}
+
+// devicesValues returns the values the path selects in the devices of the user
+func devicesValues(user *models.UserInfo, path []jsonPathStep) ([]interface{}, error) {
+	if strings.TrimSpace(user.Devices) == "" {
+		return nil, nil
+	}
+
+	var devices interface{}
+	if err := json.Unmarshal([]byte(user.Devices), &devices); err != nil {
+		return nil, fmt.Errorf("invalid devices of user %s: %w", user.ID, err)
+	}
+
+	values := []interface{}{devices}
+	for _, step := range path {
+		var next []interface{}
+		for _, value := range values {
+			switch node := value.(type) {
+			case map[string]interface{}:
+				if step.Wildcard {
+					for _, child := range node {
+						next = append(next, child)
+					}
+				} else if child, ok := node[step.Key]; ok && !step.IsIndex {
+					next = append(next, child)
+				}
+			case []interface{}:
+				if step.Wildcard {
+					next = append(next, node...)
+				} else if step.IsIndex && step.Index < len(node) {
+					next = append(next, node[step.Index])
+				}
+			}
+		}
+		values = next
+	}
+	return values, nil
+}
+
+// jsonScalarString formats a JSON scalar for comparison, false for objects and arrays
+func jsonScalarString(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	case nil:
+		return "null", true
+	}
+	return "", false
+}
+
+// DevicesHasExpr devices JSON path exists expression
+type DevicesHasExpr struct {
+	Path []jsonPathStep
+}
+
+func (d *DevicesHasExpr) Evaluate(user *models.UserInfo, ctx *EvaluationContext) (bool, error) {
+	values, err := devicesValues(user, d.Path)
+	if err != nil {
+		return false, err
+	}
+	for _, value := range values {
+		if value != nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// DevicesEqExpr devices JSON path equals expression, matches when any selected value equals
+type DevicesEqExpr struct {
+	Path  []jsonPathStep
+	Value string
+}
+
+func (d *DevicesEqExpr) Evaluate(user *models.UserInfo, ctx *EvaluationContext) (bool, error) {
+	values, err := devicesValues(user, d.Path)
+	if err != nil {
+		return false, err
+	}
+	for _, value := range values {
+		if s, ok := jsonScalarString(value); ok && s == d.Value {
+			return true, nil
+		}
+	}
+	return false, nil
+}
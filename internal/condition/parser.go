@@ -3,6 +3,7 @@ package condition
 import (
 	"fmt"
 	"quota-manager/internal/models"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -13,11 +14,35 @@ type QuotaQuerier interface {
 	QueryQuota(userID string) (float64, error)
 }
 
+// ModelQuotaQuerier interface for querying the quota a user can spend on a model
+type ModelQuotaQuerier interface {
+	QueryModelQuota(userID, model string) (float64, error)
+}
+
 // DatabaseQuerier interface for querying database information
 type DatabaseQuerier interface {
 	QueryEmployeeDepartment(employeeNumber string) ([]string, error)
 }
 
+// UsedQuotaQuerier interface for querying the quota a user has used
+type UsedQuotaQuerier interface {
+	QueryUsedQuota(userID string) (float64, error)
+}
+
+// UsageQuerier interface for querying the recorded monthly quota usage
+type UsageQuerier interface {
+	QueryMonthlyUsage(userID string, monthsAgo int) (float64, error)
+	// QueryMonthUsage returns the usage recorded for a month in YYYY-MM format
+	QueryMonthUsage(userID, yearMonth string) (float64, error)
+}
+
+// RechargeQuerier interface for querying the quota granted to a user by strategies
+type RechargeQuerier interface {
+	// QueryRechargeCount counts the grants of the named strategy to the user since the given time,
+	// all grants when the time is zero
+	QueryRechargeCount(userID, strategyName string, since time.Time) (int64, error)
+}
+
 // ConfigQuerier interface for accessing configuration
 type ConfigQuerier interface {
 	IsEmployeeSyncEnabled() bool
@@ -25,12 +50,25 @@ type ConfigQuerier interface {
 
 // EvaluationContext contains all dependencies needed for condition evaluation
 type EvaluationContext struct {
-	QuotaQuerier    QuotaQuerier
-	DatabaseQuerier DatabaseQuerier
-	ConfigQuerier   ConfigQuerier
+	QuotaQuerier      QuotaQuerier
+	ModelQuotaQuerier ModelQuotaQuerier
+	DatabaseQuerier   DatabaseQuerier
+	ConfigQuerier     ConfigQuerier
+	UsageQuerier      UsageQuerier
+	RechargeQuerier   RechargeQuerier
+	UsedQuotaQuerier  UsedQuotaQuerier
+	Location          *time.Location // Timezone of the absolute timestamps in conditions, UTC when nil
 	// Can add more dependencies here in the future (e.g., cache, etc.)
 }
 
+// localTime interprets the wall clock of a timestamp parsed from a condition in the configured timezone
+func (ctx *EvaluationContext) localTime(t time.Time) time.Time {
+	if ctx == nil || ctx.Location == nil {
+		return t
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), ctx.Location)
+}
+
 type Parser struct {
 	tokens []string
 	pos    int
@@ -99,7 +137,7 @@ type RegisterBeforeExpr struct {
 }
 
 func (r *RegisterBeforeExpr) Evaluate(user *models.UserInfo, ctx *EvaluationContext) (bool, error) {
-	return !user.CreatedAt.After(r.Timestamp), nil
+	return !user.CreatedAt.After(ctx.localTime(r.Timestamp)), nil
 }
 
 // AccessAfterExpr access time after expression
@@ -108,7 +146,7 @@ type AccessAfterExpr struct {
 }
 
 func (a *AccessAfterExpr) Evaluate(user *models.UserInfo, ctx *EvaluationContext) (bool, error) {
-	return user.AccessTime.After(a.Timestamp), nil
+	return user.AccessTime.After(ctx.localTime(a.Timestamp)), nil
 }
 
 // GithubStarExpr GitHub star expression
@@ -136,6 +174,19 @@ type QuotaLEExpr struct {
 }
 
 func (q *QuotaLEExpr) Evaluate(user *models.UserInfo, ctx *EvaluationContext) (bool, error) {
+	// A model compares against the quota the user can spend on that model
+	if q.Model != "" {
+		if ctx.ModelQuotaQuerier == nil {
+			return false, fmt.Errorf("model quota querier not available")
+		}
+
+		quota, err := ctx.ModelQuotaQuerier.QueryModelQuota(user.ID, q.Model)
+		if err != nil {
+			return false, err
+		}
+		return quota <= q.Amount, nil
+	}
+
 	if ctx.QuotaQuerier == nil {
 		return false, fmt.Errorf("quota querier not available")
 	}
@@ -147,6 +198,85 @@ func (q *QuotaLEExpr) Evaluate(user *models.UserInfo, ctx *EvaluationContext) (b
 	return quota <= q.Amount, nil
 }
 
+// UsedQuotaGEExpr used quota greater than or equal expression
+type UsedQuotaGEExpr struct {
+	Amount float64
+}
+
+func (u *UsedQuotaGEExpr) Evaluate(user *models.UserInfo, ctx *EvaluationContext) (bool, error) {
+	used, err := queryUsedQuota(user, ctx)
+	if err != nil {
+		return false, err
+	}
+	return used >= u.Amount, nil
+}
+
+// UsedQuotaLEExpr used quota less than or equal expression
+type UsedQuotaLEExpr struct {
+	Amount float64
+}
+
+func (u *UsedQuotaLEExpr) Evaluate(user *models.UserInfo, ctx *EvaluationContext) (bool, error) {
+	used, err := queryUsedQuota(user, ctx)
+	if err != nil {
+		return false, err
+	}
+	return used <= u.Amount, nil
+}
+
+// RemainingQuotaLEExpr remaining quota, total minus used, less than or equal expression
+type RemainingQuotaLEExpr struct {
+	Amount float64
+}
+
+func (r *RemainingQuotaLEExpr) Evaluate(user *models.UserInfo, ctx *EvaluationContext) (bool, error) {
+	if ctx.QuotaQuerier == nil {
+		return false, fmt.Errorf("quota querier not available")
+	}
+
+	total, err := ctx.QuotaQuerier.QueryQuota(user.ID)
+	if err != nil {
+		return false, err
+	}
+	used, err := queryUsedQuota(user, ctx)
+	if err != nil {
+		return false, err
+	}
+	return total-used <= r.Amount, nil
+}
+
+// queryUsedQuota queries the quota the user has used
+func queryUsedQuota(user *models.UserInfo, ctx *EvaluationContext) (float64, error) {
+	if ctx.UsedQuotaQuerier == nil {
+		return 0, fmt.Errorf("used quota querier not available")
+	}
+	return ctx.UsedQuotaQuerier.QueryUsedQuota(user.ID)
+}
+
+// MonthlyUsageGEExpr recorded monthly usage greater than or equal expression
+type MonthlyUsageGEExpr struct {
+	YearMonth string // Month in YYYY-MM format, empty for last month
+	Amount    float64
+}
+
+func (m *MonthlyUsageGEExpr) Evaluate(user *models.UserInfo, ctx *EvaluationContext) (bool, error) {
+	if ctx.UsageQuerier == nil {
+		return false, fmt.Errorf("usage querier not available")
+	}
+
+	var usage float64
+	var err error
+	if m.YearMonth == "" {
+		usage, err = ctx.UsageQuerier.QueryMonthlyUsage(user.ID, 1)
+	} else {
+		usage, err = ctx.UsageQuerier.QueryMonthUsage(user.ID, m.YearMonth)
+	}
+	if err != nil {
+		return false, err
+	}
+	return usage >= m.Amount, nil
+}
+
 // IsVipExpr VIP level expression
 type IsVipExpr struct {
 	Level int
@@ -216,17 +346,24 @@ func (f *FalseExpr) Evaluate(user *models.UserInfo, ctx *EvaluationContext) (boo
 // RechargeExpr already recharged expression
 type RechargeExpr struct {
 	StrategyName string
-	DB           interface {
-		Where(query interface{}, args ...interface{}) interface {
-			First(dest interface{}) interface{ Error() error }
-		}
-	}
+	WithinDays   int // Only grants of the last days count, 0 counts all grants
+	MinCount     int // Grants needed to match
 }
 
 func (r *RechargeExpr) Evaluate(user *models.UserInfo, ctx *EvaluationContext) (bool, error) {
-	var execute models.QuotaExecute
-	err := r.DB.Where("user = ? AND status = 'completed'", user.ID).First(&execute).Error()
-	return err == nil, nil
+	if ctx.RechargeQuerier == nil {
+		return false, fmt.Errorf("recharge querier not available")
+	}
+
+	var since time.Time
+	if r.WithinDays > 0 {
+		since = time.Now().AddDate(0, 0, -r.WithinDays)
+	}
+	count, err := ctx.RechargeQuerier.QueryRechargeCount(user.ID, r.StrategyName, since)
+	if err != nil {
+		return false, err
+	}
+	return count >= int64(r.MinCount), nil
 }
 
 func NewParser(condition string) *Parser {
@@ -475,6 +612,87 @@ func (p *Parser) buildFunction(funcName string, args []string) (Evaluator, error
 		}
 		return &AccessAfterExpr{Timestamp: timestamp}, nil
 
+	case "registered-within", "inactive-for":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s expects 1 argument, got %d", funcName, len(args))
+		}
+		period, err := parseRelativePeriod(args[0])
+		if err != nil {
+			return nil, err
+		}
+		if funcName == "registered-within" {
+			return &RegisteredWithinExpr{Period: period}, nil
+		}
+		return &InactiveForExpr{Period: period}, nil
+
+	case "field-eq":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("field-eq expects 2 arguments, got %d", len(args))
+		}
+		name, field, err := lookupUserField(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return &FieldEqExpr{Field: name, Value: fieldValueArg(field, args[1])}, nil
+
+	case "field-in":
+		if len(args) < 2 {
+			return nil, fmt.Errorf("field-in expects a field and at least 1 value, got %d arguments", len(args))
+		}
+		name, field, err := lookupUserField(args[0])
+		if err != nil {
+			return nil, err
+		}
+		values := make([]string, len(args)-1)
+		for i, arg := range args[1:] {
+			values[i] = fieldValueArg(field, arg)
+		}
+		return &FieldInExpr{Field: name, Values: values}, nil
+
+	case "field-regex":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("field-regex expects 2 arguments, got %d", len(args))
+		}
+		name, _, err := lookupUserField(args[0])
+		if err != nil {
+			return nil, err
+		}
+		pattern, err := regexp.Compile(strings.Trim(args[1], "\""))
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression: %w", err)
+		}
+		return &FieldRegexExpr{Field: name, Pattern: pattern}, nil
+
+	case "field-empty":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("field-empty expects 1 argument, got %d", len(args))
+		}
+		name, _, err := lookupUserField(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return &FieldEmptyExpr{Field: name}, nil
+
+	case "devices-has":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("devices-has expects 1 argument, got %d", len(args))
+		}
+		path, err := parseJSONPath(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return &DevicesHasExpr{Path: path}, nil
+
+	case "devices-eq":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("devices-eq expects 2 arguments, got %d", len(args))
+		}
+		path, err := parseJSONPath(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return &DevicesEqExpr{Path: path, Value: strings.Trim(args[1], "\"")}, nil
+
 	case "github-star":
 		if len(args) != 1 {
 			return nil, fmt.Errorf("github-star expects 1 argument, got %d", len(args))
@@ -491,6 +709,38 @@ func (p *Parser) buildFunction(funcName string, args []string) (Evaluator, error
 		}
 		return &QuotaLEExpr{Model: strings.Trim(args[0], "\""), Amount: amount}, nil
 
+	case "used-quota-ge", "used-quota-le", "remaining-quota-le":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s expects 1 argument, got %d", funcName, len(args))
+		}
+		amount, err := strconv.ParseFloat(args[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid amount: %w", err)
+		}
+		switch funcName {
+		case "used-quota-ge":
+			return &UsedQuotaGEExpr{Amount: amount}, nil
+		case "used-quota-le":
+			return &UsedQuotaLEExpr{Amount: amount}, nil
+		}
+		return &RemainingQuotaLEExpr{Amount: amount}, nil
+
+	case "monthly-usage-ge":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("monthly-usage-ge expects 2 arguments, got %d", len(args))
+		}
+		month := strings.Trim(args[0], "\"")
+		if month == "last-month" {
+			month = ""
+		} else if _, err := time.Parse("2006-01", month); err != nil {
+			return nil, fmt.Errorf("invalid month, expected YYYY-MM or last-month: %s", month)
+		}
+		amount, err := strconv.ParseFloat(args[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid amount: %w", err)
+		}
+		return &MonthlyUsageGEExpr{YearMonth: month, Amount: amount}, nil
+
 	case "is-vip":
 		if len(args) != 1 {
 			return nil, fmt.Errorf("is-vip expects 1 argument, got %d", len(args))
@@ -509,6 +759,30 @@ func (p *Parser) buildFunction(funcName string, args []string) (Evaluator, error
 		}
 		return &BelongToExpr{Orgs: orgs}, nil
 
+	case "recharged":
+		if len(args) < 1 || len(args) > 3 {
+			return nil, fmt.Errorf("recharged expects 1 to 3 arguments, got %d", len(args))
+		}
+		expr := &RechargeExpr{StrategyName: strings.Trim(args[0], "\""), MinCount: 1}
+		if expr.StrategyName == "" {
+			return nil, fmt.Errorf("recharged expects a strategy name")
+		}
+		if len(args) > 1 {
+			days, err := strconv.Atoi(args[1])
+			if err != nil || days < 0 {
+				return nil, fmt.Errorf("invalid within-days: %s", args[1])
+			}
+			expr.WithinDays = days
+		}
+		if len(args) > 2 {
+			count, err := strconv.Atoi(args[2])
+			if err != nil || count < 1 {
+				return nil, fmt.Errorf("invalid min-count: %s", args[2])
+			}
+			expr.MinCount = count
+		}
+		return expr, nil
+
 	case "true":
 		if len(args) != 0 {
 			return nil, fmt.Errorf("true expects 0 arguments, got %d", len(args))
@@ -535,15 +809,25 @@ func (p *Parser) currentToken() string {
 
 // CalcCondition calculate condition expression
 func CalcCondition(user *models.UserInfo, condition string, ctx *EvaluationContext) (bool, error) {
+	evaluator, err := ParseCondition(condition)
+	if err != nil {
+		return false, err
+	}
+
+	return evaluator.Evaluate(user, ctx)
+}
+
+// ParseCondition parses a condition once so that it can be evaluated for many users
+func ParseCondition(condition string) (Evaluator, error) {
 	if condition == "" {
-		return false, fmt.Errorf("empty condition is not allowed, use true() for always-true condition")
+		return nil, fmt.Errorf("empty condition is not allowed, use true() for always-true condition")
 	}
 
 	parser := NewParser(condition)
 	evaluator, err := parser.Parse()
 	if err != nil {
-		return false, fmt.Errorf("failed to parse condition: %w", err)
+		return nil, fmt.Errorf("failed to parse condition: %w", err)
 	}
 
-	return evaluator.Evaluate(user, ctx)
+	return evaluator, nil
 }
@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -37,13 +38,39 @@ type AiGatewayConfig struct {
 }
 
 type ServerConfig struct {
-	Port        int    `mapstructure:"port"`
-	Mode        string `mapstructure:"mode"`
-	TokenHeader string `mapstructure:"token_header"`
+	Port        int        `mapstructure:"port"`
+	Mode        string     `mapstructure:"mode"`
+	TokenHeader string     `mapstructure:"token_header"`
+	Auth        AuthConfig `mapstructure:"auth"`
+}
+
+// AuthConfig configures how user JWTs are verified
+type AuthConfig struct {
+	HS256Secret         string        `mapstructure:"hs256_secret"`          // Shared secret for HS256 tokens
+	JWKSFile            string        `mapstructure:"jwks_file"`             // Local JWKS file for RS256/ES256 tokens
+	JWKSURL             string        `mapstructure:"jwks_url"`              // Remote JWKS endpoint for RS256/ES256 tokens
+	JWKSRefreshInterval time.Duration `mapstructure:"jwks_refresh_interval"` // How often remote keys are refetched
+	Issuer              string        `mapstructure:"issuer"`                // Expected "iss" claim, skipped when empty
+	Audience            string        `mapstructure:"audience"`              // Expected "aud" claim, skipped when empty
+	Leeway              time.Duration `mapstructure:"leeway"`                // Clock skew tolerated for "exp"/"nbf"
+	RolesClaim          string        `mapstructure:"roles_claim"`           // Claim holding the caller roles, defaults to "roles"
+	AdminUsers          []string      `mapstructure:"admin_users"`           // User IDs always granted the admin role
+	APIKeyHeader        string        `mapstructure:"api_key_header"`        // Header carrying service API keys, defaults to "x-api-key"
 }
 
 type SchedulerConfig struct {
-	ScanInterval string `mapstructure:"scan_interval"`
+	ScanInterval               string        `mapstructure:"scan_interval"`
+	ReservationReleaseInterval string        `mapstructure:"reservation_release_interval"`
+	ExpiryInterval             string        `mapstructure:"expiry_interval"`
+	ExpiryBatchSize            int           `mapstructure:"expiry_batch_size"`
+	MonthlyUsageInterval       string        `mapstructure:"monthly_usage_interval"`
+	StrategyWorkers            int           `mapstructure:"strategy_workers"`         // Users evaluated and recharged concurrently by a strategy run
+	InstanceID                 string        `mapstructure:"instance_id"`              // Name of this instance in lock listings, defaults to hostname-pid
+	LeaderElectionInterval     time.Duration `mapstructure:"leader_election_interval"` // How often non-leaders try to take the scheduler leader lock
+	ExecutionRetryInterval     string        `mapstructure:"execution_retry_interval"` // Cron expression of the failed execution reconciler
+	ExecutionMaxAttempts       int           `mapstructure:"execution_max_attempts"`   // Recharge attempts per execution before giving up
+	ExecutionRetryBackoff      time.Duration `mapstructure:"execution_retry_backoff"`  // Wait before the first retry, doubled after each failure
+	ExecutionStaleAfter        time.Duration `mapstructure:"execution_stale_after"`    // Age of a processing execution considered interrupted
 }
 
 type VoucherConfig struct {
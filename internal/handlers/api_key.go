@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"quota-manager/internal/auth"
+	"quota-manager/internal/response"
+	"quota-manager/internal/services"
+	"quota-manager/internal/validation"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIKeyHandler handles service API key management HTTP requests
+type APIKeyHandler struct {
+	apiKeyService *services.APIKeyService
+}
+
+// NewAPIKeyHandler creates a new API key handler
+func NewAPIKeyHandler(apiKeyService *services.APIKeyService) *APIKeyHandler {
+	return &APIKeyHandler{
+		apiKeyService: apiKeyService,
+	}
+}
+
+// CreateAPIKey handles POST /quota-manager/api/v1/api-keys
+func (h *APIKeyHandler) CreateAPIKey(c *gin.Context) {
+	var req services.CreateAPIKeyRequest
+	if err := validation.ValidateJSON(c, &req); err != nil {
+		return
+	}
+
+	resp, err := h.apiKeyService.CreateAPIKey(&req, principalIdentity(c))
+	if err != nil {
+		if serviceErr, ok := err.(*services.ServiceError); ok && serviceErr.Code == services.ErrorValidationFailed {
+			c.JSON(http.StatusBadRequest, response.NewErrorResponse(response.BadRequestCode, serviceErr.Message))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, response.NewErrorResponse(response.DatabaseErrorCode,
+			"Failed to create API key: "+err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, response.NewSuccessResponse(resp, "API key created successfully, store the key now as it cannot be retrieved again"))
+}
+
+// ListAPIKeys handles GET /quota-manager/api/v1/api-keys
+func (h *APIKeyHandler) ListAPIKeys(c *gin.Context) {
+	var req PaginationQuery
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, response.NewErrorResponse(response.BadRequestCode,
+			"Invalid query parameters: "+err.Error()))
+		return
+	}
+
+	page, pageSize, err := validation.ValidatePageParams(req.Page, req.PageSize)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, response.NewErrorResponse(response.BadRequestCode, err.Error()))
+		return
+	}
+
+	keys, total, err := h.apiKeyService.ListAPIKeys(page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response.NewErrorResponse(response.DatabaseErrorCode,
+			"Failed to retrieve API keys: "+err.Error()))
+		return
+	}
+
+	data := gin.H{
+		"total":    total,
+		"api_keys": keys,
+	}
+
+	c.JSON(http.StatusOK, response.NewSuccessResponse(data, "API keys retrieved successfully"))
+}
+
+// RevokeAPIKey handles DELETE /quota-manager/api/v1/api-keys/:id
+func (h *APIKeyHandler) RevokeAPIKey(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, response.NewErrorResponse(response.InvalidAPIKeyIDCode, "Invalid API key ID format"))
+		return
+	}
+
+	if err := h.apiKeyService.RevokeAPIKey(id, principalIdentity(c)); err != nil {
+		if serviceErr, ok := err.(*services.ServiceError); ok {
+			switch serviceErr.Code {
+			case services.ErrorResourceNotFound:
+				c.JSON(http.StatusNotFound, response.NewErrorResponse(response.APIKeyNotFoundCode, serviceErr.Message))
+				return
+			case services.ErrorConflict:
+				c.JSON(http.StatusConflict, response.NewErrorResponse(response.APIKeyAlreadyRevokedCode, serviceErr.Message))
+				return
+			}
+		}
+		c.JSON(http.StatusInternalServerError, response.NewErrorResponse(response.DatabaseErrorCode,
+			"Failed to revoke API key: "+err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, response.NewSuccessResponse(nil, "API key revoked successfully"))
+}
+
+// principalIdentity returns the identity of the authenticated caller, empty on unprotected routes
+func principalIdentity(c *gin.Context) string {
+	if principal := auth.PrincipalFromContext(c); principal != nil {
+		return principal.Identity()
+	}
+	return ""
+}
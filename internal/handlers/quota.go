@@ -3,12 +3,13 @@ package handlers
 import (
 	"fmt"
 	"net/http"
+	"quota-manager/internal/auth"
 	"quota-manager/internal/config"
 	"quota-manager/internal/models"
 	"quota-manager/internal/response"
 	"quota-manager/internal/services"
 	"quota-manager/internal/validation"
-
+	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
@@ -16,15 +17,17 @@ import (
 
 // QuotaHandler handles quota-related HTTP requests
 type QuotaHandler struct {
-	quotaService *services.QuotaService
-	serverConfig *config.ServerConfig
+	quotaService  *services.QuotaService
+	serverConfig  *config.ServerConfig
+	tokenVerifier *auth.Verifier
 }
 
 // NewQuotaHandler creates a new quota handler
-func NewQuotaHandler(quotaService *services.QuotaService, serverConfig *config.ServerConfig) *QuotaHandler {
+func NewQuotaHandler(quotaService *services.QuotaService, serverConfig *config.ServerConfig, tokenVerifier *auth.Verifier) *QuotaHandler {
 	return &QuotaHandler{
-		quotaService: quotaService,
-		serverConfig: serverConfig,
+		quotaService:  quotaService,
+		serverConfig:  serverConfig,
+		tokenVerifier: tokenVerifier,
 	}
 }
 
@@ -40,7 +43,12 @@ func (h *QuotaHandler) getUserFromToken(c *gin.Context) (*models.AuthUser, error
 		return nil, fmt.Errorf("missing token in header: %s", tokenHeader)
 	}
 
-	return models.ParseUserInfoFromToken(token)
+	claims, err := h.tokenVerifier.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+
+	return models.AuthUserFromClaims(claims)
 }
 
 // getUserIDFromToken extracts user ID from token in request header
@@ -265,16 +273,246 @@ func (h *QuotaHandler) GetUserQuotaAuditRecordsAdmin(c *gin.Context) {
 	c.JSON(http.StatusOK, response.NewSuccessResponse(data, "User quota audit records retrieved successfully"))
 }
 
-// RegisterQuotaRoutes registers quota-related routes
-func RegisterQuotaRoutes(r *gin.RouterGroup, quotaHandler *QuotaHandler) {
+// DeductQuota handles POST /quota-manager/api/v1/quota/deduct
+func (h *QuotaHandler) DeductQuota(c *gin.Context) {
+	var req services.DeductQuotaRequest
+	if err := validation.ValidateJSON(c, &req); err != nil {
+		return
+	}
+	req.ReferenceID = strings.TrimSpace(req.ReferenceID)
+
+	var apiKeyID *int
+	if principal := auth.PrincipalFromContext(c); principal != nil {
+		apiKeyID = principal.APIKeyID
+	}
+
+	resp, err := h.quotaService.DeductQuota(&req, apiKeyID)
+	if err != nil {
+		if serviceErr, ok := err.(*services.ServiceError); ok && serviceErr.Code == services.ErrorConflict {
+			c.JSON(http.StatusConflict, response.NewErrorResponse(response.IdempotencyConflictCode, serviceErr.Message))
+			return
+		}
+		if strings.Contains(err.Error(), "insufficient quota") {
+			c.JSON(http.StatusBadRequest, response.NewErrorResponse(response.InsufficientQuotaCode, err.Error()))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, response.NewErrorResponse(response.InternalErrorCode,
+			"Failed to deduct quota: "+err.Error()))
+		return
+	}
+
+	message := "Quota deducted successfully"
+	if resp.Duplicate {
+		message = "Deduction already processed for this reference_id"
+	}
+	c.JSON(http.StatusOK, response.NewSuccessResponse(resp, message))
+}
+
+// ReserveQuota handles POST /quota-manager/api/v1/quota/reservations
+func (h *QuotaHandler) ReserveQuota(c *gin.Context) {
+	var req services.ReserveQuotaRequest
+	if err := validation.ValidateJSON(c, &req); err != nil {
+		return
+	}
+	req.ReferenceID = strings.TrimSpace(req.ReferenceID)
+
+	var apiKeyID *int
+	if principal := auth.PrincipalFromContext(c); principal != nil {
+		apiKeyID = principal.APIKeyID
+	}
+
+	resp, err := h.quotaService.ReserveQuota(&req, apiKeyID)
+	if err != nil {
+		if serviceErr, ok := err.(*services.ServiceError); ok && serviceErr.Code == services.ErrorConflict {
+			c.JSON(http.StatusConflict, response.NewErrorResponse(response.IdempotencyConflictCode, serviceErr.Message))
+			return
+		}
+		if strings.Contains(err.Error(), "insufficient quota") {
+			c.JSON(http.StatusBadRequest, response.NewErrorResponse(response.InsufficientQuotaCode, err.Error()))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, response.NewErrorResponse(response.InternalErrorCode,
+			"Failed to reserve quota: "+err.Error()))
+		return
+	}
+
+	message := "Quota reserved successfully"
+	if resp.Duplicate {
+		message = "Reservation already exists for this reference_id"
+	}
+	c.JSON(http.StatusOK, response.NewSuccessResponse(resp, message))
+}
+
+// GetReservation handles GET /quota-manager/api/v1/quota/reservations/:id
+func (h *QuotaHandler) GetReservation(c *gin.Context) {
+	id, ok := reservationIDParam(c)
+	if !ok {
+		return
+	}
+
+	reservation, err := h.quotaService.GetReservation(id)
+	if err != nil {
+		respondReservationError(c, err, "Failed to retrieve reservation: ")
+		return
+	}
+
+	c.JSON(http.StatusOK, response.NewSuccessResponse(reservation, "Reservation retrieved successfully"))
+}
+
+// CommitReservation handles POST /quota-manager/api/v1/quota/reservations/:id/commit
+func (h *QuotaHandler) CommitReservation(c *gin.Context) {
+	id, ok := reservationIDParam(c)
+	if !ok {
+		return
+	}
+
+	var req services.CommitReservationRequest
+	if err := validation.ValidateJSON(c, &req); err != nil {
+		return
+	}
+
+	resp, err := h.quotaService.CommitReservation(id, req.Amount)
+	if err != nil {
+		respondReservationError(c, err, "Failed to commit reservation: ")
+		return
+	}
+
+	message := "Reservation committed successfully"
+	if resp.Duplicate {
+		message = "Reservation was already committed"
+	}
+	c.JSON(http.StatusOK, response.NewSuccessResponse(resp, message))
+}
+
+// ReleaseReservation handles POST /quota-manager/api/v1/quota/reservations/:id/release
+func (h *QuotaHandler) ReleaseReservation(c *gin.Context) {
+	id, ok := reservationIDParam(c)
+	if !ok {
+		return
+	}
+
+	resp, err := h.quotaService.ReleaseReservation(id)
+	if err != nil {
+		respondReservationError(c, err, "Failed to release reservation: ")
+		return
+	}
+
+	message := "Reservation released successfully"
+	if resp.Duplicate {
+		message = "Reservation was already released"
+	}
+	c.JSON(http.StatusOK, response.NewSuccessResponse(resp, message))
+}
+
+// reservationIDParam parses the reservation ID path parameter, responding with 400 when invalid
+func reservationIDParam(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, response.NewErrorResponse(response.InvalidReservationIDCode, "Invalid reservation ID format"))
+		return 0, false
+	}
+	return id, true
+}
+
+// respondReservationError maps reservation service errors to HTTP responses
+func respondReservationError(c *gin.Context, err error, prefix string) {
+	if serviceErr, ok := err.(*services.ServiceError); ok {
+		switch serviceErr.Code {
+		case services.ErrorResourceNotFound:
+			c.JSON(http.StatusNotFound, response.NewErrorResponse(response.ReservationNotFoundCode, serviceErr.Message))
+			return
+		case services.ErrorConflict:
+			c.JSON(http.StatusConflict, response.NewErrorResponse(response.ReservationConflictCode, serviceErr.Message))
+			return
+		case services.ErrorValidationFailed:
+			c.JSON(http.StatusBadRequest, response.NewErrorResponse(response.BadRequestCode, serviceErr.Message))
+			return
+		}
+	}
+	c.JSON(http.StatusInternalServerError, response.NewErrorResponse(response.InternalErrorCode, prefix+err.Error()))
+}
+
+// AdminGrantQuota handles POST /quota-manager/api/v1/admin/quota/grant
+func (h *QuotaHandler) AdminGrantQuota(c *gin.Context) {
+	var req services.AdminQuotaRequest
+	if err := validation.ValidateJSON(c, &req); err != nil {
+		return
+	}
+
+	resp, err := h.quotaService.AdminGrantQuota(&req, principalIdentity(c))
+	respondAdminQuota(c, resp, err, "grant")
+}
+
+// AdminAdjustQuota handles POST /quota-manager/api/v1/admin/quota/adjust
+func (h *QuotaHandler) AdminAdjustQuota(c *gin.Context) {
+	var req services.AdminQuotaRequest
+	if err := validation.ValidateJSON(c, &req); err != nil {
+		return
+	}
+
+	resp, err := h.quotaService.AdminAdjustQuota(&req, principalIdentity(c))
+	respondAdminQuota(c, resp, err, "adjustment")
+}
+
+// respondAdminQuota writes the result of a grant or adjustment, per-user failures are part of a successful response
+func respondAdminQuota(c *gin.Context, resp *services.AdminQuotaResponse, err error, action string) {
+	if err != nil {
+		if serviceErr, ok := err.(*services.ServiceError); ok && serviceErr.Code == services.ErrorValidationFailed {
+			c.JSON(http.StatusBadRequest, response.NewErrorResponse(response.BadRequestCode, serviceErr.Message))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, response.NewErrorResponse(response.InternalErrorCode,
+			"Failed to apply quota "+action+": "+err.Error()))
+		return
+	}
+
+	message := fmt.Sprintf("Quota %s applied to %d users", action, resp.SuccessCount)
+	if resp.FailedCount > 0 {
+		message += fmt.Sprintf(", %d failed", resp.FailedCount)
+	}
+	c.JSON(http.StatusOK, response.NewSuccessResponse(resp, message))
+}
+
+// GetUserQuotaAdmin gets the quota of a specific user (admin and service function)
+func (h *QuotaHandler) GetUserQuotaAdmin(c *gin.Context) {
+	var uriReq UserIDUri
+	if err := c.ShouldBindUri(&uriReq); err != nil {
+		c.JSON(http.StatusBadRequest, response.NewErrorResponse(response.BadRequestCode, "Invalid user_id: "+err.Error()))
+		return
+	}
+
+	if err := validation.ValidateStruct(&uriReq); err != nil {
+		c.JSON(http.StatusBadRequest, response.NewErrorResponse(response.BadRequestCode, err.Error()))
+		return
+	}
+
+	quotaInfo, err := h.quotaService.GetUserQuota(uriReq.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response.NewErrorResponse(response.InternalErrorCode,
+			"Failed to retrieve user quota: "+err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, response.NewSuccessResponse(quotaInfo, "User quota retrieved successfully"))
+}
+
+// RegisterQuotaRoutes registers quota-related routes, adminAuth guards the routes reading other users' data
+// and deductAuth guards quota deduction and reservations
+func RegisterQuotaRoutes(r *gin.RouterGroup, quotaHandler *QuotaHandler, adminAuth, deductAuth gin.HandlerFunc) {
 	quota := r.Group("/quota")
 	{
 		quota.GET("", quotaHandler.GetUserQuota)
 		quota.GET("/audit", quotaHandler.GetQuotaAuditRecords)
 		quota.POST("/transfer-out", quotaHandler.TransferOut)
 		quota.POST("/transfer-in", quotaHandler.TransferIn)
+		quota.POST("/deduct", deductAuth, quotaHandler.DeductQuota)
+		quota.POST("/reservations", deductAuth, quotaHandler.ReserveQuota)
+		quota.GET("/reservations/:id", deductAuth, quotaHandler.GetReservation)
+		quota.POST("/reservations/:id/commit", deductAuth, quotaHandler.CommitReservation)
+		quota.POST("/reservations/:id/release", deductAuth, quotaHandler.ReleaseReservation)
 		// Handle empty user_id case (must be before parameterized route)
-		quota.GET("/audit/", quotaHandler.GetUserQuotaAuditRecordsAdminEmptyID)
-		quota.GET("/audit/:user_id", quotaHandler.GetUserQuotaAuditRecordsAdmin)
+		quota.GET("/audit/", adminAuth, quotaHandler.GetUserQuotaAuditRecordsAdminEmptyID)
+		quota.GET("/audit/:user_id", adminAuth, quotaHandler.GetUserQuotaAuditRecordsAdmin)
+		quota.GET("/users/:user_id", adminAuth, quotaHandler.GetUserQuotaAdmin)
 	}
 }
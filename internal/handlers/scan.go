@@ -2,8 +2,12 @@ package handlers
 
 import (
 	"net/http"
+	"strconv"
+
+	"quota-manager/internal/models"
 	"quota-manager/internal/response"
 	"quota-manager/internal/services"
+	"quota-manager/internal/validation"
 
 	"github.com/gin-gonic/gin"
 )
@@ -14,15 +18,17 @@ type ScanHandler struct {
 	unifiedPermissionService *services.UnifiedPermissionService
 	schedulerService         *services.SchedulerService
 	quotaService             *services.QuotaService
+	scanJobService           *services.ScanJobService
 }
 
 // NewScanHandler creates a new scan handler
-func NewScanHandler(strategyService *services.StrategyService, unifiedPermissionService *services.UnifiedPermissionService, schedulerService *services.SchedulerService, quotaService *services.QuotaService) *ScanHandler {
+func NewScanHandler(strategyService *services.StrategyService, unifiedPermissionService *services.UnifiedPermissionService, schedulerService *services.SchedulerService, quotaService *services.QuotaService, scanJobService *services.ScanJobService) *ScanHandler {
 	return &ScanHandler{
 		strategyService:          strategyService,
 		unifiedPermissionService: unifiedPermissionService,
 		schedulerService:         schedulerService,
 		quotaService:             quotaService,
+		scanJobService:           scanJobService,
 	}
 }
 
@@ -31,7 +37,14 @@ type ScanRequest struct {
 	Type string `json:"type" validate:"required,oneof=strategy employee-sync expire-quotas sync-quotas"`
 }
 
-// TriggerScan handles unified scan triggering
+// ScanJobQuery represents the query parameters of the job listing
+type ScanJobQuery struct {
+	PaginationQuery
+	Type   string `form:"type"`
+	Status string `form:"status"`
+}
+
+// TriggerScan handles unified scan triggering, the scan runs as a background job
 func (h *ScanHandler) TriggerScan(c *gin.Context) {
 	var req ScanRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -39,23 +52,88 @@ func (h *ScanHandler) TriggerScan(c *gin.Context) {
 		return
 	}
 
+	var run services.ScanJobFunc
 	switch req.Type {
-	case "strategy":
-		go h.strategyService.TraverseSingleStrategies()
-		c.JSON(http.StatusOK, response.NewSuccessResponse(nil, "Strategy scan triggered successfully"))
-	case "employee-sync":
-		if err := h.unifiedPermissionService.TriggerEmployeeSync(); err != nil {
-			c.JSON(http.StatusInternalServerError, response.NewErrorResponse(response.EmployeeSyncFailedCode, "Failed to sync employees: "+err.Error()))
-			return
+	case models.ScanJobTypeStrategy:
+		run = h.strategyService.ScanSingleStrategies
+	case models.ScanJobTypeEmployeeSync:
+		run = func(*services.ScanJobProgress) error {
+			return h.unifiedPermissionService.TriggerEmployeeSync()
 		}
-		c.JSON(http.StatusOK, response.NewSuccessResponse(nil, "Employee sync triggered successfully"))
-	case "expire-quotas":
-		go h.schedulerService.ExpireQuotasTask()
-		c.JSON(http.StatusOK, response.NewSuccessResponse(nil, "Quota expiry task triggered successfully"))
-	case "sync-quotas":
-		go h.quotaService.SyncQuotasWithAiGateway()
-		c.JSON(http.StatusOK, response.NewSuccessResponse(nil, "Quota sync task triggered successfully"))
+	case models.ScanJobTypeExpireQuotas:
+		run = h.schedulerService.ExpireQuotas
+	case models.ScanJobTypeSyncQuotas:
+		run = h.quotaService.SyncQuotas
 	default:
 		c.JSON(http.StatusBadRequest, response.NewErrorResponse(response.BadRequestCode, "Invalid scan type: "+req.Type))
+		return
+	}
+
+	job, err := h.scanJobService.StartJob(req.Type, principalIdentity(c), run)
+	if err != nil {
+		respondScanJobError(c, err, "Failed to start scan job: ")
+		return
+	}
+
+	c.JSON(http.StatusAccepted, response.NewSuccessResponse(job, "Scan job started successfully"))
+}
+
+// GetJob gets a scan job by ID
+func (h *ScanHandler) GetJob(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, response.NewErrorResponse(response.InvalidScanJobIDCode, "Invalid job ID format"))
+		return
+	}
+
+	job, err := h.scanJobService.GetJob(id)
+	if err != nil {
+		respondScanJobError(c, err, "Failed to retrieve scan job: ")
+		return
+	}
+
+	c.JSON(http.StatusOK, response.NewSuccessResponse(job, "Scan job retrieved successfully"))
+}
+
+// ListJobs lists scan jobs, latest first
+func (h *ScanHandler) ListJobs(c *gin.Context) {
+	var req ScanJobQuery
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, response.NewErrorResponse(response.BadRequestCode, "Invalid query parameters: "+err.Error()))
+		return
+	}
+
+	page, pageSize, err := validation.ValidatePageParams(req.Page, req.PageSize)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, response.NewErrorResponse(response.BadRequestCode, err.Error()))
+		return
+	}
+
+	jobs, total, err := h.scanJobService.ListJobs(req.Type, req.Status, page, pageSize)
+	if err != nil {
+		respondScanJobError(c, err, "Failed to retrieve scan jobs: ")
+		return
+	}
+
+	data := gin.H{
+		"total":   total,
+		"records": jobs,
+	}
+
+	c.JSON(http.StatusOK, response.NewSuccessResponse(data, "Scan jobs retrieved successfully"))
+}
+
+// respondScanJobError maps scan job service errors to HTTP responses
+func respondScanJobError(c *gin.Context, err error, prefix string) {
+	if serviceErr, ok := err.(*services.ServiceError); ok {
+		switch serviceErr.Code {
+		case services.ErrorResourceNotFound:
+			c.JSON(http.StatusNotFound, response.NewErrorResponse(response.ScanJobNotFoundCode, serviceErr.Message))
+			return
+		case services.ErrorConflict:
+			c.JSON(http.StatusConflict, response.NewErrorResponse(response.ScanJobConflictCode, serviceErr.Message))
+			return
+		}
 	}
+	c.JSON(http.StatusInternalServerError, response.NewErrorResponse(response.DatabaseErrorCode, prefix+err.Error()))
 }
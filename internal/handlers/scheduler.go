@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"net/http"
+
+	"quota-manager/internal/response"
+	"quota-manager/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SchedulerHandler handles scheduler administration HTTP requests
+type SchedulerHandler struct {
+	leaderElector *services.LeaderElector
+	jobs          *services.JobRegistry
+}
+
+// NewSchedulerHandler creates a new scheduler handler
+func NewSchedulerHandler(leaderElector *services.LeaderElector, jobs *services.JobRegistry) *SchedulerHandler {
+	return &SchedulerHandler{
+		leaderElector: leaderElector,
+		jobs:          jobs,
+	}
+}
+
+// GetLocks handles GET /quota-manager/api/v1/admin/scheduler/locks
+func (h *SchedulerHandler) GetLocks(c *gin.Context) {
+	status, err := h.leaderElector.LockHolders()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response.NewErrorResponse(response.DatabaseErrorCode, "Failed to retrieve scheduler locks: "+err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, response.NewSuccessResponse(status, "Scheduler locks retrieved successfully"))
+}
+
+// ListJobs handles GET /quota-manager/api/v1/scheduler/jobs
+func (h *SchedulerHandler) ListJobs(c *gin.Context) {
+	jobs, err := h.jobs.ListJobs()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response.NewErrorResponse(response.DatabaseErrorCode, "Failed to retrieve scheduler jobs: "+err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, response.NewSuccessResponse(jobs, "Scheduler jobs retrieved successfully"))
+}
+
+// PauseJob handles POST /quota-manager/api/v1/scheduler/jobs/:name/pause
+func (h *SchedulerHandler) PauseJob(c *gin.Context) {
+	job, err := h.jobs.PauseJob(c.Param("name"), principalIdentity(c))
+	if err != nil {
+		h.respondJobError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, response.NewSuccessResponse(job, "Scheduler job paused successfully"))
+}
+
+// ResumeJob handles POST /quota-manager/api/v1/scheduler/jobs/:name/resume
+func (h *SchedulerHandler) ResumeJob(c *gin.Context) {
+	job, err := h.jobs.ResumeJob(c.Param("name"))
+	if err != nil {
+		h.respondJobError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, response.NewSuccessResponse(job, "Scheduler job resumed successfully"))
+}
+
+// respondJobError maps a job registry error to its response
+func (h *SchedulerHandler) respondJobError(c *gin.Context, err error) {
+	if serviceErr, ok := err.(*services.ServiceError); ok && serviceErr.Code == services.ErrorResourceNotFound {
+		c.JSON(http.StatusNotFound, response.NewErrorResponse(response.SchedulerJobNotFoundCode, serviceErr.Message))
+		return
+	}
+	c.JSON(http.StatusInternalServerError, response.NewErrorResponse(response.DatabaseErrorCode, "Failed to update scheduler job: "+err.Error()))
+}
@@ -8,6 +8,8 @@ import (
 	"quota-manager/internal/services"
 	"quota-manager/internal/validation"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -59,8 +61,14 @@ func (h *StrategyHandler) CreateStrategy(c *gin.Context) {
 		}
 	}
 
-	// Server-side errors (database, service layer) should return 500
+	strategy.UpdatedBy = principalIdentity(c)
+
+	// Validation errors of the service return 400, server-side errors (database, service layer) return 500
 	if err := h.service.CreateStrategy(&strategy); err != nil {
+		if serviceErr, ok := err.(*services.ServiceError); ok && serviceErr.Code == services.ErrorValidationFailed {
+			c.JSON(http.StatusBadRequest, response.NewErrorResponse(response.BadRequestCode, serviceErr.Message))
+			return
+		}
 		c.JSON(http.StatusInternalServerError, response.NewErrorResponse(response.StrategyCreateFailedCode, "Failed to create strategy: "+err.Error()))
 		return
 	}
@@ -126,15 +134,27 @@ func (h *StrategyHandler) UpdateStrategy(c *gin.Context) {
 	}
 
 	type UpdateStrategyRequest struct {
-		Name           *string  `json:"name" validate:"omitempty,min=1,max=100"`
-		Title          *string  `json:"title" validate:"omitempty,min=1,max=200"`
-		Type           *string  `json:"type" validate:"omitempty,oneof=single periodic"`
-		Amount         *float64 `json:"amount" validate:"omitempty"`
-		PeriodicExpr   *string  `json:"periodic_expr" validate:"omitempty,cron"`
-		Model          *string  `json:"model" validate:"omitempty,min=1,max=100"`
-		Condition      *string  `json:"condition" validate:"omitempty"`
-		Status         *bool    `json:"status"`
-		MaxExecPerUser *int     `json:"max_exec_per_user" validate:"omitempty,gte=0"`
+		Name            *string    `json:"name" validate:"omitempty,min=1,max=100"`
+		Title           *string    `json:"title" validate:"omitempty,min=1,max=200"`
+		Type            *string    `json:"type" validate:"omitempty,oneof=single periodic"`
+		Amount          *float64   `json:"amount" validate:"omitempty"`
+		AmountExpr      *string    `json:"amount_expr"`
+		PeriodicExpr    *string    `json:"periodic_expr" validate:"omitempty,cron"`
+		Model           *string    `json:"model" validate:"omitempty,min=1,max=100"`
+		Condition       *string    `json:"condition" validate:"omitempty"`
+		TriggerEvent    *string    `json:"trigger_event"`
+		Status          *bool      `json:"status"`
+		MaxExecPerUser  *int       `json:"max_exec_per_user" validate:"omitempty,gte=0"`
+		Priority        *int       `json:"priority"`
+		ExclusionGroup  *string    `json:"exclusion_group" validate:"omitempty,max=100"`
+		MisfirePolicy   *string    `json:"misfire_policy" validate:"omitempty,oneof=skip run_once run_all"`
+		ExpiryPolicy    *string    `json:"expiry_policy" validate:"omitempty,oneof=end_of_month end_of_next_month relative fixed_date never"`
+		ExpiryDays      *int       `json:"expiry_days" validate:"omitempty,gte=0"`
+		ExpiryHours     *int       `json:"expiry_hours" validate:"omitempty,gte=0"`
+		FixedExpiryDate *time.Time `json:"fixed_expiry_date"`
+		StartTime       *time.Time `json:"start_time"`
+		EndTime         *time.Time `json:"end_time"`
+		TotalBudget     *float64   `json:"total_budget" validate:"omitempty,gte=0"`
 	}
 
 	var req UpdateStrategyRequest
@@ -182,6 +202,9 @@ func (h *StrategyHandler) UpdateStrategy(c *gin.Context) {
 	if req.Amount != nil {
 		updates["amount"] = *req.Amount
 	}
+	if req.AmountExpr != nil {
+		updates["amount_expr"] = *req.AmountExpr
+	}
 	if req.PeriodicExpr != nil {
 		updates["periodic_expr"] = *req.PeriodicExpr
 	}
@@ -191,14 +214,52 @@ func (h *StrategyHandler) UpdateStrategy(c *gin.Context) {
 	if req.Condition != nil {
 		updates["condition"] = *req.Condition
 	}
+	if req.TriggerEvent != nil {
+		updates["trigger_event"] = *req.TriggerEvent
+	}
 	if req.Status != nil {
 		updates["status"] = *req.Status
 	}
 	if req.MaxExecPerUser != nil {
 		updates["max_exec_per_user"] = *req.MaxExecPerUser
 	}
+	if req.Priority != nil {
+		updates["priority"] = *req.Priority
+	}
+	if req.ExclusionGroup != nil {
+		updates["exclusion_group"] = *req.ExclusionGroup
+	}
+	if req.MisfirePolicy != nil {
+		updates["misfire_policy"] = *req.MisfirePolicy
+	}
+	if req.ExpiryPolicy != nil {
+		updates["expiry_policy"] = *req.ExpiryPolicy
+	}
+	if req.ExpiryDays != nil {
+		updates["expiry_days"] = *req.ExpiryDays
+	}
+	if req.ExpiryHours != nil {
+		updates["expiry_hours"] = *req.ExpiryHours
+	}
+	if req.FixedExpiryDate != nil {
+		updates["fixed_expiry_date"] = *req.FixedExpiryDate
+	}
+	if req.StartTime != nil {
+		updates["start_time"] = *req.StartTime
+	}
+	if req.EndTime != nil {
+		updates["end_time"] = *req.EndTime
+	}
+	if req.TotalBudget != nil {
+		updates["total_budget"] = *req.TotalBudget
+	}
+	updates["updated_by"] = principalIdentity(c)
 
 	if err := h.service.UpdateStrategy(id, updates); err != nil {
+		if serviceErr, ok := err.(*services.ServiceError); ok && serviceErr.Code == services.ErrorValidationFailed {
+			c.JSON(http.StatusBadRequest, response.NewErrorResponse(response.BadRequestCode, serviceErr.Message))
+			return
+		}
 		c.JSON(http.StatusInternalServerError, response.NewErrorResponse(response.StrategyUpdateFailedCode, "Failed to update strategy: "+err.Error()))
 		return
 	}
@@ -215,7 +276,7 @@ func (h *StrategyHandler) EnableStrategy(c *gin.Context) {
 		return
 	}
 
-	if err := h.service.EnableStrategy(id); err != nil {
+	if err := h.service.EnableStrategy(id, principalIdentity(c)); err != nil {
 		c.JSON(http.StatusInternalServerError, response.NewErrorResponse(response.StrategyUpdateFailedCode, "Failed to enable strategy: "+err.Error()))
 		return
 	}
@@ -232,7 +293,7 @@ func (h *StrategyHandler) DisableStrategy(c *gin.Context) {
 		return
 	}
 
-	if err := h.service.DisableStrategy(id); err != nil {
+	if err := h.service.DisableStrategy(id, principalIdentity(c)); err != nil {
 		c.JSON(http.StatusInternalServerError, response.NewErrorResponse(response.StrategyUpdateFailedCode, "Failed to disable strategy: "+err.Error()))
 		return
 	}
@@ -263,6 +324,32 @@ func (h *StrategyHandler) TriggerScan(c *gin.Context) {
 	c.JSON(http.StatusOK, response.NewSuccessResponse(nil, "Strategy scan triggered successfully"))
 }
 
+// HandleEvent evaluates the strategies triggered by an inbound user event
+func (h *StrategyHandler) HandleEvent(c *gin.Context) {
+	var req services.StrategyEvent
+	if err := validation.ValidateJSON(c, &req); err != nil {
+		return
+	}
+
+	result, err := h.service.HandleEvent(req)
+	if err != nil {
+		if serviceErr, ok := err.(*services.ServiceError); ok {
+			switch serviceErr.Code {
+			case services.ErrorValidationFailed:
+				c.JSON(http.StatusBadRequest, response.NewErrorResponse(response.BadRequestCode, serviceErr.Message))
+				return
+			case services.ErrorResourceNotFound:
+				c.JSON(http.StatusNotFound, response.NewErrorResponse(response.EventUserNotFoundCode, serviceErr.Message))
+				return
+			}
+		}
+		c.JSON(http.StatusInternalServerError, response.NewErrorResponse(response.DatabaseErrorCode, "Failed to handle event: "+err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, response.NewSuccessResponse(result, "Event handled successfully"))
+}
+
 // GetStrategyExecuteRecords gets execution records for a strategy
 func (h *StrategyHandler) GetStrategyExecuteRecords(c *gin.Context) {
 	idStr := c.Param("id")
@@ -298,3 +385,232 @@ func (h *StrategyHandler) GetStrategyExecuteRecords(c *gin.Context) {
 
 	c.JSON(http.StatusOK, response.NewSuccessResponse(data, "Strategy execution records retrieved successfully"))
 }
+
+// PreviewStrategy evaluates an existing strategy or a strategy definition without granting quota
+func (h *StrategyHandler) PreviewStrategy(c *gin.Context) {
+	var req services.PreviewStrategyRequest
+	if err := validation.ValidateJSON(c, &req); err != nil {
+		return
+	}
+	if req.Strategy != nil {
+		if err := validation.ValidateStruct(req.Strategy); err != nil {
+			c.JSON(http.StatusBadRequest, response.NewErrorResponse(response.BadRequestCode, err.Error()))
+			return
+		}
+	}
+
+	result, err := h.service.PreviewStrategy(&req)
+	if err != nil {
+		if serviceErr, ok := err.(*services.ServiceError); ok {
+			switch serviceErr.Code {
+			case services.ErrorValidationFailed:
+				c.JSON(http.StatusBadRequest, response.NewErrorResponse(response.BadRequestCode, serviceErr.Message))
+				return
+			case services.ErrorResourceNotFound:
+				c.JSON(http.StatusNotFound, response.NewErrorResponse(response.StrategyNotFoundCode, serviceErr.Message))
+				return
+			}
+		}
+		c.JSON(http.StatusInternalServerError, response.NewErrorResponse(response.InternalErrorCode, "Failed to preview strategy: "+err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, response.NewSuccessResponse(result, "Strategy preview completed successfully"))
+}
+
+// PreviewCron returns the next fire times of a periodic expression
+func (h *StrategyHandler) PreviewCron(c *gin.Context) {
+	var req services.CronPreviewRequest
+	if err := validation.ValidateJSON(c, &req); err != nil {
+		return
+	}
+
+	preview, err := h.service.PreviewCron(&req)
+	if err != nil {
+		if serviceErr, ok := err.(*services.ServiceError); ok && serviceErr.Code == services.ErrorValidationFailed {
+			c.JSON(http.StatusBadRequest, response.NewErrorResponse(response.BadRequestCode, serviceErr.Message))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, response.NewErrorResponse(response.InternalErrorCode, "Failed to preview cron expression: "+err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, response.NewSuccessResponse(preview, "Cron preview completed successfully"))
+}
+
+// GetStrategyRuns gets the runs of a strategy with their summary statistics
+func (h *StrategyHandler) GetStrategyRuns(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, response.NewErrorResponse(response.InvalidStrategyIDCode, "Invalid strategy ID format"))
+		return
+	}
+
+	var req PaginationQuery
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, response.NewErrorResponse(response.BadRequestCode, "Invalid query parameters: "+err.Error()))
+		return
+	}
+
+	page, pageSize, err := validation.ValidatePageParams(req.Page, req.PageSize)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, response.NewErrorResponse(response.BadRequestCode, err.Error()))
+		return
+	}
+
+	runs, total, err := h.service.GetStrategyRuns(id, page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response.NewErrorResponse(response.DatabaseErrorCode, "Failed to retrieve strategy runs: "+err.Error()))
+		return
+	}
+
+	data := gin.H{
+		"total":   total,
+		"records": runs,
+	}
+
+	c.JSON(http.StatusOK, response.NewSuccessResponse(data, "Strategy runs retrieved successfully"))
+}
+
+// GetStrategyRun gets one run of a strategy by batch number
+func (h *StrategyHandler) GetStrategyRun(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, response.NewErrorResponse(response.InvalidStrategyIDCode, "Invalid strategy ID format"))
+		return
+	}
+
+	run, err := h.service.GetStrategyRun(id, c.Param("batch"))
+	if err != nil {
+		if serviceErr, ok := err.(*services.ServiceError); ok && serviceErr.Code == services.ErrorResourceNotFound {
+			c.JSON(http.StatusNotFound, response.NewErrorResponse(response.StrategyRunNotFoundCode, serviceErr.Message))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, response.NewErrorResponse(response.DatabaseErrorCode, "Failed to retrieve strategy run: "+err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, response.NewSuccessResponse(run, "Strategy run retrieved successfully"))
+}
+
+// RetryStrategyExecutions retries the failed and interrupted executions of a strategy
+func (h *StrategyHandler) RetryStrategyExecutions(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, response.NewErrorResponse(response.InvalidStrategyIDCode, "Invalid strategy ID format"))
+		return
+	}
+
+	result, err := h.service.RetryFailedExecutions(id)
+	if err != nil {
+		if serviceErr, ok := err.(*services.ServiceError); ok && serviceErr.Code == services.ErrorResourceNotFound {
+			c.JSON(http.StatusNotFound, response.NewErrorResponse(response.StrategyNotFoundCode, serviceErr.Message))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, response.NewErrorResponse(response.DatabaseErrorCode, "Failed to retry strategy executions: "+err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, response.NewSuccessResponse(result, "Strategy execution retry completed successfully"))
+}
+
+// GetStrategyVersions gets the version history of a strategy
+func (h *StrategyHandler) GetStrategyVersions(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, response.NewErrorResponse(response.InvalidStrategyIDCode, "Invalid strategy ID format"))
+		return
+	}
+
+	var req PaginationQuery
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, response.NewErrorResponse(response.BadRequestCode, "Invalid query parameters: "+err.Error()))
+		return
+	}
+
+	page, pageSize, err := validation.ValidatePageParams(req.Page, req.PageSize)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, response.NewErrorResponse(response.BadRequestCode, err.Error()))
+		return
+	}
+
+	versions, total, err := h.service.GetStrategyVersions(id, page, pageSize)
+	if err != nil {
+		respondStrategyVersionError(c, err, "Failed to retrieve strategy versions: ")
+		return
+	}
+
+	data := gin.H{
+		"total":   total,
+		"records": versions,
+	}
+
+	c.JSON(http.StatusOK, response.NewSuccessResponse(data, "Strategy versions retrieved successfully"))
+}
+
+// DiffStrategyVersions lists the fields changed between two versions of a strategy
+func (h *StrategyHandler) DiffStrategyVersions(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, response.NewErrorResponse(response.InvalidStrategyIDCode, "Invalid strategy ID format"))
+		return
+	}
+
+	var req struct {
+		From int `form:"from" binding:"required,min=1"`
+		To   int `form:"to" binding:"required,min=1"`
+	}
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, response.NewErrorResponse(response.BadRequestCode, "from and to versions are required: "+err.Error()))
+		return
+	}
+
+	diff, err := h.service.DiffStrategyVersions(id, req.From, req.To)
+	if err != nil {
+		respondStrategyVersionError(c, err, "Failed to diff strategy versions: ")
+		return
+	}
+
+	c.JSON(http.StatusOK, response.NewSuccessResponse(diff, "Strategy versions compared successfully"))
+}
+
+// RollbackStrategy restores a previous version of a strategy as its new version
+func (h *StrategyHandler) RollbackStrategy(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, response.NewErrorResponse(response.InvalidStrategyIDCode, "Invalid strategy ID format"))
+		return
+	}
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil || version < 1 {
+		c.JSON(http.StatusBadRequest, response.NewErrorResponse(response.BadRequestCode, "Invalid strategy version"))
+		return
+	}
+
+	strategy, err := h.service.RollbackStrategy(id, version, principalIdentity(c))
+	if err != nil {
+		respondStrategyVersionError(c, err, "Failed to roll back strategy: ")
+		return
+	}
+
+	c.JSON(http.StatusOK, response.NewSuccessResponse(strategy, "Strategy rolled back successfully"))
+}
+
+// respondStrategyVersionError writes the error of a strategy version operation
+func respondStrategyVersionError(c *gin.Context, err error, prefix string) {
+	if serviceErr, ok := err.(*services.ServiceError); ok {
+		switch serviceErr.Code {
+		case services.ErrorResourceNotFound:
+			code := response.StrategyNotFoundCode
+			if strings.HasPrefix(serviceErr.Message, "strategy version") {
+				code = response.StrategyVersionNotFoundCode
+			}
+			c.JSON(http.StatusNotFound, response.NewErrorResponse(code, serviceErr.Message))
+			return
+		case services.ErrorValidationFailed:
+			c.JSON(http.StatusBadRequest, response.NewErrorResponse(response.BadRequestCode, serviceErr.Message))
+			return
+		}
+	}
+	c.JSON(http.StatusInternalServerError, response.NewErrorResponse(response.DatabaseErrorCode, prefix+err.Error()))
+}
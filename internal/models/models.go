@@ -4,6 +4,8 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"reflect"
+	"sort"
 	"strings"
 	"time"
 
@@ -20,7 +22,8 @@ type AuthUser struct {
 	Phone   string `json:"phone"`
 }
 
-// parseUserInfoFromToken parses user info from JWT token
+// ParseUserInfoFromToken parses user info from JWT token without verifying its signature,
+// request handlers must use a verified token instead
 func ParseUserInfoFromToken(accessToken string) (*AuthUser, error) {
 	// Remove "Bearer " prefix if present
 	if strings.HasPrefix(accessToken, "Bearer ") {
@@ -51,43 +54,216 @@ func ParseUserInfoFromToken(accessToken string) (*AuthUser, error) {
 		return nil, fmt.Errorf("failed to unmarshal JWT claims: %w", err)
 	}
 
+	return AuthUserFromClaims(claims)
+}
+
+// AuthUserFromClaims builds user info from already decoded JWT claims
+func AuthUserFromClaims(claims map[string]interface{}) (*AuthUser, error) {
 	// Extract user ID
 	var userInfo AuthUser
-	if id, ok := claims["universal_id"].(string); ok {
+	if id, ok := claims["universal_id"].(string); ok && id != "" {
 		userInfo.ID = id
 	} else {
 		return nil, fmt.Errorf("user ID not found in JWT token")
 	}
 
+	userInfo.Name, _ = claims["name"].(string)
+	userInfo.StaffID, _ = claims["staffID"].(string)
+	userInfo.Github, _ = claims["github"].(string)
+	userInfo.Phone, _ = claims["phone"].(string)
+
 	return &userInfo, nil
 }
 
 // QuotaStrategy strategy table structure
 type QuotaStrategy struct {
-	ID             int       `gorm:"primaryKey;autoIncrement" json:"id"`
-	Name           string    `gorm:"uniqueIndex;not null" json:"name" validate:"required,min=1,max=100"`
-	Title          string    `gorm:"not null" json:"title" validate:"required,min=1,max=200"`
-	Type           string    `gorm:"not null" json:"type" validate:"required,oneof=single periodic"` // periodic/single
-	Amount         float64   `gorm:"not null" json:"amount"`
-	Model          string    `json:"model" validate:"omitempty,min=1,max=100"`
-	PeriodicExpr   string    `gorm:"column:periodic_expr" json:"periodic_expr" validate:"omitempty,cron"`
-	Condition      string    `json:"condition" validate:"omitempty"`
-	MaxExecPerUser int       `gorm:"column:max_exec_per_user;default:0" json:"max_exec_per_user" validate:"gte=0"`
-	Status         bool      `gorm:"not null;default:true" json:"status"` // true=enabled, false=disabled
-	CreateTime     time.Time `gorm:"autoCreateTime" json:"create_time"`
-	UpdateTime     time.Time `gorm:"autoUpdateTime" json:"update_time"`
+	ID              int        `gorm:"primaryKey;autoIncrement" json:"id"`
+	Name            string     `gorm:"uniqueIndex;not null" json:"name" validate:"required,min=1,max=100"`
+	Title           string     `gorm:"not null" json:"title" validate:"required,min=1,max=200"`
+	Type            string     `gorm:"not null" json:"type" validate:"required,oneof=single periodic"` // periodic/single
+	Amount          float64    `gorm:"not null" json:"amount"`
+	AmountExpr      string     `gorm:"column:amount_expr;type:text;not null;default:''" json:"amount_expr,omitempty"` // Computes the amount per user instead of Amount when set
+	Model           string     `json:"model" validate:"omitempty,min=1,max=100"`
+	PeriodicExpr    string     `gorm:"column:periodic_expr" json:"periodic_expr" validate:"omitempty,cron"`
+	Condition       string     `json:"condition" validate:"omitempty"`
+	TriggerEvent    string     `gorm:"column:trigger_event;not null;default:'';size:30" json:"trigger_event,omitempty"` // Single strategies with an event are evaluated per user when the event arrives, scans catch up missed events
+	MaxExecPerUser  int        `gorm:"column:max_exec_per_user;default:0" json:"max_exec_per_user" validate:"gte=0"`
+	Priority        int        `gorm:"not null;default:0" json:"priority"`                                                                                         // Higher priority strategies of an exclusion group are executed first
+	ExclusionGroup  string     `gorm:"column:exclusion_group;not null;default:'';size:100;index" json:"exclusion_group,omitempty" validate:"max=100"`              // A user gets at most one grant per group and period
+	MisfirePolicy   string     `gorm:"column:misfire_policy;not null;default:skip;size:20" json:"misfire_policy" validate:"omitempty,oneof=skip run_once run_all"` // Catch-up of periodic runs missed while the service was down
+	ExpiryPolicy    string     `gorm:"column:expiry_policy;not null;default:end_of_month;size:30" json:"expiry_policy" validate:"omitempty,oneof=end_of_month end_of_next_month relative fixed_date never"`
+	ExpiryDays      int        `gorm:"column:expiry_days;default:0" json:"expiry_days,omitempty" validate:"gte=0"`   // For relative policy
+	ExpiryHours     int        `gorm:"column:expiry_hours;default:0" json:"expiry_hours,omitempty" validate:"gte=0"` // For relative policy
+	FixedExpiryDate *time.Time `gorm:"column:fixed_expiry_date" json:"fixed_expiry_date,omitempty"`                  // For fixed_date policy
+	StartTime       *time.Time `gorm:"column:start_time" json:"start_time,omitempty"`                                // Nothing is granted before this time
+	EndTime         *time.Time `gorm:"column:end_time" json:"end_time,omitempty"`                                    // Nothing is granted from this time on
+	TotalBudget     float64    `gorm:"column:total_budget;not null;default:0" json:"total_budget" validate:"gte=0"`  // Quota the strategy may grant in total, 0 for unlimited
+	UsedBudget      float64    `gorm:"column:used_budget;not null;default:0" json:"used_budget"`                     // Quota granted or being granted against the total budget
+	RemainingBudget *float64   `gorm:"-" json:"remaining_budget,omitempty"`                                          // Set by GetStrategy when the strategy has a total budget
+	Status          bool       `gorm:"not null;default:true" json:"status"`                                          // true=enabled, false=disabled
+	Version         int        `gorm:"not null;default:1" json:"version"`                                            // Current version, see StrategyVersion
+	UpdatedBy       string     `gorm:"column:updated_by;size:255" json:"updated_by,omitempty"`                       // Author of the current version
+	CreateTime      time.Time  `gorm:"autoCreateTime" json:"create_time"`
+	UpdateTime      time.Time  `gorm:"autoUpdateTime" json:"update_time"`
 }
 
 // QuotaExecute execution status table
 type QuotaExecute struct {
-	ID          int       `gorm:"primaryKey;autoIncrement" json:"id"`
-	StrategyID  int       `gorm:"not null;index" json:"strategy_id"`
-	User        string    `gorm:"column:user_id;not null;index" json:"user"`
-	BatchNumber string    `gorm:"not null;index" json:"batch_number"`
-	Status      string    `gorm:"not null" json:"status"`
-	ExpiryDate  time.Time `gorm:"not null" json:"expiry_date"`
-	CreateTime  time.Time `gorm:"autoCreateTime" json:"create_time"`
-	UpdateTime  time.Time `gorm:"autoUpdateTime" json:"update_time"`
+	ID              int        `gorm:"primaryKey;autoIncrement" json:"id"`
+	StrategyID      int        `gorm:"not null;index" json:"strategy_id"`
+	StrategyVersion int        `gorm:"not null;default:0" json:"strategy_version"` // Strategy version that granted the quota, 0 before versioning
+	Amount          float64    `gorm:"not null;default:0" json:"amount"`           // Amount granted to the user, 0 before amount expressions
+	User            string     `gorm:"column:user_id;not null;index" json:"user"`
+	BatchNumber     string     `gorm:"not null;index" json:"batch_number"`
+	Status          string     `gorm:"not null" json:"status"`
+	ExpiryDate      time.Time  `gorm:"not null" json:"expiry_date"`
+	Attempts        int        `gorm:"not null;default:0" json:"attempts"`                      // Recharge attempts made, including retries
+	NextRetryTime   *time.Time `gorm:"column:next_retry_time" json:"next_retry_time,omitempty"` // Earliest time a failed execution is retried
+	LastError       string     `gorm:"type:text" json:"last_error,omitempty"`
+	CreateTime      time.Time  `gorm:"autoCreateTime" json:"create_time"`
+	UpdateTime      time.Time  `gorm:"autoUpdateTime" json:"update_time"`
+}
+
+// Quota execution status constants
+const (
+	ExecuteStatusProcessing = "processing"
+	ExecuteStatusCompleted  = "completed"
+	ExecuteStatusFailed     = "failed"
+	ExecuteStatusSuperseded = "superseded" // Failed single execution made obsolete by a later completed one
+)
+
+// StrategyRun summarizes one execution of a strategy over a batch of users
+type StrategyRun struct {
+	ID                 int                 `gorm:"primaryKey;autoIncrement" json:"id"`
+	StrategyID         int                 `gorm:"not null;uniqueIndex:idx_strategy_run_batch" json:"strategy_id"`
+	StrategyName       string              `gorm:"not null;size:100" json:"strategy_name"`
+	BatchNumber        string              `gorm:"not null;size:20;uniqueIndex:idx_strategy_run_batch" json:"batch_number"` // Same batch number as the QuotaExecute records of the run
+	Trigger            string              `gorm:"not null;size:20" json:"trigger"`                                         // cron/scan/manual/event/misfire
+	Status             string              `gorm:"not null;size:20" json:"status"`                                          // RUNNING/COMPLETED
+	StartTime          time.Time           `gorm:"not null" json:"start_time"`
+	EndTime            *time.Time          `json:"end_time,omitempty"`
+	UsersEvaluated     int                 `gorm:"not null;default:0" json:"users_evaluated"`     // Users whose condition was evaluated
+	UsersMatched       int                 `gorm:"not null;default:0" json:"users_matched"`       // Users matching the condition
+	UsersGranted       int                 `gorm:"not null;default:0" json:"users_granted"`       // Users recharged successfully
+	SkippedMaxExec     int                 `gorm:"not null;default:0" json:"skipped_max_exec"`    // Users skipped for reaching max_exec_per_user
+	SkippedBudget      int                 `gorm:"not null;default:0" json:"skipped_budget"`      // Matched users not granted because the total budget was used up
+	SkippedExclusion   int                 `gorm:"not null;default:0" json:"skipped_exclusion"`   // Users skipped for a grant by another strategy of the exclusion group
+	ConditionErrors    int                 `gorm:"not null;default:0" json:"condition_errors"`    // Users whose condition could not be evaluated
+	RechargeFailures   int                 `gorm:"not null;default:0" json:"recharge_failures"`   // Matched users whose recharge failed
+	TotalAmount        float64             `gorm:"not null;default:0" json:"total_amount"`        // Quota granted by the run
+	DurationMs         int64               `gorm:"not null;default:0" json:"duration_ms"`         // Time spent by the latest execution of the run
+	UsersPerSecond     float64             `gorm:"not null;default:0" json:"users_per_second"`    // Users evaluated per second by the latest execution
+	ExclusionConflicts string              `gorm:"column:exclusion_conflicts;type:text" json:"-"` // JSON of Conflicts
+	Conflicts          []ExclusionConflict `gorm:"-" json:"exclusion_conflicts,omitempty"`        // Exclusion conflicts of the latest execution, at most MaxRunConflicts
+	CreateTime         time.Time           `gorm:"autoCreateTime" json:"create_time"`
+	UpdateTime         time.Time           `gorm:"autoUpdateTime" json:"update_time"`
+}
+
+// MaxRunConflicts bounds the exclusion conflicts recorded by a strategy run
+const MaxRunConflicts = 100
+
+// ExclusionConflict records that a user was skipped for the grant of another strategy of the exclusion group
+type ExclusionConflict struct {
+	UserID       string `json:"user_id"`
+	StrategyID   int    `json:"strategy_id"` // Strategy that granted the user in the period
+	StrategyName string `json:"strategy_name"`
+	Priority     int    `json:"priority"`
+}
+
+// TableName sets the table name
+func (StrategyRun) TableName() string {
+	return "strategy_run"
+}
+
+// Strategy run trigger constants
+const (
+	StrategyRunTriggerCron    = "cron"    // Periodic strategy fired by its cron expression
+	StrategyRunTriggerScan    = "scan"    // Single strategy scan, scheduled or requested
+	StrategyRunTriggerManual  = "manual"  // Direct execution
+	StrategyRunTriggerEvent   = "event"   // Strategy event for one user
+	StrategyRunTriggerMisfire = "misfire" // Catch-up of a periodic run missed while the service was down
+)
+
+// Strategy trigger events
+const (
+	StrategyEventUserRegistered    = "user_registered"
+	StrategyEventGithubStarAdded   = "github_star_added"
+	StrategyEventEmployeeJoined    = "employee_joined"
+	StrategyEventDepartmentChanged = "department_changed"
+)
+
+// IsStrategyEvent checks whether the event is one strategies can be triggered by
+func IsStrategyEvent(event string) bool {
+	switch event {
+	case StrategyEventUserRegistered, StrategyEventGithubStarAdded, StrategyEventEmployeeJoined, StrategyEventDepartmentChanged:
+		return true
+	default:
+		return false
+	}
+}
+
+// Strategy run status constants
+const (
+	StrategyRunStatusRunning   = "RUNNING"
+	StrategyRunStatusCompleted = "COMPLETED"
+)
+
+// StrategyVersion records one version of a strategy's definition
+type StrategyVersion struct {
+	ID            int       `gorm:"primaryKey;autoIncrement" json:"id"`
+	StrategyID    int       `gorm:"not null;uniqueIndex:idx_strategy_version" json:"strategy_id"`
+	Version       int       `gorm:"not null;uniqueIndex:idx_strategy_version" json:"version"`
+	Action        string    `gorm:"not null;size:20" json:"action"`       // create/update/rollback
+	SourceVersion *int      `json:"source_version,omitempty"`             // Version restored by a rollback
+	Author        string    `gorm:"size:255" json:"author,omitempty"`     // Principal who made the change, empty for internal changes
+	Definition    string    `gorm:"type:text;not null" json:"definition"` // JSON StrategyDefinition of the version
+	Changes       string    `gorm:"type:text" json:"changes,omitempty"`   // JSON []StrategyFieldChange against the previous version
+	CreateTime    time.Time `gorm:"autoCreateTime" json:"create_time"`
+}
+
+// TableName sets the table name
+func (StrategyVersion) TableName() string {
+	return "strategy_version"
+}
+
+// Strategy version action constants
+const (
+	StrategyVersionActionCreate   = "create"
+	StrategyVersionActionUpdate   = "update"
+	StrategyVersionActionRollback = "rollback"
+)
+
+// StrategyDefinition is the versioned part of a strategy, everything but its identity,
+// bookkeeping and used budget
+type StrategyDefinition struct {
+	Name            string     `json:"name"`
+	Title           string     `json:"title"`
+	Type            string     `json:"type"`
+	Amount          float64    `json:"amount"`
+	AmountExpr      string     `json:"amount_expr"`
+	Model           string     `json:"model"`
+	PeriodicExpr    string     `json:"periodic_expr"`
+	Condition       string     `json:"condition"`
+	TriggerEvent    string     `json:"trigger_event"`
+	MaxExecPerUser  int        `json:"max_exec_per_user"`
+	Priority        int        `json:"priority"`
+	ExclusionGroup  string     `json:"exclusion_group"`
+	MisfirePolicy   string     `json:"misfire_policy"`
+	ExpiryPolicy    string     `json:"expiry_policy"`
+	ExpiryDays      int        `json:"expiry_days"`
+	ExpiryHours     int        `json:"expiry_hours"`
+	FixedExpiryDate *time.Time `json:"fixed_expiry_date"`
+	StartTime       *time.Time `json:"start_time"`
+	EndTime         *time.Time `json:"end_time"`
+	TotalBudget     float64    `json:"total_budget"`
+	Status          bool       `json:"status"`
+}
+
+// StrategyFieldChange is one field that differs between two strategy versions
+type StrategyFieldChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
 }
 
 // UserInfo user information table
@@ -116,6 +292,7 @@ type UserInfo struct {
 type Quota struct {
 	ID         int       `gorm:"primaryKey;autoIncrement" json:"id"`
 	UserID     string    `gorm:"not null;index;size:255" json:"user_id"`
+	Model      string    `gorm:"not null;default:'';size:100" json:"model,omitempty"` // Empty for the general pool
 	Amount     float64   `gorm:"not null" json:"amount"`
 	ExpiryDate time.Time `gorm:"not null;index" json:"expiry_date"`
 	Status     string    `gorm:"not null;default:VALID;index;size:20" json:"status"` // VALID/EXPIRED
@@ -125,24 +302,31 @@ type Quota struct {
 
 // QuotaAudit quota change audit log
 type QuotaAudit struct {
-	ID           int       `gorm:"primaryKey;autoIncrement" json:"id"`
-	UserID       string    `gorm:"not null;index;size:255" json:"user_id"`
-	Amount       float64   `gorm:"not null" json:"amount"`                  // positive or negative
-	Operation    string    `gorm:"not null;index;size:50" json:"operation"` // RECHARGE/TRANSFER_IN/TRANSFER_OUT
-	VoucherCode  string    `gorm:"index;size:1000" json:"voucher_code,omitempty"`
-	RelatedUser  string    `gorm:"size:255" json:"related_user,omitempty"`
-	StrategyID   *int      `gorm:"index" json:"strategy_id,omitempty"`            // Strategy ID for RECHARGE operations
-	StrategyName string    `gorm:"index;size:100" json:"strategy_name,omitempty"` // Strategy name for RECHARGE operations
-	ExpiryDate   time.Time `gorm:"not null" json:"expiry_date"`
-	Details      string    `gorm:"type:text" json:"details,omitempty"` // JSON string with detailed operation info
-	CreateTime   time.Time `gorm:"autoCreateTime;index" json:"create_time"`
+	ID              int       `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID          string    `gorm:"not null;index;size:255" json:"user_id"`
+	Amount          float64   `gorm:"not null" json:"amount"`                  // positive or negative
+	Operation       string    `gorm:"not null;index;size:50" json:"operation"` // RECHARGE/TRANSFER_IN/TRANSFER_OUT
+	VoucherCode     string    `gorm:"index;size:1000" json:"voucher_code,omitempty"`
+	RelatedUser     string    `gorm:"size:255" json:"related_user,omitempty"`
+	StrategyID      *int      `gorm:"index" json:"strategy_id,omitempty"`                  // Strategy ID for RECHARGE operations
+	StrategyName    string    `gorm:"index;size:100" json:"strategy_name,omitempty"`       // Strategy name for RECHARGE operations
+	StrategyVersion int       `gorm:"default:0" json:"strategy_version,omitempty"`         // Strategy version for RECHARGE operations
+	APIKeyID        *int      `gorm:"column:api_key_id;index" json:"api_key_id,omitempty"` // Service API key that made the call
+	Operator        string    `gorm:"index;size:255" json:"operator,omitempty"`            // Administrator who made a manual change
+	ExpiryDate      time.Time `gorm:"not null" json:"expiry_date"`
+	Details         string    `gorm:"type:text" json:"details,omitempty"` // JSON string with detailed operation info
+	CreateTime      time.Time `gorm:"autoCreateTime;index" json:"create_time"`
 }
 
 // QuotaAuditDetails contains detailed information about quota operations
 type QuotaAuditDetails struct {
-	Operation string                 `json:"operation"`
-	Summary   QuotaAuditSummary      `json:"summary"`
-	Items     []QuotaAuditDetailItem `json:"items,omitempty"`
+	Operation   string                 `json:"operation"`
+	ReferenceID string                 `json:"reference_id,omitempty"` // Idempotency key for DEDUCT operations
+	Reason      string                 `json:"reason,omitempty"`       // Reason for ADMIN_GRANT/ADMIN_ADJUST operations
+	Model       string                 `json:"model,omitempty"`
+	AmountExpr  string                 `json:"amount_expr,omitempty"` // Amount expression of the strategy that computed a RECHARGE amount
+	Summary     QuotaAuditSummary      `json:"summary"`
+	Items       []QuotaAuditDetailItem `json:"items,omitempty"`
 }
 
 // QuotaAuditSummary contains summary information
@@ -173,6 +357,67 @@ type VoucherRedemption struct {
 	CreateTime  time.Time `gorm:"autoCreateTime" json:"create_time"`
 }
 
+// QuotaPortion is an amount taken from or returned to the quota rows of one pool and expiry date
+type QuotaPortion struct {
+	Model      string    `json:"model,omitempty"`
+	Amount     float64   `json:"amount"`
+	ExpiryDate time.Time `json:"expiry_date"`
+}
+
+// QuotaReservation holds quota for a pending operation until it is committed or released
+type QuotaReservation struct {
+	ID              int        `gorm:"primaryKey;autoIncrement" json:"id"`
+	ReferenceID     string     `gorm:"column:reference_id;uniqueIndex;not null;size:255" json:"reference_id"`
+	UserID          string     `gorm:"not null;index;size:255" json:"user_id"`
+	Amount          float64    `gorm:"not null" json:"amount"`                                             // Held amount
+	CommittedAmount float64    `gorm:"column:committed_amount;not null;default:0" json:"committed_amount"` // Final amount charged on commit
+	Status          string     `gorm:"not null;index;size:20" json:"status"`                               // HELD/COMMITTED/RELEASED/EXPIRED
+	Reason          string     `gorm:"size:100" json:"reason,omitempty"`
+	Model           string     `gorm:"size:100" json:"model,omitempty"`
+	Items           string     `gorm:"type:text" json:"-"` // JSON list of QuotaPortion taken from quota rows
+	ExpiresAt       time.Time  `gorm:"column:expires_at;not null;index" json:"expires_at"`
+	SettledAt       *time.Time `gorm:"column:settled_at" json:"settled_at,omitempty"`
+	APIKeyID        *int       `gorm:"column:api_key_id" json:"api_key_id,omitempty"`
+	CreateTime      time.Time  `gorm:"autoCreateTime" json:"create_time"`
+	UpdateTime      time.Time  `gorm:"autoUpdateTime" json:"update_time"`
+}
+
+// MarshalItems stores the quota portions taken by the reservation
+func (r *QuotaReservation) MarshalItems(items []QuotaPortion) error {
+	jsonBytes, err := json.Marshal(items)
+	if err != nil {
+		return fmt.Errorf("failed to marshal reservation items: %w", err)
+	}
+	r.Items = string(jsonBytes)
+	return nil
+}
+
+// UnmarshalItems returns the quota portions taken by the reservation
+func (r *QuotaReservation) UnmarshalItems() ([]QuotaPortion, error) {
+	if r.Items == "" {
+		return nil, nil
+	}
+	var items []QuotaPortion
+	if err := json.Unmarshal([]byte(r.Items), &items); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal reservation items: %w", err)
+	}
+	return items, nil
+}
+
+// QuotaDeduction records processed deductions, the unique reference ID makes deductions idempotent
+type QuotaDeduction struct {
+	ID             int       `gorm:"primaryKey;autoIncrement" json:"id"`
+	ReferenceID    string    `gorm:"column:reference_id;uniqueIndex;not null;size:255" json:"reference_id"`
+	UserID         string    `gorm:"not null;index;size:255" json:"user_id"`
+	Amount         float64   `gorm:"not null" json:"amount"`
+	Reason         string    `gorm:"size:100" json:"reason,omitempty"`
+	Model          string    `gorm:"size:100" json:"model,omitempty"`
+	RemainingQuota float64   `gorm:"column:remaining_quota;not null;default:0" json:"remaining_quota"`
+	AuditID        *int      `gorm:"column:audit_id" json:"audit_id,omitempty"`
+	APIKeyID       *int      `gorm:"column:api_key_id" json:"api_key_id,omitempty"`
+	CreateTime     time.Time `gorm:"autoCreateTime" json:"create_time"`
+}
+
 // TableName sets the table name
 func (QuotaStrategy) TableName() string {
 	return "quota_strategy"
@@ -198,6 +443,14 @@ func (VoucherRedemption) TableName() string {
 	return "voucher_redemption"
 }
 
+func (QuotaDeduction) TableName() string {
+	return "quota_deduction"
+}
+
+func (QuotaReservation) TableName() string {
+	return "quota_reservation"
+}
+
 // EmployeeDepartment represents the employee department mapping
 type EmployeeDepartment struct {
 	ID                 int       `gorm:"primaryKey;autoIncrement" json:"id"`
@@ -389,6 +642,283 @@ func (s *QuotaStrategy) Disable() {
 	s.Status = false
 }
 
+// ValidateExpiryPolicy checks that the settings required by the expiry policy are present and
+// that a fixed expiry date is in the future, as required when the date is set
+func (s *QuotaStrategy) ValidateExpiryPolicy(now time.Time) error {
+	if err := s.ValidateExpiryPolicySettings(); err != nil {
+		return err
+	}
+	if s.ExpiryPolicy == ExpiryPolicyFixedDate && !s.FixedExpiryDate.After(now) {
+		return fmt.Errorf("fixed_expiry_date must be in the future")
+	}
+	return nil
+}
+
+// ValidateExpiryPolicySettings checks that the settings required by the expiry policy are present,
+// a fixed expiry date that has passed is accepted
+func (s *QuotaStrategy) ValidateExpiryPolicySettings() error {
+	switch s.ExpiryPolicy {
+	case "", ExpiryPolicyEndOfMonth, ExpiryPolicyEndOfNextMonth, ExpiryPolicyNever:
+		return nil
+	case ExpiryPolicyRelative:
+		if s.ExpiryDays < 0 || s.ExpiryHours < 0 {
+			return fmt.Errorf("expiry_days and expiry_hours must not be negative")
+		}
+		if s.ExpiryDays == 0 && s.ExpiryHours == 0 {
+			return fmt.Errorf("expiry_days or expiry_hours is required for relative expiry policy")
+		}
+		return nil
+	case ExpiryPolicyFixedDate:
+		if s.FixedExpiryDate == nil {
+			return fmt.Errorf("fixed_expiry_date is required for fixed_date expiry policy")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown expiry policy: %s", s.ExpiryPolicy)
+	}
+}
+
+// ValidateWindow checks that the validity window ends after it starts
+func (s *QuotaStrategy) ValidateWindow() error {
+	if s.StartTime != nil && s.EndTime != nil && !s.EndTime.After(*s.StartTime) {
+		return fmt.Errorf("end_time must be after start_time")
+	}
+	if s.TotalBudget < 0 {
+		return fmt.Errorf("total_budget must not be negative")
+	}
+	return nil
+}
+
+// ValidateTriggerEvent checks that only single strategies are triggered by events
+func (s *QuotaStrategy) ValidateTriggerEvent() error {
+	if s.TriggerEvent == "" {
+		return nil
+	}
+	if !IsStrategyEvent(s.TriggerEvent) {
+		return fmt.Errorf("unknown trigger event: %s", s.TriggerEvent)
+	}
+	if s.Type != "single" {
+		return fmt.Errorf("trigger_event is only supported for single strategies")
+	}
+	return nil
+}
+
+// InWindow checks whether the strategy may grant quota at the given time
+func (s *QuotaStrategy) InWindow(now time.Time) bool {
+	if s.StartTime != nil && now.Before(*s.StartTime) {
+		return false
+	}
+	if s.EndTime != nil && !now.Before(*s.EndTime) {
+		return false
+	}
+	return true
+}
+
+// SetRemainingBudget fills RemainingBudget for strategies with a total budget
+func (s *QuotaStrategy) SetRemainingBudget() {
+	if s.TotalBudget <= 0 {
+		s.RemainingBudget = nil
+		return
+	}
+	remaining := s.TotalBudget - s.UsedBudget
+	if remaining < 0 {
+		remaining = 0
+	}
+	s.RemainingBudget = &remaining
+}
+
+// Definition returns the versioned part of the strategy
+func (s *QuotaStrategy) Definition() StrategyDefinition {
+	return StrategyDefinition{
+		Name:            s.Name,
+		Title:           s.Title,
+		Type:            s.Type,
+		Amount:          s.Amount,
+		AmountExpr:      s.AmountExpr,
+		Model:           s.Model,
+		PeriodicExpr:    s.PeriodicExpr,
+		Condition:       s.Condition,
+		TriggerEvent:    s.TriggerEvent,
+		MaxExecPerUser:  s.MaxExecPerUser,
+		Priority:        s.Priority,
+		ExclusionGroup:  s.ExclusionGroup,
+		MisfirePolicy:   s.MisfirePolicy,
+		ExpiryPolicy:    s.ExpiryPolicy,
+		ExpiryDays:      s.ExpiryDays,
+		ExpiryHours:     s.ExpiryHours,
+		FixedExpiryDate: s.FixedExpiryDate,
+		StartTime:       s.StartTime,
+		EndTime:         s.EndTime,
+		TotalBudget:     s.TotalBudget,
+		Status:          s.Status,
+	}
+}
+
+// Updates returns the column updates that restore the definition
+func (d StrategyDefinition) Updates() map[string]interface{} {
+	return map[string]interface{}{
+		"name":              d.Name,
+		"title":             d.Title,
+		"type":              d.Type,
+		"amount":            d.Amount,
+		"amount_expr":       d.AmountExpr,
+		"model":             d.Model,
+		"periodic_expr":     d.PeriodicExpr,
+		"condition":         d.Condition,
+		"trigger_event":     d.TriggerEvent,
+		"max_exec_per_user": d.MaxExecPerUser,
+		"priority":          d.Priority,
+		"exclusion_group":   d.ExclusionGroup,
+		"misfire_policy":    d.MisfirePolicy,
+		"expiry_policy":     d.ExpiryPolicy,
+		"expiry_days":       d.ExpiryDays,
+		"expiry_hours":      d.ExpiryHours,
+		"fixed_expiry_date": d.FixedExpiryDate,
+		"start_time":        d.StartTime,
+		"end_time":          d.EndTime,
+		"total_budget":      d.TotalBudget,
+		"status":            d.Status,
+	}
+}
+
+// DiffStrategyDefinitions lists the fields that differ between two definitions, in field order
+func DiffStrategyDefinitions(oldDef, newDef StrategyDefinition) ([]StrategyFieldChange, error) {
+	oldFields, err := definitionFields(oldDef)
+	if err != nil {
+		return nil, err
+	}
+	newFields, err := definitionFields(newDef)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make([]StrategyFieldChange, 0)
+	for field := range oldFields {
+		oldJSON, _ := json.Marshal(oldFields[field])
+		newJSON, _ := json.Marshal(newFields[field])
+		if string(oldJSON) != string(newJSON) {
+			changes = append(changes, StrategyFieldChange{Field: field, Old: oldFields[field], New: newFields[field]})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return strategyDefinitionFieldOrder[changes[i].Field] < strategyDefinitionFieldOrder[changes[j].Field]
+	})
+	return changes, nil
+}
+
+// strategyDefinitionFieldOrder orders the fields of a diff like StrategyDefinition
+var strategyDefinitionFieldOrder = func() map[string]int {
+	order := make(map[string]int)
+	t := reflect.TypeOf(StrategyDefinition{})
+	for i := 0; i < t.NumField(); i++ {
+		order[strings.Split(t.Field(i).Tag.Get("json"), ",")[0]] = i
+	}
+	return order
+}()
+
+// definitionFields returns the JSON fields of a definition
+func definitionFields(def StrategyDefinition) (map[string]interface{}, error) {
+	data, err := json.Marshal(def)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal strategy definition: %w", err)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal strategy definition: %w", err)
+	}
+	return fields, nil
+}
+
+// MarshalDefinition stores the definition of the version as JSON
+func (v *StrategyVersion) MarshalDefinition(def StrategyDefinition) error {
+	data, err := json.Marshal(def)
+	if err != nil {
+		return fmt.Errorf("failed to marshal strategy definition: %w", err)
+	}
+	v.Definition = string(data)
+	return nil
+}
+
+// UnmarshalDefinition converts the JSON definition of the version back
+func (v *StrategyVersion) UnmarshalDefinition() (StrategyDefinition, error) {
+	var def StrategyDefinition
+	if err := json.Unmarshal([]byte(v.Definition), &def); err != nil {
+		return def, fmt.Errorf("failed to unmarshal strategy definition: %w", err)
+	}
+	return def, nil
+}
+
+// MarshalChanges stores the changes against the previous version as JSON
+func (v *StrategyVersion) MarshalChanges(changes []StrategyFieldChange) error {
+	if len(changes) == 0 {
+		v.Changes = ""
+		return nil
+	}
+	data, err := json.Marshal(changes)
+	if err != nil {
+		return fmt.Errorf("failed to marshal strategy changes: %w", err)
+	}
+	v.Changes = string(data)
+	return nil
+}
+
+// MarshalConflicts stores the exclusion conflicts of the run as JSON
+func (r *StrategyRun) MarshalConflicts() error {
+	if len(r.Conflicts) == 0 {
+		r.ExclusionConflicts = ""
+		return nil
+	}
+	data, err := json.Marshal(r.Conflicts)
+	if err != nil {
+		return fmt.Errorf("failed to marshal exclusion conflicts: %w", err)
+	}
+	r.ExclusionConflicts = string(data)
+	return nil
+}
+
+// UnmarshalConflicts converts the JSON exclusion conflicts of the run back into Conflicts
+func (r *StrategyRun) UnmarshalConflicts() error {
+	r.Conflicts = nil
+	if r.ExclusionConflicts == "" {
+		return nil
+	}
+	if err := json.Unmarshal([]byte(r.ExclusionConflicts), &r.Conflicts); err != nil {
+		return fmt.Errorf("failed to unmarshal exclusion conflicts: %w", err)
+	}
+	return nil
+}
+
+// UnmarshalChanges converts the JSON changes of the version back
+func (v *StrategyVersion) UnmarshalChanges() ([]StrategyFieldChange, error) {
+	changes := make([]StrategyFieldChange, 0)
+	if v.Changes == "" {
+		return changes, nil
+	}
+	if err := json.Unmarshal([]byte(v.Changes), &changes); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal strategy changes: %w", err)
+	}
+	return changes, nil
+}
+
+// ExpiryDateAt returns the expiry date of quota granted by the strategy at the given time,
+// the month based policies use the location of now
+func (s *QuotaStrategy) ExpiryDateAt(now time.Time) time.Time {
+	switch s.ExpiryPolicy {
+	case ExpiryPolicyEndOfNextMonth:
+		return time.Date(now.Year(), now.Month()+2, 0, 23, 59, 59, 0, now.Location())
+	case ExpiryPolicyRelative:
+		return now.Add(time.Duration(s.ExpiryDays)*24*time.Hour + time.Duration(s.ExpiryHours)*time.Hour)
+	case ExpiryPolicyFixedDate:
+		if s.FixedExpiryDate != nil {
+			return *s.FixedExpiryDate
+		}
+	case ExpiryPolicyNever:
+		return NeverExpiryDate
+	}
+	// end_of_month is the default
+	return time.Date(now.Year(), now.Month()+1, 0, 23, 59, 59, 0, now.Location())
+}
+
 // IsValid checks if quota is valid
 func (q *Quota) IsValid() bool {
 	return q.Status == "VALID"
@@ -419,6 +949,21 @@ const (
 	OperationTransferIn  = "TRANSFER_IN"
 	OperationTransferOut = "TRANSFER_OUT"
 	OperationDeduct      = "DEDUCT"
+
+	OperationReserve        = "RESERVE"
+	OperationReserveCommit  = "RESERVE_COMMIT"
+	OperationReserveRelease = "RESERVE_RELEASE"
+
+	OperationAdminGrant  = "ADMIN_GRANT"
+	OperationAdminAdjust = "ADMIN_ADJUST"
+)
+
+// Quota reservation status constants
+const (
+	ReservationStatusHeld      = "HELD"
+	ReservationStatusCommitted = "COMMITTED"
+	ReservationStatusReleased  = "RELEASED"
+	ReservationStatusExpired   = "EXPIRED"
 )
 
 // Status constants for quota audit detail items
@@ -464,6 +1009,25 @@ const (
 	StatusExpired = "EXPIRED"
 )
 
+// Strategy expiry policy constants
+const (
+	ExpiryPolicyEndOfMonth     = "end_of_month"
+	ExpiryPolicyEndOfNextMonth = "end_of_next_month"
+	ExpiryPolicyRelative       = "relative"
+	ExpiryPolicyFixedDate      = "fixed_date"
+	ExpiryPolicyNever          = "never"
+)
+
+// Strategy misfire policy constants, an empty policy skips
+const (
+	MisfirePolicySkip    = "skip"     // Missed runs are not executed
+	MisfirePolicyRunOnce = "run_once" // Missed runs are executed once in total
+	MisfirePolicyRunAll  = "run_all"  // Every missed run is executed
+)
+
+// NeverExpiryDate is the expiry date stored for quota that never expires
+var NeverExpiryDate = time.Date(9999, 12, 31, 23, 59, 59, 0, time.UTC)
+
 // MonthlyQuotaUsage monthly quota usage record table
 type MonthlyQuotaUsage struct {
 	ID         int       `gorm:"primaryKey;autoIncrement" json:"id"`
@@ -478,3 +1042,133 @@ type MonthlyQuotaUsage struct {
 func (MonthlyQuotaUsage) TableName() string {
 	return "monthly_quota_usage"
 }
+
+// QuotaExpiryRun tracks a quota expiry pass so an interrupted pass resumes where it stopped
+type QuotaExpiryRun struct {
+	ID                int        `gorm:"primaryKey;autoIncrement" json:"id"`
+	Cutoff            time.Time  `gorm:"not null" json:"cutoff"`                                                     // Quota expiring before this time is expired by the run
+	Status            string     `gorm:"not null;index;size:20" json:"status"`                                       // RUNNING/COMPLETED
+	LastUserID        string     `gorm:"column:last_user_id;not null;default:'';size:255" json:"last_user_id"`       // Users are processed in user_id order
+	PendingUserID     string     `gorm:"column:pending_user_id;not null;default:'';size:255" json:"pending_user_id"` // User whose AiGateway adjustment is not applied yet
+	PendingUsedDelta  float64    `gorm:"column:pending_used_delta;not null;default:0" json:"pending_used_delta"`
+	PendingQuotaDelta float64    `gorm:"column:pending_quota_delta;not null;default:0" json:"pending_quota_delta"`
+	ProcessedUsers    int        `gorm:"column:processed_users;not null;default:0" json:"processed_users"`
+	FailedUsers       int        `gorm:"column:failed_users;not null;default:0" json:"failed_users"`
+	ExpiredAmount     float64    `gorm:"column:expired_amount;not null;default:0" json:"expired_amount"`
+	FinishTime        *time.Time `gorm:"column:finish_time" json:"finish_time,omitempty"`
+	CreateTime        time.Time  `gorm:"autoCreateTime" json:"create_time"`
+	UpdateTime        time.Time  `gorm:"autoUpdateTime" json:"update_time"`
+}
+
+// TableName sets the table name
+func (QuotaExpiryRun) TableName() string {
+	return "quota_expiry_run"
+}
+
+// Quota expiry run status constants
+const (
+	ExpiryRunStatusRunning   = "RUNNING"
+	ExpiryRunStatusCompleted = "COMPLETED"
+)
+
+// SchedulerJob is the shared state of a scheduled job, a job without a row was never paused or run
+type SchedulerJob struct {
+	Name          string     `gorm:"primaryKey;size:150" json:"name"`
+	Paused        bool       `gorm:"not null;default:false" json:"paused"`
+	PausedBy      string     `gorm:"column:paused_by;size:255" json:"paused_by,omitempty"`
+	LastStartTime *time.Time `gorm:"column:last_start_time" json:"last_start_time,omitempty"`
+	LastEndTime   *time.Time `gorm:"column:last_end_time" json:"last_end_time,omitempty"`
+	LastOutcome   string     `gorm:"column:last_outcome;not null;default:'';size:20" json:"last_outcome,omitempty"` // succeeded/failed
+	LastError     string     `gorm:"column:last_error;type:text" json:"last_error,omitempty"`
+	LastInstance  string     `gorm:"column:last_instance;size:255" json:"last_instance,omitempty"` // Instance that ran the job last
+	CreateTime    time.Time  `gorm:"autoCreateTime" json:"create_time"`
+	UpdateTime    time.Time  `gorm:"autoUpdateTime" json:"update_time"`
+}
+
+// TableName sets the table name
+func (SchedulerJob) TableName() string {
+	return "scheduler_job"
+}
+
+// Scheduler job outcome constants
+const (
+	SchedulerJobOutcomeSucceeded = "succeeded"
+	SchedulerJobOutcomeFailed    = "failed"
+)
+
+// ScanJob is a manually triggered scan or maintenance task run in the background. At most one job
+// of each type runs at a time, across all instances
+type ScanJob struct {
+	ID          int        `gorm:"primaryKey;autoIncrement" json:"id"`
+	Type        string     `gorm:"not null;size:30;index:idx_scan_job_type_time;uniqueIndex:idx_scan_job_running,where:status = 'running'" json:"type"`
+	Status      string     `gorm:"not null;size:20;index" json:"status"` // running/succeeded/failed
+	Total       int        `gorm:"not null;default:0" json:"total"`      // Items to process, 0 while unknown
+	Processed   int        `gorm:"not null;default:0" json:"processed"`  // Items processed successfully
+	Failed      int        `gorm:"not null;default:0" json:"failed"`     // Items that failed
+	Error       string     `gorm:"type:text" json:"error,omitempty"`
+	TriggeredBy string     `gorm:"column:triggered_by;size:255" json:"triggered_by,omitempty"`
+	Instance    string     `gorm:"size:255" json:"instance,omitempty"` // Instance running the job
+	StartTime   time.Time  `gorm:"not null;index:idx_scan_job_type_time" json:"start_time"`
+	EndTime     *time.Time `json:"end_time,omitempty"`
+	CreateTime  time.Time  `gorm:"autoCreateTime" json:"create_time"`
+	UpdateTime  time.Time  `gorm:"autoUpdateTime" json:"update_time"` // Refreshed while the job runs
+}
+
+// TableName sets the table name
+func (ScanJob) TableName() string {
+	return "scan_job"
+}
+
+// Scan job type constants
+const (
+	ScanJobTypeStrategy     = "strategy"
+	ScanJobTypeEmployeeSync = "employee-sync"
+	ScanJobTypeExpireQuotas = "expire-quotas"
+	ScanJobTypeSyncQuotas   = "sync-quotas"
+)
+
+// Scan job status constants
+const (
+	ScanJobStatusRunning   = "running"
+	ScanJobStatusSucceeded = "succeeded"
+	ScanJobStatusFailed    = "failed"
+)
+
+// APIKey service API key used by machine callers, only the key hash is stored
+type APIKey struct {
+	ID         int        `gorm:"primaryKey;autoIncrement" json:"id"`
+	Name       string     `gorm:"not null;size:100" json:"name"`
+	KeyPrefix  string     `gorm:"column:key_prefix;not null;size:20" json:"key_prefix"`          // Leading characters of the key for identification
+	KeyHash    string     `gorm:"column:key_hash;uniqueIndex;not null;size:64" json:"-"`         // SHA-256 hex digest of the key
+	Scopes     string     `gorm:"type:text;not null" json:"scopes"`                              // Store as comma-separated string
+	ExpiresAt  *time.Time `gorm:"column:expires_at;type:timestamptz(0)" json:"expires_at"`       // Never expires when null
+	LastUsedAt *time.Time `gorm:"column:last_used_at;type:timestamptz(0)" json:"last_used_at"`   // Last successful authentication
+	RevokedAt  *time.Time `gorm:"column:revoked_at;type:timestamptz(0);index" json:"revoked_at"` // Set when the key is revoked
+	CreatedBy  string     `gorm:"column:created_by;size:255" json:"created_by"`
+	CreateTime time.Time  `gorm:"autoCreateTime" json:"create_time"`
+	UpdateTime time.Time  `gorm:"autoUpdateTime" json:"update_time"`
+}
+
+// TableName sets the table name
+func (APIKey) TableName() string {
+	return "api_keys"
+}
+
+// ScopeList returns the key scopes as a slice
+func (k *APIKey) ScopeList() []string {
+	var scopes []string
+	for _, scope := range strings.Split(k.Scopes, ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+// IsActive checks whether the key is neither revoked nor expired at the given time
+func (k *APIKey) IsActive(now time.Time) bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+	return k.ExpiresAt == nil || now.Before(*k.ExpiresAt)
+}
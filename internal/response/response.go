@@ -53,6 +53,7 @@ const (
 	QuotaTransferFailedCode = "quota-manager.quota_transfer_failed"
 	DatabaseErrorCode       = "quota-manager.database_error"
 	AiGatewayErrorCode      = "quota-manager.aigateway_error"
+	IdempotencyConflictCode = "quota-manager.idempotency_conflict"
 
 	// The following codes are used for internal only
 
@@ -94,4 +95,31 @@ const (
 
 	UnifiedPermissionInvalidTypeCode = "quota-manager.invalid_permission_type"
 	EmployeeSyncFailedCode           = "quota-manager.employee_sync_failed"
+
+	// API key codes
+	InvalidAPIKeyIDCode      = "quota-manager.invalid_api_key_id"
+	APIKeyNotFoundCode       = "quota-manager.api_key_not_found"
+	APIKeyAlreadyRevokedCode = "quota-manager.api_key_already_revoked"
+
+	// Reservation codes
+	InvalidReservationIDCode = "quota-manager.invalid_reservation_id"
+	ReservationNotFoundCode  = "quota-manager.reservation_not_found"
+	ReservationConflictCode  = "quota-manager.reservation_conflict"
+
+	// Strategy run codes
+	StrategyRunNotFoundCode = "quota-manager.strategy_run_not_found"
+
+	// Strategy version codes
+	StrategyVersionNotFoundCode = "quota-manager.strategy_version_not_found"
+
+	// Strategy event codes
+	EventUserNotFoundCode = "quota-manager.event_user_not_found"
+
+	// Scheduler job codes
+	SchedulerJobNotFoundCode = "quota-manager.scheduler_job_not_found"
+
+	// Scan job codes
+	InvalidScanJobIDCode = "quota-manager.invalid_scan_job_id"
+	ScanJobNotFoundCode  = "quota-manager.scan_job_not_found"
+	ScanJobConflictCode  = "quota-manager.scan_job_conflict"
 )
@@ -0,0 +1,226 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"quota-manager/internal/models"
+	"quota-manager/pkg/logger"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm/clause"
+)
+
+// maxAdminQuotaUsers bounds the number of users changed by one grant or adjustment
+const maxAdminQuotaUsers = 1000
+
+// AdminQuotaRequest represents a manual quota grant or adjustment, user_id and user_ids may be combined
+type AdminQuotaRequest struct {
+	UserID     string    `json:"user_id" validate:"omitempty,max=255"`
+	UserIDs    []string  `json:"user_ids" validate:"omitempty,max=1000,dive,required,max=255"`
+	Amount     float64   `json:"amount" validate:"required"`
+	Model      string    `json:"model" validate:"omitempty,max=100"`
+	ExpiryDate time.Time `json:"expiry_date" validate:"required"`
+	Reason     string    `json:"reason" validate:"required,max=500"`
+}
+
+// AdminQuotaResult is the outcome of a grant or adjustment for one user
+type AdminQuotaResult struct {
+	UserID  string  `json:"user_id"`
+	Amount  float64 `json:"amount"`
+	Success bool    `json:"success"`
+	Error   string  `json:"error,omitempty"`
+}
+
+// AdminQuotaResponse represents the result of a grant or adjustment
+type AdminQuotaResponse struct {
+	Operation    string             `json:"operation"`
+	SuccessCount int                `json:"success_count"`
+	FailedCount  int                `json:"failed_count"`
+	Results      []AdminQuotaResult `json:"results"`
+}
+
+// AdminGrantQuota adds quota to each user on behalf of an administrator
+func (s *QuotaService) AdminGrantQuota(req *AdminQuotaRequest, operator string) (*AdminQuotaResponse, error) {
+	if req.Amount <= 0 {
+		return nil, NewValidationFailedError("amount must be positive for a grant, use adjust to remove quota")
+	}
+	return s.applyAdminQuota(models.OperationAdminGrant, req, operator)
+}
+
+// AdminAdjustQuota adds or removes quota of the given expiry date for each user on behalf of an administrator
+func (s *QuotaService) AdminAdjustQuota(req *AdminQuotaRequest, operator string) (*AdminQuotaResponse, error) {
+	return s.applyAdminQuota(models.OperationAdminAdjust, req, operator)
+}
+
+// applyAdminQuota validates the request and changes each user's quota in its own transaction,
+// a failure for one user does not stop the others
+func (s *QuotaService) applyAdminQuota(operation string, req *AdminQuotaRequest, operator string) (*AdminQuotaResponse, error) {
+	userIDs := adminQuotaUserIDs(req)
+	if len(userIDs) == 0 {
+		return nil, NewValidationFailedError("user_id or user_ids is required")
+	}
+	if len(userIDs) > maxAdminQuotaUsers {
+		return nil, NewValidationFailedError(fmt.Sprintf("at most %d users can be changed at once", maxAdminQuotaUsers))
+	}
+
+	reason := strings.TrimSpace(req.Reason)
+	if reason == "" {
+		return nil, NewValidationFailedError("reason is required")
+	}
+
+	expiryDate := req.ExpiryDate.Truncate(time.Second)
+	if !expiryDate.After(time.Now()) {
+		return nil, NewValidationFailedError("expiry_date must be in the future")
+	}
+
+	resp := &AdminQuotaResponse{
+		Operation: operation,
+		Results:   make([]AdminQuotaResult, 0, len(userIDs)),
+	}
+	for _, userID := range userIDs {
+		result := AdminQuotaResult{UserID: userID, Amount: req.Amount}
+		if err := s.changeUserQuota(operation, userID, req.Model, req.Amount, expiryDate, reason, operator); err != nil {
+			logger.Error("Failed to apply admin quota change",
+				zap.String("operation", operation),
+				zap.String("user_id", userID),
+				zap.String("operator", operator),
+				zap.Error(err))
+			result.Error = err.Error()
+			resp.FailedCount++
+		} else {
+			result.Success = true
+			resp.SuccessCount++
+		}
+		resp.Results = append(resp.Results, result)
+	}
+
+	return resp, nil
+}
+
+// changeUserQuota adds amount to, or for a negative amount removes it from, the user's quota of the model
+// pool and expiry date, writes the audit record and updates AiGateway the same way strategy recharges do
+func (s *QuotaService) changeUserQuota(operation, userID, model string, amount float64, expiryDate time.Time, reason, operator string) error {
+	// Used quota decides how much of each quota item is left, query it before the transaction
+	var usedQuota float64
+	if amount < 0 {
+		var err error
+		if usedQuota, err = s.aiGatewayClient.QueryUsedQuotaValue(userID); err != nil {
+			return fmt.Errorf("failed to get used quota: %w", err)
+		}
+	}
+
+	tx := s.db.DB.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var quota *models.Quota
+	if amount > 0 {
+		var err error
+		if quota, err = addQuotaRow(tx, userID, model, amount, expiryDate); err != nil {
+			tx.Rollback()
+			return err
+		}
+	} else {
+		var quotas []models.Quota
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ? AND status = ?", userID, models.StatusValid).
+			Order("expiry_date ASC").Find(&quotas).Error; err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to get quota list: %w", err)
+		}
+
+		available := 0.0
+		for _, item := range remainingQuotaItems(quotas, usedQuota) {
+			if item.Model == model && item.ExpiryDate.Equal(expiryDate) {
+				available = item.Amount
+			}
+		}
+		if -amount > available {
+			tx.Rollback()
+			return NewValidationFailedError(fmt.Sprintf("insufficient quota: %g left expiring at %s, cannot remove %g",
+				available, expiryDate.Format(time.RFC3339), -amount))
+		}
+
+		for i := range quotas {
+			if quotas[i].Model == model && quotas[i].ExpiryDate.Equal(expiryDate) {
+				quota = &quotas[i]
+			}
+		}
+		quota.Amount += amount
+		if quota.Amount > 0 {
+			if err := tx.Model(&models.Quota{}).Where("id = ?", quota.ID).
+				Update("amount", quota.Amount).Error; err != nil {
+				tx.Rollback()
+				return fmt.Errorf("failed to update quota: %w", err)
+			}
+		} else if err := tx.Delete(&models.Quota{}, quota.ID).Error; err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to delete quota: %w", err)
+		}
+	}
+
+	auditDetails := &models.QuotaAuditDetails{
+		Operation: operation,
+		Model:     model,
+		Reason:    reason,
+		Summary: models.QuotaAuditSummary{
+			TotalAmount:        amount,
+			TotalItems:         1,
+			SuccessfulItems:    1,
+			EarliestExpiryDate: expiryDate.Format(time.RFC3339),
+		},
+		Items: []models.QuotaAuditDetailItem{
+			{
+				Amount:        amount,
+				ExpiryDate:    expiryDate.Format(time.RFC3339),
+				Status:        models.AuditStatusSuccess,
+				OriginalQuota: quota.Amount - amount,
+				NewQuota:      quota.Amount,
+			},
+		},
+	}
+
+	auditRecord := &models.QuotaAudit{
+		UserID:     userID,
+		Amount:     amount,
+		Operation:  operation,
+		Operator:   operator,
+		ExpiryDate: expiryDate,
+	}
+	if err := auditRecord.MarshalDetails(auditDetails); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to marshal audit details: %w", err)
+	}
+	if err := tx.Create(auditRecord).Error; err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to create audit record: %w", err)
+	}
+
+	// Update AiGateway quota
+	if err := s.aiGatewayClient.DeltaQuota(userID, amount); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to update AiGateway quota: %w", err)
+	}
+
+	return tx.Commit().Error
+}
+
+// adminQuotaUserIDs merges user_id and user_ids, dropping blanks and duplicates
+func adminQuotaUserIDs(req *AdminQuotaRequest) []string {
+	seen := make(map[string]bool)
+	userIDs := make([]string, 0, len(req.UserIDs)+1)
+	for _, userID := range append([]string{req.UserID}, req.UserIDs...) {
+		userID = strings.TrimSpace(userID)
+		if userID == "" || seen[userID] {
+			continue
+		}
+		seen[userID] = true
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs
+}
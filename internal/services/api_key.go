@@ -0,0 +1,194 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"quota-manager/internal/database"
+	"quota-manager/internal/models"
+	"quota-manager/pkg/logger"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	// apiKeyPrefix marks quota-manager keys so they are recognizable in configs and logs
+	apiKeyPrefix = "qm_"
+	// apiKeyRandomBytes is the amount of randomness in a generated key
+	apiKeyRandomBytes = 32
+	// apiKeyDisplayPrefixLen is the number of leading key characters stored for identification
+	apiKeyDisplayPrefixLen = 11
+	// apiKeyLastUsedResolution limits how often last_used_at is written for a busy key
+	apiKeyLastUsedResolution = time.Minute
+)
+
+// APIKeyService manages service API keys
+type APIKeyService struct {
+	db *database.DB
+}
+
+// NewAPIKeyService creates a new API key service
+func NewAPIKeyService(db *database.DB) *APIKeyService {
+	return &APIKeyService{db: db}
+}
+
+// CreateAPIKeyRequest represents an API key creation request
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name" validate:"required,min=1,max=100"`
+	Scopes    []string   `json:"scopes" validate:"required,min=1,dive,oneof=quota:read quota:deduct strategy:write scan:trigger event:publish"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// CreateAPIKeyResponse contains the created key, the plaintext key is only returned once
+type CreateAPIKeyResponse struct {
+	Key    string         `json:"key"`
+	APIKey *models.APIKey `json:"api_key"`
+}
+
+// CreateAPIKey generates a new API key and stores its hash
+func (s *APIKeyService) CreateAPIKey(req *CreateAPIKeyRequest, createdBy string) (*CreateAPIKeyResponse, error) {
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return nil, NewValidationFailedError("expires_at must be in the future")
+	}
+
+	rawKey, err := generateAPIKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate API key: %w", err)
+	}
+
+	apiKey := &models.APIKey{
+		Name:      strings.TrimSpace(req.Name),
+		KeyPrefix: rawKey[:apiKeyDisplayPrefixLen],
+		KeyHash:   hashAPIKey(rawKey),
+		Scopes:    strings.Join(uniqueStrings(req.Scopes), ","),
+		ExpiresAt: req.ExpiresAt,
+		CreatedBy: createdBy,
+	}
+	if err := s.db.DB.Create(apiKey).Error; err != nil {
+		return nil, NewDatabaseError("create api key", err)
+	}
+
+	logger.Info("API key created",
+		zap.Int("api_key_id", apiKey.ID),
+		zap.String("name", apiKey.Name),
+		zap.String("scopes", apiKey.Scopes),
+		zap.String("created_by", createdBy))
+
+	return &CreateAPIKeyResponse{Key: rawKey, APIKey: apiKey}, nil
+}
+
+// ListAPIKeys returns API keys with pagination, newest first
+func (s *APIKeyService) ListAPIKeys(page, pageSize int) ([]models.APIKey, int64, error) {
+	var keys []models.APIKey
+	var total int64
+
+	if err := s.db.DB.Model(&models.APIKey{}).Count(&total).Error; err != nil {
+		return nil, 0, NewDatabaseError("count api keys", err)
+	}
+
+	offset := (page - 1) * pageSize
+	if err := s.db.DB.Order("id DESC").Offset(offset).Limit(pageSize).Find(&keys).Error; err != nil {
+		return nil, 0, NewDatabaseError("list api keys", err)
+	}
+
+	return keys, total, nil
+}
+
+// RevokeAPIKey revokes an API key, revoked keys are kept so audit records still resolve
+func (s *APIKeyService) RevokeAPIKey(id int, revokedBy string) error {
+	var apiKey models.APIKey
+	if err := s.db.DB.First(&apiKey, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return NewResourceNotFoundError("api key", strconv.Itoa(id))
+		}
+		return NewDatabaseError("get api key", err)
+	}
+
+	if apiKey.RevokedAt != nil {
+		return NewConflictError(fmt.Sprintf("api key %d is already revoked", id))
+	}
+
+	if err := s.db.DB.Model(&apiKey).Update("revoked_at", time.Now()).Error; err != nil {
+		return NewDatabaseError("revoke api key", err)
+	}
+
+	logger.Info("API key revoked",
+		zap.Int("api_key_id", id),
+		zap.String("name", apiKey.Name),
+		zap.String("revoked_by", revokedBy))
+
+	return nil
+}
+
+// AuthenticateAPIKey resolves an active API key from its plaintext value and records its use
+func (s *APIKeyService) AuthenticateAPIKey(rawKey string) (*models.APIKey, error) {
+	rawKey = strings.TrimSpace(rawKey)
+	if !strings.HasPrefix(rawKey, apiKeyPrefix) {
+		return nil, fmt.Errorf("invalid API key format")
+	}
+
+	var apiKey models.APIKey
+	if err := s.db.DB.Where("key_hash = ?", hashAPIKey(rawKey)).First(&apiKey).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("invalid API key")
+		}
+		return nil, fmt.Errorf("failed to look up API key: %w", err)
+	}
+
+	now := time.Now()
+	if apiKey.RevokedAt != nil {
+		return nil, fmt.Errorf("API key has been revoked")
+	}
+	if !apiKey.IsActive(now) {
+		return nil, fmt.Errorf("API key has expired")
+	}
+
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) >= apiKeyLastUsedResolution {
+		if err := s.db.DB.Model(&models.APIKey{}).Where("id = ?", apiKey.ID).
+			Update("last_used_at", now).Error; err != nil {
+			// Usage tracking must not block authentication
+			logger.Warn("Failed to update API key last used time",
+				zap.Int("api_key_id", apiKey.ID),
+				zap.Error(err))
+		} else {
+			apiKey.LastUsedAt = &now
+		}
+	}
+
+	return &apiKey, nil
+}
+
+// generateAPIKey returns a new random key in the form qm_<hex>
+func generateAPIKey() (string, error) {
+	buf := make([]byte, apiKeyRandomBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return apiKeyPrefix + hex.EncodeToString(buf), nil
+}
+
+// hashAPIKey returns the SHA-256 hex digest stored for a key
+func hashAPIKey(rawKey string) string {
+	sum := sha256.Sum256([]byte(rawKey))
+	return hex.EncodeToString(sum[:])
+}
+
+// uniqueStrings removes duplicates while keeping the original order
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			result = append(result, value)
+		}
+	}
+	return result
+}
@@ -0,0 +1,94 @@
+package services
+
+import (
+	"time"
+
+	"quota-manager/internal/utils"
+
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	// defaultCronPreviewCount is used when a cron preview request does not set count
+	defaultCronPreviewCount = 10
+	// maxCronPreviewCount bounds the fire times returned by a cron preview
+	maxCronPreviewCount = 100
+)
+
+// cronParser parses cron expressions the way the schedulers do, with a leading seconds field
+var cronParser = cron.NewParser(cron.Second | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// previousFireLookbacks are tried in order to find the latest fire time of a schedule
+var previousFireLookbacks = []time.Duration{
+	time.Minute,
+	time.Hour,
+	24 * time.Hour,
+	32 * 24 * time.Hour,
+	366 * 24 * time.Hour,
+	5 * 366 * 24 * time.Hour,
+}
+
+// previousFireTime returns the latest time not after now the cron expression fired at,
+// the zero time if the expression is invalid or did not fire within five years
+func previousFireTime(expr string, now time.Time) time.Time {
+	schedule, err := cronParser.Parse(expr)
+	if err != nil {
+		return time.Time{}
+	}
+
+	for _, lookback := range previousFireLookbacks {
+		fire := schedule.Next(now.Add(-lookback))
+		if fire.IsZero() || fire.After(now) {
+			continue
+		}
+		for {
+			next := schedule.Next(fire)
+			if next.IsZero() || next.After(now) {
+				return fire
+			}
+			fire = next
+		}
+	}
+	return time.Time{}
+}
+
+// CronPreviewRequest asks for the next fire times of a periodic expression
+type CronPreviewRequest struct {
+	Expression string `json:"expression" validate:"required"`
+	Count      int    `json:"count" validate:"omitempty,min=1,max=100"`
+}
+
+// CronPreview lists the next fire times of a periodic expression
+type CronPreview struct {
+	Expression string      `json:"expression"`
+	Timezone   string      `json:"timezone"`   // Timezone the fire times are shown in
+	FireTimes  []time.Time `json:"fire_times"` // Next fire times after now
+}
+
+// PreviewCron returns the next fire times of a periodic expression as the strategy scheduler would
+// fire it, shown in the configured timezone
+func (s *StrategyService) PreviewCron(req *CronPreviewRequest) (*CronPreview, error) {
+	schedule, err := cronParser.Parse(req.Expression)
+	if err != nil {
+		return nil, NewValidationFailedError("invalid periodic expression: " + err.Error())
+	}
+
+	count := req.Count
+	if count <= 0 {
+		count = defaultCronPreviewCount
+	}
+	if count > maxCronPreviewCount {
+		count = maxCronPreviewCount
+	}
+
+	tz := utils.GetTimezone(s.quotaService.GetConfigManager().GetDirect())
+	preview := &CronPreview{
+		Expression: req.Expression,
+		Timezone:   tz.String(),
+		FireTimes:  make([]time.Time, 0, count),
+	}
+	for fire := schedule.Next(time.Now().In(s.cron.Location())); !fire.IsZero() && len(preview.FireTimes) < count; fire = schedule.Next(fire) {
+		preview.FireTimes = append(preview.FireTimes, fire.In(tz))
+	}
+	return preview, nil
+}
@@ -28,6 +28,9 @@ type EmployeeSyncService struct {
 	starCheckPermissionSvc  *StarCheckPermissionService
 	quotaCheckPermissionSvc *QuotaCheckPermissionService
 	cron                    *cron.Cron
+	leader                  *LeaderElector   // Scheduled sync only runs on the leader, nil runs it here
+	jobs                    *JobRegistry     // Tracks the sync cron entry, nil leaves it untracked
+	strategyService         *StrategyService // Receives employee_joined and department_changed events, nil drops them
 }
 
 // NewEmployeeSyncService creates a new employee sync service
@@ -48,6 +51,11 @@ func NewEmployeeSyncService(
 	}
 }
 
+// SetStrategyService sets the strategy service evaluating the strategies triggered by employee changes
+func (s *EmployeeSyncService) SetStrategyService(strategyService *StrategyService) {
+	s.strategyService = strategyService
+}
+
 // IsEmployeeDepartmentTableEmpty checks if the employee_department table is empty
 func (s *EmployeeSyncService) IsEmployeeDepartmentTableEmpty() (bool, error) {
 	var count int64
@@ -69,17 +77,19 @@ func (s *EmployeeSyncService) StartCron() error {
 	logger.Logger.Info("Setting up employee sync cron", zap.String("schedule", "every day at 1:00 AM"))
 
 	// Add employee sync task
-	_, err := s.cron.AddFunc(syncInterval, func() {
+	entryID, err := s.cron.AddFunc(syncInterval, s.leader.Guard("employee-sync", s.jobs.Wrap("employee-sync", func() error {
 		logger.Logger.Info("Starting scheduled employee synchronization")
 		if err := s.SyncEmployees(); err != nil {
 			logger.Logger.Error("Scheduled employee sync failed", zap.Error(err))
-		} else {
-			logger.Logger.Info("Scheduled employee synchronization completed successfully")
+			return err
 		}
-	})
+		logger.Logger.Info("Scheduled employee synchronization completed successfully")
+		return nil
+	})))
 	if err != nil {
 		return fmt.Errorf("failed to add employee sync task: %w", err)
 	}
+	s.jobs.Track("employee-sync", syncInterval, s.cron, entryID)
 
 	s.cron.Start()
 	logger.Logger.Info("Employee sync cron started", zap.String("schedule", "daily at 1:00 AM"))
@@ -100,6 +110,10 @@ func (s *EmployeeSyncService) TriggerInitialSyncIfNeeded() error {
 		logger.Logger.Info("Employee sync is disabled, skipping initial sync check")
 		return nil
 	}
+	if !s.leader.IsLeader() {
+		logger.Logger.Info("Not the scheduler leader, skipping initial sync check")
+		return nil
+	}
 
 	isEmpty, err := s.IsEmployeeDepartmentTableEmpty()
 	if err != nil {
@@ -301,6 +315,9 @@ func (s *EmployeeSyncService) processEmployees(employees []HREmployee, deptHiera
 	}
 
 	var updatedEmployees []string
+	// Joins are only reported once employees were synced before, the initial sync imports everyone
+	var events []StrategyEvent
+	reportJoins := len(dbEmployees) > 0
 
 	// Process each employee
 	for _, emp := range employees {
@@ -351,6 +368,9 @@ func (s *EmployeeSyncService) processEmployees(employees []HREmployee, deptHiera
 				}
 
 				updatedEmployees = append(updatedEmployees, emp.EmployeeNumber)
+				if isDeptChanged {
+					events = append(events, StrategyEvent{Event: models.StrategyEventDepartmentChanged, EmployeeNumber: emp.EmployeeNumber})
+				}
 			}
 		} else {
 			// Create new employee
@@ -368,6 +388,9 @@ func (s *EmployeeSyncService) processEmployees(employees []HREmployee, deptHiera
 			}
 
 			updatedEmployees = append(updatedEmployees, emp.EmployeeNumber)
+			if reportJoins {
+				events = append(events, StrategyEvent{Event: models.StrategyEventEmployeeJoined, EmployeeNumber: emp.EmployeeNumber})
+			}
 		}
 	}
 
@@ -423,6 +446,9 @@ func (s *EmployeeSyncService) processEmployees(employees []HREmployee, deptHiera
 		}
 	}
 
+	// Strategies triggered by the changes see the employee departments as synced
+	s.dispatchEmployeeEvents(events)
+
 	return updatedEmployees, nil
 }
 
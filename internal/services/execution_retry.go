@@ -0,0 +1,361 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"quota-manager/internal/models"
+	"quota-manager/pkg/logger"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	// defaultExecutionMaxAttempts is used when scheduler.execution_max_attempts is not configured
+	defaultExecutionMaxAttempts = 5
+	// defaultExecutionRetryBackoff is used when scheduler.execution_retry_backoff is not configured
+	defaultExecutionRetryBackoff = 5 * time.Minute
+	// maxExecutionRetryBackoff caps the doubled backoff
+	maxExecutionRetryBackoff = 24 * time.Hour
+	// defaultExecutionStaleAfter is used when scheduler.execution_stale_after is not configured
+	defaultExecutionStaleAfter = 30 * time.Minute
+	// executionRetryBatchSize bounds the executions reconciled by one pass
+	executionRetryBatchSize = 500
+)
+
+// Outcomes of reconciling one execution
+const (
+	reconcileRecovered = "recovered" // Quota was written, the execution only missed its status update
+	reconcileSucceeded = "succeeded"
+	reconcileFailed    = "failed"    // Retry failed, retried again after the backoff
+	reconcileExhausted = "exhausted" // No attempts left, or the quota would already be expired
+	reconcileSkipped   = "skipped"
+)
+
+// ExecutionRetryResult summarizes a reconciliation pass over failed and interrupted executions
+type ExecutionRetryResult struct {
+	StrategyID int `json:"strategy_id,omitempty"`
+	Checked    int `json:"checked"`
+	Recovered  int `json:"recovered"` // Quota was already written, marked completed without a retry
+	Retried    int `json:"retried"`
+	Succeeded  int `json:"succeeded"`
+	Failed     int `json:"failed"`    // Retry failed, retried again after the backoff
+	Exhausted  int `json:"exhausted"` // Given up after the last attempt or because the quota would already be expired
+	Skipped    int `json:"skipped"`   // Disabled strategies, superseded single executions and executions claimed elsewhere
+}
+
+// RetryFailedExecutions reconciles the failed and interrupted executions of a strategy right away,
+// ignoring the backoff but not the maximum attempt count
+func (s *StrategyService) RetryFailedExecutions(strategyID int) (*ExecutionRetryResult, error) {
+	if _, err := s.getStrategyOrNotFound(strategyID); err != nil {
+		return nil, err
+	}
+
+	result, err := s.reconcileExecutions(&strategyID, true)
+	if err != nil {
+		return nil, NewDatabaseError("reconcile strategy executions", err)
+	}
+	result.StrategyID = strategyID
+	return result, nil
+}
+
+// ReconcileExecutions retries failed executions whose backoff has passed and executions left
+// processing for longer than scheduler.execution_stale_after
+func (s *StrategyService) ReconcileExecutions() (*ExecutionRetryResult, error) {
+	return s.reconcileExecutions(nil, false)
+}
+
+// reconcileExecutions reconciles one batch of failed and stale processing executions
+func (s *StrategyService) reconcileExecutions(strategyID *int, ignoreBackoff bool) (*ExecutionRetryResult, error) {
+	cfg := s.quotaService.GetConfigManager().GetDirect().Scheduler
+	maxAttempts := cfg.ExecutionMaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultExecutionMaxAttempts
+	}
+	staleAfter := cfg.ExecutionStaleAfter
+	if staleAfter <= 0 {
+		staleAfter = defaultExecutionStaleAfter
+	}
+
+	now := time.Now()
+	failedCond := "status = ? AND attempts < ?"
+	args := []interface{}{models.ExecuteStatusFailed, maxAttempts}
+	if !ignoreBackoff {
+		failedCond += " AND (next_retry_time IS NULL OR next_retry_time <= ?)"
+		args = append(args, now)
+	}
+	args = append(args, models.ExecuteStatusProcessing, now.Add(-staleAfter))
+	query := s.db.Where("("+failedCond+") OR (status = ? AND update_time < ?)", args...)
+	if strategyID != nil {
+		query = query.Where("strategy_id = ?", *strategyID)
+	}
+
+	var executes []models.QuotaExecute
+	if err := query.Order("id ASC").Limit(executionRetryBatchSize).Find(&executes).Error; err != nil {
+		return nil, fmt.Errorf("failed to query executions to reconcile: %w", err)
+	}
+
+	result := &ExecutionRetryResult{}
+	strategies := make(map[int]*models.QuotaStrategy)
+	for i := range executes {
+		execute := &executes[i]
+		strategy, ok := strategies[execute.StrategyID]
+		if !ok {
+			var loaded models.QuotaStrategy
+			if err := s.db.First(&loaded, execute.StrategyID).Error; err != nil {
+				logger.Error("Failed to load strategy of execution",
+					zap.Int("execute_id", execute.ID),
+					zap.Int("strategy_id", execute.StrategyID),
+					zap.Error(err))
+				continue
+			}
+			strategy = &loaded
+			strategies[execute.StrategyID] = strategy
+		}
+
+		result.Checked++
+		outcome, err := s.reconcileExecution(execute, strategy, maxAttempts)
+		if err != nil {
+			logger.Error("Failed to reconcile execution",
+				zap.Int("execute_id", execute.ID),
+				zap.String("user", execute.User),
+				zap.String("strategy", strategy.Name),
+				zap.Error(err))
+			continue
+		}
+
+		switch outcome {
+		case reconcileRecovered:
+			result.Recovered++
+		case reconcileSucceeded:
+			result.Retried++
+			result.Succeeded++
+		case reconcileFailed:
+			result.Retried++
+			result.Failed++
+		case reconcileExhausted:
+			result.Exhausted++
+		case reconcileSkipped:
+			result.Skipped++
+		}
+	}
+
+	if result.Checked > 0 {
+		logger.Info("Strategy executions reconciled",
+			zap.Int("checked", result.Checked),
+			zap.Int("recovered", result.Recovered),
+			zap.Int("succeeded", result.Succeeded),
+			zap.Int("failed", result.Failed),
+			zap.Int("exhausted", result.Exhausted),
+			zap.Int("skipped", result.Skipped))
+	}
+	return result, nil
+}
+
+// reconcileExecution claims an execution, marks it completed when its quota was already written
+// and otherwise retries the recharge. A processing execution holds a reservation of the strategy
+// budget, a failed one has released it
+func (s *StrategyService) reconcileExecution(execute *models.QuotaExecute, strategy *models.QuotaStrategy, maxAttempts int) (string, error) {
+	reserved := execute.Status == models.ExecuteStatusProcessing
+	strategy = s.strategyForExecution(strategy, execute)
+
+	// Claim the execution so that concurrent passes and the stale check leave it alone
+	claim := s.db.Model(&models.QuotaExecute{}).
+		Where("id = ? AND status = ? AND update_time = ?", execute.ID, execute.Status, execute.UpdateTime).
+		Update("status", models.ExecuteStatusProcessing)
+	if claim.Error != nil {
+		return "", fmt.Errorf("failed to claim execution: %w", claim.Error)
+	}
+	if claim.RowsAffected == 0 {
+		return reconcileSkipped, nil
+	}
+
+	written, err := s.rechargeWritten(execute)
+	if err != nil {
+		s.updateExecution(execute, map[string]interface{}{"status": execute.Status})
+		return "", err
+	}
+	if written {
+		if !reserved {
+			s.chargeBudget(strategy.ID, strategy.Amount)
+		}
+		s.updateExecution(execute, map[string]interface{}{
+			"status":          models.ExecuteStatusCompleted,
+			"last_error":      "",
+			"next_retry_time": nil,
+		})
+		return reconcileRecovered, nil
+	}
+
+	if !strategy.IsEnabled() {
+		s.releaseReservation(strategy, reserved)
+		s.updateExecution(execute, map[string]interface{}{"status": models.ExecuteStatusFailed})
+		return reconcileSkipped, nil
+	}
+
+	if !execute.ExpiryDate.After(time.Now()) {
+		s.releaseReservation(strategy, reserved)
+		s.updateExecution(execute, map[string]interface{}{
+			"status":          models.ExecuteStatusFailed,
+			"attempts":        maxAttempts,
+			"last_error":      "quota expiry date has passed",
+			"next_retry_time": nil,
+		})
+		return reconcileExhausted, nil
+	}
+
+	// A single strategy granted or being granted by another execution must not be granted again,
+	// the budget is reserved under the same lock so that a failed check leaves it untouched
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if strategy.Type == "single" {
+			if err := lockSingleGrant(tx, strategy.ID, execute.User, execute.ID); err != nil {
+				return err
+			}
+		}
+		if !reserved {
+			return s.reserveBudget(tx, strategy.ID, strategy.Amount)
+		}
+		return nil
+	})
+	switch {
+	case err == nil:
+	case errors.Is(err, errAlreadyGranted):
+		s.releaseReservation(strategy, reserved)
+		s.updateExecution(execute, map[string]interface{}{
+			"status":          models.ExecuteStatusSuperseded,
+			"next_retry_time": nil,
+		})
+		return reconcileSkipped, nil
+	case errors.Is(err, errBudgetExhausted):
+		s.updateExecution(execute, map[string]interface{}{
+			"status":          models.ExecuteStatusFailed,
+			"attempts":        maxAttempts,
+			"last_error":      err.Error(),
+			"next_retry_time": nil,
+		})
+		return reconcileExhausted, nil
+	default:
+		s.updateExecution(execute, map[string]interface{}{"status": execute.Status})
+		return "", err
+	}
+
+	attempts := execute.Attempts + 1
+	if err := s.quotaService.addModelQuotaForStrategy(execute.User, strategy.Model, strategy.Amount, execute.ExpiryDate, strategy.ID, strategy.Version, strategy.Name, strategy.AmountExpr); err != nil {
+		s.releaseBudget(strategy.ID, strategy.Amount)
+		updates := map[string]interface{}{
+			"status":          models.ExecuteStatusFailed,
+			"attempts":        attempts,
+			"last_error":      err.Error(),
+			"next_retry_time": nil,
+		}
+		outcome := reconcileExhausted
+		if attempts < maxAttempts {
+			updates["next_retry_time"] = time.Now().Add(s.executionRetryBackoff(attempts))
+			outcome = reconcileFailed
+		}
+		s.updateExecution(execute, updates)
+		logger.Warn("Strategy execution retry failed",
+			zap.Int("execute_id", execute.ID),
+			zap.String("user", execute.User),
+			zap.String("strategy", strategy.Name),
+			zap.Int("attempts", attempts),
+			zap.Error(err))
+		return outcome, nil
+	}
+
+	s.updateExecution(execute, map[string]interface{}{
+		"status":          models.ExecuteStatusCompleted,
+		"attempts":        attempts,
+		"last_error":      "",
+		"next_retry_time": nil,
+	})
+	logger.Info("Strategy execution retried",
+		zap.Int("execute_id", execute.ID),
+		zap.String("user", execute.User),
+		zap.String("strategy", strategy.Name),
+		zap.Int("attempts", attempts))
+	return reconcileSucceeded, nil
+}
+
+// rechargeWritten checks whether the recharge of an execution was committed, by looking for its
+// audit record between the execution and the next execution of the strategy for the same user
+func (s *StrategyService) rechargeWritten(execute *models.QuotaExecute) (bool, error) {
+	query := s.db.Model(&models.QuotaAudit{}).
+		Where("user_id = ? AND operation = ? AND strategy_id = ? AND expiry_date = ? AND create_time >= ?",
+			execute.User, models.OperationRecharge, execute.StrategyID, execute.ExpiryDate, execute.CreateTime)
+
+	var next models.QuotaExecute
+	err := s.db.Where("strategy_id = ? AND user_id = ? AND id > ?", execute.StrategyID, execute.User, execute.ID).
+		Order("id ASC").First(&next).Error
+	if err == nil {
+		query = query.Where("create_time < ?", next.CreateTime)
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, fmt.Errorf("failed to query next execution: %w", err)
+	}
+
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		return false, fmt.Errorf("failed to query recharge audit records: %w", err)
+	}
+	return count > 0, nil
+}
+
+// strategyForExecution returns the strategy with the amount and model of the version that made
+// the execution, so that a retry grants what the original attempt would have granted. The amount
+// recorded by the execution is the one computed for the user and takes precedence
+func (s *StrategyService) strategyForExecution(strategy *models.QuotaStrategy, execute *models.QuotaExecute) *models.QuotaStrategy {
+	versioned := *strategy
+	if execute.StrategyVersion != 0 && execute.StrategyVersion != strategy.Version {
+		definition, err := s.getStrategyVersionDefinition(strategy.ID, execute.StrategyVersion)
+		if err != nil {
+			logger.Warn("Failed to load strategy version of execution, retrying with the current version",
+				zap.Int("execute_id", execute.ID),
+				zap.Int("strategy_version", execute.StrategyVersion),
+				zap.Error(err))
+		} else {
+			versioned.Amount = definition.Amount
+			versioned.AmountExpr = definition.AmountExpr
+			versioned.Model = definition.Model
+			versioned.Version = execute.StrategyVersion
+		}
+	}
+
+	if execute.Amount > 0 {
+		versioned.Amount = execute.Amount
+	}
+	return &versioned
+}
+
+// releaseReservation releases the budget reserved by an interrupted execution that is given up
+func (s *StrategyService) releaseReservation(strategy *models.QuotaStrategy, reserved bool) {
+	if reserved {
+		s.releaseBudget(strategy.ID, strategy.Amount)
+	}
+}
+
+// updateExecution persists the reconciliation outcome of an execution
+func (s *StrategyService) updateExecution(execute *models.QuotaExecute, updates map[string]interface{}) {
+	if err := s.db.Model(&models.QuotaExecute{}).Where("id = ?", execute.ID).Updates(updates).Error; err != nil {
+		logger.Error("Failed to update execution",
+			zap.Int("execute_id", execute.ID),
+			zap.Error(err))
+	}
+}
+
+// executionRetryBackoff returns the wait after the given number of failed attempts,
+// scheduler.execution_retry_backoff doubled after each failure
+func (s *StrategyService) executionRetryBackoff(attempts int) time.Duration {
+	backoff := s.quotaService.GetConfigManager().GetDirect().Scheduler.ExecutionRetryBackoff
+	if backoff <= 0 {
+		backoff = defaultExecutionRetryBackoff
+	}
+	for i := 1; i < attempts && backoff < maxExecutionRetryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxExecutionRetryBackoff {
+		backoff = maxExecutionRetryBackoff
+	}
+	return backoff
+}
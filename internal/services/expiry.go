@@ -0,0 +1,309 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"quota-manager/internal/models"
+	"quota-manager/internal/utils"
+	"quota-manager/pkg/logger"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// defaultExpiryBatchSize is used when scheduler.expiry_batch_size is not configured
+const defaultExpiryBatchSize = 100
+
+// ExpireQuotas expires quota whose expiry date has passed and adjusts AiGateway accordingly.
+// Users are processed one at a time in user_id order, each in its own short transaction, and the
+// progress is stored in a quota_expiry_run row so a pass interrupted by a crash resumes where it stopped.
+func (s *QuotaService) ExpireQuotas() error {
+	return s.expireQuotas(nil)
+}
+
+// expireQuotas expires quota, counting each user in the progress
+func (s *QuotaService) expireQuotas(progress *ScanJobProgress) error {
+	run, err := s.currentExpiryRun()
+	if err != nil {
+		return err
+	}
+	if run == nil {
+		return nil
+	}
+
+	// Finish the AiGateway adjustment of a user that was interrupted
+	if run.PendingUserID != "" {
+		if err := s.applyPendingExpiry(run); err != nil {
+			return err
+		}
+	}
+
+	batchSize := s.configManager.GetDirect().Scheduler.ExpiryBatchSize
+	if batchSize <= 0 {
+		batchSize = defaultExpiryBatchSize
+	}
+
+	for {
+		var userIDs []string
+		if err := s.db.DB.Model(&models.Quota{}).
+			Distinct("user_id").
+			Where("status = ? AND expiry_date < ? AND user_id > ?", models.StatusValid, run.Cutoff, run.LastUserID).
+			Order("user_id ASC").
+			Limit(batchSize).
+			Pluck("user_id", &userIDs).Error; err != nil {
+			return fmt.Errorf("failed to find users with expired quotas: %w", err)
+		}
+		if len(userIDs) == 0 {
+			break
+		}
+		progress.AddTotal(len(userIDs))
+
+		for _, userID := range userIDs {
+			if err := s.expireUserQuotas(run, userID); err != nil {
+				progress.Failed()
+				if run.PendingUserID != "" {
+					// The expiry is committed but AiGateway is not adjusted yet, the next pass retries it
+					return fmt.Errorf("failed to adjust AiGateway quota for user %s: %w", userID, err)
+				}
+
+				logger.Error("Failed to expire user quota",
+					zap.String("user_id", userID),
+					zap.Error(err))
+				if err := s.db.DB.Model(run).Updates(map[string]interface{}{
+					"last_user_id": userID,
+					"failed_users": gorm.Expr("failed_users + 1"),
+				}).Error; err != nil {
+					return fmt.Errorf("failed to update quota expiry run: %w", err)
+				}
+				run.LastUserID = userID
+				run.FailedUsers++
+				continue
+			}
+			progress.Processed()
+		}
+	}
+
+	finishTime := time.Now()
+	if err := s.db.DB.Model(run).Updates(map[string]interface{}{
+		"status":      models.ExpiryRunStatusCompleted,
+		"finish_time": finishTime,
+	}).Error; err != nil {
+		return fmt.Errorf("failed to complete quota expiry run: %w", err)
+	}
+
+	logger.Info("Quota expiry run completed",
+		zap.Int("run_id", run.ID),
+		zap.Int("processed_users", run.ProcessedUsers),
+		zap.Int("failed_users", run.FailedUsers),
+		zap.Float64("expired_amount", run.ExpiredAmount))
+
+	if run.FailedUsers > 0 {
+		// Quota of failed users stays valid and is picked up by the next pass
+		return fmt.Errorf("failed to expire quota for %d users", run.FailedUsers)
+	}
+	return nil
+}
+
+// currentExpiryRun returns the interrupted expiry run, or starts a new one when quota is due to expire
+func (s *QuotaService) currentExpiryRun() (*models.QuotaExpiryRun, error) {
+	var run models.QuotaExpiryRun
+	err := s.db.DB.Where("status = ?", models.ExpiryRunStatusRunning).Order("id ASC").First(&run).Error
+	if err == nil {
+		logger.Info("Resuming quota expiry run",
+			zap.Int("run_id", run.ID),
+			zap.String("last_user_id", run.LastUserID))
+		return &run, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to query quota expiry run: %w", err)
+	}
+
+	now := utils.NowInConfigTimezone(s.configManager.GetDirect()).Truncate(time.Second)
+
+	var dueCount int64
+	if err := s.db.DB.Model(&models.Quota{}).
+		Where("status = ? AND expiry_date < ?", models.StatusValid, now).
+		Count(&dueCount).Error; err != nil {
+		return nil, fmt.Errorf("failed to count expired quotas: %w", err)
+	}
+	if dueCount == 0 {
+		return nil, nil
+	}
+
+	run = models.QuotaExpiryRun{
+		Cutoff: now,
+		Status: models.ExpiryRunStatusRunning,
+	}
+	if err := s.db.DB.Create(&run).Error; err != nil {
+		return nil, fmt.Errorf("failed to create quota expiry run: %w", err)
+	}
+
+	logger.Info("Started quota expiry run",
+		zap.Int("run_id", run.ID),
+		zap.Time("cutoff", run.Cutoff),
+		zap.Int64("expired_quotas", dueCount))
+	return &run, nil
+}
+
+// expireUserQuotas expires a user's due quota and adjusts AiGateway. AiGateway is queried before and
+// adjusted after the transaction; the adjustment is stored on the run first so it survives a crash.
+func (s *QuotaService) expireUserQuotas(run *models.QuotaExpiryRun, userID string) error {
+	totalQuota, err := s.aiGatewayClient.QueryQuotaValue(userID)
+	if err != nil {
+		return fmt.Errorf("failed to get total quota from AiGateway: %w", err)
+	}
+
+	usedQuota, err := s.aiGatewayClient.QueryUsedQuotaValue(userID)
+	if err != nil {
+		return fmt.Errorf("failed to get used quota from AiGateway: %w", err)
+	}
+
+	tx := s.db.DB.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var expiredQuotas []models.Quota
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ? AND status = ? AND expiry_date < ?", userID, models.StatusValid, run.Cutoff).
+		Find(&expiredQuotas).Error; err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to find expired quotas: %w", err)
+	}
+
+	var expiredAmount float64
+	monthEnd := false
+	loc := utils.GetTimezone(s.configManager.GetDirect())
+	ids := make([]int, 0, len(expiredQuotas))
+	for _, quota := range expiredQuotas {
+		expiredAmount += quota.Amount
+		monthEnd = monthEnd || isMonthEndExpiry(quota.ExpiryDate, loc)
+		ids = append(ids, quota.ID)
+	}
+
+	updates := map[string]interface{}{
+		"last_user_id":    userID,
+		"processed_users": gorm.Expr("processed_users + 1"),
+	}
+
+	var usedDelta, quotaDelta float64
+	if len(ids) > 0 {
+		if err := tx.Model(&models.Quota{}).Where("id IN ?", ids).
+			Update("status", models.StatusExpired).Error; err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to update quota status: %w", err)
+		}
+
+		// Get user's remaining valid quota
+		var validQuotaSum float64
+		if err := tx.Model(&models.Quota{}).
+			Where("user_id = ? AND status = ?", userID, models.StatusValid).
+			Select("COALESCE(SUM(amount), 0)").Scan(&validQuotaSum).Error; err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to calculate valid quota: %w", err)
+		}
+
+		// Used quota is spent from the earliest expiring quota, so the expired quota covers it first.
+		// Only that part is taken back, except that month-end expiry resets the used quota of the month
+		coveredUsed := math.Min(usedQuota, expiredAmount)
+		if monthEnd {
+			coveredUsed = usedQuota
+		}
+		newUsedQuota := usedQuota - coveredUsed
+
+		// Cap the remaining quota at what is still valid and not used
+		remainingQuota := totalQuota - usedQuota
+		if validQuotaSum-newUsedQuota < remainingQuota {
+			remainingQuota = validQuotaSum - newUsedQuota
+		}
+		usedDelta = -coveredUsed
+		quotaDelta = newUsedQuota + remainingQuota - totalQuota
+
+		auditRecord := &models.QuotaAudit{
+			UserID:       userID,
+			Amount:       -expiredAmount, // Negative amount for expiry
+			Operation:    "EXPIRE",
+			StrategyName: "Credit 到期失效",
+			ExpiryDate:   run.Cutoff, // Use the run's cutoff as expiry time
+			CreateTime:   utils.NowInConfigTimezone(s.configManager.GetDirect()),
+		}
+		if err := tx.Create(auditRecord).Error; err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to create expiry audit record: %w", err)
+		}
+
+		updates["pending_user_id"] = userID
+		updates["pending_used_delta"] = usedDelta
+		updates["pending_quota_delta"] = quotaDelta
+		updates["expired_amount"] = gorm.Expr("expired_amount + ?", expiredAmount)
+	}
+
+	if err := tx.Model(run).Updates(updates).Error; err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to update quota expiry run: %w", err)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return fmt.Errorf("failed to commit quota expiry: %w", err)
+	}
+
+	run.LastUserID = userID
+	run.ProcessedUsers++
+	if len(ids) == 0 {
+		// Expired meanwhile by another pass
+		return nil
+	}
+	run.PendingUserID = userID
+	run.PendingUsedDelta = usedDelta
+	run.PendingQuotaDelta = quotaDelta
+	run.ExpiredAmount += expiredAmount
+
+	return s.applyPendingExpiry(run)
+}
+
+// isMonthEndExpiry reports whether quota expires at the end of a month in the configured timezone,
+// as quota of the month based expiry policies does
+func isMonthEndExpiry(expiryDate time.Time, loc *time.Location) bool {
+	next := expiryDate.In(loc).Add(time.Second)
+	return next.Day() == 1 && next.Hour() == 0 && next.Minute() == 0 && next.Second() == 0
+}
+
+// applyPendingExpiry applies the AiGateway adjustment stored on the run and clears it
+func (s *QuotaService) applyPendingExpiry(run *models.QuotaExpiryRun) error {
+	userID := run.PendingUserID
+
+	// Take back used quota first, the step is recorded so a retry does not take it back twice
+	if run.PendingUsedDelta != 0 {
+		if err := s.aiGatewayClient.DeltaUsedQuota(userID, run.PendingUsedDelta); err != nil {
+			return fmt.Errorf("failed to reset used quota for user %s: %w", userID, err)
+		}
+		if err := s.db.DB.Model(run).Update("pending_used_delta", 0).Error; err != nil {
+			return fmt.Errorf("failed to update quota expiry run: %w", err)
+		}
+		run.PendingUsedDelta = 0
+	}
+
+	// Adjust total quota
+	if run.PendingQuotaDelta != 0 {
+		if err := s.aiGatewayClient.DeltaQuota(userID, run.PendingQuotaDelta); err != nil {
+			return fmt.Errorf("failed to adjust total quota for user %s: %w", userID, err)
+		}
+	}
+
+	if err := s.db.DB.Model(run).Updates(map[string]interface{}{
+		"pending_user_id":     "",
+		"pending_quota_delta": 0,
+	}).Error; err != nil {
+		return fmt.Errorf("failed to update quota expiry run: %w", err)
+	}
+	run.PendingUserID = ""
+	run.PendingQuotaDelta = 0
+
+	return nil
+}
@@ -0,0 +1,287 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"quota-manager/internal/config"
+	"quota-manager/internal/database"
+	"quota-manager/pkg/logger"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// advisoryLockNamespace is the first key of every advisory lock taken by quota-manager
+	advisoryLockNamespace = 51730
+	// leaderLockID is the second key of the scheduler leader lock
+	leaderLockID = 1
+	// LeaderLockName names the scheduler leader lock
+	LeaderLockName = "scheduler-leader"
+	// defaultLeaderElectionInterval is used when scheduler.leader_election_interval is not configured
+	defaultLeaderElectionInterval = 10 * time.Second
+	// applicationNamePrefix marks database sessions holding quota-manager locks
+	applicationNamePrefix = "quota-manager:"
+)
+
+// advisoryLocks lists the advisory locks reported by LockHolders
+var advisoryLocks = []struct {
+	Name string
+	ID   int
+}{
+	{Name: LeaderLockName, ID: leaderLockID},
+}
+
+// LockHolder describes which instance holds an advisory lock
+type LockHolder struct {
+	Lock        string     `json:"lock"`
+	Held        bool       `json:"held"`
+	InstanceID  string     `json:"instance_id,omitempty"`
+	PID         int        `json:"pid,omitempty"`         // Backend process of the holding session
+	ClientAddr  string     `json:"client_addr,omitempty"` // Address the holding session connects from
+	ConnectedAt *time.Time `json:"connected_at,omitempty"`
+	Self        bool       `json:"self"` // Held by this instance
+}
+
+// LockStatus is this instance's view of the scheduler locks
+type LockStatus struct {
+	InstanceID string       `json:"instance_id"`
+	IsLeader   bool         `json:"is_leader"`
+	Locks      []LockHolder `json:"locks"`
+}
+
+// LeaderElector elects one instance to run scheduled jobs using a PostgreSQL session advisory lock.
+// The lock is held on a dedicated connection, so it is released when the leader stops or its
+// connection is lost, and another instance takes over at its next attempt. A nil elector always
+// leads, which keeps single-instance setups and tests unchanged
+type LeaderElector struct {
+	db         *database.DB
+	instanceID string
+	interval   time.Duration
+
+	mu        sync.Mutex
+	conn      *sql.Conn // Connection holding the leader lock, nil when not leading
+	onElected []func()  // Run each time this instance becomes leader
+	stopChan  chan struct{}
+	stopped   sync.WaitGroup
+}
+
+// NewLeaderElector creates a leader elector, the instance ID defaults to hostname-pid
+func NewLeaderElector(db *database.DB, cfg *config.SchedulerConfig) *LeaderElector {
+	instanceID := cfg.InstanceID
+	if instanceID == "" {
+		hostname, _ := os.Hostname()
+		instanceID = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+
+	interval := cfg.LeaderElectionInterval
+	if interval <= 0 {
+		interval = defaultLeaderElectionInterval
+	}
+
+	return &LeaderElector{
+		db:         db,
+		instanceID: instanceID,
+		interval:   interval,
+	}
+}
+
+// Start makes a first election attempt and keeps campaigning in the background
+func (e *LeaderElector) Start() {
+	e.stopChan = make(chan struct{})
+	e.campaign()
+
+	e.stopped.Add(1)
+	go func() {
+		defer e.stopped.Done()
+		ticker := time.NewTicker(e.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				e.campaign()
+			case <-e.stopChan:
+				return
+			}
+		}
+	}()
+
+	logger.Info("Leader election started",
+		zap.String("instance_id", e.instanceID),
+		zap.Duration("interval", e.interval),
+		zap.Bool("is_leader", e.IsLeader()))
+}
+
+// Stop stops campaigning and releases the leader lock if held
+func (e *LeaderElector) Stop() {
+	if e.stopChan != nil {
+		close(e.stopChan)
+		e.stopped.Wait()
+		e.stopChan = nil
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.conn != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		e.unlock(ctx)
+		logger.Info("Leadership released", zap.String("instance_id", e.instanceID))
+	}
+}
+
+// InstanceID returns the ID this instance is known by in lock listings
+func (e *LeaderElector) InstanceID() string {
+	if e == nil {
+		return ""
+	}
+	return e.instanceID
+}
+
+// IsLeader reports whether this instance runs scheduled jobs
+func (e *LeaderElector) IsLeader() bool {
+	if e == nil {
+		return true
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.conn != nil
+}
+
+// Guard wraps a scheduled job so that it only runs on the leader
+func (e *LeaderElector) Guard(job string, fn func()) func() {
+	return func() {
+		if !e.IsLeader() {
+			logger.Debug("Skipping scheduled job on non-leader instance",
+				zap.String("job", job),
+				zap.String("instance_id", e.InstanceID()))
+			return
+		}
+		fn()
+	}
+}
+
+// OnElected registers fn to run in the background each time this instance becomes leader, and right
+// away if it already leads. A nil elector always leads, so fn runs once
+func (e *LeaderElector) OnElected(fn func()) {
+	if e == nil {
+		go fn()
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.onElected = append(e.onElected, fn)
+	if e.conn != nil {
+		go fn()
+	}
+}
+
+// campaign checks that a held lock is still alive, or tries to take the lock when not leading
+func (e *LeaderElector) campaign() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if e.conn != nil {
+		if err := e.conn.PingContext(ctx); err != nil {
+			logger.Error("Leader lock connection lost, leadership given up",
+				zap.String("instance_id", e.instanceID),
+				zap.Error(err))
+			e.unlock(ctx)
+		}
+		return
+	}
+
+	sqlDB, err := e.db.DB.DB()
+	if err != nil {
+		logger.Error("Failed to get database handle for leader election", zap.Error(err))
+		return
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		logger.Error("Failed to open leader election connection", zap.Error(err))
+		return
+	}
+
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1, $2)", advisoryLockNamespace, leaderLockID).Scan(&acquired); err != nil {
+		logger.Error("Failed to try leader lock", zap.Error(err))
+		conn.Close()
+		return
+	}
+	if !acquired {
+		conn.Close()
+		return
+	}
+	e.conn = conn
+
+	// The application name identifies this instance in pg_stat_activity
+	if _, err := conn.ExecContext(ctx, "SELECT set_config('application_name', $1, false)", applicationNamePrefix+e.instanceID); err != nil {
+		logger.Warn("Failed to set leader lock application name", zap.Error(err))
+	}
+	logger.Info("Leadership acquired, scheduled jobs run on this instance", zap.String("instance_id", e.instanceID))
+	for _, fn := range e.onElected {
+		go fn()
+	}
+}
+
+// unlock releases the leader lock and returns its connection to the pool. A broken connection
+// is discarded by the pool, which ends its session and the lock with it
+func (e *LeaderElector) unlock(ctx context.Context) {
+	if _, err := e.conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1, $2), set_config('application_name', '', false)",
+		advisoryLockNamespace, leaderLockID); err != nil {
+		logger.Warn("Failed to release leader lock", zap.Error(err))
+	}
+	if err := e.conn.Close(); err != nil {
+		logger.Warn("Failed to close leader lock connection", zap.Error(err))
+	}
+	e.conn = nil
+}
+
+// LockHolders lists the quota-manager advisory locks and the sessions holding them
+func (e *LeaderElector) LockHolders() (*LockStatus, error) {
+	var rows []struct {
+		ObjID           int       `gorm:"column:obj_id"`
+		PID             int       `gorm:"column:pid"`
+		ApplicationName string    `gorm:"column:application_name"`
+		ClientAddr      string    `gorm:"column:client_addr"`
+		BackendStart    time.Time `gorm:"column:backend_start"`
+	}
+	if err := e.db.DB.Raw(`SELECT l.objid::int AS obj_id, a.pid, a.application_name,
+			COALESCE(host(a.client_addr), '') AS client_addr, a.backend_start
+		FROM pg_locks l JOIN pg_stat_activity a ON a.pid = l.pid
+		WHERE l.locktype = 'advisory' AND l.granted AND l.objsubid = 2 AND l.classid::int = ?`,
+		advisoryLockNamespace).Scan(&rows).Error; err != nil {
+		return nil, NewDatabaseError("query advisory locks", err)
+	}
+
+	status := &LockStatus{
+		InstanceID: e.instanceID,
+		IsLeader:   e.IsLeader(),
+		Locks:      make([]LockHolder, 0, len(advisoryLocks)),
+	}
+	for _, lock := range advisoryLocks {
+		holder := LockHolder{Lock: lock.Name}
+		for _, row := range rows {
+			if row.ObjID != lock.ID {
+				continue
+			}
+			connectedAt := row.BackendStart
+			holder.Held = true
+			holder.InstanceID = strings.TrimPrefix(row.ApplicationName, applicationNamePrefix)
+			holder.PID = row.PID
+			holder.ClientAddr = row.ClientAddr
+			holder.ConnectedAt = &connectedAt
+			holder.Self = holder.InstanceID == e.instanceID
+		}
+		status.Locks = append(status.Locks, holder)
+	}
+	return status, nil
+}
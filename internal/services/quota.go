@@ -2,6 +2,7 @@ package services
 
 import (
 	"fmt"
+	"math"
 	"quota-manager/internal/config"
 	"quota-manager/internal/database"
 	"quota-manager/internal/models"
@@ -13,6 +14,7 @@ import (
 
 	"go.uber.org/zap"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // QuotaService handles quota-related operations
@@ -42,28 +44,33 @@ func NewQuotaService(db *database.DB, configManager *config.Manager, aiGatewayCl
 
 // QuotaInfo represents user quota information
 type QuotaInfo struct {
-	TotalQuota float64           `json:"total_quota"`
-	UsedQuota  float64           `json:"used_quota"`
-	QuotaList  []QuotaDetailItem `json:"quota_list"`
-	IsStar     string            `json:"is_star,omitempty"`
+	TotalQuota  float64            `json:"total_quota"`
+	UsedQuota   float64            `json:"used_quota"`
+	HeldQuota   float64            `json:"held_quota"`
+	QuotaList   []QuotaDetailItem  `json:"quota_list"`
+	ModelQuotas map[string]float64 `json:"model_quotas,omitempty"` // Remaining quota of each model pool
+	IsStar      string             `json:"is_star,omitempty"`
 }
 
 // QuotaDetailItem represents quota detail item
 type QuotaDetailItem struct {
+	Model      string    `json:"model,omitempty"` // Empty for the general pool
 	Amount     float64   `json:"amount"`
 	ExpiryDate time.Time `json:"expiry_date"`
 }
 
 // QuotaAuditRecord represents quota audit record
 type QuotaAuditRecord struct {
-	Amount       float64                   `json:"amount"`
-	Operation    string                    `json:"operation"`
-	VoucherCode  string                    `json:"voucher_code,omitempty"`
-	RelatedUser  string                    `json:"related_user,omitempty"`
-	StrategyName string                    `json:"strategy_name,omitempty"`
-	ExpiryDate   time.Time                 `json:"expiry_date"`
-	Details      *models.QuotaAuditDetails `json:"details,omitempty"`
-	CreateTime   time.Time                 `json:"create_time"`
+	Amount          float64                   `json:"amount"`
+	Operation       string                    `json:"operation"`
+	VoucherCode     string                    `json:"voucher_code,omitempty"`
+	RelatedUser     string                    `json:"related_user,omitempty"`
+	StrategyName    string                    `json:"strategy_name,omitempty"`
+	StrategyVersion int                       `json:"strategy_version,omitempty"`
+	Operator        string                    `json:"operator,omitempty"`
+	ExpiryDate      time.Time                 `json:"expiry_date"`
+	Details         *models.QuotaAuditDetails `json:"details,omitempty"`
+	CreateTime      time.Time                 `json:"create_time"`
 }
 
 // TransferOutRequest represents transfer out request
@@ -155,27 +162,24 @@ func (s *QuotaService) GetUserQuota(userID string) (*QuotaInfo, error) {
 	}
 
 	// Calculate remaining quotas considering used quota
-	quotaList := make([]QuotaDetailItem, 0)
-	remainingUsed := usedQuota
+	quotaList := remainingQuotaItems(quotas, usedQuota)
 
-	for _, quota := range quotas {
-		if remainingUsed <= 0 {
-			// No more used quota to deduct
-			quotaList = append(quotaList, QuotaDetailItem{
-				Amount:     quota.Amount,
-				ExpiryDate: quota.ExpiryDate,
-			})
-		} else if quota.Amount > remainingUsed {
-			// This quota is partially consumed
-			quotaList = append(quotaList, QuotaDetailItem{
-				Amount:     quota.Amount - remainingUsed,
-				ExpiryDate: quota.ExpiryDate,
-			})
-			remainingUsed = 0
-		} else {
-			// This quota is fully consumed
-			remainingUsed -= quota.Amount
+	// Break the remaining quota down by model pool
+	var modelQuotas map[string]float64
+	for _, item := range quotaList {
+		if item.Model == "" {
+			continue
 		}
+		if modelQuotas == nil {
+			modelQuotas = make(map[string]float64)
+		}
+		modelQuotas[item.Model] += item.Amount
+	}
+
+	// Held quota is already taken out of the quota list, report it separately
+	heldQuota, err := s.getHeldQuota(userID)
+	if err != nil {
+		return nil, err
 	}
 
 	// checkGithubStar checks if user has starred the required GitHub repository
@@ -201,17 +205,21 @@ func (s *QuotaService) GetUserQuota(userID string) (*QuotaInfo, error) {
 			}
 		}
 		return &QuotaInfo{
-			TotalQuota: totalQuota,
-			UsedQuota:  usedQuota,
-			QuotaList:  quotaList,
-			IsStar:     isStar,
+			TotalQuota:  totalQuota,
+			UsedQuota:   usedQuota,
+			HeldQuota:   heldQuota,
+			QuotaList:   quotaList,
+			ModelQuotas: modelQuotas,
+			IsStar:      isStar,
 		}, nil
 	}
 
 	return &QuotaInfo{
-		TotalQuota: totalQuota,
-		UsedQuota:  usedQuota,
-		QuotaList:  quotaList,
+		TotalQuota:  totalQuota,
+		UsedQuota:   usedQuota,
+		HeldQuota:   heldQuota,
+		QuotaList:   quotaList,
+		ModelQuotas: modelQuotas,
 	}, nil
 }
 
@@ -248,14 +256,16 @@ func (s *QuotaService) GetQuotaAuditRecords(userID string, page, pageSize int) (
 		}
 
 		result[i] = QuotaAuditRecord{
-			Amount:       record.Amount,
-			Operation:    record.Operation,
-			VoucherCode:  record.VoucherCode,
-			RelatedUser:  record.RelatedUser,
-			StrategyName: record.StrategyName,
-			ExpiryDate:   record.ExpiryDate,
-			Details:      details,
-			CreateTime:   record.CreateTime,
+			Amount:          record.Amount,
+			Operation:       record.Operation,
+			VoucherCode:     record.VoucherCode,
+			RelatedUser:     record.RelatedUser,
+			StrategyName:    record.StrategyName,
+			StrategyVersion: record.StrategyVersion,
+			Operator:        record.Operator,
+			ExpiryDate:      record.ExpiryDate,
+			Details:         details,
+			CreateTime:      record.CreateTime,
 		}
 	}
 
@@ -282,25 +292,23 @@ func (s *QuotaService) TransferOut(giver *models.AuthUser, req *TransferOutReque
 		return nil, fmt.Errorf("failed to get quota list: %w", err)
 	}
 
-	// Calculate remaining quotas for each expiry date
+	// Calculate remaining quotas for each expiry date, model pools cannot be transferred
 	quotaAvailabilityMap := make(map[string]float64) // key: expiry_date as string, value: available amount
-	remainingUsed := usedQuota
-
 	for _, quota := range quotas {
+		// Fully used expiry dates stay in the map with nothing available
 		dateKey := quota.ExpiryDate.Format("2006-01-02T15:04:05Z07:00")
-		var availableFromThisQuota float64
-		if remainingUsed <= 0 {
-			availableFromThisQuota = quota.Amount
-		} else if quota.Amount > remainingUsed {
-			availableFromThisQuota = quota.Amount - remainingUsed
-			remainingUsed = 0
-		} else {
-			availableFromThisQuota = 0
-			remainingUsed -= quota.Amount
+		if _, exists := quotaAvailabilityMap[dateKey]; !exists && quota.Model == "" {
+			quotaAvailabilityMap[dateKey] = 0
 		}
+	}
+	for _, item := range remainingQuotaItems(quotas, usedQuota) {
+		if item.Model != "" {
+			continue
+		}
+		dateKey := item.ExpiryDate.Format("2006-01-02T15:04:05Z07:00")
 
 		// Add to existing amount for the same expiry date (accumulate instead of overwriting)
-		quotaAvailabilityMap[dateKey] += availableFromThisQuota
+		quotaAvailabilityMap[dateKey] += item.Amount
 	}
 
 	// Start transaction
@@ -329,8 +337,8 @@ func (s *QuotaService) TransferOut(giver *models.AuthUser, req *TransferOutReque
 		// Also validate the total quota exists in database for this expiry date
 		var totalQuotaAmount float64
 		if err := tx.Model(&models.Quota{}).
-			Where("user_id = ? AND expiry_date = ? AND status = ?",
-				giver.ID, quotaItem.ExpiryDate, models.StatusValid).
+			Where("user_id = ? AND model = ? AND expiry_date = ? AND status = ?",
+				giver.ID, "", quotaItem.ExpiryDate, models.StatusValid).
 			Select("COALESCE(SUM(amount), 0)").
 			Scan(&totalQuotaAmount).Error; err != nil {
 			tx.Rollback()
@@ -383,16 +391,16 @@ func (s *QuotaService) TransferOut(giver *models.AuthUser, req *TransferOutReque
 	// Update quota table - reduce giver's quota
 	for _, quotaItem := range req.QuotaList {
 		if err := tx.Model(&models.Quota{}).
-			Where("user_id = ? AND expiry_date = ? AND status = ?",
-				giver.ID, quotaItem.ExpiryDate, models.StatusValid).
+			Where("user_id = ? AND model = ? AND expiry_date = ? AND status = ?",
+				giver.ID, "", quotaItem.ExpiryDate, models.StatusValid).
 			Update("amount", gorm.Expr("amount - ?", quotaItem.Amount)).Error; err != nil {
 			tx.Rollback()
 			return nil, fmt.Errorf("failed to update quota: %w", err)
 		}
 
 		// Delete quota records with zero or negative amounts
-		if err := tx.Where("user_id = ? AND expiry_date = ? AND status = ? AND amount <= 0",
-			giver.ID, quotaItem.ExpiryDate, models.StatusValid).Delete(&models.Quota{}).Error; err != nil {
+		if err := tx.Where("user_id = ? AND model = ? AND expiry_date = ? AND status = ? AND amount <= 0",
+			giver.ID, "", quotaItem.ExpiryDate, models.StatusValid).Delete(&models.Quota{}).Error; err != nil {
 			tx.Rollback()
 			return nil, fmt.Errorf("failed to delete zero quota records: %w", err)
 		}
@@ -541,8 +549,8 @@ func (s *QuotaService) TransferIn(receiver *models.AuthUser, req *TransferInRequ
 		// Only process valid quota
 		if !isExpired {
 			var existingQuota models.Quota
-			if err := tx.Where("user_id = ? AND expiry_date = ? AND status = ?",
-				receiver.ID, quotaItem.ExpiryDate, models.StatusValid).First(&existingQuota).Error; err != nil {
+			if err := tx.Where("user_id = ? AND model = ? AND expiry_date = ? AND status = ?",
+				receiver.ID, "", quotaItem.ExpiryDate, models.StatusValid).First(&existingQuota).Error; err != nil {
 				// Create new quota record
 				newQuota := &models.Quota{
 					UserID:     receiver.ID,
@@ -725,15 +733,23 @@ func (s *QuotaService) TransferIn(receiver *models.AuthUser, req *TransferInRequ
 	}, nil
 }
 
-// AddQuotaForStrategy adds quota for strategy execution
+// AddQuotaForStrategy adds general pool quota for strategy execution using the default
+// end_of_month expiry policy
 func (s *QuotaService) AddQuotaForStrategy(userID string, amount float64, strategyID int, strategyName string) error {
-	// Calculate expiry date (end of this/next month)
 	now := utils.NowInConfigTimezone(s.configManager.GetDirect()).Truncate(time.Second)
-	var expiryDate time.Time
+	defaultStrategy := models.QuotaStrategy{ExpiryPolicy: models.ExpiryPolicyEndOfMonth}
+	return s.AddModelQuotaForStrategy(userID, "", amount, defaultStrategy.ExpiryDateAt(now), strategyID, strategyName)
+}
 
-	// Always set to end of current month
-	expiryDate = time.Date(now.Year(), now.Month()+1, 0, 23, 59, 59, 0, now.Location())
+// AddModelQuotaForStrategy adds quota expiring at expiryDate to the model pool for strategy execution,
+// an empty model adds to the general pool
+func (s *QuotaService) AddModelQuotaForStrategy(userID, model string, amount float64, expiryDate time.Time, strategyID int, strategyName string) error {
+	return s.addModelQuotaForStrategy(userID, model, amount, expiryDate, strategyID, 0, strategyName, "")
+}
 
+// addModelQuotaForStrategy adds strategy quota and records the strategy version that granted it,
+// and the amount expression when the amount was computed for the user
+func (s *QuotaService) addModelQuotaForStrategy(userID, model string, amount float64, expiryDate time.Time, strategyID, strategyVersion int, strategyName, amountExpr string) error {
 	// Start transaction
 	tx := s.db.DB.Begin()
 	defer func() {
@@ -743,36 +759,17 @@ func (s *QuotaService) AddQuotaForStrategy(userID string, amount float64, strate
 	}()
 
 	// Add or update quota
-	var quota models.Quota
-	err := tx.Where("user_id = ? AND expiry_date = ? AND status = ?",
-		userID, expiryDate, models.StatusValid).First(&quota).Error
-
-	if err == gorm.ErrRecordNotFound {
-		// Create new quota record
-		quota = models.Quota{
-			UserID:     userID,
-			Amount:     amount,
-			ExpiryDate: expiryDate,
-			Status:     models.StatusValid,
-		}
-		if err := tx.Create(&quota).Error; err != nil {
-			tx.Rollback()
-			return fmt.Errorf("failed to create quota: %w", err)
-		}
-	} else if err != nil {
+	quota, err := addQuotaRow(tx, userID, model, amount, expiryDate)
+	if err != nil {
 		tx.Rollback()
-		return fmt.Errorf("failed to query quota: %w", err)
-	} else {
-		// Update existing quota
-		if err := tx.Model(&quota).Update("amount", quota.Amount+amount).Error; err != nil {
-			tx.Rollback()
-			return fmt.Errorf("failed to update quota: %w", err)
-		}
+		return err
 	}
 
 	// Prepare detailed audit information for recharge
 	auditDetails := &models.QuotaAuditDetails{
-		Operation: models.OperationRecharge,
+		Operation:  models.OperationRecharge,
+		Model:      model,
+		AmountExpr: amountExpr,
 		Summary: models.QuotaAuditSummary{
 			TotalAmount:        amount,
 			TotalItems:         1,
@@ -797,12 +794,13 @@ func (s *QuotaService) AddQuotaForStrategy(userID string, amount float64, strate
 
 	// Record audit log only if it's not expired yet
 	auditRecord := &models.QuotaAudit{
-		UserID:       userID,
-		Amount:       amount,
-		Operation:    models.OperationRecharge,
-		StrategyID:   &strategyID,
-		StrategyName: strategyName,
-		ExpiryDate:   expiryDate,
+		UserID:          userID,
+		Amount:          amount,
+		Operation:       models.OperationRecharge,
+		StrategyID:      &strategyID,
+		StrategyName:    strategyName,
+		StrategyVersion: strategyVersion,
+		ExpiryDate:      expiryDate,
 	}
 	if err := auditRecord.MarshalDetails(auditDetails); err != nil {
 		tx.Rollback()
@@ -819,128 +817,48 @@ func (s *QuotaService) AddQuotaForStrategy(userID string, amount float64, strate
 		return fmt.Errorf("failed to update AiGateway quota: %w", err)
 	}
 
-	tx.Commit()
+	if err := tx.Commit().Error; err != nil {
+		return fmt.Errorf("failed to commit recharge: %w", err)
+	}
 	return nil
 }
 
-// ExpireQuotas expires quotas and synchronizes with AiGateway
-func (s *QuotaService) ExpireQuotas() error {
-	now := utils.NowInConfigTimezone(s.configManager.GetDirect()).Truncate(time.Second)
-
-	// Step 1: Record monthly used quota (before finding expired but still valid quotas)
-	logger.Info("Step 1: Recording monthly used quota before expiry processing")
-	if err := s.recordMonthlyUsedQuota(now); err != nil {
-		logger.Error("Failed to record monthly used quota", zap.Error(err))
-		// Note: Do not return error directly here, continue with expiry processing but log the error
-		// because monthly quota recording failure should not affect the main quota expiry functionality
-	}
-
-	// Step 2: Find expired but still valid quotas (original logic)
-	logger.Info("Step 2: Finding expired but still valid quotas")
-	var expiredQuotas []models.Quota
-	if err := s.db.DB.Where("status = ? AND expiry_date < ?", models.StatusValid, now).Find(&expiredQuotas).Error; err != nil {
-		return fmt.Errorf("failed to find expired quotas: %w", err)
-	}
-
-	if len(expiredQuotas) == 0 {
-		return nil
-	}
-
-	// Group by user
-	userQuotaMap := make(map[string]float64)
-	for _, quota := range expiredQuotas {
-		userQuotaMap[quota.UserID] += quota.Amount
-	}
-
-	// Start transaction
-	tx := s.db.DB.Begin()
-	defer func() {
-		if r := recover(); r != nil {
-			tx.Rollback()
-		}
-	}()
-
-	// Update status to expired
-	if err := tx.Model(&models.Quota{}).
-		Where("status = ? AND expiry_date < ?", models.StatusValid, now).
-		Update("status", models.StatusExpired).Error; err != nil {
-		tx.Rollback()
-		return fmt.Errorf("failed to update quota status: %w", err)
-	}
-
-	// Process each user
-	for userID, expiredAmount := range userQuotaMap {
-		// Get user's remaining valid quota
-		var validQuotaSum float64
-		if err := tx.Model(&models.Quota{}).
-			Where("user_id = ? AND status = ?", userID, models.StatusValid).
-			Select("COALESCE(SUM(amount), 0)").Scan(&validQuotaSum).Error; err != nil {
-			tx.Rollback()
-			return fmt.Errorf("failed to calculate valid quota for user %s: %w", userID, err)
-		}
-
-		// Get current quota info from AiGateway
-		totalQuota, err := s.aiGatewayClient.QueryQuotaValue(userID)
-		if err != nil {
-			tx.Rollback()
-			return fmt.Errorf("failed to get total quota from AiGateway for user %s: %w", userID, err)
-		}
-
-		usedQuota, err := s.aiGatewayClient.QueryUsedQuotaValue(userID)
-		if err != nil {
-			tx.Rollback()
-			return fmt.Errorf("failed to get used quota from AiGateway for user %s: %w", userID, err)
-		}
-
-		remainingQuota := totalQuota - usedQuota
-
-		// Reset used quota first
-		if err := s.aiGatewayClient.DeltaUsedQuota(userID, -usedQuota); err != nil {
-			tx.Rollback()
-			return fmt.Errorf("failed to reset used quota for user %s: %w", userID, err)
-		}
-
-		// Adjust total quota
-		validQuota := validQuotaSum
-		var newTotalQuota float64
-		if validQuota >= remainingQuota {
-			newTotalQuota = remainingQuota
-		} else {
-			newTotalQuota = validQuota
-		}
+// addQuotaRow adds amount to the user's valid quota row of the model pool and expiry date, creating it if needed
+func addQuotaRow(tx *gorm.DB, userID, model string, amount float64, expiryDate time.Time) (*models.Quota, error) {
+	var quota models.Quota
+	err := tx.Where("user_id = ? AND model = ? AND expiry_date = ? AND status = ?",
+		userID, model, expiryDate, models.StatusValid).First(&quota).Error
 
-		deltaQuota := newTotalQuota - totalQuota
-		if deltaQuota != 0 {
-			if err := s.aiGatewayClient.DeltaQuota(userID, deltaQuota); err != nil {
-				tx.Rollback()
-				return fmt.Errorf("failed to adjust total quota for user %s: %w", userID, err)
-			}
+	if err == gorm.ErrRecordNotFound {
+		// Create new quota record
+		quota = models.Quota{
+			UserID:     userID,
+			Model:      model,
+			Amount:     amount,
+			ExpiryDate: expiryDate,
+			Status:     models.StatusValid,
 		}
-
-		// Create audit record for quota expiry
-		auditRecord := &models.QuotaAudit{
-			UserID:       userID,
-			Amount:       -expiredAmount, // Negative amount for expiry
-			Operation:    "EXPIRE",
-			StrategyName: "Credit 到期失效",
-			ExpiryDate:   now, // Use current time as expiry time
-			CreateTime:   utils.NowInConfigTimezone(s.configManager.GetDirect()),
+		if err := tx.Create(&quota).Error; err != nil {
+			return nil, fmt.Errorf("failed to create quota: %w", err)
 		}
-		if err := tx.Create(auditRecord).Error; err != nil {
-			tx.Rollback()
-			return fmt.Errorf("failed to create expiry audit record for user %s: %w", userID, err)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to query quota: %w", err)
+	} else {
+		// Update existing quota
+		if err := tx.Model(&quota).Update("amount", quota.Amount+amount).Error; err != nil {
+			return nil, fmt.Errorf("failed to update quota: %w", err)
 		}
 	}
 
-	tx.Commit()
-	return nil
+	return &quota, nil
 }
 
-// MergeQuotaRecords merges quota records for the same user and expiry date
+// MergeQuotaRecords merges quota records for the same user, model pool and expiry date
 func (s *QuotaService) MergeQuotaRecords() error {
-	// QuotaGroup represents quota records grouped by user and expiry date
+	// QuotaGroup represents quota records grouped by user, model pool and expiry date
 	type QuotaGroup struct {
 		UserID      string    `gorm:"column:user_id"`
+		Model       string    `gorm:"column:model"`
 		ExpiryDate  time.Time `gorm:"column:expiry_date"`
 		Status      string    `gorm:"column:status"`
 		TotalAmount float64   `gorm:"column:total_amount"`
@@ -950,8 +868,8 @@ func (s *QuotaService) MergeQuotaRecords() error {
 	// Find groups with multiple records
 	var groups []QuotaGroup
 	result := s.db.DB.Model(&models.Quota{}).
-		Select("user_id, expiry_date, status, SUM(amount) as total_amount, COUNT(*) as record_count").
-		Group("user_id, expiry_date, status").
+		Select("user_id, model, expiry_date, status, SUM(amount) as total_amount, COUNT(*) as record_count").
+		Group("user_id, model, expiry_date, status").
 		Having("COUNT(*) > 1").
 		Scan(&groups)
 
@@ -970,8 +888,8 @@ func (s *QuotaService) MergeQuotaRecords() error {
 	// Process each group that has duplicates
 	for _, group := range groups {
 		// Delete all existing records for this group
-		if err := tx.Where("user_id = ? AND expiry_date = ? AND status = ?",
-			group.UserID, group.ExpiryDate, group.Status).Delete(&models.Quota{}).Error; err != nil {
+		if err := tx.Where("user_id = ? AND model = ? AND expiry_date = ? AND status = ?",
+			group.UserID, group.Model, group.ExpiryDate, group.Status).Delete(&models.Quota{}).Error; err != nil {
 			tx.Rollback()
 			return fmt.Errorf("failed to delete duplicate quota records: %w", err)
 		}
@@ -980,6 +898,7 @@ func (s *QuotaService) MergeQuotaRecords() error {
 		if group.TotalAmount > 0 {
 			mergedQuota := &models.Quota{
 				UserID:     group.UserID,
+				Model:      group.Model,
 				Amount:     group.TotalAmount,
 				ExpiryDate: group.ExpiryDate,
 				Status:     group.Status,
@@ -1024,6 +943,7 @@ func (s *QuotaService) GetUserQuotaAuditRecords(userID string, page, pageSize in
 			VoucherCode:  record.VoucherCode,
 			RelatedUser:  record.RelatedUser,
 			StrategyName: record.StrategyName,
+			Operator:     record.Operator,
 			ExpiryDate:   record.ExpiryDate,
 			CreateTime:   record.CreateTime,
 		}
@@ -1115,6 +1035,11 @@ func (s *QuotaService) recordUserMonthlyUsedQuota(userID string, yearMonth strin
 
 // SyncQuotasWithAiGateway synchronizes all users' quotas with AiGateway
 func (s *QuotaService) SyncQuotasWithAiGateway() error {
+	return s.SyncQuotas(nil)
+}
+
+// SyncQuotas synchronizes the quota of users with valid quota with AiGateway, counting each user in the progress
+func (s *QuotaService) SyncQuotas(progress *ScanJobProgress) error {
 	logger.Info("Starting quota sync task")
 
 	// Step 1: Get all users with valid quotas from quota table
@@ -1124,6 +1049,7 @@ func (s *QuotaService) SyncQuotasWithAiGateway() error {
 	}
 
 	logger.Info("Found users with valid quota", zap.Int("user_count", len(userIDs)))
+	progress.AddTotal(len(userIDs))
 
 	// Step 2: Process each user
 	for _, userID := range userIDs {
@@ -1131,9 +1057,11 @@ func (s *QuotaService) SyncQuotasWithAiGateway() error {
 			logger.Error("Failed to sync user quota",
 				zap.String("user_id", userID),
 				zap.Error(err))
+			progress.Failed()
 			// Continue processing other users, don't interrupt the entire flow
 			continue
 		}
+		progress.Processed()
 	}
 
 	logger.Info("Quota sync task completed")
@@ -1182,11 +1110,13 @@ func (s *QuotaService) syncUserQuotaWithAiGateway(userID string) error {
 	return nil
 }
 
-// recordMonthlyUsedQuota records monthly used quota for all users
-func (s *QuotaService) recordMonthlyUsedQuota(now time.Time) error {
+// RecordMonthlyUsedQuota records last month's used quota for all users, it runs at the start of a month
+// before quota expiry resets the used quota in AiGateway
+func (s *QuotaService) RecordMonthlyUsedQuota() error {
 	logger.Info("Starting to record monthly used quota")
 
 	// Get last month's year-month in YYYY-MM format
+	now := utils.NowInConfigTimezone(s.configManager.GetDirect())
 	lastMonth := now.AddDate(0, -1, 0)
 	yearMonth := lastMonth.Format("2006-01")
 
@@ -1215,17 +1145,52 @@ func (s *QuotaService) recordMonthlyUsedQuota(now time.Time) error {
 	return nil
 }
 
-// DeductQuota deducts quota from a user's account
-func (s *QuotaService) DeductQuota(userID string, amount float64, reason, referenceID, model string) error {
+// DeductQuotaRequest represents a quota deduction request
+type DeductQuotaRequest struct {
+	UserID      string  `json:"user_id" validate:"required,uuid"`
+	Amount      float64 `json:"amount" validate:"required,gt=0"`
+	Reason      string  `json:"reason" validate:"omitempty,max=100"`
+	ReferenceID string  `json:"reference_id" validate:"required,min=1,max=255"`
+	Model       string  `json:"model" validate:"omitempty,max=100"`
+}
+
+// DeductQuotaResponse represents the result of a quota deduction
+type DeductQuotaResponse struct {
+	ReferenceID    string    `json:"reference_id"`
+	UserID         string    `json:"user_id"`
+	Amount         float64   `json:"amount"`
+	Model          string    `json:"model,omitempty"`
+	RemainingQuota float64   `json:"remaining_quota"`
+	Duplicate      bool      `json:"duplicate"` // true when the reference ID was already processed
+	CreateTime     time.Time `json:"create_time"`
+}
+
+// DeductQuota deducts quota from a user's account. The reference ID is an idempotency key:
+// repeating a deduction with the same reference ID returns the original result without deducting again.
+func (s *QuotaService) DeductQuota(req *DeductQuotaRequest, apiKeyID *int) (*DeductQuotaResponse, error) {
+	userID := req.UserID
+	amount := roundToCents(req.Amount)
+
 	// Validate amount
 	if amount <= 0 {
-		return fmt.Errorf("amount must be positive")
+		return nil, fmt.Errorf("amount must be positive")
+	}
+	if req.ReferenceID == "" {
+		return nil, fmt.Errorf("reference_id is required")
+	}
+
+	// Return the original result if this reference ID was already processed
+	var existing models.QuotaDeduction
+	if err := s.db.DB.Where("reference_id = ?", req.ReferenceID).First(&existing).Error; err == nil {
+		return replayDeduction(&existing, req)
+	} else if err != gorm.ErrRecordNotFound {
+		return nil, fmt.Errorf("failed to check deduction reference: %w", err)
 	}
 
 	// Get used quota from AiGateway
 	usedQuota, err := s.aiGatewayClient.QueryUsedQuotaValue(userID)
 	if err != nil {
-		return fmt.Errorf("failed to get used quota: %w", err)
+		return nil, fmt.Errorf("failed to get used quota: %w", err)
 	}
 
 	// Start database transaction
@@ -1236,65 +1201,43 @@ func (s *QuotaService) DeductQuota(userID string, amount float64, reason, refere
 		}
 	}()
 
-	// Get all valid quotas for the user, ordered by expiry date
-	var quotas []models.Quota
-	if err := tx.Where("user_id = ? AND status = ?", userID, models.StatusValid).
-		Order("expiry_date ASC").Find(&quotas).Error; err != nil {
+	// Claim the reference ID first, the unique index serializes concurrent requests with the same key
+	deduction := &models.QuotaDeduction{
+		ReferenceID: req.ReferenceID,
+		UserID:      userID,
+		Amount:      amount,
+		Reason:      req.Reason,
+		Model:       req.Model,
+		APIKeyID:    apiKeyID,
+	}
+	if err := tx.Create(deduction).Error; err != nil {
 		tx.Rollback()
-		return fmt.Errorf("failed to get quota list: %w", err)
+		if strings.Contains(err.Error(), "duplicate key") {
+			var original models.QuotaDeduction
+			if err := s.db.DB.Where("reference_id = ?", req.ReferenceID).First(&original).Error; err != nil {
+				return nil, fmt.Errorf("failed to load original deduction: %w", err)
+			}
+			return replayDeduction(&original, req)
+		}
+		return nil, fmt.Errorf("failed to record deduction: %w", err)
 	}
 
-	// Calculate total available quota
-	totalDatabaseQuota := 0.0
-	for _, quota := range quotas {
-		totalDatabaseQuota += quota.Amount
+	// Get the quotas the deduction may draw from, the model pool first
+	quotas, availableQuota, err := spendableQuotas(tx, userID, req.Model, usedQuota)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
 	}
 
-	availableQuota := totalDatabaseQuota - usedQuota
 	if availableQuota < amount {
 		tx.Rollback()
-		return fmt.Errorf("insufficient quota: available %g, needed %g", availableQuota, amount)
-	}
-
-	// Deduct the amount from quotas, starting from earliest expiry date
-	remainingDeduct := amount
-	updatedQuotas := make([]*models.Quota, 0)
-	deletedQuotaIDs := make([]int, 0)
-
-	for _, quota := range quotas {
-		if remainingDeduct <= 0 {
-			break
-		}
-
-		// Calculate how much to deduct from this quota
-		deductFromThis := min(remainingDeduct, quota.Amount)
-		quota.Amount -= deductFromThis
-		remainingDeduct -= deductFromThis
-
-		if quota.Amount > 0 {
-			// Update the quota record
-			updatedQuotas = append(updatedQuotas, &quota)
-		} else {
-			// Mark for deletion if amount becomes zero or negative
-			deletedQuotaIDs = append(deletedQuotaIDs, quota.ID)
-		}
+		return nil, fmt.Errorf("insufficient quota: available %g, needed %g", availableQuota, amount)
 	}
 
-	// Update quota records
-	for _, quota := range updatedQuotas {
-		if err := tx.Model(&models.Quota{}).Where("id = ?", quota.ID).
-			Update("amount", quota.Amount).Error; err != nil {
-			tx.Rollback()
-			return fmt.Errorf("failed to update quota: %w", err)
-		}
-	}
-
-	// Delete zero amount quotas
-	if len(deletedQuotaIDs) > 0 {
-		if err := tx.Where("id IN ?", deletedQuotaIDs).Delete(&models.Quota{}).Error; err != nil {
-			tx.Rollback()
-			return fmt.Errorf("failed to delete zero quota records: %w", err)
-		}
+	// Deduct the amount from quotas, starting from earliest expiry date of each pool
+	if _, err := takeQuotaEarliestFirst(tx, quotas, amount); err != nil {
+		tx.Rollback()
+		return nil, err
 	}
 
 	// Find earliest expiry date for audit record
@@ -1315,21 +1258,20 @@ func (s *QuotaService) DeductQuota(userID string, amount float64, reason, refere
 		UserID:     userID,
 		Amount:     -amount, // Negative amount for deduction
 		Operation:  models.OperationDeduct,
+		APIKeyID:   apiKeyID,
 		ExpiryDate: earliestExpiryDate,
 	}
 
-	// Add reason, referenceID, and model to audit record if provided
-	if reason != "" {
-		auditRecord.StrategyName = reason // Use StrategyName field to store reason
-	}
-	if referenceID != "" {
-		// Store referenceID in RelatedUser field
-		auditRecord.RelatedUser = referenceID
+	// Add reason to audit record if provided
+	if req.Reason != "" {
+		auditRecord.StrategyName = req.Reason // Use StrategyName field to store reason
 	}
 
 	// Prepare audit details
 	auditDetails := &models.QuotaAuditDetails{
-		Operation: models.OperationDeduct,
+		Operation:   models.OperationDeduct,
+		ReferenceID: req.ReferenceID,
+		Model:       req.Model,
 		Summary: models.QuotaAuditSummary{
 			TotalAmount:        amount,
 			TotalItems:         1,
@@ -1346,16 +1288,27 @@ func (s *QuotaService) DeductQuota(userID string, amount float64, reason, refere
 	}
 	if err := auditRecord.MarshalDetails(auditDetails); err != nil {
 		tx.Rollback()
-		return fmt.Errorf("failed to marshal audit details: %w", err)
+		return nil, fmt.Errorf("failed to marshal audit details: %w", err)
 	}
 	if err := tx.Create(auditRecord).Error; err != nil {
 		tx.Rollback()
-		return fmt.Errorf("failed to create audit record: %w", err)
+		return nil, fmt.Errorf("failed to create audit record: %w", err)
+	}
+
+	// Store the result so that retries return it
+	deduction.AuditID = &auditRecord.ID
+	deduction.RemainingQuota = availableQuota - amount
+	if err := tx.Model(deduction).Updates(map[string]interface{}{
+		"audit_id":        deduction.AuditID,
+		"remaining_quota": deduction.RemainingQuota,
+	}).Error; err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to update deduction record: %w", err)
 	}
 
 	// Commit transaction
 	if err := tx.Commit().Error; err != nil {
-		return fmt.Errorf("failed to commit transaction: %w", err)
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
 	// Update AiGateway quota
@@ -1364,7 +1317,196 @@ func (s *QuotaService) DeductQuota(userID string, amount float64, reason, refere
 		logger.Error("Failed to update AiGateway quota after deduction", zap.Error(err), zap.String("user_id", userID))
 	}
 
-	return nil
+	return deductionResponse(deduction, false), nil
+}
+
+// replayDeduction returns the stored result of an already processed reference ID,
+// rejecting reuse of the reference ID for a different deduction
+func replayDeduction(original *models.QuotaDeduction, req *DeductQuotaRequest) (*DeductQuotaResponse, error) {
+	if original.UserID != req.UserID || original.Amount != roundToCents(req.Amount) || original.Model != req.Model {
+		return nil, NewConflictError(fmt.Sprintf("reference_id %s was already used for a different deduction", req.ReferenceID))
+	}
+
+	logger.Info("Duplicate deduction request, returning original result",
+		zap.String("reference_id", req.ReferenceID),
+		zap.String("user_id", req.UserID))
+
+	return deductionResponse(original, true), nil
+}
+
+// roundToCents rounds an amount to the two decimals of the DECIMAL(10,2) amount columns, so a
+// request amount compares equal to the amount stored for it
+func roundToCents(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// deductionResponse converts a deduction record to its API response
+func deductionResponse(deduction *models.QuotaDeduction, duplicate bool) *DeductQuotaResponse {
+	return &DeductQuotaResponse{
+		ReferenceID:    deduction.ReferenceID,
+		UserID:         deduction.UserID,
+		Amount:         deduction.Amount,
+		Model:          deduction.Model,
+		RemainingQuota: deduction.RemainingQuota,
+		Duplicate:      duplicate,
+		CreateTime:     deduction.CreateTime,
+	}
+}
+
+// remainingQuotaItems applies the used quota to the rows in order of expiry date and
+// returns what remains of each row
+func remainingQuotaItems(quotas []models.Quota, usedQuota float64) []QuotaDetailItem {
+	quotaList := make([]QuotaDetailItem, 0)
+	remainingUsed := usedQuota
+
+	for _, quota := range quotas {
+		if remainingUsed <= 0 {
+			// No more used quota to deduct
+			quotaList = append(quotaList, QuotaDetailItem{
+				Model:      quota.Model,
+				Amount:     quota.Amount,
+				ExpiryDate: quota.ExpiryDate,
+			})
+		} else if quota.Amount > remainingUsed {
+			// This quota is partially consumed
+			quotaList = append(quotaList, QuotaDetailItem{
+				Model:      quota.Model,
+				Amount:     quota.Amount - remainingUsed,
+				ExpiryDate: quota.ExpiryDate,
+			})
+			remainingUsed = 0
+		} else {
+			// This quota is fully consumed
+			remainingUsed -= quota.Amount
+		}
+	}
+
+	return quotaList
+}
+
+// spendableQuotas locks the user's valid quota rows and returns the rows a charge for the model
+// may draw from, the model pool before the general pool and each by expiry date, together with
+// the amount available to it. An empty model only draws from the general pool.
+func spendableQuotas(tx *gorm.DB, userID, model string, usedQuota float64) ([]models.Quota, float64, error) {
+	var quotas []models.Quota
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ? AND status = ?", userID, models.StatusValid).
+		Order("expiry_date ASC").Find(&quotas).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to get quota list: %w", err)
+	}
+
+	totalDatabaseQuota := 0.0
+	modelQuotas := make([]models.Quota, 0)
+	generalQuotas := make([]models.Quota, 0)
+	eligibleQuota := 0.0
+	for _, quota := range quotas {
+		totalDatabaseQuota += quota.Amount
+		switch {
+		case model != "" && quota.Model == model:
+			modelQuotas = append(modelQuotas, quota)
+			eligibleQuota += quota.Amount
+		case quota.Model == "":
+			generalQuotas = append(generalQuotas, quota)
+			eligibleQuota += quota.Amount
+		}
+	}
+
+	// Used quota is not attributed to a model in AiGateway, so it limits every pool
+	available := min(totalDatabaseQuota-usedQuota, eligibleQuota)
+	return append(modelQuotas, generalQuotas...), available, nil
+}
+
+// QueryModelQuota returns the quota a user can still spend on the model, which is the
+// remaining quota of the model pool and of the general pool
+func (s *QuotaService) QueryModelQuota(userID, model string) (float64, error) {
+	usedQuota, err := s.aiGatewayClient.QueryUsedQuotaValue(userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get used quota: %w", err)
+	}
+
+	var quotas []models.Quota
+	if err := s.db.DB.Where("user_id = ? AND status = ?", userID, models.StatusValid).
+		Order("expiry_date ASC").Find(&quotas).Error; err != nil {
+		return 0, fmt.Errorf("failed to get quota list: %w", err)
+	}
+
+	balance := 0.0
+	for _, item := range remainingQuotaItems(quotas, usedQuota) {
+		if item.Model == "" || item.Model == model {
+			balance += item.Amount
+		}
+	}
+	return balance, nil
+}
+
+// QueryMonthlyUsage returns the quota used by a user in the month the given number of months
+// before the current one, in the configured timezone, 0 when no usage was recorded
+func (s *QuotaService) QueryMonthlyUsage(userID string, monthsAgo int) (float64, error) {
+	now := utils.NowInConfigTimezone(s.configManager.GetDirect())
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()).AddDate(0, -monthsAgo, 0)
+	return s.QueryMonthUsage(userID, month.Format("2006-01"))
+}
+
+// QueryMonthUsage returns the quota used by a user in a month in YYYY-MM format, 0 when no usage was recorded
+func (s *QuotaService) QueryMonthUsage(userID, yearMonth string) (float64, error) {
+	var usage models.MonthlyQuotaUsage
+	err := s.db.DB.Where("user_id = ? AND year_month = ?", userID, yearMonth).First(&usage).Error
+	if err == gorm.ErrRecordNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get monthly quota usage: %w", err)
+	}
+	return usage.UsedQuota, nil
+}
+
+// takeQuotaEarliestFirst removes the amount from the given valid quota rows in order, callers pass
+// them sorted by expiry date, and returns how much was taken per pool and expiry date
+func takeQuotaEarliestFirst(tx *gorm.DB, quotas []models.Quota, amount float64) ([]models.QuotaPortion, error) {
+	remainingDeduct := amount
+	updatedQuotas := make([]*models.Quota, 0)
+	deletedQuotaIDs := make([]int, 0)
+	taken := make([]models.QuotaPortion, 0)
+
+	for _, quota := range quotas {
+		if remainingDeduct <= 0 {
+			break
+		}
+
+		// Calculate how much to deduct from this quota
+		deductFromThis := min(remainingDeduct, quota.Amount)
+		if deductFromThis <= 0 {
+			continue
+		}
+		quota.Amount -= deductFromThis
+		remainingDeduct -= deductFromThis
+		taken = append(taken, models.QuotaPortion{Model: quota.Model, Amount: deductFromThis, ExpiryDate: quota.ExpiryDate})
+
+		if quota.Amount > 0 {
+			// Update the quota record
+			updatedQuotas = append(updatedQuotas, &quota)
+		} else {
+			// Mark for deletion if amount becomes zero or negative
+			deletedQuotaIDs = append(deletedQuotaIDs, quota.ID)
+		}
+	}
+
+	// Update quota records
+	for _, quota := range updatedQuotas {
+		if err := tx.Model(&models.Quota{}).Where("id = ?", quota.ID).
+			Update("amount", quota.Amount).Error; err != nil {
+			return nil, fmt.Errorf("failed to update quota: %w", err)
+		}
+	}
+
+	// Delete zero amount quotas
+	if len(deletedQuotaIDs) > 0 {
+		if err := tx.Where("id IN ?", deletedQuotaIDs).Delete(&models.Quota{}).Error; err != nil {
+			return nil, fmt.Errorf("failed to delete zero quota records: %w", err)
+		}
+	}
+
+	return taken, nil
 }
 
 // min returns the minimum of two float64 values
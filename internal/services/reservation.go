@@ -0,0 +1,429 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"quota-manager/internal/models"
+	"quota-manager/pkg/logger"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// defaultReservationTTL is used when a reservation request does not set ttl_seconds
+	defaultReservationTTL = 10 * time.Minute
+	// maxReservationTTL bounds how long quota can be held
+	maxReservationTTL = 24 * time.Hour
+)
+
+// ReserveQuotaRequest represents a quota reservation request
+type ReserveQuotaRequest struct {
+	UserID      string  `json:"user_id" validate:"required,uuid"`
+	Amount      float64 `json:"amount" validate:"required,gt=0"`
+	ReferenceID string  `json:"reference_id" validate:"required,min=1,max=255"`
+	TTLSeconds  int     `json:"ttl_seconds" validate:"omitempty,gt=0,lte=86400"`
+	Reason      string  `json:"reason" validate:"omitempty,max=100"`
+	Model       string  `json:"model" validate:"omitempty,max=100"`
+}
+
+// CommitReservationRequest represents the final amount charged for a reservation
+type CommitReservationRequest struct {
+	Amount float64 `json:"amount" validate:"gte=0"`
+}
+
+// ReservationResponse represents a reservation and whether the request was a replay
+type ReservationResponse struct {
+	*models.QuotaReservation
+	Duplicate bool `json:"duplicate"`
+}
+
+// ReserveQuota holds quota for a pending operation. The held amount is taken from quota rows in
+// earliest-expiry order, so it can no longer be spent or transferred until the hold is released.
+// The reference ID is an idempotency key like in DeductQuota.
+func (s *QuotaService) ReserveQuota(req *ReserveQuotaRequest, apiKeyID *int) (*ReservationResponse, error) {
+	amount := roundToCents(req.Amount)
+	if amount <= 0 {
+		return nil, fmt.Errorf("amount must be positive")
+	}
+
+	ttl := defaultReservationTTL
+	if req.TTLSeconds > 0 {
+		ttl = time.Duration(req.TTLSeconds) * time.Second
+	}
+	if ttl > maxReservationTTL {
+		ttl = maxReservationTTL
+	}
+
+	// Return the original reservation if this reference ID was already processed
+	var existing models.QuotaReservation
+	if err := s.db.DB.Where("reference_id = ?", req.ReferenceID).First(&existing).Error; err == nil {
+		return replayReservation(&existing, req)
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to check reservation reference: %w", err)
+	}
+
+	// Get used quota from AiGateway
+	usedQuota, err := s.aiGatewayClient.QueryUsedQuotaValue(req.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get used quota: %w", err)
+	}
+
+	// Start database transaction
+	tx := s.db.DB.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	// Claim the reference ID first, the unique index serializes concurrent requests with the same key
+	reservation := &models.QuotaReservation{
+		ReferenceID: req.ReferenceID,
+		UserID:      req.UserID,
+		Amount:      amount,
+		Status:      models.ReservationStatusHeld,
+		Reason:      req.Reason,
+		Model:       req.Model,
+		ExpiresAt:   time.Now().Add(ttl),
+		APIKeyID:    apiKeyID,
+	}
+	if err := tx.Create(reservation).Error; err != nil {
+		tx.Rollback()
+		if strings.Contains(err.Error(), "duplicate key") {
+			var original models.QuotaReservation
+			if err := s.db.DB.Where("reference_id = ?", req.ReferenceID).First(&original).Error; err != nil {
+				return nil, fmt.Errorf("failed to load original reservation: %w", err)
+			}
+			return replayReservation(&original, req)
+		}
+		return nil, fmt.Errorf("failed to create reservation: %w", err)
+	}
+
+	// Lock the user's valid quota rows so concurrent holds and deductions see each other
+	quotas, availableQuota, err := spendableQuotas(tx, req.UserID, req.Model, usedQuota)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if availableQuota < amount {
+		tx.Rollback()
+		return nil, fmt.Errorf("insufficient quota: available %g, needed %g", availableQuota, amount)
+	}
+
+	taken, err := takeQuotaEarliestFirst(tx, quotas, amount)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := reservation.MarshalItems(taken); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Model(reservation).Update("items", reservation.Items).Error; err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to store reservation items: %w", err)
+	}
+
+	if err := createReservationAudit(tx, reservation, models.OperationReserve, -amount, taken); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	// Held quota must not be consumable through AiGateway either
+	if err := s.aiGatewayClient.DeltaQuota(req.UserID, -amount); err != nil {
+		logger.Error("Failed to update AiGateway quota after reservation",
+			zap.Error(err),
+			zap.String("user_id", req.UserID),
+			zap.Int("reservation_id", reservation.ID))
+	}
+
+	logger.Info("Quota reserved",
+		zap.Int("reservation_id", reservation.ID),
+		zap.String("user_id", req.UserID),
+		zap.Float64("amount", amount),
+		zap.Time("expires_at", reservation.ExpiresAt))
+
+	return &ReservationResponse{QuotaReservation: reservation}, nil
+}
+
+// GetReservation returns a reservation by ID
+func (s *QuotaService) GetReservation(id int) (*models.QuotaReservation, error) {
+	var reservation models.QuotaReservation
+	if err := s.db.DB.First(&reservation, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, NewResourceNotFoundError("reservation", strconv.Itoa(id))
+		}
+		return nil, NewDatabaseError("get reservation", err)
+	}
+	return &reservation, nil
+}
+
+// CommitReservation charges the final amount of a held reservation and returns the rest of the hold
+func (s *QuotaService) CommitReservation(id int, amount float64) (*ReservationResponse, error) {
+	amount = roundToCents(amount)
+	if amount < 0 {
+		return nil, NewValidationFailedError("amount must not be negative")
+	}
+	return s.settleReservation(id, amount, models.ReservationStatusCommitted)
+}
+
+// ReleaseReservation returns the whole held amount of a reservation
+func (s *QuotaService) ReleaseReservation(id int) (*ReservationResponse, error) {
+	return s.settleReservation(id, 0, models.ReservationStatusReleased)
+}
+
+// ReleaseExpiredReservations releases every hold whose expiry time has passed
+func (s *QuotaService) ReleaseExpiredReservations() (int, error) {
+	var ids []int
+	if err := s.db.DB.Model(&models.QuotaReservation{}).
+		Where("status = ? AND expires_at <= ?", models.ReservationStatusHeld, time.Now()).
+		Order("expires_at ASC").
+		Pluck("id", &ids).Error; err != nil {
+		return 0, fmt.Errorf("failed to query expired reservations: %w", err)
+	}
+
+	released := 0
+	for _, id := range ids {
+		if _, err := s.settleReservation(id, 0, models.ReservationStatusExpired); err != nil {
+			// A concurrent commit or release may have settled it already
+			logger.Warn("Failed to release expired reservation",
+				zap.Int("reservation_id", id),
+				zap.Error(err))
+			continue
+		}
+		released++
+	}
+
+	return released, nil
+}
+
+// settleReservation moves a held reservation to its final status, charging commitAmount and
+// returning the rest of the hold to the quota rows it was taken from
+func (s *QuotaService) settleReservation(id int, commitAmount float64, finalStatus string) (*ReservationResponse, error) {
+	tx := s.db.DB.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var reservation models.QuotaReservation
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&reservation, id).Error; err != nil {
+		tx.Rollback()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, NewResourceNotFoundError("reservation", strconv.Itoa(id))
+		}
+		return nil, NewDatabaseError("get reservation", err)
+	}
+
+	if reservation.Status != models.ReservationStatusHeld {
+		tx.Rollback()
+		// Repeating the same commit or release returns the settled reservation
+		if reservation.Status == finalStatus && (finalStatus != models.ReservationStatusCommitted || reservation.CommittedAmount == commitAmount) {
+			return &ReservationResponse{QuotaReservation: &reservation, Duplicate: true}, nil
+		}
+		return nil, NewConflictError(fmt.Sprintf("reservation %d is already %s", id, strings.ToLower(reservation.Status)))
+	}
+
+	if finalStatus == models.ReservationStatusCommitted {
+		if time.Now().After(reservation.ExpiresAt) {
+			tx.Rollback()
+			return nil, NewConflictError(fmt.Sprintf("reservation %d has expired", id))
+		}
+		if commitAmount > reservation.Amount {
+			tx.Rollback()
+			return nil, NewValidationFailedError(fmt.Sprintf("commit amount %g exceeds held amount %g", commitAmount, reservation.Amount))
+		}
+	}
+
+	items, err := reservation.UnmarshalItems()
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	// The committed amount is charged from the earliest expiring portions, the rest goes back
+	refundItems := remainingPortions(items, commitAmount)
+	refunded, expired, err := returnQuotaPortions(tx, reservation.UserID, refundItems)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	now := time.Now()
+	reservation.Status = finalStatus
+	reservation.CommittedAmount = commitAmount
+	reservation.SettledAt = &now
+	if err := tx.Model(&reservation).Updates(map[string]interface{}{
+		"status":           reservation.Status,
+		"committed_amount": reservation.CommittedAmount,
+		"settled_at":       reservation.SettledAt,
+	}).Error; err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to update reservation: %w", err)
+	}
+
+	operation := models.OperationReserveRelease
+	if finalStatus == models.ReservationStatusCommitted {
+		operation = models.OperationReserveCommit
+	}
+	if err := createReservationAudit(tx, &reservation, operation, refunded, refundItems); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	// Give the returned quota back in AiGateway, portions that expired meanwhile are lost
+	if refunded > 0 {
+		if err := s.aiGatewayClient.DeltaQuota(reservation.UserID, refunded); err != nil {
+			logger.Error("Failed to update AiGateway quota after settling reservation",
+				zap.Error(err),
+				zap.String("user_id", reservation.UserID),
+				zap.Int("reservation_id", reservation.ID))
+		}
+	}
+
+	logger.Info("Quota reservation settled",
+		zap.Int("reservation_id", reservation.ID),
+		zap.String("user_id", reservation.UserID),
+		zap.String("status", finalStatus),
+		zap.Float64("committed", commitAmount),
+		zap.Float64("refunded", refunded),
+		zap.Float64("expired", expired))
+
+	return &ReservationResponse{QuotaReservation: &reservation}, nil
+}
+
+// getHeldQuota returns the total amount currently held by a user's reservations
+func (s *QuotaService) getHeldQuota(userID string) (float64, error) {
+	var held float64
+	if err := s.db.DB.Model(&models.QuotaReservation{}).
+		Where("user_id = ? AND status = ?", userID, models.ReservationStatusHeld).
+		Select("COALESCE(SUM(amount), 0)").
+		Scan(&held).Error; err != nil {
+		return 0, fmt.Errorf("failed to get held quota: %w", err)
+	}
+	return held, nil
+}
+
+// replayReservation returns an existing reservation for a repeated reference ID,
+// rejecting reuse of the reference ID for a different reservation
+func replayReservation(original *models.QuotaReservation, req *ReserveQuotaRequest) (*ReservationResponse, error) {
+	if original.UserID != req.UserID || original.Amount != roundToCents(req.Amount) || original.Model != req.Model {
+		return nil, NewConflictError(fmt.Sprintf("reference_id %s was already used for a different reservation", req.ReferenceID))
+	}
+	return &ReservationResponse{QuotaReservation: original, Duplicate: true}, nil
+}
+
+// remainingPortions skips the first amount of the portions and returns what is left
+func remainingPortions(items []models.QuotaPortion, amount float64) []models.QuotaPortion {
+	remaining := make([]models.QuotaPortion, 0, len(items))
+	for _, item := range items {
+		if amount >= item.Amount {
+			amount -= item.Amount
+			continue
+		}
+		remaining = append(remaining, models.QuotaPortion{Model: item.Model, Amount: item.Amount - amount, ExpiryDate: item.ExpiryDate})
+		amount = 0
+	}
+	return remaining
+}
+
+// returnQuotaPortions adds the portions back to the user's valid quota rows of their pool,
+// portions whose expiry date has passed are dropped
+func returnQuotaPortions(tx *gorm.DB, userID string, items []models.QuotaPortion) (refunded, expired float64, err error) {
+	now := time.Now()
+	for _, item := range items {
+		if !item.ExpiryDate.After(now) {
+			expired += item.Amount
+			continue
+		}
+
+		result := tx.Model(&models.Quota{}).
+			Where("user_id = ? AND model = ? AND expiry_date = ? AND status = ?", userID, item.Model, item.ExpiryDate, models.StatusValid).
+			Update("amount", gorm.Expr("amount + ?", item.Amount))
+		if result.Error != nil {
+			return 0, 0, fmt.Errorf("failed to return quota: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			quota := &models.Quota{
+				UserID:     userID,
+				Model:      item.Model,
+				Amount:     item.Amount,
+				ExpiryDate: item.ExpiryDate,
+				Status:     models.StatusValid,
+			}
+			if err := tx.Create(quota).Error; err != nil {
+				return 0, 0, fmt.Errorf("failed to recreate quota: %w", err)
+			}
+		}
+		refunded += item.Amount
+	}
+	return refunded, expired, nil
+}
+
+// createReservationAudit records a reservation change in the quota audit log
+func createReservationAudit(tx *gorm.DB, reservation *models.QuotaReservation, operation string, amount float64, items []models.QuotaPortion) error {
+	expiryDate := reservation.ExpiresAt
+	if len(items) > 0 {
+		expiryDate = items[0].ExpiryDate
+	}
+
+	details := &models.QuotaAuditDetails{
+		Operation:   operation,
+		ReferenceID: reservation.ReferenceID,
+		Model:       reservation.Model,
+		Summary: models.QuotaAuditSummary{
+			TotalAmount:        reservation.Amount,
+			TotalItems:         len(items),
+			EarliestExpiryDate: expiryDate.Format(time.RFC3339),
+		},
+		Items: make([]models.QuotaAuditDetailItem, 0, len(items)),
+	}
+	now := time.Now()
+	for _, item := range items {
+		status := models.AuditStatusSuccess
+		if operation != models.OperationReserve && !item.ExpiryDate.After(now) {
+			status = models.AuditStatusExpired
+			details.Summary.ExpiredItems++
+		} else {
+			details.Summary.SuccessfulItems++
+		}
+		details.Items = append(details.Items, models.QuotaAuditDetailItem{
+			Amount:     item.Amount,
+			ExpiryDate: item.ExpiryDate.Format(time.RFC3339),
+			Status:     status,
+		})
+	}
+
+	auditRecord := &models.QuotaAudit{
+		UserID:       reservation.UserID,
+		Amount:       amount,
+		Operation:    operation,
+		StrategyName: reservation.Reason, // Use StrategyName field to store reason
+		APIKeyID:     reservation.APIKeyID,
+		ExpiryDate:   expiryDate,
+	}
+	if err := auditRecord.MarshalDetails(details); err != nil {
+		return err
+	}
+	if err := tx.Create(auditRecord).Error; err != nil {
+		return fmt.Errorf("failed to create audit record: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"quota-manager/internal/database"
+	"quota-manager/internal/models"
+	"quota-manager/pkg/logger"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	// scanJobHeartbeatInterval is how often a running job saves its progress
+	scanJobHeartbeatInterval = 10 * time.Second
+	// scanJobStaleAfter is how long a running job may go without saving its progress before it is
+	// considered interrupted, its instance stopped before finishing it
+	scanJobStaleAfter = time.Minute
+)
+
+// ScanJobFunc runs a job, reporting its progress
+type ScanJobFunc func(progress *ScanJobProgress) error
+
+// ScanJobProgress counts the items of a running job. Counting on a nil progress does nothing,
+// so job functions can also be run outside of a job
+type ScanJobProgress struct {
+	total     atomic.Int64
+	processed atomic.Int64
+	failed    atomic.Int64
+}
+
+// AddTotal adds items to process
+func (p *ScanJobProgress) AddTotal(n int) {
+	if p != nil {
+		p.total.Add(int64(n))
+	}
+}
+
+// Processed counts an item processed successfully
+func (p *ScanJobProgress) Processed() {
+	if p != nil {
+		p.processed.Add(1)
+	}
+}
+
+// Failed counts an item that failed
+func (p *ScanJobProgress) Failed() {
+	if p != nil {
+		p.failed.Add(1)
+	}
+}
+
+// counters returns the counters as scan_job columns
+func (p *ScanJobProgress) counters() map[string]interface{} {
+	return map[string]interface{}{
+		"total":     p.total.Load(),
+		"processed": p.processed.Load(),
+		"failed":    p.failed.Load(),
+	}
+}
+
+// ScanJobService runs manually triggered jobs in the background and scheduled ones in place, and
+// records them in scan_job
+type ScanJobService struct {
+	db         *database.DB
+	instanceID string
+}
+
+// NewScanJobService creates a scan job service, jobs are recorded with the given instance ID
+func NewScanJobService(db *database.DB, instanceID string) *ScanJobService {
+	return &ScanJobService{
+		db:         db,
+		instanceID: instanceID,
+	}
+}
+
+// StartJob records a job and runs it in the background. A job of the same type that is still
+// running, on any instance, is a conflict
+func (s *ScanJobService) StartJob(jobType, triggeredBy string, fn ScanJobFunc) (*models.ScanJob, error) {
+	job, err := s.claimJob(jobType, triggeredBy)
+	if err != nil {
+		return nil, err
+	}
+	go s.run(job, fn)
+	return job, nil
+}
+
+// RunJob records a job and runs it until it finishes, returning its error. Scheduled jobs run
+// through it so that they never overlap a job of the same type, triggered or scheduled, on any instance
+func (s *ScanJobService) RunJob(jobType, triggeredBy string, fn ScanJobFunc) error {
+	job, err := s.claimJob(jobType, triggeredBy)
+	if err != nil {
+		return err
+	}
+	return s.run(job, fn)
+}
+
+// claimJob records a running job of the type, failing with a conflict while another one runs
+func (s *ScanJobService) claimJob(jobType, triggeredBy string) (*models.ScanJob, error) {
+	// A job whose instance stopped while running it would otherwise block its type forever
+	now := time.Now()
+	if err := s.db.Model(&models.ScanJob{}).
+		Where("type = ? AND status = ? AND update_time < ?", jobType, models.ScanJobStatusRunning, now.Add(-scanJobStaleAfter)).
+		Updates(map[string]interface{}{
+			"status":   models.ScanJobStatusFailed,
+			"error":    "interrupted: the job stopped reporting progress",
+			"end_time": now,
+		}).Error; err != nil {
+		return nil, NewDatabaseError("expire interrupted scan jobs", err)
+	}
+
+	job := &models.ScanJob{
+		Type:        jobType,
+		Status:      models.ScanJobStatusRunning,
+		TriggeredBy: triggeredBy,
+		Instance:    s.instanceID,
+		StartTime:   now,
+	}
+	if err := s.db.Create(job).Error; err != nil {
+		if strings.Contains(err.Error(), "duplicate key") {
+			var running models.ScanJob
+			if findErr := s.db.Where("type = ? AND status = ?", jobType, models.ScanJobStatusRunning).First(&running).Error; findErr == nil {
+				return nil, NewConflictError(fmt.Sprintf("%s job %d is already running", jobType, running.ID))
+			}
+			return nil, NewConflictError(fmt.Sprintf("a %s job is already running", jobType))
+		}
+		return nil, NewDatabaseError("create scan job", err)
+	}
+
+	logger.Info("Scan job started",
+		zap.Int("job_id", job.ID),
+		zap.String("type", jobType),
+		zap.String("triggered_by", triggeredBy))
+	return job, nil
+}
+
+// run runs a job, saving its progress until it finishes, and returns the error of the job
+func (s *ScanJobService) run(job *models.ScanJob, fn ScanJobFunc) error {
+	progress := &ScanJobProgress{}
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(scanJobHeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				s.saveProgress(job, progress)
+			}
+		}
+	}()
+
+	err := runScanJobFunc(fn, progress)
+	close(done)
+
+	updates := progress.counters()
+	updates["status"] = models.ScanJobStatusSucceeded
+	updates["end_time"] = time.Now()
+	if err != nil {
+		updates["status"] = models.ScanJobStatusFailed
+		updates["error"] = err.Error()
+	}
+	if dbErr := s.db.Model(&models.ScanJob{}).Where("id = ?", job.ID).Updates(updates).Error; dbErr != nil {
+		logger.Error("Failed to finish scan job", zap.Int("job_id", job.ID), zap.Error(dbErr))
+		return err
+	}
+
+	logger.Info("Scan job finished",
+		zap.Int("job_id", job.ID),
+		zap.String("type", job.Type),
+		zap.Any("status", updates["status"]),
+		zap.Error(err))
+	return err
+}
+
+// runScanJobFunc runs a job function, turning a panic into an error
+func runScanJobFunc(fn ScanJobFunc, progress *ScanJobProgress) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return fn(progress)
+}
+
+// saveProgress saves the counters of a running job, which also shows the job is still running
+func (s *ScanJobService) saveProgress(job *models.ScanJob, progress *ScanJobProgress) {
+	updates := progress.counters()
+	updates["update_time"] = time.Now()
+	if err := s.db.Model(&models.ScanJob{}).
+		Where("id = ? AND status = ?", job.ID, models.ScanJobStatusRunning).
+		Updates(updates).Error; err != nil {
+		logger.Error("Failed to save scan job progress", zap.Int("job_id", job.ID), zap.Error(err))
+	}
+}
+
+// GetJob gets a job by ID
+func (s *ScanJobService) GetJob(id int) (*models.ScanJob, error) {
+	var job models.ScanJob
+	if err := s.db.First(&job, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, NewResourceNotFoundError("scan job", fmt.Sprintf("%d", id))
+		}
+		return nil, NewDatabaseError("get scan job", err)
+	}
+	return &job, nil
+}
+
+// ListJobs lists jobs, latest first, optionally filtered by type and status
+func (s *ScanJobService) ListJobs(jobType, status string, page, pageSize int) ([]models.ScanJob, int64, error) {
+	filter := func(db *gorm.DB) *gorm.DB {
+		if jobType != "" {
+			db = db.Where("type = ?", jobType)
+		}
+		if status != "" {
+			db = db.Where("status = ?", status)
+		}
+		return db
+	}
+
+	var total int64
+	if err := s.db.Model(&models.ScanJob{}).Scopes(filter).Count(&total).Error; err != nil {
+		return nil, 0, NewDatabaseError("count scan jobs", err)
+	}
+
+	var jobs []models.ScanJob
+	if err := s.db.Scopes(filter).
+		Order("start_time DESC, id DESC").
+		Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Find(&jobs).Error; err != nil {
+		return nil, 0, NewDatabaseError("query scan jobs", err)
+	}
+	return jobs, total, nil
+}
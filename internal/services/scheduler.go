@@ -1,7 +1,11 @@
 package services
 
 import (
+	"errors"
+	"sync"
+
 	"quota-manager/internal/config"
+	"quota-manager/internal/models"
 	"quota-manager/internal/utils"
 	"quota-manager/pkg/logger"
 
@@ -9,6 +13,9 @@ import (
 	"go.uber.org/zap"
 )
 
+// scheduledJobTrigger is recorded as the trigger of scan jobs started by the scheduler
+const scheduledJobTrigger = "scheduler"
+
 // SchedulerService handles scheduled tasks
 type SchedulerService struct {
 	quotaService        *QuotaService
@@ -16,6 +23,10 @@ type SchedulerService struct {
 	employeeSyncService *EmployeeSyncService
 	config              *config.Config
 	cron                *cron.Cron
+	quotaJobMu          sync.Mutex      // Serializes quota expiry and monthly usage recording
+	leader              *LeaderElector  // Scheduled jobs only run on the leader, nil runs them here
+	jobs                *JobRegistry    // Cron entries of all schedulers, listed and paused through the API
+	scanJobs            *ScanJobService // Records the scheduled scans and expiries, nil runs them unrecorded
 }
 
 // NewSchedulerService creates a new scheduler service
@@ -23,13 +34,66 @@ func NewSchedulerService(quotaService *QuotaService, strategyService *StrategySe
 	// Get configured timezone
 	tz := utils.GetTimezone(cfg)
 
+	// Periodic strategies and employee sync have their own cron, their jobs are tracked here as well
+	jobs := NewJobRegistry(quotaService.db, cfg)
+	strategyService.jobs = jobs
+	employeeSyncService.jobs = jobs
+
 	return &SchedulerService{
 		quotaService:        quotaService,
 		strategyService:     strategyService,
 		employeeSyncService: employeeSyncService,
 		config:              cfg,
 		cron:                cron.New(cron.WithSeconds(), cron.WithLocation(tz)),
+		jobs:                jobs,
+	}
+}
+
+// Jobs returns the registry of the scheduled jobs
+func (s *SchedulerService) Jobs() *JobRegistry {
+	return s.jobs
+}
+
+// addJob adds a job to the scheduler cron, running on the leader only and tracked by the job registry
+func (s *SchedulerService) addJob(name, schedule string, fn func() error) error {
+	entryID, err := s.cron.AddFunc(schedule, s.leader.Guard(name, s.jobs.Wrap(name, fn)))
+	if err != nil {
+		return err
+	}
+	s.jobs.Track(name, schedule, s.cron, entryID)
+	return nil
+}
+
+// SetLeaderElector makes scheduled jobs, including periodic strategies and employee sync,
+// run only on the instance elected leader. It must be called before Start
+func (s *SchedulerService) SetLeaderElector(leader *LeaderElector) {
+	s.leader = leader
+	s.jobs.leader = leader
+	s.strategyService.leader = leader
+	s.employeeSyncService.leader = leader
+}
+
+// SetScanJobService makes the scheduled single-strategy scan and quota expiry run as scan jobs,
+// so that they never overlap a job of the same type on any instance. It must be called before Start
+func (s *SchedulerService) SetScanJobService(scanJobs *ScanJobService) {
+	s.scanJobs = scanJobs
+}
+
+// runScanJob runs a scheduled job as a scan job of the given type. The run is skipped while a job
+// of the type is running, triggered through the API or scheduled on another instance
+func (s *SchedulerService) runScanJob(jobType string, fn ScanJobFunc) error {
+	if s.scanJobs == nil {
+		return fn(nil)
+	}
+	err := s.scanJobs.RunJob(jobType, scheduledJobTrigger, fn)
+	var serviceErr *ServiceError
+	if errors.As(err, &serviceErr) && serviceErr.Code == ErrorConflict {
+		logger.Info("Skipping scheduled job, a job of its type is already running",
+			zap.String("type", jobType),
+			zap.String("reason", serviceErr.Message))
+		return nil
 	}
+	return err
 }
 
 // Start starts the scheduler service
@@ -68,23 +132,67 @@ func (s *SchedulerService) Start() error {
 	}
 
 	// Add single strategy scan task (periodic strategies are handled by strategy service cron)
-	_, err := s.cron.AddFunc(scanInterval, s.strategyService.TraverseSingleStrategies)
+	err := s.addJob("single-strategy-scan", scanInterval, func() error {
+		return s.runScanJob(models.ScanJobTypeStrategy, s.strategyService.ScanSingleStrategies)
+	})
 	if err != nil {
 		logger.Error("Failed to add single strategy scan task", zap.String("interval", scanInterval), zap.Error(err))
 		return err
 	}
 
-	// Add quota expiry task - run at 00:00 on the first day of every month (6 fields with seconds)
+	// Add monthly usage task - run at 00:00 on the first day of every month unless configured
 	// Cron expression: second minute hour day month weekday
-	_, err = s.cron.AddFunc("0 0 0 1 * *", s.expireQuotasTask)
+	monthlyUsageInterval := s.config.Scheduler.MonthlyUsageInterval
+	if monthlyUsageInterval == "" {
+		monthlyUsageInterval = "0 0 0 1 * *"
+	}
+	err = s.addJob("monthly-usage", monthlyUsageInterval, s.recordMonthlyUsageTask)
+	if err != nil {
+		logger.Error("Failed to add monthly usage task", zap.String("interval", monthlyUsageInterval), zap.Error(err))
+		return err
+	}
+
+	// Add quota expiry task, every 5 minutes at second 30 unless configured so that
+	// the monthly usage is recorded before month-end quota expires
+	expiryInterval := s.config.Scheduler.ExpiryInterval
+	if expiryInterval == "" {
+		expiryInterval = "30 */5 * * * *"
+	}
+	err = s.addJob("quota-expiry", expiryInterval, s.expireQuotasTask)
+	if err != nil {
+		logger.Error("Failed to add quota expiry task", zap.String("interval", expiryInterval), zap.Error(err))
+		return err
+	}
+
+	// Add expired reservation release task, every minute unless configured
+	releaseInterval := s.config.Scheduler.ReservationReleaseInterval
+	if releaseInterval == "" {
+		releaseInterval = "0 * * * * *"
+	}
+	err = s.addJob("reservation-release", releaseInterval, s.releaseExpiredReservationsTask)
 	if err != nil {
-		logger.Error("Failed to add quota expiry task", zap.Error(err))
+		logger.Error("Failed to add reservation release task", zap.String("interval", releaseInterval), zap.Error(err))
+		return err
+	}
+
+	// Add failed strategy execution reconciler, every 10 minutes at second 15 unless configured
+	executionRetryInterval := s.config.Scheduler.ExecutionRetryInterval
+	if executionRetryInterval == "" {
+		executionRetryInterval = "15 */10 * * * *"
+	}
+	err = s.addJob("execution-retry", executionRetryInterval, s.reconcileExecutionsTask)
+	if err != nil {
+		logger.Error("Failed to add execution retry task", zap.String("interval", executionRetryInterval), zap.Error(err))
 		return err
 	}
 
 	s.cron.Start()
 	logger.Info("Scheduler service started",
 		zap.String("single_strategy_scan_interval", scanInterval),
+		zap.String("expiry_interval", expiryInterval),
+		zap.String("monthly_usage_interval", monthlyUsageInterval),
+		zap.String("reservation_release_interval", releaseInterval),
+		zap.String("execution_retry_interval", executionRetryInterval),
 		zap.String("mode", s.config.Server.Mode))
 	return nil
 }
@@ -98,18 +206,63 @@ func (s *SchedulerService) Stop() {
 }
 
 // expireQuotasTask handles quota expiry task
-func (s *SchedulerService) expireQuotasTask() {
+func (s *SchedulerService) expireQuotasTask() error {
+	return s.runScanJob(models.ScanJobTypeExpireQuotas, s.ExpireQuotas)
+}
+
+// ExpireQuotas runs the quota expiry task, counting each user in the progress. It waits for a
+// running expiry or monthly usage task to finish first
+func (s *SchedulerService) ExpireQuotas(progress *ScanJobProgress) error {
+	s.quotaJobMu.Lock()
+	defer s.quotaJobMu.Unlock()
+
 	logger.Info("Starting quota expiry task")
 
-	if err := s.quotaService.ExpireQuotas(); err != nil {
+	if err := s.quotaService.expireQuotas(progress); err != nil {
 		logger.Error("Failed to expire quotas", zap.Error(err))
-		return
+		return err
 	}
 
 	logger.Info("Quota expiry task completed")
+	return nil
+}
+
+// recordMonthlyUsageTask records last month's used quota of every user
+func (s *SchedulerService) recordMonthlyUsageTask() error {
+	s.quotaJobMu.Lock()
+	defer s.quotaJobMu.Unlock()
+
+	if err := s.quotaService.RecordMonthlyUsedQuota(); err != nil {
+		logger.Error("Failed to record monthly used quota", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// releaseExpiredReservationsTask returns quota held by expired reservations
+func (s *SchedulerService) releaseExpiredReservationsTask() error {
+	released, err := s.quotaService.ReleaseExpiredReservations()
+	if err != nil {
+		logger.Error("Failed to release expired reservations", zap.Error(err))
+		return err
+	}
+
+	if released > 0 {
+		logger.Info("Expired reservations released", zap.Int("count", released))
+	}
+	return nil
+}
+
+// reconcileExecutionsTask retries failed and interrupted strategy executions
+func (s *SchedulerService) reconcileExecutionsTask() error {
+	if _, err := s.strategyService.ReconcileExecutions(); err != nil {
+		logger.Error("Failed to reconcile strategy executions", zap.Error(err))
+		return err
+	}
+	return nil
 }
 
 // ExpireQuotasTask is a public wrapper for expireQuotasTask to allow external triggering
 func (s *SchedulerService) ExpireQuotasTask() {
-	s.expireQuotasTask()
+	_ = s.expireQuotasTask()
 }
@@ -0,0 +1,273 @@
+package services
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"quota-manager/internal/config"
+	"quota-manager/internal/database"
+	"quota-manager/internal/models"
+	"quota-manager/internal/utils"
+	"quota-manager/pkg/logger"
+
+	"github.com/robfig/cron/v3"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// JobRegistry keeps track of the cron entries of all schedulers so they can be listed, paused and
+// resumed. Pauses and the last outcome of each job are stored in scheduler_job and shared by all
+// instances. A nil registry runs jobs untracked, which keeps services created outside of the
+// scheduler and tests unchanged
+type JobRegistry struct {
+	db     *database.DB
+	config *config.Config
+	leader *LeaderElector // Names the instance recorded with each run
+
+	mu   sync.RWMutex
+	jobs map[string]*trackedJob // job name -> cron entry
+}
+
+// trackedJob is a cron entry known to the registry
+type trackedJob struct {
+	schedule string
+	cron     *cron.Cron
+	entryID  cron.EntryID
+}
+
+// SchedulerJobInfo describes a scheduled job with its fire times in the configured timezone
+type SchedulerJobInfo struct {
+	Name        string               `json:"name"`
+	Schedule    string               `json:"schedule"`
+	NextRun     *time.Time           `json:"next_run,omitempty"`
+	PreviousRun *time.Time           `json:"previous_run,omitempty"` // Latest fire time of the schedule, whether or not this instance ran it
+	Paused      bool                 `json:"paused"`
+	PausedBy    string               `json:"paused_by,omitempty"`
+	LastRun     *SchedulerJobLastRun `json:"last_run,omitempty"`
+}
+
+// SchedulerJobLastRun is the outcome of the latest run of a job on any instance
+type SchedulerJobLastRun struct {
+	StartTime time.Time  `json:"start_time"`
+	EndTime   *time.Time `json:"end_time,omitempty"`
+	Outcome   string     `json:"outcome"`
+	Error     string     `json:"error,omitempty"`
+	Instance  string     `json:"instance,omitempty"`
+}
+
+// NewJobRegistry creates a job registry
+func NewJobRegistry(db *database.DB, cfg *config.Config) *JobRegistry {
+	return &JobRegistry{
+		db:     db,
+		config: cfg,
+		jobs:   make(map[string]*trackedJob),
+	}
+}
+
+// Wrap returns the cron function of a job, it skips paused jobs and records the outcome of each run
+func (r *JobRegistry) Wrap(name string, fn func() error) func() {
+	if r == nil {
+		return func() {
+			_ = fn()
+		}
+	}
+	return func() {
+		if r.isPaused(name) {
+			logger.Info("Skipping paused scheduled job", zap.String("job", name))
+			return
+		}
+		startTime := time.Now()
+		err := fn()
+		r.recordRun(name, startTime, err)
+	}
+}
+
+// Track registers the cron entry of a job, replacing an earlier entry with the same name
+func (r *JobRegistry) Track(name, schedule string, c *cron.Cron, entryID cron.EntryID) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.jobs[name] = &trackedJob{schedule: schedule, cron: c, entryID: entryID}
+}
+
+// Untrack removes the cron entry of a job
+func (r *JobRegistry) Untrack(name string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.jobs, name)
+}
+
+// ListJobs lists the registered jobs by name
+func (r *JobRegistry) ListJobs() ([]SchedulerJobInfo, error) {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.jobs))
+	for name := range r.jobs {
+		names = append(names, name)
+	}
+	r.mu.RUnlock()
+	sort.Strings(names)
+
+	var states []models.SchedulerJob
+	if len(names) > 0 {
+		if err := r.db.Where("name IN ?", names).Find(&states).Error; err != nil {
+			return nil, NewDatabaseError("query scheduler jobs", err)
+		}
+	}
+	byName := make(map[string]*models.SchedulerJob, len(states))
+	for i := range states {
+		byName[states[i].Name] = &states[i]
+	}
+
+	jobs := make([]SchedulerJobInfo, 0, len(names))
+	for _, name := range names {
+		if info, ok := r.jobInfo(name, byName[name]); ok {
+			jobs = append(jobs, info)
+		}
+	}
+	return jobs, nil
+}
+
+// GetJob gets a registered job
+func (r *JobRegistry) GetJob(name string) (*SchedulerJobInfo, error) {
+	var state models.SchedulerJob
+	err := r.db.Where("name = ?", name).First(&state).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, NewDatabaseError("get scheduler job", err)
+	}
+	var statePtr *models.SchedulerJob
+	if err == nil {
+		statePtr = &state
+	}
+
+	info, ok := r.jobInfo(name, statePtr)
+	if !ok {
+		return nil, NewResourceNotFoundError("scheduler job", name)
+	}
+	return &info, nil
+}
+
+// PauseJob pauses a registered job on all instances until it is resumed
+func (r *JobRegistry) PauseJob(name, author string) (*SchedulerJobInfo, error) {
+	return r.setPaused(name, true, author)
+}
+
+// ResumeJob resumes a paused job, the job next runs at its next fire time
+func (r *JobRegistry) ResumeJob(name string) (*SchedulerJobInfo, error) {
+	return r.setPaused(name, false, "")
+}
+
+// setPaused stores the paused state of a registered job
+func (r *JobRegistry) setPaused(name string, paused bool, author string) (*SchedulerJobInfo, error) {
+	r.mu.RLock()
+	_, exists := r.jobs[name]
+	r.mu.RUnlock()
+	if !exists {
+		return nil, NewResourceNotFoundError("scheduler job", name)
+	}
+
+	state := &models.SchedulerJob{Name: name, Paused: paused, PausedBy: author}
+	if err := r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}},
+		DoUpdates: clause.AssignmentColumns([]string{"paused", "paused_by", "update_time"}),
+	}).Create(state).Error; err != nil {
+		return nil, NewDatabaseError("update scheduler job", err)
+	}
+
+	logger.Info("Scheduled job paused state changed",
+		zap.String("job", name),
+		zap.Bool("paused", paused),
+		zap.String("author", author))
+	return r.GetJob(name)
+}
+
+// jobInfo describes a registered job with its stored state, false if the job is not registered
+func (r *JobRegistry) jobInfo(name string, state *models.SchedulerJob) (SchedulerJobInfo, bool) {
+	r.mu.RLock()
+	job, exists := r.jobs[name]
+	r.mu.RUnlock()
+	if !exists {
+		return SchedulerJobInfo{}, false
+	}
+
+	tz := utils.GetTimezone(r.config)
+	now := time.Now().In(job.cron.Location())
+	info := SchedulerJobInfo{
+		Name:     name,
+		Schedule: job.schedule,
+	}
+
+	// The entry has no next time before its cron is started
+	next := job.cron.Entry(job.entryID).Next
+	if next.IsZero() {
+		if schedule, err := cronParser.Parse(job.schedule); err == nil {
+			next = schedule.Next(now)
+		}
+	}
+	if !next.IsZero() {
+		next = next.In(tz)
+		info.NextRun = &next
+	}
+	if previous := previousFireTime(job.schedule, now); !previous.IsZero() {
+		previous = previous.In(tz)
+		info.PreviousRun = &previous
+	}
+
+	if state != nil {
+		info.Paused = state.Paused
+		info.PausedBy = state.PausedBy
+		if state.LastStartTime != nil {
+			info.LastRun = &SchedulerJobLastRun{
+				StartTime: state.LastStartTime.In(tz),
+				Outcome:   state.LastOutcome,
+				Error:     state.LastError,
+				Instance:  state.LastInstance,
+			}
+			if state.LastEndTime != nil {
+				endTime := state.LastEndTime.In(tz)
+				info.LastRun.EndTime = &endTime
+			}
+		}
+	}
+	return info, true
+}
+
+// isPaused reports whether a job is paused, a failure to check runs the job
+func (r *JobRegistry) isPaused(name string) bool {
+	var count int64
+	if err := r.db.Model(&models.SchedulerJob{}).Where("name = ? AND paused = ?", name, true).Count(&count).Error; err != nil {
+		logger.Error("Failed to check scheduled job pause", zap.String("job", name), zap.Error(err))
+		return false
+	}
+	return count > 0
+}
+
+// recordRun stores the outcome of a run of a job
+func (r *JobRegistry) recordRun(name string, startTime time.Time, runErr error) {
+	endTime := time.Now()
+	state := &models.SchedulerJob{
+		Name:          name,
+		LastStartTime: &startTime,
+		LastEndTime:   &endTime,
+		LastOutcome:   models.SchedulerJobOutcomeSucceeded,
+		LastInstance:  r.leader.InstanceID(),
+	}
+	if runErr != nil {
+		state.LastOutcome = models.SchedulerJobOutcomeFailed
+		state.LastError = runErr.Error()
+	}
+
+	if err := r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}},
+		DoUpdates: clause.AssignmentColumns([]string{"last_start_time", "last_end_time", "last_outcome", "last_error", "last_instance", "update_time"}),
+	}).Create(state).Error; err != nil {
+		logger.Error("Failed to record scheduled job run", zap.String("job", name), zap.Error(err))
+	}
+}
@@ -6,10 +6,12 @@ import (
 	"quota-manager/internal/config"
 	"quota-manager/internal/database"
 	"quota-manager/internal/models"
+	"quota-manager/internal/utils"
 	"quota-manager/pkg/aigateway"
 	"quota-manager/pkg/logger"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"database/sql"
@@ -18,6 +20,7 @@ import (
 	"github.com/robfig/cron/v3"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // StrategyDatabaseQuerier implements condition.DatabaseQuerier interface
@@ -38,6 +41,31 @@ func (q *StrategyDatabaseQuerier) QueryEmployeeDepartment(employeeNumber string)
 	return employee.GetDeptFullLevelNamesAsSlice(), nil
 }
 
+// QueryRechargeCount implements condition.RechargeQuerier. Completed executions of the strategy are
+// counted while it exists, the RECHARGE audit records written under its name once it was deleted
+func (q *StrategyDatabaseQuerier) QueryRechargeCount(userID, strategyName string, since time.Time) (int64, error) {
+	var strategy models.QuotaStrategy
+	err := q.db.DB.Select("id").Where("name = ?", strategyName).First(&strategy).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, fmt.Errorf("failed to query strategy: %w", err)
+	}
+
+	var count int64
+	if err == nil {
+		err = q.db.DB.Model(&models.QuotaExecute{}).
+			Where("strategy_id = ? AND user_id = ? AND status = ? AND create_time >= ?", strategy.ID, userID, models.ExecuteStatusCompleted, since).
+			Count(&count).Error
+	} else {
+		err = q.db.DB.Model(&models.QuotaAudit{}).
+			Where("strategy_name = ? AND user_id = ? AND operation = ? AND create_time >= ?", strategyName, userID, models.OperationRecharge, since).
+			Count(&count).Error
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to count recharges: %w", err)
+	}
+	return count, nil
+}
+
 // StrategyConfigQuerier implements condition.ConfigQuerier interface
 type StrategyConfigQuerier struct {
 	employeeSyncConfig *config.EmployeeSyncConfig
@@ -51,13 +79,19 @@ type StrategyService struct {
 	db                 *database.DB
 	gateway            *aigateway.Client
 	quotaQuerier       condition.QuotaQuerier
+	usedQuotaQuerier   condition.UsedQuotaQuerier
 	quotaService       *QuotaService
 	cron               *cron.Cron
 	cronJobs           map[int]cron.EntryID // strategyID -> cronEntryID
 	mu                 sync.RWMutex         // protect cronJobs map
 	databaseQuerier    condition.DatabaseQuerier
+	rechargeQuerier    condition.RechargeQuerier
 	configQuerier      condition.ConfigQuerier
 	employeeSyncConfig *config.EmployeeSyncConfig
+	leader             *LeaderElector // Periodic strategies only fire on the leader, nil fires them here
+	jobs               *JobRegistry   // Tracks the cron entries of periodic strategies, nil leaves them untracked
+	exclusionLocks     sync.Map       // exclusion group -> *exclusionLock
+	misfireMu          sync.Mutex     // Held by a running misfire catch-up, later ones are skipped
 }
 
 // NewStrategyService creates a new strategy service
@@ -70,10 +104,12 @@ func NewStrategyService(db *database.DB, gateway *aigateway.Client, quotaService
 		db:                 db,
 		gateway:            gateway,
 		quotaQuerier:       condition.NewAiGatewayQuotaQuerier(gateway),
+		usedQuotaQuerier:   condition.NewAiGatewayUsedQuotaQuerier(gateway),
 		quotaService:       quotaService,
 		cron:               cron.New(cron.WithSeconds()),
 		cronJobs:           make(map[int]cron.EntryID),
 		databaseQuerier:    dbQuerier,
+		rechargeQuerier:    dbQuerier,
 		configQuerier:      cfgQuerier,
 		employeeSyncConfig: employeeSyncConfig,
 	}
@@ -95,7 +131,13 @@ func (s *StrategyService) StartCron() error {
 		}
 	}
 
+	// Runs missed while no instance led are caught up each time this instance becomes leader,
+	// later fire times are left to cron
 	s.cron.Start()
+	s.leader.OnElected(func() {
+		s.CatchUpMisfiredStrategies(time.Now())
+	})
+
 	logger.Info("Strategy cron scheduler started", zap.Int("periodic_strategies", len(strategies)))
 	return nil
 }
@@ -122,20 +164,28 @@ func (s *StrategyService) registerPeriodicStrategy(strategy *models.QuotaStrateg
 	}
 
 	// Add new job
-	entryID, err := s.cron.AddFunc(strategy.PeriodicExpr, func() {
-		s.executePeriodicStrategy(strategy.ID)
-	})
+	strategyID := strategy.ID
+	jobName := strategyJobName(strategyID)
+	entryID, err := s.cron.AddFunc(strategy.PeriodicExpr, s.leader.Guard(jobName, s.jobs.Wrap(jobName, func() error {
+		return s.executePeriodicStrategy(strategyID)
+	})))
 	if err != nil {
 		return fmt.Errorf("failed to add cron job for strategy %s: %w", strategy.Name, err)
 	}
 
 	s.cronJobs[strategy.ID] = entryID
+	s.jobs.Track(jobName, strategy.PeriodicExpr, s.cron, entryID)
 	logger.Info("Registered periodic strategy to cron",
 		zap.String("strategy", strategy.Name),
 		zap.String("expression", strategy.PeriodicExpr))
 	return nil
 }
 
+// strategyJobName names the cron job of a periodic strategy
+func strategyJobName(strategyID int) string {
+	return fmt.Sprintf("strategy-%d", strategyID)
+}
+
 // unregisterPeriodicStrategy removes a periodic strategy from cron
 func (s *StrategyService) unregisterPeriodicStrategy(strategyID int) {
 	s.mu.Lock()
@@ -144,25 +194,32 @@ func (s *StrategyService) unregisterPeriodicStrategy(strategyID int) {
 	if entryID, exists := s.cronJobs[strategyID]; exists {
 		s.cron.Remove(entryID)
 		delete(s.cronJobs, strategyID)
+		s.jobs.Untrack(strategyJobName(strategyID))
 		logger.Info("Unregistered periodic strategy from cron", zap.Int("strategy_id", strategyID))
 	}
 }
 
-// executePeriodicStrategy executes a specific periodic strategy
-func (s *StrategyService) executePeriodicStrategy(strategyID int) {
+// executePeriodicStrategy executes a specific periodic strategy, returning why it could not be executed
+func (s *StrategyService) executePeriodicStrategy(strategyID int) error {
 	// Get strategy details
 	strategy, err := s.GetStrategy(strategyID)
 	if err != nil {
 		logger.Error("Failed to get strategy for execution",
 			zap.Int("strategy_id", strategyID),
 			zap.Error(err))
-		return
+		return err
 	}
 
 	// Check if strategy is still enabled
 	if !strategy.IsEnabled() {
 		logger.Warn("Skipping disabled strategy", zap.String("strategy", strategy.Name))
-		return
+		return nil
+	}
+
+	// Check if strategy is within its validity window
+	if !strategy.InWindow(time.Now()) {
+		logger.Info("Skipping strategy outside its validity window", zap.String("strategy", strategy.Name))
+		return nil
 	}
 
 	// Get users
@@ -171,7 +228,17 @@ func (s *StrategyService) executePeriodicStrategy(strategyID int) {
 		logger.Error("Failed to load users for strategy execution",
 			zap.String("strategy", strategy.Name),
 			zap.Error(err))
-		return
+		return err
+	}
+
+	// Members of an exclusion group firing together are executed in priority order
+	if strategy.ExclusionGroup != "" {
+		lock := s.exclusionLock(strategy.ExclusionGroup)
+		lock.cron.Lock()
+		defer lock.cron.Unlock()
+		if !s.executeExclusionGroupMembers(strategy, users) {
+			return nil
+		}
 	}
 
 	logger.Info("Executing periodic strategy",
@@ -179,7 +246,8 @@ func (s *StrategyService) executePeriodicStrategy(strategyID int) {
 		zap.Int("user_count", len(users)))
 
 	// Execute strategy
-	s.ExecStrategy(strategy, users)
+	s.execStrategy(strategy, users, models.StrategyRunTriggerCron)
+	return nil
 }
 
 // loadEnabledPeriodicStrategies loads enabled periodic strategies with retry mechanism
@@ -225,35 +293,45 @@ func (s *StrategyService) loadEnabledPeriodicStrategies() ([]models.QuotaStrateg
 // TraverseSingleStrategies traverses single-type strategies only
 // Periodic strategies are now handled by cron directly
 func (s *StrategyService) TraverseSingleStrategies() {
+	_ = s.ScanSingleStrategies(nil)
+}
+
+// ScanSingleStrategies traverses single-type strategies, counting each executed strategy in the
+// progress. It returns why the traversal could not run
+func (s *StrategyService) ScanSingleStrategies(progress *ScanJobProgress) error {
 	logger.Info("Starting single strategy traversal")
 
 	// 1. Get user list
 	users, err := s.loadUsers()
 	if err != nil {
 		logger.Error("Failed to load users", zap.Error(err))
-		return
+		return err
 	}
 
 	// 2. Get enabled single-type strategies
 	strategies, err := s.loadEnabledSingleStrategies()
 	if err != nil {
 		logger.Error("Failed to load enabled single strategies", zap.Error(err))
-		return
+		return err
 	}
 
 	logger.Info("Found enabled single strategies", zap.Int("count", len(strategies)))
+	progress.AddTotal(len(strategies))
 
-	// 3. Execute single strategies
+	// 3. Execute single strategies, higher priority strategies of an exclusion group grant first
 	for _, strategy := range strategies {
 		logger.Info("Processing single strategy",
 			zap.String("strategy", strategy.Name))
-		s.ExecStrategy(&strategy, users)
+		s.execStrategy(&strategy, users, models.StrategyRunTriggerScan)
+		progress.Processed()
 	}
 
 	logger.Info("Single strategy traversal completed")
+	return nil
 }
 
-// loadEnabledSingleStrategies loads enabled single-type strategies with retry mechanism
+// loadEnabledSingleStrategies loads enabled single-type strategies with retry mechanism, highest priority first.
+// Event strategies are included so that users whose event was missed are still granted
 func (s *StrategyService) loadEnabledSingleStrategies() ([]models.QuotaStrategy, error) {
 	var strategies []models.QuotaStrategy
 	var err error
@@ -270,7 +348,9 @@ func (s *StrategyService) loadEnabledSingleStrategies() ([]models.QuotaStrategy,
 			}
 		}
 
-		err = s.db.Where("status = ? AND type = ?", true, "single").Find(&strategies).Error
+		err = s.db.Where("status = ? AND type = ?", true, "single").
+			Order("priority DESC, id").
+			Find(&strategies).Error
 		if err == nil {
 			logger.Info("Successfully loaded enabled single strategies", zap.Int("count", len(strategies)))
 			return strategies, nil
@@ -381,141 +461,421 @@ func (s *StrategyService) loadStrategies() ([]models.QuotaStrategy, error) {
 	return strategies, nil
 }
 
-// ExecStrategy executes a strategy
+// ExecStrategy executes a strategy manually
 func (s *StrategyService) ExecStrategy(strategy *models.QuotaStrategy, users []models.UserInfo) {
+	s.execStrategy(strategy, users, models.StrategyRunTriggerManual)
+}
+
+// defaultStrategyWorkers is used when scheduler.strategy_workers is not configured
+const defaultStrategyWorkers = 8
+
+// execStrategy executes a strategy and records the run with its summary statistics.
+// The condition is parsed once and execution counts are loaded in bulk, then matching
+// users are evaluated and recharged by a bounded pool of workers. Users granted by another
+// strategy of the exclusion group in the current period are skipped. It returns the statistics
+// of the run, nil if the strategy was not executed
+func (s *StrategyService) execStrategy(strategy *models.QuotaStrategy, users []models.UserInfo, trigger string) *models.StrategyRun {
+	return s.execStrategyBatch(strategy, users, trigger, "")
+}
+
+// execStrategyBatch executes a strategy like execStrategy under the given batch number, an empty
+// one is generated from the time the execution starts
+func (s *StrategyService) execStrategyBatch(strategy *models.QuotaStrategy, users []models.UserInfo, trigger, batchNumber string) *models.StrategyRun {
 	// Validate strategy status (should already be enabled since we got it from loadEnabledStrategies)
 	if !strategy.IsEnabled() {
 		logger.Warn("Skipping disabled strategy", zap.String("strategy", strategy.Name))
-		return
+		return nil
+	}
+	if !strategy.InWindow(time.Now()) {
+		logger.Info("Skipping strategy outside its validity window", zap.String("strategy", strategy.Name))
+		return nil
 	}
 
-	batchNumber := s.generateBatchNumber()
+	// Members of an exclusion group are executed one at a time so each sees the grants of the others
+	if strategy.ExclusionGroup != "" {
+		lock := s.exclusionLock(strategy.ExclusionGroup)
+		lock.exec.Lock()
+		defer lock.exec.Unlock()
+	}
 
-	for _, user := range users {
-		// For single strategy, check if it has already been executed
-		if strategy.Type == "single" {
-			if s.hasExecuted(strategy.ID, user.ID) {
-				continue
-			}
-		}
-		// For periodic strategy with per-user max execution limit
-		if strategy.Type == "periodic" && strategy.MaxExecPerUser > 0 {
-			var count int64
-			if err := s.db.Model(&models.QuotaExecute{}).
-				Where("strategy_id = ? AND user_id = ? AND status = ?",
-					strategy.ID, user.ID, "completed").
-				Count(&count).Error; err != nil {
-				logger.Error("Failed to count periodic executions",
-					zap.Int("strategy_id", strategy.ID),
-					zap.String("user", user.ID),
-					zap.Error(err))
-				// conservative: skip on error to avoid over-grant; or we could continue
-				continue
-			}
-			if count >= int64(strategy.MaxExecPerUser) {
-				logger.Info("Skip user due to max_exec_per_user reached",
-					zap.String("user", user.ID),
-					zap.Int("strategy_id", strategy.ID),
-					zap.Int("max_exec_per_user", strategy.MaxExecPerUser))
-				continue
+	startTime := time.Now()
+	if batchNumber == "" {
+		batchNumber = s.generateBatchNumber()
+	}
+	ctx := s.newEvaluationContext()
+
+	evaluator, parseErr := condition.ParseCondition(strategy.Condition)
+	if parseErr != nil {
+		logger.Error("Failed to parse strategy condition",
+			zap.String("strategy", strategy.Name),
+			zap.Error(parseErr))
+	}
+	amount := parseStrategyAmount(strategy)
+	if amount.err != nil {
+		logger.Error("Failed to parse strategy amount expression",
+			zap.String("strategy", strategy.Name),
+			zap.Error(amount.err))
+	}
+
+	executions, err := s.loadUserExecutions(strategy, batchNumber)
+	if err != nil {
+		logger.Error("Failed to load strategy executions",
+			zap.String("strategy", strategy.Name),
+			zap.Error(err))
+		return nil
+	}
+	exclusionGrants, err := s.loadExclusionGrants(strategy, exclusionPeriodStart(strategy, startTime))
+	if err != nil {
+		logger.Error("Failed to load exclusion group grants",
+			zap.String("strategy", strategy.Name),
+			zap.String("exclusion_group", strategy.ExclusionGroup),
+			zap.Error(err))
+		return nil
+	}
+
+	run := s.startStrategyRun(strategy, batchNumber, trigger)
+	stats := &models.StrategyRun{}
+	var statsMu sync.Mutex
+	// Set once the remaining budget is below the smallest grant, no later grant of the run can succeed.
+	// Amounts computed per user may still fit after a larger one did not
+	var budgetExhausted atomic.Bool
+
+	jobs := make(chan *models.UserInfo)
+	var wg sync.WaitGroup
+	for i := 0; i < s.strategyWorkers(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for user := range jobs {
+				if budgetExhausted.Load() {
+					continue
+				}
+				outcome, granted := s.execStrategyForUser(strategy, user, evaluator, parseErr, amount, ctx, batchNumber)
+				if outcome == userOutcomeBudgetExhausted && s.budgetCannotGrant(strategy) {
+					budgetExhausted.Store(true)
+				}
+
+				statsMu.Lock()
+				stats.UsersEvaluated++
+				switch outcome {
+				case userOutcomeConditionError:
+					stats.ConditionErrors++
+				case userOutcomeRechargeFailed:
+					stats.UsersMatched++
+					stats.RechargeFailures++
+				case userOutcomeBudgetExhausted:
+					stats.UsersMatched++
+					stats.SkippedBudget++
+				case userOutcomeNoAmount, userOutcomeAlreadyGranted:
+					stats.UsersMatched++
+				case userOutcomeGranted:
+					stats.UsersMatched++
+					stats.UsersGranted++
+					stats.TotalAmount += granted
+				}
+				statsMu.Unlock()
 			}
-		}
+		}()
+	}
 
-		// Check condition
-		ctx := &condition.EvaluationContext{
-			QuotaQuerier:    s.quotaQuerier,
-			DatabaseQuerier: s.databaseQuerier,
-			ConfigQuerier:   s.configQuerier,
-		}
-		match, err := condition.CalcCondition(&user, strategy.Condition, ctx)
-		if err != nil {
-			logger.Error("Failed to calculate condition",
-				zap.String("user", user.ID),
+	for i := range users {
+		if budgetExhausted.Load() {
+			logger.Info("Strategy total budget exhausted, stopping execution",
 				zap.String("strategy", strategy.Name),
-				zap.Error(err))
+				zap.Float64("total_budget", strategy.TotalBudget))
+			break
+		}
+		switch skipReason(strategy, executions[users[i].ID]) {
+		case skipReasonExecuted:
+			continue
+		case skipReasonMaxExec:
+			stats.SkippedMaxExec++
 			continue
 		}
-
-		if !match {
+		if conflict, exists := exclusionGrants[users[i].ID]; exists {
+			stats.SkippedExclusion++
+			if len(stats.Conflicts) < models.MaxRunConflicts {
+				stats.Conflicts = append(stats.Conflicts, conflict)
+			}
 			continue
 		}
+		jobs <- &users[i]
+	}
+	close(jobs)
+	wg.Wait()
 
-		// Execute recharge
-		if err := s.executeRecharge(strategy, &user, batchNumber); err != nil {
-			logger.Error("Failed to execute recharge",
-				zap.String("user", user.ID),
-				zap.String("strategy", strategy.Name),
-				zap.Error(err))
-		}
+	duration := time.Since(startTime)
+	stats.DurationMs = duration.Milliseconds()
+	if duration > 0 {
+		stats.UsersPerSecond = float64(stats.UsersEvaluated) / duration.Seconds()
 	}
+
+	s.finishStrategyRun(run, stats)
+	return stats
 }
 
-// hasExecuted checks if single strategy has been executed
-func (s *StrategyService) hasExecuted(strategyID int, userID string) bool {
-	var count int64
+// Outcomes of executing a strategy for one user
+const (
+	userOutcomeNotMatched = iota
+	userOutcomeGranted
+	userOutcomeConditionError
+	userOutcomeRechargeFailed
+	userOutcomeBudgetExhausted
+	userOutcomeNoAmount       // Matched, but the amount computed for the user is not positive
+	userOutcomeAlreadyGranted // Matched, but a concurrent run granted the single strategy first
+)
 
-	// First check if there is a completed record
-	err := s.db.Model(&models.QuotaExecute{}).
-		Where("strategy_id = ? AND user_id = ? AND status = ?",
-			strategyID, userID, "completed").
-		Count(&count).Error
+// execStrategyForUser evaluates the strategy condition for one user and recharges the user on a match,
+// returning the outcome and the amount granted
+func (s *StrategyService) execStrategyForUser(strategy *models.QuotaStrategy, user *models.UserInfo, evaluator condition.Evaluator,
+	parseErr error, amount strategyAmount, ctx *condition.EvaluationContext, batchNumber string) (int, float64) {
+	// Check condition
+	match, err := evaluateCondition(evaluator, parseErr, user, ctx)
+	if err != nil {
+		logger.Error("Failed to calculate condition",
+			zap.String("user", user.ID),
+			zap.String("strategy", strategy.Name),
+			zap.Error(err))
+		return userOutcomeConditionError, 0
+	}
+
+	if !match {
+		return userOutcomeNotMatched, 0
+	}
 
+	// Compute the amount, errors count as condition errors
+	value, err := amount.forUser(strategy, user, ctx)
 	if err != nil {
-		logger.Error("Failed to check execution status", zap.Error(err))
-		return false
+		logger.Error("Failed to calculate amount",
+			zap.String("user", user.ID),
+			zap.String("strategy", strategy.Name),
+			zap.Error(err))
+		return userOutcomeConditionError, 0
+	}
+	if value <= 0 {
+		return userOutcomeNoAmount, 0
 	}
 
-	if count > 0 {
-		return true
+	// Execute recharge
+	if err := s.executeRecharge(strategy, user, batchNumber, value); err != nil {
+		if errors.Is(err, errBudgetExhausted) {
+			return userOutcomeBudgetExhausted, 0
+		}
+		if errors.Is(err, errAlreadyGranted) {
+			return userOutcomeAlreadyGranted, 0
+		}
+		logger.Error("Failed to execute recharge",
+			zap.String("user", user.ID),
+			zap.String("strategy", strategy.Name),
+			zap.Error(err))
+		return userOutcomeRechargeFailed, 0
 	}
+	return userOutcomeGranted, value
+}
 
-	// If there is no completed record, check for processing records in the current batch
-	currentBatch := s.generateBatchNumber()
-	err = s.db.Model(&models.QuotaExecute{}).
-		Where("strategy_id = ? AND user_id = ? AND status = ? AND batch_number = ?",
-			strategyID, userID, "processing", currentBatch).
-		Count(&count).Error
+// strategyAmount is the parsed amount expression of a strategy, strategies without one grant their fixed amount
+type strategyAmount struct {
+	expr condition.AmountEvaluator
+	err  error // Parse error, reported for every user like a condition that fails to parse
+}
 
-	if err != nil {
-		logger.Error("Failed to check execution status", zap.Error(err))
-		return false
+// validateAmountExpr checks that an amount expression parses, an empty one grants the fixed amount
+func validateAmountExpr(expr string) error {
+	if expr == "" {
+		return nil
+	}
+	_, err := condition.ParseAmount(expr)
+	return err
+}
+
+// parseStrategyAmount parses the amount expression of a strategy once for all users
+func parseStrategyAmount(strategy *models.QuotaStrategy) strategyAmount {
+	if strategy.AmountExpr == "" {
+		return strategyAmount{}
+	}
+	expr, err := condition.ParseAmount(strategy.AmountExpr)
+	return strategyAmount{expr: expr, err: err}
+}
+
+// forUser computes the amount the strategy grants to the user
+func (a strategyAmount) forUser(strategy *models.QuotaStrategy, user *models.UserInfo, ctx *condition.EvaluationContext) (float64, error) {
+	if a.err != nil {
+		return 0, a.err
+	}
+	if a.expr == nil {
+		return strategy.Amount, nil
+	}
+	return condition.CalcAmount(a.expr, user, ctx)
+}
+
+// evaluateCondition evaluates a parsed condition, a condition that failed to parse fails for every user
+func evaluateCondition(evaluator condition.Evaluator, parseErr error, user *models.UserInfo, ctx *condition.EvaluationContext) (bool, error) {
+	if parseErr != nil {
+		return false, parseErr
+	}
+	return evaluator.Evaluate(user, ctx)
+}
+
+// strategyWorkers returns the number of users evaluated and recharged concurrently
+func (s *StrategyService) strategyWorkers() int {
+	if workers := s.quotaService.GetConfigManager().GetDirect().Scheduler.StrategyWorkers; workers > 0 {
+		return workers
 	}
+	return defaultStrategyWorkers
+}
+
+// Reasons for not evaluating a strategy for a user
+const (
+	skipReasonExecuted = "executed"
+	skipReasonMaxExec  = "max_exec"
+)
 
-	return count > 0
+// userExecutions counts the execution records of a strategy for one user
+type userExecutions struct {
+	Completed  int64 // Completed executions in any batch
+	Processing int64 // Executions of the current batch still processing
 }
 
-// executeRecharge executes recharge
-func (s *StrategyService) executeRecharge(strategy *models.QuotaStrategy, user *models.UserInfo, batchNumber string) error {
+// loadUserExecutions loads the execution counts of a strategy for all users in one query,
+// strategies without per-user limits need none
+func (s *StrategyService) loadUserExecutions(strategy *models.QuotaStrategy, batchNumber string) (map[string]userExecutions, error) {
+	executions := make(map[string]userExecutions)
+	if strategy.Type != "single" && !(strategy.Type == "periodic" && strategy.MaxExecPerUser > 0) {
+		return executions, nil
+	}
+
+	var rows []struct {
+		UserID     string
+		Completed  int64
+		Processing int64
+	}
+	if err := s.db.Model(&models.QuotaExecute{}).
+		Select("user_id, "+
+			"SUM(CASE WHEN status = ? THEN 1 ELSE 0 END) AS completed, "+
+			"SUM(CASE WHEN status = ? AND batch_number = ? THEN 1 ELSE 0 END) AS processing",
+			"completed", "processing", batchNumber).
+		Where("strategy_id = ?", strategy.ID).
+		Group("user_id").
+		Scan(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to count strategy executions: %w", err)
+	}
+
+	for _, row := range rows {
+		executions[row.UserID] = userExecutions{Completed: row.Completed, Processing: row.Processing}
+	}
+	return executions, nil
+}
+
+// skipReason returns why the strategy must not be applied to the user, empty if it may be
+func skipReason(strategy *models.QuotaStrategy, executions userExecutions) string {
+	// For single strategy, check if it has already been executed or is being executed in this batch
+	if strategy.Type == "single" && (executions.Completed > 0 || executions.Processing > 0) {
+		return skipReasonExecuted
+	}
+	// For periodic strategy with per-user max execution limit
+	if strategy.Type == "periodic" && strategy.MaxExecPerUser > 0 && executions.Completed >= int64(strategy.MaxExecPerUser) {
+		return skipReasonMaxExec
+	}
+	return ""
+}
+
+// errAlreadyGranted is returned when a single strategy already has a processing or completed
+// execution for the user
+var errAlreadyGranted = errors.New("single strategy already granted to the user")
+
+// lockSingleGrant locks the grants of a single strategy to the user until the transaction ends and
+// fails with errAlreadyGranted when the user has a processing or completed execution other than
+// excludeID. Overlapping runs, events and retries take the lock before granting, so the user is
+// granted once
+func lockSingleGrant(tx *gorm.DB, strategyID int, userID string, excludeID int) error {
+	if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtextextended(?, 0))",
+		fmt.Sprintf("single-grant:%d:%s", strategyID, userID)).Error; err != nil {
+		return fmt.Errorf("failed to lock single strategy grant: %w", err)
+	}
+
+	var count int64
+	if err := tx.Model(&models.QuotaExecute{}).
+		Where("strategy_id = ? AND user_id = ? AND status IN ? AND id <> ?",
+			strategyID, userID, []string{models.ExecuteStatusProcessing, models.ExecuteStatusCompleted}, excludeID).
+		Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to check strategy executions: %w", err)
+	}
+	if count > 0 {
+		return errAlreadyGranted
+	}
+	return nil
+}
+
+// newEvaluationContext returns the context strategy conditions are evaluated with
+func (s *StrategyService) newEvaluationContext() *condition.EvaluationContext {
+	return &condition.EvaluationContext{
+		QuotaQuerier:      s.quotaQuerier,
+		ModelQuotaQuerier: s.quotaService,
+		DatabaseQuerier:   s.databaseQuerier,
+		ConfigQuerier:     s.configQuerier,
+		UsageQuerier:      s.quotaService,
+		RechargeQuerier:   s.rechargeQuerier,
+		UsedQuotaQuerier:  s.usedQuotaQuerier,
+		Location:          utils.GetTimezone(s.quotaService.GetConfigManager().GetDirect()),
+	}
+}
+
+// executeRecharge grants amount to the user
+func (s *StrategyService) executeRecharge(strategy *models.QuotaStrategy, user *models.UserInfo, batchNumber string, amount float64) error {
 	// Strategy should already be validated as enabled before reaching here
 	if !strategy.IsEnabled() {
 		return fmt.Errorf("strategy is disabled")
 	}
 
-	// Calculate expiry date (end of this/next month)
-	now := time.Now().Truncate(time.Second)
-	var expiryDate time.Time
-
-	// Always set to end of current month
-	expiryDate = time.Date(now.Year(), now.Month()+1, 0, 23, 59, 59, 0, now.Location())
+	// Calculate expiry date from the strategy's expiry policy
+	now := utils.NowInConfigTimezone(s.quotaService.GetConfigManager().GetDirect()).Truncate(time.Second)
+	expiryDate := strategy.ExpiryDateAt(now)
+	if !expiryDate.After(now) {
+		return fmt.Errorf("strategy expiry date %s has passed", expiryDate.Format(time.RFC3339))
+	}
 
-	// 1. Record execution status as processing
+	// 1. Record execution status as processing and reserve the amount against the total budget,
+	// released again if the recharge fails. Single strategies check under a lock that the user
+	// was not granted meanwhile by an overlapping run
 	execute := &models.QuotaExecute{
-		StrategyID:  strategy.ID,
-		User:        user.ID,
-		BatchNumber: batchNumber,
-		Status:      "processing",
-		ExpiryDate:  expiryDate,
+		StrategyID:      strategy.ID,
+		StrategyVersion: strategy.Version,
+		Amount:          amount,
+		User:            user.ID,
+		BatchNumber:     batchNumber,
+		Status:          "processing",
+		ExpiryDate:      expiryDate,
+		Attempts:        1,
 	}
 
-	if err := s.db.Create(execute).Error; err != nil {
-		return fmt.Errorf("failed to create execute record: %w", err)
+	if err := s.db.Transaction(func(tx *gorm.DB) error {
+		if strategy.Type == "single" {
+			if err := lockSingleGrant(tx, strategy.ID, user.ID, 0); err != nil {
+				return err
+			}
+		}
+		if err := s.reserveBudget(tx, strategy.ID, amount); err != nil {
+			return err
+		}
+		if err := tx.Create(execute).Error; err != nil {
+			return fmt.Errorf("failed to create execute record: %w", err)
+		}
+		return nil
+	}); err != nil {
+		return err
 	}
 
-	// 2. Add quota using QuotaService
-	err := s.quotaService.AddQuotaForStrategy(user.ID, strategy.Amount, strategy.ID, strategy.Name)
+	// 2. Add quota to the strategy's model pool using QuotaService
+	err := s.quotaService.addModelQuotaForStrategy(user.ID, strategy.Model, amount, expiryDate, strategy.ID, strategy.Version, strategy.Name, strategy.AmountExpr)
 	if err != nil {
-		// Update execution status to failed
-		s.db.Model(execute).Update("status", "failed")
+		// Update execution status to failed, the reconciler retries it after the backoff
+		s.releaseBudget(strategy.ID, amount)
+		nextRetryTime := time.Now().Add(s.executionRetryBackoff(execute.Attempts))
+		s.db.Model(execute).Updates(map[string]interface{}{
+			"status":          "failed",
+			"last_error":      err.Error(),
+			"next_retry_time": nextRetryTime,
+		})
 		return fmt.Errorf("failed to recharge quota: %w", err)
 	}
 
@@ -527,7 +887,7 @@ func (s *StrategyService) executeRecharge(strategy *models.QuotaStrategy, user *
 	logger.Info("Recharge completed",
 		zap.String("user", user.ID),
 		zap.String("strategy", strategy.Name),
-		zap.Float64("amount", strategy.Amount),
+		zap.Float64("amount", amount),
 		zap.String("model", strategy.Model),
 		zap.Time("expiry_date", expiryDate))
 
@@ -536,8 +896,12 @@ func (s *StrategyService) executeRecharge(strategy *models.QuotaStrategy, user *
 
 // generateBatchNumber generates batch number with second precision
 func (s *StrategyService) generateBatchNumber() string {
-	now := time.Now().Truncate(time.Second)
-	return now.Format("20060102150405") // YearMonthDayHourMinuteSecond
+	return batchNumberAt(time.Now())
+}
+
+// batchNumberAt returns the batch number of an execution started at the given time
+func batchNumberAt(t time.Time) string {
+	return t.Local().Truncate(time.Second).Format("20060102150405") // YearMonthDayHourMinuteSecond
 }
 
 // CreateStrategy creates a strategy and registers periodic ones to cron
@@ -555,9 +919,32 @@ func (s *StrategyService) CreateStrategy(strategy *models.QuotaStrategy) error {
 		}
 	}
 
-	// Create strategy in database
-	if err := s.db.Create(strategy).Error; err != nil {
-		return fmt.Errorf("failed to create strategy: %w", err)
+	// Validate the settings of the expiry policy
+	if err := strategy.ValidateExpiryPolicy(time.Now()); err != nil {
+		return NewValidationFailedError("invalid expiry policy: " + err.Error())
+	}
+
+	// Validate the validity window, the budget is only used by executions
+	if err := strategy.ValidateWindow(); err != nil {
+		return NewValidationFailedError("invalid validity window: " + err.Error())
+	}
+	if err := strategy.ValidateTriggerEvent(); err != nil {
+		return NewValidationFailedError("invalid trigger event: " + err.Error())
+	}
+	if err := validateAmountExpr(strategy.AmountExpr); err != nil {
+		return NewValidationFailedError("invalid amount expression: " + err.Error())
+	}
+	strategy.UsedBudget = 0
+	strategy.Version = 1
+
+	// Create strategy in database together with its first version
+	if err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(strategy).Error; err != nil {
+			return fmt.Errorf("failed to create strategy: %w", err)
+		}
+		return recordStrategyVersion(tx, strategy, nil, models.StrategyVersionActionCreate, nil)
+	}); err != nil {
+		return err
 	}
 
 	// Reload the strategy from the database to get the default value
@@ -614,11 +1001,18 @@ func (s *StrategyService) GetStrategy(id int) (*models.QuotaStrategy, error) {
 		}
 		return nil, fmt.Errorf("failed to get strategy: %w", err)
 	}
+	strategy.SetRemainingBudget()
 	return &strategy, nil
 }
 
-// UpdateStrategy updates a strategy and manages cron registration
+// UpdateStrategy updates a strategy, records the change as a new version and manages cron
+// registration. The author of the change is taken from updates["updated_by"]
 func (s *StrategyService) UpdateStrategy(id int, updates map[string]interface{}) error {
+	return s.updateStrategy(id, updates, models.StrategyVersionActionUpdate, nil)
+}
+
+// updateStrategy applies updates to a strategy and records them as a version with the given action
+func (s *StrategyService) updateStrategy(id int, updates map[string]interface{}, action string, sourceVersion *int) error {
 	// Get current strategy
 	oldStrategy, err := s.GetStrategy(id)
 	if err != nil {
@@ -638,21 +1032,81 @@ func (s *StrategyService) UpdateStrategy(id int, updates map[string]interface{})
 
 			if strategyType == "periodic" {
 				if periodicExprStr == "" {
-					return fmt.Errorf("periodic expression cannot be empty for periodic strategy")
+					return NewValidationFailedError("periodic expression cannot be empty for periodic strategy")
 				}
 				// Test cron expression by trying to add it to a temporary cron instance
 				tempCron := cron.New(cron.WithSeconds())
 				_, err := tempCron.AddFunc(periodicExprStr, func() {})
 				if err != nil {
-					return fmt.Errorf("invalid cron expression '%s': %w", periodicExprStr, err)
+					return NewValidationFailedError(fmt.Sprintf("invalid cron expression '%s': %v", periodicExprStr, err))
 				}
 			}
 		}
 	}
 
-	// Update strategy in database
-	if err := s.db.Model(&models.QuotaStrategy{}).Where("id = ?", id).Updates(updates).Error; err != nil {
-		return fmt.Errorf("failed to update strategy: %w", err)
+	// Validate the expiry policy, the validity window, the trigger event and the amount expression as they will be after the update
+	merged := applyValidatedUpdates(*oldStrategy, updates)
+	if err := validateExpiryPolicyUpdate(oldStrategy, &merged); err != nil {
+		return NewValidationFailedError("invalid expiry policy: " + err.Error())
+	}
+	if err := merged.ValidateWindow(); err != nil {
+		return NewValidationFailedError("invalid validity window: " + err.Error())
+	}
+	if err := merged.ValidateTriggerEvent(); err != nil {
+		return NewValidationFailedError("invalid trigger event: " + err.Error())
+	}
+	if err := validateAmountExpr(merged.AmountExpr); err != nil {
+		return NewValidationFailedError("invalid amount expression: " + err.Error())
+	}
+
+	// The author is only recorded together with a new version
+	author, _ := updates["updated_by"].(string)
+	columns := make(map[string]interface{}, len(updates))
+	for column, value := range updates {
+		if column != "updated_by" {
+			columns[column] = value
+		}
+	}
+
+	// Update strategy in database, the row lock keeps concurrent updates from taking the same version
+	if err := s.db.Transaction(func(tx *gorm.DB) error {
+		var current models.QuotaStrategy
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&current, id).Error; err != nil {
+			return fmt.Errorf("failed to lock strategy: %w", err)
+		}
+		if len(columns) == 0 {
+			return nil
+		}
+		if err := tx.Model(&models.QuotaStrategy{}).Where("id = ?", id).Updates(columns).Error; err != nil {
+			return fmt.Errorf("failed to update strategy: %w", err)
+		}
+		var updated models.QuotaStrategy
+		if err := tx.First(&updated, id).Error; err != nil {
+			return fmt.Errorf("failed to reload strategy: %w", err)
+		}
+
+		previous := current.Definition()
+		changes, err := models.DiffStrategyDefinitions(previous, updated.Definition())
+		if err != nil {
+			return err
+		}
+		if len(changes) == 0 {
+			return nil
+		}
+
+		// Strategies created before versioning get their previous definition recorded first
+		if err := ensureStrategyVersion(tx, &current); err != nil {
+			return err
+		}
+		updated.Version = current.Version + 1
+		updated.UpdatedBy = author
+		if err := tx.Model(&models.QuotaStrategy{}).Where("id = ?", id).
+			UpdateColumns(map[string]interface{}{"version": updated.Version, "updated_by": author}).Error; err != nil {
+			return fmt.Errorf("failed to update strategy version: %w", err)
+		}
+		return recordStrategyVersion(tx, &updated, &previous, action, sourceVersion)
+	}); err != nil {
+		return err
 	}
 
 	// Get updated strategy
@@ -682,16 +1136,74 @@ func (s *StrategyService) UpdateStrategy(id int, updates map[string]interface{})
 	return nil
 }
 
+// applyValidatedUpdates returns the strategy with the expiry policy, validity window, trigger and amount fields of updates applied
+func applyValidatedUpdates(strategy models.QuotaStrategy, updates map[string]interface{}) models.QuotaStrategy {
+	if amountExpr, ok := updates["amount_expr"].(string); ok {
+		strategy.AmountExpr = amountExpr
+	}
+	if strategyType, ok := updates["type"].(string); ok {
+		strategy.Type = strategyType
+	}
+	if event, ok := updates["trigger_event"].(string); ok {
+		strategy.TriggerEvent = event
+	}
+	if policy, ok := updates["expiry_policy"].(string); ok {
+		strategy.ExpiryPolicy = policy
+	}
+	if days, ok := updates["expiry_days"].(int); ok {
+		strategy.ExpiryDays = days
+	}
+	if hours, ok := updates["expiry_hours"].(int); ok {
+		strategy.ExpiryHours = hours
+	}
+	if date, ok := timeUpdate(updates["fixed_expiry_date"]); ok {
+		strategy.FixedExpiryDate = date
+	}
+	if startTime, ok := timeUpdate(updates["start_time"]); ok {
+		strategy.StartTime = startTime
+	}
+	if endTime, ok := timeUpdate(updates["end_time"]); ok {
+		strategy.EndTime = endTime
+	}
+	if budget, ok := updates["total_budget"].(float64); ok {
+		strategy.TotalBudget = budget
+	}
+	return strategy
+}
+
+// validateExpiryPolicyUpdate validates the expiry policy of an updated strategy. A fixed expiry date
+// must only be in the future when the policy or the date changes, so a strategy whose date has passed
+// can still be disabled, edited or rolled back
+func validateExpiryPolicyUpdate(oldStrategy, updated *models.QuotaStrategy) error {
+	dateChanged := (updated.FixedExpiryDate == nil) != (oldStrategy.FixedExpiryDate == nil) ||
+		(updated.FixedExpiryDate != nil && !updated.FixedExpiryDate.Equal(*oldStrategy.FixedExpiryDate))
+	if updated.ExpiryPolicy != oldStrategy.ExpiryPolicy || dateChanged {
+		return updated.ValidateExpiryPolicy(time.Now())
+	}
+	return updated.ValidateExpiryPolicySettings()
+}
+
+// timeUpdate reads a time update given by value or by pointer, a nil pointer clears the time
+func timeUpdate(value interface{}) (*time.Time, bool) {
+	switch t := value.(type) {
+	case time.Time:
+		return &t, true
+	case *time.Time:
+		return t, true
+	}
+	return nil, false
+}
+
 // EnableStrategy enables a strategy and registers periodic ones to cron
-func (s *StrategyService) EnableStrategy(id int) error {
+func (s *StrategyService) EnableStrategy(id int, author string) error {
 	// UpdateStrategy already handles cron registration for periodic strategies
-	return s.UpdateStrategy(id, map[string]interface{}{"status": true})
+	return s.UpdateStrategy(id, map[string]interface{}{"status": true, "updated_by": author})
 }
 
 // DisableStrategy disables a strategy and unregisters periodic ones from cron
-func (s *StrategyService) DisableStrategy(id int) error {
+func (s *StrategyService) DisableStrategy(id int, author string) error {
 	// UpdateStrategy already handles cron unregistration for periodic strategies
-	return s.UpdateStrategy(id, map[string]interface{}{"status": false})
+	return s.UpdateStrategy(id, map[string]interface{}{"status": false, "updated_by": author})
 }
 
 // DeleteStrategy deletes a strategy and unregisters periodic ones from cron
@@ -706,6 +1218,14 @@ func (s *StrategyService) DeleteStrategy(id int) error {
 			return fmt.Errorf("failed to delete related execution records: %w", err)
 		}
 
+		if err := tx.Where("strategy_id = ?", id).Delete(&models.StrategyRun{}).Error; err != nil {
+			return fmt.Errorf("failed to delete related strategy runs: %w", err)
+		}
+
+		if err := tx.Where("strategy_id = ?", id).Delete(&models.StrategyVersion{}).Error; err != nil {
+			return fmt.Errorf("failed to delete related strategy versions: %w", err)
+		}
+
 		// Then delete the strategy itself
 		if err := tx.Delete(&models.QuotaStrategy{}, id).Error; err != nil {
 			return fmt.Errorf("failed to delete strategy: %w", err)
@@ -0,0 +1,77 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"quota-manager/internal/models"
+	"quota-manager/pkg/logger"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// errBudgetExhausted is returned when a grant would exceed the strategy's total budget
+var errBudgetExhausted = errors.New("strategy total budget exhausted")
+
+// reserveBudget atomically adds amount to the used budget of a strategy, failing with
+// errBudgetExhausted when the total budget would be exceeded. Strategies without a total
+// budget count their grants too, so that a budget set later starts from what was granted.
+// It runs on tx so that the reservation is rolled back with the grant that made it
+func (s *StrategyService) reserveBudget(tx *gorm.DB, strategyID int, amount float64) error {
+	result := tx.Model(&models.QuotaStrategy{}).
+		Where("id = ? AND (total_budget = 0 OR used_budget + ? <= total_budget)", strategyID, amount).
+		UpdateColumn("used_budget", gorm.Expr("used_budget + ?", amount))
+	if result.Error != nil {
+		return fmt.Errorf("failed to reserve strategy budget: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return errBudgetExhausted
+	}
+	return nil
+}
+
+// chargeBudget adds amount to the used budget of a strategy even if the total budget is
+// exceeded, for grants found to be written without a reservation
+func (s *StrategyService) chargeBudget(strategyID int, amount float64) {
+	if err := s.db.Model(&models.QuotaStrategy{}).Where("id = ?", strategyID).
+		UpdateColumn("used_budget", gorm.Expr("used_budget + ?", amount)).Error; err != nil {
+		logger.Error("Failed to charge strategy budget",
+			zap.Int("strategy_id", strategyID),
+			zap.Float64("amount", amount),
+			zap.Error(err))
+	}
+}
+
+// releaseBudget returns the reservation of a grant that was not written
+func (s *StrategyService) releaseBudget(strategyID int, amount float64) {
+	if err := s.db.Model(&models.QuotaStrategy{}).Where("id = ?", strategyID).
+		UpdateColumn("used_budget", gorm.Expr("GREATEST(used_budget - ?, 0)", amount)).Error; err != nil {
+		logger.Error("Failed to release strategy budget",
+			zap.Int("strategy_id", strategyID),
+			zap.Float64("amount", amount),
+			zap.Error(err))
+	}
+}
+
+// minStrategyGrant is the smallest amount a strategy can grant: its fixed amount, or a cent for
+// amounts computed per user
+func minStrategyGrant(strategy *models.QuotaStrategy) float64 {
+	if strategy.AmountExpr == "" {
+		return strategy.Amount
+	}
+	return 0.01
+}
+
+// budgetCannotGrant checks whether the remaining total budget of a strategy is too small for any
+// further grant, so that a run may stop instead of evaluating the remaining users
+func (s *StrategyService) budgetCannotGrant(strategy *models.QuotaStrategy) bool {
+	var current models.QuotaStrategy
+	if err := s.db.Select("total_budget", "used_budget").First(&current, strategy.ID).Error; err != nil {
+		logger.Error("Failed to load strategy budget",
+			zap.Int("strategy_id", strategy.ID),
+			zap.Error(err))
+		return false
+	}
+	return current.TotalBudget > 0 && roundToCents(current.TotalBudget-current.UsedBudget) < minStrategyGrant(strategy)
+}
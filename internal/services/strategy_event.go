@@ -0,0 +1,156 @@
+package services
+
+import (
+	"errors"
+
+	"quota-manager/internal/models"
+	"quota-manager/pkg/logger"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// StrategyEvent is an event about one user, identified by any one of its identifiers
+type StrategyEvent struct {
+	Event          string `json:"event" validate:"required"`
+	UserID         string `json:"user_id" validate:"omitempty,max=100"`
+	EmployeeNumber string `json:"employee_number" validate:"omitempty,max=100"`
+	GithubID       string `json:"github_id" validate:"omitempty,max=100"`
+}
+
+// Outcomes of an event-triggered strategy for the user of the event
+const (
+	StrategyEventOutcomeGranted         = "granted"
+	StrategyEventOutcomeNotMatched      = "not_matched"
+	StrategyEventOutcomeNoAmount        = "no_amount" // Matched, but the amount expression computed nothing to grant
+	StrategyEventOutcomeAlreadyGranted  = "already_granted"
+	StrategyEventOutcomeConditionError  = "condition_error"
+	StrategyEventOutcomeRechargeFailed  = "recharge_failed"
+	StrategyEventOutcomeBudgetExhausted = "budget_exhausted"
+	StrategyEventOutcomeExcluded        = "excluded" // Granted by another strategy of the exclusion group
+	StrategyEventOutcomeSkipped         = "skipped"  // Outside its validity window or not executed
+)
+
+// StrategyEventOutcome is the outcome of one strategy triggered by an event
+type StrategyEventOutcome struct {
+	StrategyID   int    `json:"strategy_id"`
+	StrategyName string `json:"strategy_name"`
+	Outcome      string `json:"outcome"`
+}
+
+// StrategyEventResult summarizes the strategies triggered by an event
+type StrategyEventResult struct {
+	Event      string                 `json:"event"`
+	UserID     string                 `json:"user_id"`
+	Strategies []StrategyEventOutcome `json:"strategies"`
+}
+
+// HandleEvent evaluates the enabled single strategies triggered by the event for its user only
+func (s *StrategyService) HandleEvent(event StrategyEvent) (*StrategyEventResult, error) {
+	if !models.IsStrategyEvent(event.Event) {
+		return nil, NewValidationFailedError("unknown event: " + event.Event)
+	}
+
+	user, err := s.findEventUser(event)
+	if err != nil {
+		return nil, err
+	}
+
+	var strategies []models.QuotaStrategy
+	if err := s.db.Where("status = ? AND type = ? AND trigger_event = ?", true, "single", event.Event).
+		Order("priority DESC, id").
+		Find(&strategies).Error; err != nil {
+		return nil, NewDatabaseError("query event strategies", err)
+	}
+
+	result := &StrategyEventResult{
+		Event:      event.Event,
+		UserID:     user.ID,
+		Strategies: make([]StrategyEventOutcome, 0, len(strategies)),
+	}
+	for i := range strategies {
+		stats := s.execStrategy(&strategies[i], []models.UserInfo{*user}, models.StrategyRunTriggerEvent)
+		result.Strategies = append(result.Strategies, StrategyEventOutcome{
+			StrategyID:   strategies[i].ID,
+			StrategyName: strategies[i].Name,
+			Outcome:      eventOutcome(stats),
+		})
+	}
+
+	logger.Info("Strategy event handled",
+		zap.String("event", event.Event),
+		zap.String("user", user.ID),
+		zap.Int("strategies", len(strategies)))
+
+	return result, nil
+}
+
+// findEventUser finds the user an event is about
+func (s *StrategyService) findEventUser(event StrategyEvent) (*models.UserInfo, error) {
+	query := s.db.AuthDB
+	var identifier string
+	switch {
+	case event.UserID != "":
+		query, identifier = query.Where("id = ?", event.UserID), event.UserID
+	case event.EmployeeNumber != "":
+		query, identifier = query.Where("employee_number = ?", event.EmployeeNumber), event.EmployeeNumber
+	case event.GithubID != "":
+		query, identifier = query.Where("github_id = ?", event.GithubID), event.GithubID
+	default:
+		return nil, NewValidationFailedError("one of user_id, employee_number or github_id is required")
+	}
+
+	var user models.UserInfo
+	if err := query.First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, NewResourceNotFoundError("user", identifier)
+		}
+		return nil, NewDatabaseError("get event user", err)
+	}
+	return &user, nil
+}
+
+// eventOutcome derives the outcome for the single user of an event run from its statistics
+func eventOutcome(stats *models.StrategyRun) string {
+	switch {
+	case stats == nil:
+		return StrategyEventOutcomeSkipped
+	case stats.UsersGranted > 0:
+		return StrategyEventOutcomeGranted
+	case stats.SkippedBudget > 0:
+		return StrategyEventOutcomeBudgetExhausted
+	case stats.RechargeFailures > 0:
+		return StrategyEventOutcomeRechargeFailed
+	case stats.ConditionErrors > 0:
+		return StrategyEventOutcomeConditionError
+	case stats.SkippedExclusion > 0:
+		return StrategyEventOutcomeExcluded
+	case stats.UsersEvaluated == 0:
+		// Single strategies skip users they were already executed for
+		return StrategyEventOutcomeAlreadyGranted
+	case stats.UsersMatched > 0:
+		return StrategyEventOutcomeNoAmount
+	default:
+		return StrategyEventOutcomeNotMatched
+	}
+}
+
+// dispatchEmployeeEvents hands the events found by an employee sync to the strategy service
+func (s *EmployeeSyncService) dispatchEmployeeEvents(events []StrategyEvent) {
+	if s.strategyService == nil {
+		return
+	}
+	for _, event := range events {
+		if _, err := s.strategyService.HandleEvent(event); err != nil {
+			// Employees without a user account yet have nothing to be granted
+			var serviceErr *ServiceError
+			if errors.As(err, &serviceErr) && serviceErr.Code == ErrorResourceNotFound {
+				continue
+			}
+			logger.Logger.Error("Failed to handle employee event",
+				zap.String("event", event.Event),
+				zap.String("employee_number", event.EmployeeNumber),
+				zap.Error(err))
+		}
+	}
+}
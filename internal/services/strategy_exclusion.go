@@ -0,0 +1,123 @@
+package services
+
+import (
+	"sync"
+	"time"
+
+	"quota-manager/internal/models"
+	"quota-manager/pkg/logger"
+
+	"go.uber.org/zap"
+)
+
+// exclusionLock serializes the strategies of one exclusion group
+type exclusionLock struct {
+	exec sync.Mutex // Held while a member is executed, so grants of the group are seen by the next member
+	cron sync.Mutex // Held while members firing together are executed in priority order
+}
+
+// exclusionLock returns the lock of an exclusion group
+func (s *StrategyService) exclusionLock(group string) *exclusionLock {
+	lock, _ := s.exclusionLocks.LoadOrStore(group, &exclusionLock{})
+	return lock.(*exclusionLock)
+}
+
+// exclusionPeriodStart returns the start of the period a strategy grants for: the latest
+// fire time of a periodic strategy, the zero time for single strategies which grant once
+func exclusionPeriodStart(strategy *models.QuotaStrategy, now time.Time) time.Time {
+	if strategy.Type != "periodic" {
+		return time.Time{}
+	}
+	return previousFireTime(strategy.PeriodicExpr, now)
+}
+
+// loadExclusionGrants loads, per user, a grant made since the given time by another strategy of the
+// exclusion group of the strategy. Strategies without a group have no conflicts
+func (s *StrategyService) loadExclusionGrants(strategy *models.QuotaStrategy, since time.Time) (map[string]models.ExclusionConflict, error) {
+	grants := make(map[string]models.ExclusionConflict)
+	if strategy.ExclusionGroup == "" {
+		return grants, nil
+	}
+
+	var rows []struct {
+		UserID       string
+		StrategyID   int
+		StrategyName string
+		Priority     int
+	}
+	if err := s.db.Table("quota_execute").
+		Select("quota_execute.user_id, quota_execute.strategy_id, quota_strategy.name AS strategy_name, quota_strategy.priority").
+		Joins("JOIN quota_strategy ON quota_strategy.id = quota_execute.strategy_id").
+		Where("quota_strategy.exclusion_group = ? AND quota_strategy.id <> ?", strategy.ExclusionGroup, strategy.ID).
+		Where("quota_execute.status IN ? AND quota_execute.create_time >= ?",
+			[]string{models.ExecuteStatusCompleted, models.ExecuteStatusProcessing}, since).
+		Order("quota_strategy.priority DESC, quota_execute.id").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		if _, exists := grants[row.UserID]; exists {
+			continue
+		}
+		grants[row.UserID] = models.ExclusionConflict{
+			UserID:       row.UserID,
+			StrategyID:   row.StrategyID,
+			StrategyName: row.StrategyName,
+			Priority:     row.Priority,
+		}
+	}
+	return grants, nil
+}
+
+// executeExclusionGroupMembers executes, before a periodic strategy of an exclusion group, the enabled
+// members of higher priority firing at the same time that have not run yet. It returns false if the
+// strategy itself already ran for this fire time
+func (s *StrategyService) executeExclusionGroupMembers(strategy *models.QuotaStrategy, users []models.UserInfo) bool {
+	fireTime := previousFireTime(strategy.PeriodicExpr, time.Now())
+	if fireTime.IsZero() {
+		return true
+	}
+	if s.ranSince(strategy.ID, fireTime) {
+		logger.Info("Skipping strategy already executed with its exclusion group",
+			zap.String("strategy", strategy.Name),
+			zap.String("exclusion_group", strategy.ExclusionGroup))
+		return false
+	}
+
+	var members []models.QuotaStrategy
+	if err := s.db.Where("status = ? AND type = ? AND exclusion_group = ? AND id <> ? AND priority > ?",
+		true, "periodic", strategy.ExclusionGroup, strategy.ID, strategy.Priority).
+		Order("priority DESC, id").
+		Find(&members).Error; err != nil {
+		logger.Error("Failed to load exclusion group members",
+			zap.String("strategy", strategy.Name),
+			zap.String("exclusion_group", strategy.ExclusionGroup),
+			zap.Error(err))
+		return true
+	}
+
+	for i := range members {
+		member := &members[i]
+		if !previousFireTime(member.PeriodicExpr, time.Now()).Equal(fireTime) || s.ranSince(member.ID, fireTime) {
+			continue
+		}
+		logger.Info("Executing higher priority strategy of the exclusion group first",
+			zap.String("strategy", member.Name),
+			zap.String("before", strategy.Name))
+		s.execStrategy(member, users, models.StrategyRunTriggerCron)
+	}
+	return true
+}
+
+// ranSince reports whether a cron run of the strategy started at or after the given time
+func (s *StrategyService) ranSince(strategyID int, since time.Time) bool {
+	var count int64
+	if err := s.db.Model(&models.StrategyRun{}).
+		Where("strategy_id = ? AND trigger = ? AND start_time >= ?", strategyID, models.StrategyRunTriggerCron, since.Truncate(time.Second)).
+		Count(&count).Error; err != nil {
+		logger.Error("Failed to query strategy runs", zap.Int("strategy_id", strategyID), zap.Error(err))
+		return false
+	}
+	return count > 0
+}
@@ -0,0 +1,145 @@
+package services
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"quota-manager/internal/models"
+	"quota-manager/pkg/logger"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// maxMisfiredRuns bounds the missed runs listed, and executed by run_all, for one strategy to the latest ones
+const maxMisfiredRuns = 100
+
+// CatchUpMisfiredStrategies executes the runs of enabled periodic strategies whose fire times
+// between their last completed run and now were missed, following each strategy's misfire policy.
+// A catch-up started while another is running is skipped, the running one covers its runs
+func (s *StrategyService) CatchUpMisfiredStrategies(now time.Time) {
+	if !s.misfireMu.TryLock() {
+		logger.Info("Misfire catch-up already running, skipping")
+		return
+	}
+	defer s.misfireMu.Unlock()
+
+	strategies, err := s.loadEnabledPeriodicStrategies()
+	if err != nil {
+		logger.Error("Failed to load periodic strategies for misfire catch-up", zap.Error(err))
+		return
+	}
+
+	// Higher priority strategies of an exclusion group grant first
+	sort.SliceStable(strategies, func(i, j int) bool {
+		if strategies[i].Priority != strategies[j].Priority {
+			return strategies[i].Priority > strategies[j].Priority
+		}
+		return strategies[i].ID < strategies[j].ID
+	})
+
+	var users []models.UserInfo
+	for i := range strategies {
+		strategy := &strategies[i]
+		if strategy.MisfirePolicy == "" || strategy.MisfirePolicy == models.MisfirePolicySkip {
+			continue
+		}
+
+		missed, err := s.misfiredRuns(strategy, now)
+		if err != nil {
+			logger.Error("Failed to check missed strategy runs",
+				zap.String("strategy", strategy.Name),
+				zap.Error(err))
+			continue
+		}
+		if len(missed) == 0 {
+			continue
+		}
+
+		// run_once catches up the latest missed run only
+		runs := missed
+		if strategy.MisfirePolicy != models.MisfirePolicyRunAll {
+			runs = missed[len(missed)-1:]
+		}
+		logger.Warn("Catching up missed periodic strategy runs",
+			zap.String("strategy", strategy.Name),
+			zap.String("misfire_policy", strategy.MisfirePolicy),
+			zap.Int("missed", len(missed)),
+			zap.Int("runs", len(runs)))
+
+		if users == nil {
+			if users, err = s.loadUsers(); err != nil {
+				logger.Error("Failed to load users for misfire catch-up", zap.Error(err))
+				return
+			}
+		}
+		// Each run is recorded under the batch number of its missed fire time
+		for _, fire := range runs {
+			s.execStrategyBatch(strategy, users, models.StrategyRunTriggerMisfire, batchNumberAt(fire))
+		}
+	}
+}
+
+// misfiredRuns lists the fire times of a periodic strategy after its last completed run, or after
+// its creation if it never ran, up to now. Fire times before the strategy was last enabled were not
+// missed. At most the latest maxMisfiredRuns are listed
+func (s *StrategyService) misfiredRuns(strategy *models.QuotaStrategy, now time.Time) ([]time.Time, error) {
+	schedule, err := cronParser.Parse(strategy.PeriodicExpr)
+	if err != nil {
+		return nil, err
+	}
+
+	since := strategy.CreateTime
+	var lastRun models.StrategyRun
+	err = s.db.Where("strategy_id = ? AND trigger IN ? AND status = ?", strategy.ID,
+		[]string{models.StrategyRunTriggerCron, models.StrategyRunTriggerMisfire}, models.StrategyRunStatusCompleted).
+		Order("start_time DESC").
+		First(&lastRun).Error
+	switch {
+	case err == nil:
+		since = lastRun.StartTime
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, err
+	}
+
+	enabled, err := s.lastEnabledTime(strategy.ID)
+	if err != nil {
+		return nil, err
+	}
+	if enabled.After(since) {
+		since = enabled
+	}
+
+	var missed []time.Time
+	for fire := schedule.Next(since); !fire.IsZero() && !fire.After(now); fire = schedule.Next(fire) {
+		missed = append(missed, fire)
+		if len(missed) > maxMisfiredRuns {
+			missed = missed[1:]
+		}
+	}
+	return missed, nil
+}
+
+// lastEnabledTime returns when a strategy was last enabled according to its versions, zero when
+// no version enabled it, like for strategies created enabled
+func (s *StrategyService) lastEnabledTime(strategyID int) (time.Time, error) {
+	var versions []models.StrategyVersion
+	if err := s.db.Where("strategy_id = ? AND changes LIKE ?", strategyID, `%"field":"status"%`).
+		Order("version DESC").
+		Find(&versions).Error; err != nil {
+		return time.Time{}, err
+	}
+	for i := range versions {
+		changes, err := versions[i].UnmarshalChanges()
+		if err != nil {
+			return time.Time{}, err
+		}
+		for _, change := range changes {
+			if change.Field == "status" && change.New == true {
+				return versions[i].CreateTime, nil
+			}
+		}
+	}
+	return time.Time{}, nil
+}
@@ -0,0 +1,162 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"quota-manager/internal/condition"
+	"quota-manager/internal/models"
+	"quota-manager/internal/utils"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// defaultPreviewSampleSize is used when a preview request does not set sample_size
+	defaultPreviewSampleSize = 20
+	// maxPreviewErrors bounds the evaluation errors returned by a preview
+	maxPreviewErrors = 100
+)
+
+// PreviewStrategyRequest represents a strategy dry run, either of an existing strategy or of a definition
+type PreviewStrategyRequest struct {
+	StrategyID *int                  `json:"strategy_id" validate:"omitempty,min=1"`
+	Strategy   *models.QuotaStrategy `json:"strategy"`
+	SampleSize int                   `json:"sample_size" validate:"omitempty,min=1,max=100"`
+}
+
+// StrategyPreviewError is a condition evaluation error for one user
+type StrategyPreviewError struct {
+	UserID string `json:"user_id"`
+	Error  string `json:"error"`
+}
+
+// StrategyPreviewSample is a sampled matching user with the amount it would be granted
+type StrategyPreviewSample struct {
+	UserID string  `json:"user_id"`
+	Amount float64 `json:"amount"`
+}
+
+// StrategyPreviewResult describes what executing a strategy now would do
+type StrategyPreviewResult struct {
+	StrategyID       int                     `json:"strategy_id,omitempty"`
+	EvaluatedUsers   int                     `json:"evaluated_users"`
+	MatchedUsers     int                     `json:"matched_users"`
+	SkippedExecuted  int                     `json:"skipped_executed"`  // Single strategies already executed for the user
+	SkippedMaxExec   int                     `json:"skipped_max_exec"`  // Periodic strategies that reached max_exec_per_user
+	SkippedExclusion int                     `json:"skipped_exclusion"` // Users granted by another strategy of the exclusion group in the period
+	TotalAmount      float64                 `json:"total_amount"`
+	ExpiryDate       time.Time               `json:"expiry_date"`
+	SampleUserIDs    []string                `json:"sample_user_ids"`
+	Samples          []StrategyPreviewSample `json:"samples"` // Sampled users with their computed amounts
+	ErrorCount       int                     `json:"error_count"`
+	Errors           []StrategyPreviewError  `json:"errors"` // At most 100 errors are returned
+}
+
+// PreviewStrategy evaluates a strategy against all users with the same checks as ExecStrategy
+// without recording executions or granting quota
+func (s *StrategyService) PreviewStrategy(req *PreviewStrategyRequest) (*StrategyPreviewResult, error) {
+	if (req.StrategyID == nil) == (req.Strategy == nil) {
+		return nil, NewValidationFailedError("exactly one of strategy_id and strategy is required")
+	}
+
+	strategy := req.Strategy
+	if req.StrategyID != nil {
+		var stored models.QuotaStrategy
+		if err := s.db.First(&stored, *req.StrategyID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, NewResourceNotFoundError("strategy", strconv.Itoa(*req.StrategyID))
+			}
+			return nil, NewDatabaseError("get strategy", err)
+		}
+		strategy = &stored
+	}
+
+	// An empty condition is reported as an evaluation error for every user, like ExecStrategy does
+	evaluator, parseErr := condition.ParseCondition(strategy.Condition)
+	if parseErr != nil && strategy.Condition != "" {
+		return nil, NewValidationFailedError("invalid condition expression: " + parseErr.Error())
+	}
+	amount := parseStrategyAmount(strategy)
+	if amount.err != nil {
+		return nil, NewValidationFailedError("invalid amount expression: " + amount.err.Error())
+	}
+
+	now := utils.NowInConfigTimezone(s.quotaService.GetConfigManager().GetDirect()).Truncate(time.Second)
+	if err := strategy.ValidateExpiryPolicy(now); err != nil {
+		return nil, NewValidationFailedError("invalid expiry policy: " + err.Error())
+	}
+
+	sampleSize := req.SampleSize
+	if sampleSize <= 0 {
+		sampleSize = defaultPreviewSampleSize
+	}
+
+	users, err := s.loadUsers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load users: %w", err)
+	}
+	executions, err := s.loadUserExecutions(strategy, s.generateBatchNumber())
+	if err != nil {
+		return nil, err
+	}
+	exclusionGrants, err := s.loadExclusionGrants(strategy, exclusionPeriodStart(strategy, time.Now()))
+	if err != nil {
+		return nil, NewDatabaseError("load exclusion group grants", err)
+	}
+
+	result := &StrategyPreviewResult{
+		StrategyID:    strategy.ID,
+		ExpiryDate:    strategy.ExpiryDateAt(now),
+		SampleUserIDs: make([]string, 0, sampleSize),
+		Samples:       make([]StrategyPreviewSample, 0, sampleSize),
+		Errors:        make([]StrategyPreviewError, 0),
+	}
+	ctx := s.newEvaluationContext()
+
+	for _, user := range users {
+		switch skipReason(strategy, executions[user.ID]) {
+		case skipReasonExecuted:
+			result.SkippedExecuted++
+			continue
+		case skipReasonMaxExec:
+			result.SkippedMaxExec++
+			continue
+		}
+		if _, exists := exclusionGrants[user.ID]; exists {
+			result.SkippedExclusion++
+			continue
+		}
+
+		result.EvaluatedUsers++
+		match, err := evaluateCondition(evaluator, parseErr, &user, ctx)
+		var value float64
+		if err == nil && match {
+			value, err = amount.forUser(strategy, &user, ctx)
+		}
+		if err != nil {
+			result.ErrorCount++
+			if len(result.Errors) < maxPreviewErrors {
+				result.Errors = append(result.Errors, StrategyPreviewError{UserID: user.ID, Error: err.Error()})
+			}
+			continue
+		}
+		if !match {
+			continue
+		}
+
+		result.MatchedUsers++
+		if value <= 0 {
+			continue
+		}
+		result.TotalAmount += value
+		if len(result.SampleUserIDs) < sampleSize {
+			result.SampleUserIDs = append(result.SampleUserIDs, user.ID)
+			result.Samples = append(result.Samples, StrategyPreviewSample{UserID: user.ID, Amount: value})
+		}
+	}
+
+	return result, nil
+}
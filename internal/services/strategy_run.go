@@ -0,0 +1,134 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"quota-manager/internal/models"
+	"quota-manager/pkg/logger"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// startStrategyRun records the start of a strategy run. Executions of the same strategy within
+// one second share a batch number and are counted in the same run. A failure to record the run
+// is logged and does not stop the execution, nil is returned instead
+func (s *StrategyService) startStrategyRun(strategy *models.QuotaStrategy, batchNumber, trigger string) *models.StrategyRun {
+	run := &models.StrategyRun{
+		StrategyID:   strategy.ID,
+		StrategyName: strategy.Name,
+		BatchNumber:  batchNumber,
+		Trigger:      trigger,
+		Status:       models.StrategyRunStatusRunning,
+		StartTime:    time.Now().Truncate(time.Second),
+	}
+
+	err := s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "strategy_id"}, {Name: "batch_number"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"status": models.StrategyRunStatusRunning, "end_time": nil}),
+	}).Create(run).Error
+	if err == nil {
+		err = s.db.Where("strategy_id = ? AND batch_number = ?", strategy.ID, batchNumber).First(run).Error
+	}
+	if err != nil {
+		logger.Error("Failed to record strategy run",
+			zap.String("strategy", strategy.Name),
+			zap.String("batch_number", batchNumber),
+			zap.Error(err))
+		return nil
+	}
+	return run
+}
+
+// finishStrategyRun adds the statistics of an execution to its run and marks the run completed
+func (s *StrategyService) finishStrategyRun(run *models.StrategyRun, stats *models.StrategyRun) {
+	if run == nil {
+		return
+	}
+
+	if err := stats.MarshalConflicts(); err != nil {
+		logger.Error("Failed to record exclusion conflicts",
+			zap.String("strategy", run.StrategyName),
+			zap.Error(err))
+	}
+
+	if err := s.db.Model(&models.StrategyRun{}).Where("id = ?", run.ID).Updates(map[string]interface{}{
+		"status":              models.StrategyRunStatusCompleted,
+		"end_time":            time.Now().Truncate(time.Second),
+		"users_evaluated":     gorm.Expr("users_evaluated + ?", stats.UsersEvaluated),
+		"users_matched":       gorm.Expr("users_matched + ?", stats.UsersMatched),
+		"users_granted":       gorm.Expr("users_granted + ?", stats.UsersGranted),
+		"skipped_max_exec":    gorm.Expr("skipped_max_exec + ?", stats.SkippedMaxExec),
+		"skipped_budget":      gorm.Expr("skipped_budget + ?", stats.SkippedBudget),
+		"skipped_exclusion":   gorm.Expr("skipped_exclusion + ?", stats.SkippedExclusion),
+		"condition_errors":    gorm.Expr("condition_errors + ?", stats.ConditionErrors),
+		"recharge_failures":   gorm.Expr("recharge_failures + ?", stats.RechargeFailures),
+		"total_amount":        gorm.Expr("total_amount + ?", stats.TotalAmount),
+		"duration_ms":         stats.DurationMs,
+		"users_per_second":    stats.UsersPerSecond,
+		"exclusion_conflicts": stats.ExclusionConflicts,
+	}).Error; err != nil {
+		logger.Error("Failed to complete strategy run",
+			zap.String("strategy", run.StrategyName),
+			zap.String("batch_number", run.BatchNumber),
+			zap.Error(err))
+		return
+	}
+
+	logger.Info("Strategy run completed",
+		zap.String("strategy", run.StrategyName),
+		zap.String("batch_number", run.BatchNumber),
+		zap.String("trigger", run.Trigger),
+		zap.Int("users_evaluated", stats.UsersEvaluated),
+		zap.Int("users_granted", stats.UsersGranted),
+		zap.Int("condition_errors", stats.ConditionErrors),
+		zap.Int("recharge_failures", stats.RechargeFailures),
+		zap.Int("skipped_exclusion", stats.SkippedExclusion),
+		zap.Float64("total_amount", stats.TotalAmount),
+		zap.Int64("duration_ms", stats.DurationMs),
+		zap.Float64("users_per_second", stats.UsersPerSecond))
+}
+
+// GetStrategyRuns gets the runs of a strategy, most recent first
+func (s *StrategyService) GetStrategyRuns(strategyID int, page, pageSize int) ([]models.StrategyRun, int64, error) {
+	var runs []models.StrategyRun
+	var total int64
+
+	if err := s.db.Model(&models.StrategyRun{}).Where("strategy_id = ?", strategyID).Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count strategy runs: %w", err)
+	}
+
+	offset := (page - 1) * pageSize
+	if err := s.db.Where("strategy_id = ?", strategyID).
+		Order("start_time DESC, id DESC").
+		Offset(offset).
+		Limit(pageSize).
+		Find(&runs).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to query strategy runs: %w", err)
+	}
+	for i := range runs {
+		if err := runs[i].UnmarshalConflicts(); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return runs, total, nil
+}
+
+// GetStrategyRun gets the run of a strategy with the given batch number
+func (s *StrategyService) GetStrategyRun(strategyID int, batchNumber string) (*models.StrategyRun, error) {
+	var run models.StrategyRun
+	if err := s.db.Where("strategy_id = ? AND batch_number = ?", strategyID, batchNumber).First(&run).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, NewResourceNotFoundError("strategy run", batchNumber)
+		}
+		return nil, NewDatabaseError("get strategy run", err)
+	}
+	if err := run.UnmarshalConflicts(); err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
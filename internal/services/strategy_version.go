@@ -0,0 +1,221 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"quota-manager/internal/models"
+	"quota-manager/pkg/logger"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// StrategyVersionRecord represents a strategy version with its definition and changes parsed
+type StrategyVersionRecord struct {
+	Version       int                          `json:"version"`
+	Action        string                       `json:"action"`
+	SourceVersion *int                         `json:"source_version,omitempty"`
+	Author        string                       `json:"author,omitempty"`
+	Definition    models.StrategyDefinition    `json:"definition"`
+	Changes       []models.StrategyFieldChange `json:"changes"` // Against the previous version
+	CreateTime    time.Time                    `json:"create_time"`
+}
+
+// StrategyVersionDiff lists the fields that differ between two versions of a strategy
+type StrategyVersionDiff struct {
+	StrategyID  int                          `json:"strategy_id"`
+	FromVersion int                          `json:"from_version"`
+	ToVersion   int                          `json:"to_version"`
+	Changes     []models.StrategyFieldChange `json:"changes"`
+}
+
+// recordStrategyVersion saves the definition of a strategy as its current version, with the
+// changes against the previous definition if there is one
+func recordStrategyVersion(tx *gorm.DB, strategy *models.QuotaStrategy, previous *models.StrategyDefinition, action string, sourceVersion *int) error {
+	version := &models.StrategyVersion{
+		StrategyID:    strategy.ID,
+		Version:       strategy.Version,
+		Action:        action,
+		SourceVersion: sourceVersion,
+		Author:        strategy.UpdatedBy,
+	}
+	definition := strategy.Definition()
+	if err := version.MarshalDefinition(definition); err != nil {
+		return err
+	}
+	if previous != nil {
+		changes, err := models.DiffStrategyDefinitions(*previous, definition)
+		if err != nil {
+			return err
+		}
+		if err := version.MarshalChanges(changes); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Create(version).Error; err != nil {
+		return fmt.Errorf("failed to record strategy version: %w", err)
+	}
+	return nil
+}
+
+// ensureStrategyVersion records the current definition of a strategy created before versioning
+func ensureStrategyVersion(tx *gorm.DB, strategy *models.QuotaStrategy) error {
+	var count int64
+	if err := tx.Model(&models.StrategyVersion{}).
+		Where("strategy_id = ? AND version = ?", strategy.ID, strategy.Version).
+		Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to check strategy version: %w", err)
+	}
+	if count > 0 {
+		return nil
+	}
+	return recordStrategyVersion(tx, strategy, nil, models.StrategyVersionActionCreate, nil)
+}
+
+// GetStrategyVersions gets the versions of a strategy, most recent first
+func (s *StrategyService) GetStrategyVersions(strategyID int, page, pageSize int) ([]StrategyVersionRecord, int64, error) {
+	if _, err := s.getStrategyOrNotFound(strategyID); err != nil {
+		return nil, 0, err
+	}
+
+	var total int64
+	if err := s.db.Model(&models.StrategyVersion{}).Where("strategy_id = ?", strategyID).Count(&total).Error; err != nil {
+		return nil, 0, NewDatabaseError("count strategy versions", err)
+	}
+
+	var versions []models.StrategyVersion
+	offset := (page - 1) * pageSize
+	if err := s.db.Where("strategy_id = ?", strategyID).
+		Order("version DESC").
+		Offset(offset).
+		Limit(pageSize).
+		Find(&versions).Error; err != nil {
+		return nil, 0, NewDatabaseError("query strategy versions", err)
+	}
+
+	records := make([]StrategyVersionRecord, 0, len(versions))
+	for i := range versions {
+		definition, err := versions[i].UnmarshalDefinition()
+		if err != nil {
+			return nil, 0, NewDatabaseError("parse strategy version", err)
+		}
+		changes, err := versions[i].UnmarshalChanges()
+		if err != nil {
+			return nil, 0, NewDatabaseError("parse strategy version", err)
+		}
+		records = append(records, StrategyVersionRecord{
+			Version:       versions[i].Version,
+			Action:        versions[i].Action,
+			SourceVersion: versions[i].SourceVersion,
+			Author:        versions[i].Author,
+			Definition:    definition,
+			Changes:       changes,
+			CreateTime:    versions[i].CreateTime,
+		})
+	}
+	return records, total, nil
+}
+
+// DiffStrategyVersions lists the fields changed from one version of a strategy to another
+func (s *StrategyService) DiffStrategyVersions(strategyID, fromVersion, toVersion int) (*StrategyVersionDiff, error) {
+	from, err := s.getStrategyVersionDefinition(strategyID, fromVersion)
+	if err != nil {
+		return nil, err
+	}
+	to, err := s.getStrategyVersionDefinition(strategyID, toVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := models.DiffStrategyDefinitions(from, to)
+	if err != nil {
+		return nil, NewDatabaseError("diff strategy versions", err)
+	}
+	return &StrategyVersionDiff{
+		StrategyID:  strategyID,
+		FromVersion: fromVersion,
+		ToVersion:   toVersion,
+		Changes:     changes,
+	}, nil
+}
+
+// RollbackStrategy restores the definition of a previous version as a new version of the strategy,
+// periodic strategies are re-registered to cron with the restored expression. The status is kept,
+// a rollback neither enables nor disables the strategy
+func (s *StrategyService) RollbackStrategy(strategyID, version int, author string) (*models.QuotaStrategy, error) {
+	strategy, err := s.getStrategyOrNotFound(strategyID)
+	if err != nil {
+		return nil, err
+	}
+	definition, err := s.getStrategyVersionDefinition(strategyID, version)
+	if err != nil {
+		return nil, err
+	}
+
+	updates := definition.Updates()
+	delete(updates, "status")
+
+	// The restored definition must still be valid, e.g. a restored fixed expiry date may have passed
+	restored := applyValidatedUpdates(*strategy, updates)
+	if err := validateExpiryPolicyUpdate(strategy, &restored); err != nil {
+		return nil, NewValidationFailedError(fmt.Sprintf("version %d cannot be restored: %v", version, err))
+	}
+	if err := restored.ValidateWindow(); err != nil {
+		return nil, NewValidationFailedError(fmt.Sprintf("version %d cannot be restored: %v", version, err))
+	}
+	if err := restored.ValidateTriggerEvent(); err != nil {
+		return nil, NewValidationFailedError(fmt.Sprintf("version %d cannot be restored: %v", version, err))
+	}
+	if err := validateAmountExpr(restored.AmountExpr); err != nil {
+		return nil, NewValidationFailedError(fmt.Sprintf("version %d cannot be restored: %v", version, err))
+	}
+
+	updates["updated_by"] = author
+	if err := s.updateStrategy(strategyID, updates, models.StrategyVersionActionRollback, &version); err != nil {
+		var serviceErr *ServiceError
+		if errors.As(err, &serviceErr) {
+			return nil, err
+		}
+		return nil, NewDatabaseError("roll back strategy", err)
+	}
+
+	logger.Info("Strategy rolled back",
+		zap.Int("strategy_id", strategyID),
+		zap.Int("restored_version", version),
+		zap.String("author", author))
+
+	return s.GetStrategy(strategyID)
+}
+
+// getStrategyOrNotFound gets a strategy, reporting a missing one as ErrorResourceNotFound
+func (s *StrategyService) getStrategyOrNotFound(strategyID int) (*models.QuotaStrategy, error) {
+	var strategy models.QuotaStrategy
+	if err := s.db.First(&strategy, strategyID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, NewResourceNotFoundError("strategy", strconv.Itoa(strategyID))
+		}
+		return nil, NewDatabaseError("get strategy", err)
+	}
+	return &strategy, nil
+}
+
+// getStrategyVersionDefinition gets the definition of a strategy version
+func (s *StrategyService) getStrategyVersionDefinition(strategyID, version int) (models.StrategyDefinition, error) {
+	var record models.StrategyVersion
+	if err := s.db.Where("strategy_id = ? AND version = ?", strategyID, version).First(&record).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.StrategyDefinition{}, NewResourceNotFoundError("strategy version", strconv.Itoa(version))
+		}
+		return models.StrategyDefinition{}, NewDatabaseError("get strategy version", err)
+	}
+
+	definition, err := record.UnmarshalDefinition()
+	if err != nil {
+		return models.StrategyDefinition{}, NewDatabaseError("parse strategy version", err)
+	}
+	return definition, nil
+}
@@ -59,33 +59,123 @@ CREATE TABLE IF NOT EXISTS quota_strategy (
     title VARCHAR(255) NOT NULL,
     type VARCHAR(50) NOT NULL,
     amount DECIMAL(10,2) NOT NULL,
+    amount_expr TEXT NOT NULL DEFAULT '',  -- Computes the amount per user instead of amount when set
     model VARCHAR(255),
     periodic_expr VARCHAR(255),
     condition TEXT,
+    trigger_event VARCHAR(30) NOT NULL DEFAULT '' CHECK (trigger_event IN ('', 'user_registered', 'github_star_added', 'employee_joined', 'department_changed')),  -- Evaluated per user on the event instead of by scans
     max_exec_per_user INTEGER NOT NULL DEFAULT 0,
+    priority INTEGER NOT NULL DEFAULT 0,  -- Higher priority strategies of an exclusion group are executed first
+    exclusion_group VARCHAR(100) NOT NULL DEFAULT '',  -- A user gets at most one grant per group and period
+    misfire_policy VARCHAR(20) NOT NULL DEFAULT 'skip' CHECK (misfire_policy IN ('skip', 'run_once', 'run_all')),  -- Catch-up of periodic runs missed while the service was down
+    expiry_policy VARCHAR(30) NOT NULL DEFAULT 'end_of_month' CHECK (expiry_policy IN ('end_of_month', 'end_of_next_month', 'relative', 'fixed_date', 'never')),
+    expiry_days INTEGER NOT NULL DEFAULT 0,
+    expiry_hours INTEGER NOT NULL DEFAULT 0,
+    fixed_expiry_date TIMESTAMPTZ(0),
+    start_time TIMESTAMPTZ(0),  -- Nothing is granted before this time
+    end_time TIMESTAMPTZ(0),  -- Nothing is granted from this time on
+    total_budget DECIMAL(14,2) NOT NULL DEFAULT 0 CHECK (total_budget >= 0),  -- 0 for unlimited
+    used_budget DECIMAL(14,2) NOT NULL DEFAULT 0,
     status BOOLEAN DEFAULT true NOT NULL,  -- Status field: true=enabled, false=disabled
+    version INTEGER NOT NULL DEFAULT 1,  -- Current version, see strategy_version
+    updated_by VARCHAR(255),  -- Author of the current version
     create_time TIMESTAMPTZ(0) DEFAULT CURRENT_TIMESTAMP,
     update_time TIMESTAMPTZ(0) DEFAULT CURRENT_TIMESTAMP
 );
 
+-- Upgrade existing quota_strategy tables
+ALTER TABLE quota_strategy ADD COLUMN IF NOT EXISTS amount_expr TEXT NOT NULL DEFAULT '';
+ALTER TABLE quota_strategy ADD COLUMN IF NOT EXISTS trigger_event VARCHAR(30) NOT NULL DEFAULT '' CHECK (trigger_event IN ('', 'user_registered', 'github_star_added', 'employee_joined', 'department_changed'));
+ALTER TABLE quota_strategy ADD COLUMN IF NOT EXISTS priority INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE quota_strategy ADD COLUMN IF NOT EXISTS exclusion_group VARCHAR(100) NOT NULL DEFAULT '';
+ALTER TABLE quota_strategy ADD COLUMN IF NOT EXISTS misfire_policy VARCHAR(20) NOT NULL DEFAULT 'skip' CHECK (misfire_policy IN ('skip', 'run_once', 'run_all'));
+ALTER TABLE quota_strategy ADD COLUMN IF NOT EXISTS expiry_policy VARCHAR(30) NOT NULL DEFAULT 'end_of_month' CHECK (expiry_policy IN ('end_of_month', 'end_of_next_month', 'relative', 'fixed_date', 'never'));
+ALTER TABLE quota_strategy ADD COLUMN IF NOT EXISTS expiry_days INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE quota_strategy ADD COLUMN IF NOT EXISTS expiry_hours INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE quota_strategy ADD COLUMN IF NOT EXISTS fixed_expiry_date TIMESTAMPTZ(0);
+ALTER TABLE quota_strategy ADD COLUMN IF NOT EXISTS start_time TIMESTAMPTZ(0);
+ALTER TABLE quota_strategy ADD COLUMN IF NOT EXISTS end_time TIMESTAMPTZ(0);
+ALTER TABLE quota_strategy ADD COLUMN IF NOT EXISTS total_budget DECIMAL(14,2) NOT NULL DEFAULT 0 CHECK (total_budget >= 0);
+ALTER TABLE quota_strategy ADD COLUMN IF NOT EXISTS used_budget DECIMAL(14,2) NOT NULL DEFAULT 0;
+ALTER TABLE quota_strategy ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE quota_strategy ADD COLUMN IF NOT EXISTS updated_by VARCHAR(255);
+
 -- Quota execution status table
 CREATE TABLE IF NOT EXISTS quota_execute (
     id SERIAL PRIMARY KEY,
     strategy_id INTEGER NOT NULL,
+    strategy_version INTEGER NOT NULL DEFAULT 0,  -- Strategy version that granted the quota, 0 before versioning
+    amount DECIMAL(10,2) NOT NULL DEFAULT 0,  -- Amount granted to the user, 0 before amount expressions
     user_id VARCHAR(255) NOT NULL,
     batch_number VARCHAR(20) NOT NULL,
     status VARCHAR(50) NOT NULL,
     expiry_date TIMESTAMPTZ(0) NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_retry_time TIMESTAMPTZ(0),
+    last_error TEXT,
     create_time TIMESTAMPTZ(0) DEFAULT CURRENT_TIMESTAMP,
     update_time TIMESTAMPTZ(0) DEFAULT CURRENT_TIMESTAMP,
     FOREIGN KEY (strategy_id) REFERENCES quota_strategy(id)
 );
 
+-- Upgrade existing quota_execute tables
+ALTER TABLE quota_execute ADD COLUMN IF NOT EXISTS strategy_version INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE quota_execute ADD COLUMN IF NOT EXISTS amount DECIMAL(10,2) NOT NULL DEFAULT 0;
+ALTER TABLE quota_execute ADD COLUMN IF NOT EXISTS attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE quota_execute ADD COLUMN IF NOT EXISTS next_retry_time TIMESTAMPTZ(0);
+ALTER TABLE quota_execute ADD COLUMN IF NOT EXISTS last_error TEXT;
+
 -- Create indexes for quota_execute table
 CREATE INDEX IF NOT EXISTS idx_quota_execute_strategy_id ON quota_execute(strategy_id);
 CREATE INDEX IF NOT EXISTS idx_quota_execute_user_id ON quota_execute(user_id);
 CREATE INDEX IF NOT EXISTS idx_quota_execute_batch_number ON quota_execute(batch_number);
 CREATE INDEX IF NOT EXISTS idx_quota_execute_sid_uid_status ON quota_execute(strategy_id, user_id, status);
+CREATE INDEX IF NOT EXISTS idx_quota_execute_status_update_time ON quota_execute(status, update_time);
+
+-- Strategy run table, one row per execution of a strategy
+CREATE TABLE IF NOT EXISTS strategy_run (
+    id SERIAL PRIMARY KEY,
+    strategy_id INTEGER NOT NULL,
+    strategy_name VARCHAR(100) NOT NULL,
+    batch_number VARCHAR(20) NOT NULL,
+    trigger VARCHAR(20) NOT NULL CHECK (trigger IN ('cron', 'scan', 'manual', 'event', 'misfire')),
+    status VARCHAR(20) NOT NULL DEFAULT 'RUNNING' CHECK (status IN ('RUNNING', 'COMPLETED')),
+    start_time TIMESTAMPTZ(0) NOT NULL,
+    end_time TIMESTAMPTZ(0),
+    users_evaluated INTEGER NOT NULL DEFAULT 0,
+    users_matched INTEGER NOT NULL DEFAULT 0,
+    users_granted INTEGER NOT NULL DEFAULT 0,
+    skipped_max_exec INTEGER NOT NULL DEFAULT 0,
+    skipped_budget INTEGER NOT NULL DEFAULT 0,
+    skipped_exclusion INTEGER NOT NULL DEFAULT 0,  -- Users granted by another strategy of the exclusion group
+    condition_errors INTEGER NOT NULL DEFAULT 0,
+    recharge_failures INTEGER NOT NULL DEFAULT 0,
+    total_amount DECIMAL(14,2) NOT NULL DEFAULT 0,
+    duration_ms BIGINT NOT NULL DEFAULT 0,
+    users_per_second DECIMAL(12,2) NOT NULL DEFAULT 0,
+    exclusion_conflicts TEXT,  -- JSON of the exclusion conflicts of the latest execution
+    create_time TIMESTAMPTZ(0) DEFAULT CURRENT_TIMESTAMP,
+    update_time TIMESTAMPTZ(0) DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (strategy_id) REFERENCES quota_strategy(id)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_strategy_run_batch ON strategy_run(strategy_id, batch_number);
+
+-- Strategy version history, one row per change of a strategy's definition
+CREATE TABLE IF NOT EXISTS strategy_version (
+    id SERIAL PRIMARY KEY,
+    strategy_id INTEGER NOT NULL,
+    version INTEGER NOT NULL,
+    action VARCHAR(20) NOT NULL CHECK (action IN ('create', 'update', 'rollback')),
+    source_version INTEGER,  -- Version restored by a rollback
+    author VARCHAR(255),
+    definition TEXT NOT NULL,  -- JSON definition of the version
+    changes TEXT,  -- JSON changes against the previous version
+    create_time TIMESTAMPTZ(0) DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (strategy_id) REFERENCES quota_strategy(id)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_strategy_version ON strategy_version(strategy_id, version);
 
 -- Add index for strategy status field to improve query performance
 CREATE INDEX IF NOT EXISTS idx_quota_strategy_status ON quota_strategy(status);
@@ -94,6 +184,7 @@ CREATE INDEX IF NOT EXISTS idx_quota_strategy_status ON quota_strategy(status);
 CREATE TABLE IF NOT EXISTS quota (
     id SERIAL PRIMARY KEY,
     user_id VARCHAR(255) NOT NULL,
+    model VARCHAR(100) NOT NULL DEFAULT '',
     amount DECIMAL(10,2) NOT NULL,
     expiry_date TIMESTAMPTZ(0) NOT NULL,
     status VARCHAR(20) DEFAULT 'VALID' NOT NULL,
@@ -101,9 +192,13 @@ CREATE TABLE IF NOT EXISTS quota (
     update_time TIMESTAMPTZ(0) DEFAULT CURRENT_TIMESTAMP
 );
 
+-- Upgrade existing quota tables, rows of earlier versions belong to the general pool
+ALTER TABLE quota ADD COLUMN IF NOT EXISTS model VARCHAR(100) NOT NULL DEFAULT '';
+
 CREATE INDEX IF NOT EXISTS idx_quota_user_id ON quota(user_id);
 CREATE INDEX IF NOT EXISTS idx_quota_expiry_date ON quota(expiry_date);
 CREATE INDEX IF NOT EXISTS idx_quota_status ON quota(status);
+CREATE INDEX IF NOT EXISTS idx_quota_status_expiry_user ON quota(status, expiry_date, user_id);
 
 -- Quota audit table
 CREATE TABLE IF NOT EXISTS quota_audit (
@@ -115,15 +210,25 @@ CREATE TABLE IF NOT EXISTS quota_audit (
     related_user VARCHAR(255),
     strategy_id INTEGER,
     strategy_name VARCHAR(100),
+    strategy_version INTEGER DEFAULT 0,
+    api_key_id INTEGER,
+    operator VARCHAR(255),
     expiry_date TIMESTAMPTZ(0) NOT NULL,
     details TEXT,
     create_time TIMESTAMPTZ(0) DEFAULT CURRENT_TIMESTAMP
 );
 
+-- Upgrade existing quota_audit tables
+ALTER TABLE quota_audit ADD COLUMN IF NOT EXISTS strategy_version INTEGER DEFAULT 0;
+ALTER TABLE quota_audit ADD COLUMN IF NOT EXISTS api_key_id INTEGER;
+ALTER TABLE quota_audit ADD COLUMN IF NOT EXISTS operator VARCHAR(255);
+
 CREATE INDEX IF NOT EXISTS idx_quota_audit_user_id ON quota_audit(user_id);
 CREATE INDEX IF NOT EXISTS idx_quota_audit_operation ON quota_audit(operation);
 CREATE INDEX IF NOT EXISTS idx_quota_audit_strategy_name ON quota_audit(strategy_name);
 CREATE INDEX IF NOT EXISTS idx_quota_audit_create_time ON quota_audit(create_time);
+CREATE INDEX IF NOT EXISTS idx_quota_audit_api_key_id ON quota_audit(api_key_id);
+CREATE INDEX IF NOT EXISTS idx_quota_audit_operator ON quota_audit(operator);
 
 -- Voucher redemption table
 CREATE TABLE IF NOT EXISTS voucher_redemption (
@@ -133,8 +238,47 @@ CREATE TABLE IF NOT EXISTS voucher_redemption (
     create_time TIMESTAMPTZ(0) DEFAULT CURRENT_TIMESTAMP
 );
 
--- Create unique index to enforce one record per user per expiry date per status
-CREATE UNIQUE INDEX IF NOT EXISTS idx_quota_user_expiry_status ON quota(user_id, expiry_date, status);
+-- Deduction idempotency table, one row per processed reference ID
+CREATE TABLE IF NOT EXISTS quota_deduction (
+    id SERIAL PRIMARY KEY,
+    reference_id VARCHAR(255) UNIQUE NOT NULL,
+    user_id VARCHAR(255) NOT NULL,
+    amount DECIMAL(10,2) NOT NULL,
+    reason VARCHAR(100),
+    model VARCHAR(100),
+    remaining_quota DECIMAL(10,2) NOT NULL DEFAULT 0,
+    audit_id INTEGER,
+    api_key_id INTEGER,
+    create_time TIMESTAMPTZ(0) DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_quota_deduction_user_id ON quota_deduction(user_id);
+
+-- Quota reservation table, held quota is taken out of the quota rows until committed or released
+CREATE TABLE IF NOT EXISTS quota_reservation (
+    id SERIAL PRIMARY KEY,
+    reference_id VARCHAR(255) UNIQUE NOT NULL,
+    user_id VARCHAR(255) NOT NULL,
+    amount DECIMAL(10,2) NOT NULL,
+    committed_amount DECIMAL(10,2) NOT NULL DEFAULT 0,
+    status VARCHAR(20) NOT NULL DEFAULT 'HELD' CHECK (status IN ('HELD', 'COMMITTED', 'RELEASED', 'EXPIRED')),
+    reason VARCHAR(100),
+    model VARCHAR(100),
+    items TEXT,
+    expires_at TIMESTAMPTZ(0) NOT NULL,
+    settled_at TIMESTAMPTZ(0),
+    api_key_id INTEGER,
+    create_time TIMESTAMPTZ(0) DEFAULT CURRENT_TIMESTAMP,
+    update_time TIMESTAMPTZ(0) DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_quota_reservation_user_id ON quota_reservation(user_id);
+CREATE INDEX IF NOT EXISTS idx_quota_reservation_status_expires ON quota_reservation(status, expires_at);
+
+-- Create unique index to enforce one record per user per model pool per expiry date per status,
+-- replacing the index without the model that kept model pool rows from sharing an expiry date with general ones
+DROP INDEX IF EXISTS idx_quota_user_expiry_status;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_quota_user_model_expiry_status ON quota(user_id, model, expiry_date, status);
 
 -- Employee department mapping table
 CREATE TABLE IF NOT EXISTS employee_department (
@@ -288,3 +432,80 @@ COMMENT ON COLUMN monthly_quota_usage.year_month IS 'Year and month identifier,
 COMMENT ON COLUMN monthly_quota_usage.used_quota IS 'Used quota amount';
 COMMENT ON COLUMN monthly_quota_usage.record_time IS 'Record time';
 COMMENT ON COLUMN monthly_quota_usage.create_time IS 'Create time';
+
+-- Quota expiry run table, progress of a quota expiry pass
+CREATE TABLE IF NOT EXISTS quota_expiry_run (
+    id SERIAL PRIMARY KEY,
+    cutoff TIMESTAMPTZ(0) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'RUNNING' CHECK (status IN ('RUNNING', 'COMPLETED')),
+    last_user_id VARCHAR(255) NOT NULL DEFAULT '',
+    pending_user_id VARCHAR(255) NOT NULL DEFAULT '',
+    pending_used_delta DECIMAL(10,2) NOT NULL DEFAULT 0,
+    pending_quota_delta DECIMAL(10,2) NOT NULL DEFAULT 0,
+    processed_users INTEGER NOT NULL DEFAULT 0,
+    failed_users INTEGER NOT NULL DEFAULT 0,
+    expired_amount DECIMAL(12,2) NOT NULL DEFAULT 0,
+    finish_time TIMESTAMPTZ(0),
+    create_time TIMESTAMPTZ(0) DEFAULT CURRENT_TIMESTAMP,
+    update_time TIMESTAMPTZ(0) DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_quota_expiry_run_status ON quota_expiry_run(status);
+
+-- Scheduled job state shared by all instances, pauses and the last outcome
+CREATE TABLE IF NOT EXISTS scheduler_job (
+    name VARCHAR(150) PRIMARY KEY,
+    paused BOOLEAN NOT NULL DEFAULT false,
+    paused_by VARCHAR(255),
+    last_start_time TIMESTAMPTZ(0),
+    last_end_time TIMESTAMPTZ(0),
+    last_outcome VARCHAR(20) NOT NULL DEFAULT '' CHECK (last_outcome IN ('', 'succeeded', 'failed')),
+    last_error TEXT,
+    last_instance VARCHAR(255),
+    create_time TIMESTAMPTZ(0) DEFAULT CURRENT_TIMESTAMP,
+    update_time TIMESTAMPTZ(0) DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Manually triggered background jobs table
+CREATE TABLE IF NOT EXISTS scan_job (
+    id SERIAL PRIMARY KEY,
+    type VARCHAR(30) NOT NULL,
+    status VARCHAR(20) NOT NULL CHECK (status IN ('running', 'succeeded', 'failed')),
+    total INTEGER NOT NULL DEFAULT 0, -- Items to process, 0 while unknown
+    processed INTEGER NOT NULL DEFAULT 0,
+    failed INTEGER NOT NULL DEFAULT 0,
+    error TEXT,
+    triggered_by VARCHAR(255),
+    instance VARCHAR(255), -- Instance running the job
+    start_time TIMESTAMPTZ(0) NOT NULL,
+    end_time TIMESTAMPTZ(0),
+    create_time TIMESTAMPTZ(0) DEFAULT CURRENT_TIMESTAMP,
+    update_time TIMESTAMPTZ(0) DEFAULT CURRENT_TIMESTAMP -- Refreshed while the job runs
+);
+
+CREATE INDEX IF NOT EXISTS idx_scan_job_type_time ON scan_job(type, start_time);
+CREATE INDEX IF NOT EXISTS idx_scan_job_status ON scan_job(status);
+-- At most one running job of each type
+CREATE UNIQUE INDEX IF NOT EXISTS idx_scan_job_running ON scan_job(type) WHERE status = 'running';
+
+-- Service API keys table
+CREATE TABLE IF NOT EXISTS api_keys (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    key_prefix VARCHAR(20) NOT NULL,
+    key_hash VARCHAR(64) UNIQUE NOT NULL,
+    scopes TEXT NOT NULL,
+    expires_at TIMESTAMPTZ(0),
+    last_used_at TIMESTAMPTZ(0),
+    revoked_at TIMESTAMPTZ(0),
+    created_by VARCHAR(255),
+    create_time TIMESTAMPTZ(0) DEFAULT CURRENT_TIMESTAMP,
+    update_time TIMESTAMPTZ(0) DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_revoked_at ON api_keys(revoked_at);
+
+COMMENT ON TABLE api_keys IS 'Service API keys for machine callers';
+COMMENT ON COLUMN api_keys.key_prefix IS 'Leading characters of the key for identification';
+COMMENT ON COLUMN api_keys.key_hash IS 'SHA-256 hex digest of the key, the key itself is never stored';
+COMMENT ON COLUMN api_keys.scopes IS 'Comma-separated scopes, e.g. quota:read,quota:deduct';
@@ -2,20 +2,32 @@ package main
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"time"
 
+	"quota-manager/internal/auth"
 	"quota-manager/internal/config"
 	"quota-manager/internal/handlers"
 	"quota-manager/internal/models"
 	"quota-manager/internal/response"
+	"quota-manager/internal/services"
 
 	"github.com/gin-gonic/gin"
 )
 
+// testJWTSecret is the HS256 secret accepted by the API test router
+const testJWTSecret = "quota-manager-test-secret"
+
+// testAdminUserID is granted the admin role through the admin_users list
+const testAdminUserID = "test-admin-user"
+
 // APITestContext holds the HTTP test context
 type APITestContext struct {
 	*TestContext
@@ -31,8 +43,21 @@ func setupAPITestContext(ctx *TestContext) *APITestContext {
 
 	// Create handlers
 	strategyHandler := handlers.NewStrategyHandler(ctx.StrategyService)
-	serverConfig := &config.ServerConfig{TokenHeader: "authorization"}
-	quotaHandler := handlers.NewQuotaHandler(ctx.QuotaService, serverConfig)
+	serverConfig := &config.ServerConfig{
+		TokenHeader: "authorization",
+		Auth: config.AuthConfig{
+			HS256Secret: testJWTSecret,
+			Leeway:      time.Minute,
+			AdminUsers:  []string{testAdminUserID},
+		},
+	}
+	tokenVerifier, err := auth.NewVerifier(&serverConfig.Auth)
+	if err != nil {
+		panic(fmt.Sprintf("failed to create token verifier: %v", err))
+	}
+	quotaHandler := handlers.NewQuotaHandler(ctx.QuotaService, serverConfig, tokenVerifier)
+	apiKeyService := services.NewAPIKeyService(ctx.DB)
+	authorizer := auth.NewAuthorizer(tokenVerifier, apiKeyService, serverConfig)
 
 	// Create router
 	router := gin.New()
@@ -52,6 +77,8 @@ func setupAPITestContext(ctx *TestContext) *APITestContext {
 			strategies := v1.Group("/strategies")
 			{
 				strategies.POST("", strategyHandler.CreateStrategy)
+				strategies.POST("/preview", strategyHandler.PreviewStrategy)
+				strategies.POST("/cron-preview", strategyHandler.PreviewCron)
 				strategies.GET("", strategyHandler.GetStrategies)
 				strategies.GET("/:id", strategyHandler.GetStrategy)
 				strategies.PUT("/:id", strategyHandler.UpdateStrategy)
@@ -59,10 +86,33 @@ func setupAPITestContext(ctx *TestContext) *APITestContext {
 				strategies.POST("/:id/enable", strategyHandler.EnableStrategy)
 				strategies.POST("/:id/disable", strategyHandler.DisableStrategy)
 				strategies.POST("/scan", strategyHandler.TriggerScan)
+				strategies.POST("/:id/executions/retry", strategyHandler.RetryStrategyExecutions)
+				strategies.GET("/:id/versions", strategyHandler.GetStrategyVersions)
+				strategies.GET("/:id/versions/diff", strategyHandler.DiffStrategyVersions)
+				strategies.POST("/:id/versions/:version/rollback", strategyHandler.RollbackStrategy)
+				strategies.GET("/:id/runs", strategyHandler.GetStrategyRuns)
+				strategies.GET("/:id/runs/:batch", strategyHandler.GetStrategyRun)
 			}
 
+			// Inbound user events
+			v1.POST("/events", strategyHandler.HandleEvent)
+
 			// Quota management API
-			handlers.RegisterQuotaRoutes(v1, quotaHandler)
+			handlers.RegisterQuotaRoutes(v1, quotaHandler,
+				authorizer.RequireAccess(auth.RoleViewer, auth.ScopeQuotaRead),
+				authorizer.RequireAccess(auth.RoleOperator, auth.ScopeQuotaDeduct))
+
+			// Manual quota grants and adjustments
+			adminQuota := v1.Group("/admin/quota", authorizer.RequireRole(auth.RoleAdmin))
+			{
+				adminQuota.POST("/grant", quotaHandler.AdminGrantQuota)
+				adminQuota.POST("/adjust", quotaHandler.AdminAdjustQuota)
+			}
+
+			// Admin-only route used to check role hierarchy
+			v1.GET("/admin-check", authorizer.RequireRole(auth.RoleAdmin), func(c *gin.Context) {
+				c.JSON(http.StatusOK, response.NewSuccessResponse(auth.PrincipalFromContext(c), "ok"))
+			})
 		}
 	}
 
@@ -403,19 +453,234 @@ func testAPIQuotaUnauthorized(ctx *TestContext) TestResult {
 	return TestResult{Passed: true, Message: "API Quota Unauthorized Test Succeeded"}
 }
 
-// Helper function to create a valid JWT token for testing
-func createTestJWTToken() string {
-	// Create a simple base64url encoded JSON without signature (for testing)
-	userInfo := map[string]interface{}{
-		"id":      "test-user-123",
-		"name":    "Test User",
-		"staffID": "emp001",
-		"github":  "testuser",
-		"phone":   "13800138000",
+// createTestJWTToken creates an HS256 token signed with the test secret
+func createTestJWTToken(claims map[string]interface{}) string {
+	return signTestJWT(claims, testJWTSecret)
+}
+
+// signTestJWT signs the claims as an HS256 JWT with the given secret
+func signTestJWT(claims map[string]interface{}, secret string) string {
+	header, _ := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signingInput))
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// testUserClaims returns valid claims for the given user that expire in one hour
+func testUserClaims(userID string) map[string]interface{} {
+	return map[string]interface{}{
+		"universal_id": userID,
+		"name":         "Test User",
+		"staffID":      "emp001",
+		"github":       "testuser",
+		"phone":        "13800138000",
+		"exp":          time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+// testAPIQuotaInvalidToken tests that tokens failing signature or claims verification are rejected
+func testAPIQuotaInvalidToken(ctx *TestContext) TestResult {
+	apiCtx := setupAPITestContext(ctx)
+
+	validToken := createTestJWTToken(testUserClaims("user001"))
+	parts := strings.Split(validToken, ".")
+
+	expiredClaims := testUserClaims("user001")
+	expiredClaims["exp"] = time.Now().Add(-time.Hour).Unix()
+
+	notYetValidClaims := testUserClaims("user001")
+	notYetValidClaims["nbf"] = time.Now().Add(time.Hour).Unix()
+
+	noExpClaims := testUserClaims("user001")
+	delete(noExpClaims, "exp")
+
+	noneHeader := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+
+	testCases := []struct {
+		name  string
+		token string
+	}{
+		{"unsigned token", parts[0] + "." + parts[1] + ".signature"},
+		{"tampered payload", parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"universal_id":"admin","exp":9999999999}`)) + "." + parts[2]},
+		{"wrong secret", signTestJWT(testUserClaims("user001"), "another-secret")},
+		{"alg none", noneHeader + "." + parts[1] + "."},
+		{"expired token", createTestJWTToken(expiredClaims)},
+		{"not yet valid token", createTestJWTToken(notYetValidClaims)},
+		{"missing exp", createTestJWTToken(noExpClaims)},
+	}
+
+	for _, tc := range testCases {
+		req, _ := http.NewRequest("GET", "/quota-manager/api/v1/quota", nil)
+		req.Header.Set("Authorization", "Bearer "+tc.token)
+		w := httptest.NewRecorder()
+		apiCtx.Router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusUnauthorized {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Test case '%s': expected status 401, got %d", tc.name, w.Code)}
+		}
+
+		var resp response.ResponseData
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Test case '%s': failed to parse response: %v", tc.name, err)}
+		}
+		if resp.Code != response.TokenInvalidCode {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Test case '%s': expected code %s, got %s", tc.name, response.TokenInvalidCode, resp.Code)}
+		}
+	}
+
+	return TestResult{Passed: true, Message: "API Quota Invalid Token Test Succeeded"}
+}
+
+// testAPIRoleAuthorization tests role requirements on admin routes
+func testAPIRoleAuthorization(ctx *TestContext) TestResult {
+	apiCtx := setupAPITestContext(ctx)
+
+	withRoles := func(userID string, roles interface{}) string {
+		claims := testUserClaims(userID)
+		if roles != nil {
+			claims["roles"] = roles
+		}
+		return createTestJWTToken(claims)
+	}
+
+	auditPath := "/quota-manager/api/v1/quota/audit/123e4567-e89b-12d3-a456-426614174000"
+	adminPath := "/quota-manager/api/v1/admin-check"
+
+	testCases := []struct {
+		name           string
+		path           string
+		token          string
+		expectedStatus int
+	}{
+		{"missing token", auditPath, "", http.StatusUnauthorized},
+		{"invalid token", auditPath, signTestJWT(testUserClaims("user001"), "another-secret"), http.StatusUnauthorized},
+		{"no roles", auditPath, withRoles("user001", nil), http.StatusForbidden},
+		{"unknown role", auditPath, withRoles("user001", []string{"superuser"}), http.StatusForbidden},
+		{"viewer on viewer route", auditPath, withRoles("user001", []string{"viewer"}), http.StatusOK},
+		{"operator on viewer route", auditPath, withRoles("user001", "operator"), http.StatusOK},
+		{"operator on admin route", adminPath, withRoles("user001", []string{"viewer", "operator"}), http.StatusForbidden},
+		{"admin claim on admin route", adminPath, withRoles("user001", []string{"admin"}), http.StatusOK},
+		{"admin list on admin route", adminPath, withRoles(testAdminUserID, nil), http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		req, _ := http.NewRequest("GET", tc.path, nil)
+		if tc.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		w := httptest.NewRecorder()
+		apiCtx.Router.ServeHTTP(w, req)
+
+		if w.Code != tc.expectedStatus {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Test case '%s': expected status %d, got %d", tc.name, tc.expectedStatus, w.Code)}
+		}
+
+		if tc.expectedStatus != http.StatusOK {
+			var resp response.ResponseData
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				return TestResult{Passed: false, Message: fmt.Sprintf("Test case '%s': failed to parse response: %v", tc.name, err)}
+			}
+			if resp.Code != response.UnauthorizedCode {
+				return TestResult{Passed: false, Message: fmt.Sprintf("Test case '%s': expected code %s, got %s", tc.name, response.UnauthorizedCode, resp.Code)}
+			}
+		}
+	}
+
+	return TestResult{Passed: true, Message: "API Role Authorization Test Succeeded"}
+}
+
+// testAPIKeyAuthorization tests service API key authentication and scopes
+func testAPIKeyAuthorization(ctx *TestContext) TestResult {
+	apiCtx := setupAPITestContext(ctx)
+	apiKeyService := services.NewAPIKeyService(ctx.DB)
+
+	readKey, err := apiKeyService.CreateAPIKey(&services.CreateAPIKeyRequest{
+		Name:   "test-read-key",
+		Scopes: []string{"quota:read"},
+	}, testAdminUserID)
+	if err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Failed to create API key: %v", err)}
+	}
+
+	deductKey, err := apiKeyService.CreateAPIKey(&services.CreateAPIKeyRequest{
+		Name:   "test-deduct-key",
+		Scopes: []string{"quota:deduct"},
+	}, testAdminUserID)
+	if err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Failed to create API key: %v", err)}
+	}
+
+	revokedKey, err := apiKeyService.CreateAPIKey(&services.CreateAPIKeyRequest{
+		Name:   "test-revoked-key",
+		Scopes: []string{"quota:read"},
+	}, testAdminUserID)
+	if err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Failed to create API key: %v", err)}
+	}
+	if err := apiKeyService.RevokeAPIKey(revokedKey.APIKey.ID, testAdminUserID); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Failed to revoke API key: %v", err)}
+	}
+	if err := apiKeyService.RevokeAPIKey(revokedKey.APIKey.ID, testAdminUserID); err == nil {
+		return TestResult{Passed: false, Message: "Revoking an already revoked key should fail"}
+	}
+
+	expiredKey, err := apiKeyService.CreateAPIKey(&services.CreateAPIKeyRequest{
+		Name:   "test-expired-key",
+		Scopes: []string{"quota:read"},
+	}, testAdminUserID)
+	if err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Failed to create API key: %v", err)}
+	}
+	if err := ctx.DB.DB.Model(&models.APIKey{}).Where("id = ?", expiredKey.APIKey.ID).
+		Update("expires_at", time.Now().Add(-time.Hour)).Error; err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Failed to expire API key: %v", err)}
+	}
+
+	auditPath := "/quota-manager/api/v1/quota/audit/123e4567-e89b-12d3-a456-426614174000"
+	adminPath := "/quota-manager/api/v1/admin-check"
+
+	testCases := []struct {
+		name           string
+		path           string
+		key            string
+		expectedStatus int
+	}{
+		{"key with scope", auditPath, readKey.Key, http.StatusOK},
+		{"key without scope", auditPath, deductKey.Key, http.StatusForbidden},
+		{"key on token-only route", adminPath, readKey.Key, http.StatusForbidden},
+		{"revoked key", auditPath, revokedKey.Key, http.StatusUnauthorized},
+		{"expired key", auditPath, expiredKey.Key, http.StatusUnauthorized},
+		{"unknown key", auditPath, "qm_0000000000000000", http.StatusUnauthorized},
+	}
+
+	for _, tc := range testCases {
+		req, _ := http.NewRequest("GET", tc.path, nil)
+		req.Header.Set("X-API-Key", tc.key)
+		w := httptest.NewRecorder()
+		apiCtx.Router.ServeHTTP(w, req)
+
+		if w.Code != tc.expectedStatus {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Test case '%s': expected status %d, got %d", tc.name, tc.expectedStatus, w.Code)}
+		}
+	}
+
+	// Successful authentication records the last used time, the key itself is never stored
+	var stored models.APIKey
+	if err := ctx.DB.DB.First(&stored, readKey.APIKey.ID).Error; err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Failed to load API key: %v", err)}
+	}
+	if stored.LastUsedAt == nil {
+		return TestResult{Passed: false, Message: "Expected last_used_at to be set after use"}
+	}
+	if stored.KeyHash == readKey.Key || !strings.HasPrefix(readKey.Key, stored.KeyPrefix) {
+		return TestResult{Passed: false, Message: "API key must be stored hashed with a display prefix"}
 	}
 
-	payload, _ := json.Marshal(userInfo)
-	return "Bearer " + string(payload) // Simplified for testing
+	return TestResult{Passed: true, Message: "API Key Authorization Test Succeeded"}
 }
 
 // testAPICreateStrategyInvalidCondition tests strategy creation with invalid condition expression
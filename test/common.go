@@ -22,7 +22,7 @@ import (
 // testClearData test clear data - unified data clearing for all test modules
 func testClearData(ctx *TestContext) TestResult {
 	// Clear quota-related tables from main database
-	quotaTables := []string{"voucher_redemption", "quota_audit", "quota", "quota_execute", "quota_strategy"}
+	quotaTables := []string{"voucher_redemption", "quota_audit", "quota", "quota_execute", "strategy_run", "strategy_version", "quota_strategy", "quota_deduction", "quota_reservation", "quota_expiry_run", "api_keys", "scan_job"}
 	for _, table := range quotaTables {
 		if err := ctx.DB.DB.Exec("DELETE FROM " + table).Error; err != nil {
 			return TestResult{Passed: false, Message: fmt.Sprintf("Clear table %s failed: %v", table, err)}
@@ -133,7 +133,7 @@ func setupTestEnvironment() (*TestContext, error) {
 	}
 
 	// Auto migrate - ensure all tables exist in test environment
-	if err := db.DB.AutoMigrate(&models.QuotaStrategy{}, &models.QuotaExecute{}, &models.Quota{}, &models.QuotaAudit{}, &models.VoucherRedemption{}, &models.MonthlyQuotaUsage{}); err != nil {
+	if err := db.DB.AutoMigrate(&models.QuotaStrategy{}, &models.QuotaExecute{}, &models.StrategyRun{}, &models.StrategyVersion{}, &models.Quota{}, &models.QuotaAudit{}, &models.VoucherRedemption{}, &models.MonthlyQuotaUsage{}, &models.APIKey{}, &models.QuotaDeduction{}, &models.QuotaReservation{}, &models.QuotaExpiryRun{}, &models.SchedulerJob{}, &models.ScanJob{}); err != nil {
 		return nil, fmt.Errorf("failed to migrate main tables: %w", err)
 	}
 
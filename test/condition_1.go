@@ -337,9 +337,18 @@ func testQuotaLECondition(ctx *TestContext) TestResult {
 		}
 	}
 
-	// Set mock quota using the actual user IDs
-	mockStore.SetQuota(userQuotaLow.ID, 5)   // Low quota
-	mockStore.SetQuota(userQuotaHigh.ID, 50) // High quota
+	// Set quota using the actual user IDs, quota-le with a model compares against the quota rows
+	quotas := []*models.Quota{
+		{UserID: userQuotaLow.ID, Amount: 5, ExpiryDate: time.Now().Add(24 * time.Hour), Status: models.StatusValid},   // Low quota
+		{UserID: userQuotaHigh.ID, Amount: 50, ExpiryDate: time.Now().Add(24 * time.Hour), Status: models.StatusValid}, // High quota
+	}
+	for _, quota := range quotas {
+		if err := ctx.DB.DB.Create(quota).Error; err != nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Create quota failed: %v", err)}
+		}
+	}
+	mockStore.SetQuota(userQuotaLow.ID, 5)
+	mockStore.SetQuota(userQuotaHigh.ID, 50)
 
 	// Create quota-le strategy
 	strategy := &models.QuotaStrategy{
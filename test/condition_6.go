@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"quota-manager/internal/condition"
+	"quota-manager/internal/models"
+	"quota-manager/internal/utils"
+)
+
+// testRechargedCondition test recharged condition
+func testRechargedCondition(ctx *TestContext) TestResult {
+	trialOnly := createTestUser("user_recharged_trial", "Trial Only User", 0)
+	trialAndWelcome := createTestUser("user_recharged_both", "Trial And Welcome User", 0)
+	neither := createTestUser("user_recharged_none", "No Grant User", 0)
+	for _, user := range []*models.UserInfo{trialOnly, trialAndWelcome, neither} {
+		if err := ctx.DB.AuthDB.Create(user).Error; err != nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Create user failed: %v", err)}
+		}
+	}
+	userList := []models.UserInfo{*trialOnly, *trialAndWelcome, *neither}
+
+	// Grant the trial to two users and the welcome bonus to one of them
+	trial := &models.QuotaStrategy{
+		Name:      "recharged-trial",
+		Title:     "Recharged Trial",
+		Type:      "single",
+		Amount:    10,
+		Condition: fmt.Sprintf(`match-user("%s", "%s")`, trialOnly.ID, trialAndWelcome.ID),
+		Status:    true,
+	}
+	welcome := &models.QuotaStrategy{
+		Name:      "recharged-welcome",
+		Title:     "Recharged Welcome",
+		Type:      "single",
+		Amount:    20,
+		Condition: fmt.Sprintf(`match-user("%s")`, trialAndWelcome.ID),
+		Status:    true,
+	}
+	for _, strategy := range []*models.QuotaStrategy{trial, welcome} {
+		if err := ctx.StrategyService.CreateStrategy(strategy); err != nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Create strategy failed: %v", err)}
+		}
+		ctx.StrategyService.ExecStrategy(strategy, userList)
+	}
+
+	// Top up users who got the trial but not the welcome bonus
+	topUp := &models.QuotaStrategy{
+		Name:      "recharged-top-up",
+		Title:     "Recharged Top Up",
+		Type:      "single",
+		Amount:    5,
+		Condition: `and(recharged("recharged-trial"), not(recharged("recharged-welcome")))`,
+		Status:    true,
+	}
+	if err := ctx.StrategyService.CreateStrategy(topUp); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Create strategy failed: %v", err)}
+	}
+	ctx.StrategyService.ExecStrategy(topUp, userList)
+
+	expected := map[string]int64{trialOnly.ID: 1, trialAndWelcome.ID: 0, neither.ID: 0}
+	for userID, want := range expected {
+		var executeCount int64
+		ctx.DB.Model(&models.QuotaExecute{}).Where("strategy_id = ? AND user_id = ? AND status = 'completed'", topUp.ID, userID).Count(&executeCount)
+		if executeCount != want {
+			return TestResult{Passed: false, Message: fmt.Sprintf("User %s expected top-up execution %d times, actually executed %d times", userID, want, executeCount)}
+		}
+	}
+
+	// within-days only counts recent grants and min-count needs enough grants
+	if err := ctx.DB.Model(&models.QuotaExecute{}).
+		Where("strategy_id = ? AND user_id = ?", trial.ID, trialOnly.ID).
+		UpdateColumn("create_time", time.Now().AddDate(0, 0, -40)).Error; err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Backdate execution failed: %v", err)}
+	}
+	cases := []struct {
+		condition string
+		user      *models.UserInfo
+		want      bool
+	}{
+		{`recharged("recharged-trial", 30)`, trialOnly, false},
+		{`recharged("recharged-trial", 30)`, trialAndWelcome, true},
+		{`recharged("recharged-trial", 60)`, trialOnly, true},
+		{`recharged("recharged-trial", 0, 2)`, trialAndWelcome, false},
+		{`recharged("recharged-unknown")`, trialAndWelcome, false},
+	}
+	for i, tc := range cases {
+		got, err := conditionGrants(ctx, fmt.Sprintf("recharged-case-%d", i), tc.condition, tc.user)
+		if err != nil || got != tc.want {
+			return TestResult{Passed: false, Message: fmt.Sprintf("%s for %s: expected %v, got %v (%v)", tc.condition, tc.user.ID, tc.want, got, err)}
+		}
+	}
+
+	// Grants of a deleted strategy are still found through the audit records
+	if err := ctx.StrategyService.DeleteStrategy(welcome.ID); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Delete strategy failed: %v", err)}
+	}
+	if got, err := conditionGrants(ctx, "recharged-deleted", `recharged("recharged-welcome")`, trialAndWelcome); err != nil || !got {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Deleted strategy grant should match through audit records: %v, %v", got, err)}
+	}
+
+	for _, invalid := range []string{`recharged()`, `recharged("")`, `recharged("recharged-trial", -1)`, `recharged("recharged-trial", 0, 0)`} {
+		if _, err := condition.NewParser(invalid).Parse(); err == nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Expected %s to be rejected", invalid)}
+		}
+	}
+
+	return TestResult{Passed: true, Message: "recharged condition test succeeded"}
+}
+
+// testUsageConditions test used-quota-ge, used-quota-le, remaining-quota-le and monthly-usage-ge conditions
+func testUsageConditions(ctx *TestContext) TestResult {
+	heavy := createTestUser("user_usage_heavy", "Heavy Usage User", 0)
+	light := createTestUser("user_usage_light", "Light Usage User", 0)
+	for _, user := range []*models.UserInfo{heavy, light} {
+		if err := ctx.DB.AuthDB.Create(user).Error; err != nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Create user failed: %v", err)}
+		}
+	}
+	mockStore.SetQuota(heavy.ID, 100)
+	mockStore.SetUsed(heavy.ID, 90)
+	mockStore.SetQuota(light.ID, 100)
+	mockStore.SetUsed(light.ID, 5)
+
+	// Usage of last month in the configured timezone, and of a fixed month
+	now := utils.NowInConfigTimezone(ctx.QuotaService.GetConfigManager().GetDirect())
+	lastMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()).AddDate(0, -1, 0).Format("2006-01")
+	usages := []*models.MonthlyQuotaUsage{
+		{UserID: heavy.ID, YearMonth: lastMonth, UsedQuota: 500, RecordTime: now},
+		{UserID: light.ID, YearMonth: lastMonth, UsedQuota: 100, RecordTime: now},
+		{UserID: light.ID, YearMonth: "2024-01", UsedQuota: 80, RecordTime: now},
+	}
+	for _, usage := range usages {
+		if err := ctx.DB.Create(usage).Error; err != nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Create monthly usage failed: %v", err)}
+		}
+	}
+
+	cases := []struct {
+		condition string
+		user      *models.UserInfo
+		want      bool
+	}{
+		{`used-quota-ge(50)`, heavy, true},
+		{`used-quota-ge(50)`, light, false},
+		{`used-quota-le(10)`, light, true},
+		{`used-quota-le(10)`, heavy, false},
+		{`remaining-quota-le(20)`, heavy, true},
+		{`remaining-quota-le(20)`, light, false},
+		{`monthly-usage-ge("last-month", 300)`, heavy, true},
+		{`monthly-usage-ge("last-month", 300)`, light, false},
+		{`monthly-usage-ge("2024-01", 50)`, light, true},
+		{`monthly-usage-ge("2024-01", 50)`, heavy, false},
+		{`and(used-quota-ge(50), remaining-quota-le(20))`, heavy, true},
+	}
+	for i, tc := range cases {
+		got, err := conditionGrants(ctx, fmt.Sprintf("usage-case-%d", i), tc.condition, tc.user)
+		if err != nil || got != tc.want {
+			return TestResult{Passed: false, Message: fmt.Sprintf("%s for %s: expected %v, got %v (%v)", tc.condition, tc.user.ID, tc.want, got, err)}
+		}
+	}
+
+	for _, invalid := range []string{`used-quota-ge()`, `used-quota-le("a")`, `remaining-quota-le(1, 2)`, `monthly-usage-ge("2024-13", 1)`, `monthly-usage-ge("last-month")`} {
+		if _, err := condition.NewParser(invalid).Parse(); err == nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Expected %s to be rejected", invalid)}
+		}
+	}
+
+	return TestResult{Passed: true, Message: "usage conditions test succeeded"}
+}
+
+// conditionGrants executes a single strategy with the condition for the user and reports whether it granted
+func conditionGrants(ctx *TestContext, name, conditionStr string, user *models.UserInfo) (bool, error) {
+	strategy := &models.QuotaStrategy{
+		Name:      name,
+		Title:     name,
+		Type:      "single",
+		Amount:    1,
+		Condition: conditionStr,
+		Status:    true,
+	}
+	if err := ctx.StrategyService.CreateStrategy(strategy); err != nil {
+		return false, err
+	}
+	defer ctx.StrategyService.DeleteStrategy(strategy.ID)
+
+	ctx.StrategyService.ExecStrategy(strategy, []models.UserInfo{*user})
+
+	var executeCount int64
+	if err := ctx.DB.Model(&models.QuotaExecute{}).Where("strategy_id = ? AND user_id = ? AND status = 'completed'", strategy.ID, user.ID).Count(&executeCount).Error; err != nil {
+		return false, err
+	}
+	return executeCount > 0, nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"quota-manager/internal/condition"
+	"quota-manager/internal/models"
+	"quota-manager/internal/utils"
+)
+
+// conditionCase is a condition expected to grant, or not, a user
+type conditionCase struct {
+	condition string
+	user      *models.UserInfo
+	want      bool
+}
+
+// runConditionCases executes each condition case as a strategy, returning a failed result for the first mismatch
+func runConditionCases(ctx *TestContext, prefix string, cases []conditionCase) *TestResult {
+	for i, tc := range cases {
+		got, err := conditionGrants(ctx, fmt.Sprintf("%s-case-%d", prefix, i), tc.condition, tc.user)
+		if err != nil || got != tc.want {
+			return &TestResult{Passed: false, Message: fmt.Sprintf("%s for %s: expected %v, got %v (%v)", tc.condition, tc.user.ID, tc.want, got, err)}
+		}
+	}
+	return nil
+}
+
+// testRelativeTimeConditions test registered-within and inactive-for conditions and timestamps in the configured timezone
+func testRelativeTimeConditions(ctx *TestContext) TestResult {
+	loc := utils.GetTimezone(ctx.QuotaService.GetConfigManager().GetDirect())
+
+	newcomer := createTestUser("user_relative_new", "Relative Newcomer", 0)
+	newcomer.CreatedAt = time.Now().Add(-5 * 24 * time.Hour)
+	newcomer.AccessTime = time.Now().Add(-time.Hour)
+
+	dormant := createTestUser("user_relative_dormant", "Relative Dormant", 0)
+	dormant.CreatedAt = time.Date(2025, 1, 1, 12, 0, 0, 0, loc)
+	dormant.AccessTime = time.Now().Add(-20 * 24 * time.Hour)
+
+	for _, user := range []*models.UserInfo{newcomer, dormant} {
+		if err := ctx.DB.AuthDB.Create(user).Error; err != nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Create user failed: %v", err)}
+		}
+	}
+
+	if result := runConditionCases(ctx, "relative", []conditionCase{
+		{`registered-within("30d")`, newcomer, true},
+		{`registered-within("30d")`, dormant, false},
+		{`registered-within("72h")`, newcomer, false},
+		{`inactive-for("14d")`, dormant, true},
+		{`inactive-for("2w")`, newcomer, false},
+		{`and(registered-within("1w"), not(inactive-for("1d")))`, newcomer, true},
+		// Absolute timestamps are wall clock times in the configured timezone
+		{`register-before("2025-01-01 12:30:00")`, dormant, true},
+		{`register-before("2025-01-01 11:30:00")`, dormant, false},
+	}); result != nil {
+		return *result
+	}
+
+	for _, invalid := range []string{`registered-within("30")`, `registered-within("0d")`, `inactive-for("14m")`, `inactive-for()`} {
+		if _, err := condition.NewParser(invalid).Parse(); err == nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Expected %s to be rejected", invalid)}
+		}
+	}
+
+	return TestResult{Passed: true, Message: "relative time conditions test succeeded"}
+}
+
+// testFieldConditions test field-eq, field-in, field-regex, field-empty, devices-has and devices-eq conditions
+func testFieldConditions(ctx *TestContext) TestResult {
+	alice := createTestUser("user_field_alice", "Field Alice", 0)
+	alice.Company = "Acme Corp"
+	alice.Location = "Shenzhen"
+	alice.Email = "alice@Example.COM"
+	alice.GithubName = "alice-dev"
+	alice.Devices = `[{"os": "windows", "ide": {"name": "vscode", "version": "1.90"}}, {"os": "macos"}]`
+
+	bob := createTestUser("user_field_bob", "Field Bob", 0)
+	bob.Company = ""
+	bob.Location = "Beijing"
+	bob.Email = "bob@partner.org"
+	bob.GithubName = "bob"
+	bob.Devices = `{"os": "linux", "beta": true}`
+
+	for _, user := range []*models.UserInfo{alice, bob} {
+		if err := ctx.DB.AuthDB.Create(user).Error; err != nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Create user failed: %v", err)}
+		}
+	}
+
+	if result := runConditionCases(ctx, "field", []conditionCase{
+		{`field-eq("company", "Acme Corp")`, alice, true},
+		{`field-eq("company", "Acme Corp")`, bob, false},
+		{`field-eq("email-domain", "example.com")`, alice, true},
+		{`field-in("location", "Shenzhen", "Guangzhou")`, alice, true},
+		{`field-in("location", "Shenzhen", "Guangzhou")`, bob, false},
+		{`field-regex("github-name", "^alice(-.*)?$")`, alice, true},
+		{`field-regex("github-name", "^alice(-.*)?$")`, bob, false},
+		{`field-empty("company")`, bob, true},
+		{`field-empty("company")`, alice, false},
+		{`devices-has("$[*].ide.version")`, alice, true},
+		{`devices-has("$[*].ide.version")`, bob, false},
+		{`devices-eq("$[*].os", "macos")`, alice, true},
+		{`devices-eq("$[0].ide.name", "vscode")`, alice, true},
+		{`devices-eq("$.os", "linux")`, bob, true},
+		{`devices-eq("$.beta", true)`, bob, true},
+	}); result != nil {
+		return *result
+	}
+
+	for _, invalid := range []string{`field-eq("phone", "1")`, `field-in("company")`, `field-regex("company", "(")`, `devices-has("$[x]")`, `devices-eq("$.a..b", "1")`} {
+		if _, err := condition.NewParser(invalid).Parse(); err == nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Expected %s to be rejected", invalid)}
+		}
+	}
+
+	return TestResult{Passed: true, Message: "field conditions test succeeded"}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"quota-manager/internal/config"
+	"quota-manager/internal/handlers"
+	"quota-manager/internal/response"
+	"quota-manager/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getSchedulerLocks reads the scheduler locks as seen by the given instance
+func getSchedulerLocks(elector *services.LeaderElector) (*services.LockStatus, error) {
+	router := gin.New()
+	router.GET("/locks", handlers.NewSchedulerHandler(elector, nil).GetLocks)
+
+	req, _ := http.NewRequest("GET", "/locks", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		return nil, fmt.Errorf("status %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		response.ResponseData
+		Data *services.LockStatus `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
+
+// testLeaderElection tests that exactly one instance runs scheduled jobs and that another takes over
+func testLeaderElection(ctx *TestContext) TestResult {
+	// A missing elector keeps single-instance behavior
+	var single *services.LeaderElector
+	if !single.IsLeader() {
+		return TestResult{Passed: false, Message: "A nil elector should always lead"}
+	}
+
+	first := services.NewLeaderElector(ctx.DB, &config.SchedulerConfig{InstanceID: "test-instance-1", LeaderElectionInterval: 200 * time.Millisecond})
+	first.Start()
+	defer first.Stop()
+	second := services.NewLeaderElector(ctx.DB, &config.SchedulerConfig{InstanceID: "test-instance-2", LeaderElectionInterval: 200 * time.Millisecond})
+	second.Start()
+	defer second.Stop()
+
+	if !first.IsLeader() || second.IsLeader() {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected only the first instance to lead, got %v and %v", first.IsLeader(), second.IsLeader())}
+	}
+
+	// Guarded jobs only run on the leader
+	runs := 0
+	first.Guard("test-job", func() { runs++ })()
+	second.Guard("test-job", func() { runs++ })()
+	if runs != 1 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected the job to run once, ran %d times", runs)}
+	}
+
+	// Both instances report the leader as the lock holder
+	for _, tc := range []struct {
+		elector *services.LeaderElector
+		self    bool
+	}{{first, true}, {second, false}} {
+		status, err := getSchedulerLocks(tc.elector)
+		if err != nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Get scheduler locks failed: %v", err)}
+		}
+		if status.IsLeader != tc.self || len(status.Locks) != 1 {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Unexpected lock status: %+v", status)}
+		}
+		lock := status.Locks[0]
+		if lock.Lock != services.LeaderLockName || !lock.Held || lock.InstanceID != "test-instance-1" || lock.Self != tc.self || lock.PID == 0 {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Unexpected lock holder: %+v", lock)}
+		}
+	}
+
+	// Hooks run on the leader right away, and on a follower only once it is elected
+	elected := make(chan string, 2)
+	first.OnElected(func() { elected <- "test-instance-1" })
+	second.OnElected(func() { elected <- "test-instance-2" })
+	select {
+	case instance := <-elected:
+		if instance != "test-instance-1" {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Elected hook ran on follower %s", instance)}
+		}
+	case <-time.After(time.Second):
+		return TestResult{Passed: false, Message: "Elected hook should run on the leader right away"}
+	}
+
+	// The second instance takes over once the leader stops
+	first.Stop()
+	deadline := time.Now().Add(3 * time.Second)
+	for !second.IsLeader() && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if !second.IsLeader() || first.IsLeader() {
+		return TestResult{Passed: false, Message: "Second instance should lead after the first stopped"}
+	}
+
+	select {
+	case instance := <-elected:
+		if instance != "test-instance-2" {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Unexpected elected hook after failover: %s", instance)}
+		}
+	case <-time.After(time.Second):
+		return TestResult{Passed: false, Message: "Elected hook should run when the second instance takes over"}
+	}
+
+	status, err := getSchedulerLocks(second)
+	if err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Get scheduler locks failed: %v", err)}
+	}
+	if !status.Locks[0].Held || status.Locks[0].InstanceID != "test-instance-2" || !status.Locks[0].Self {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Unexpected lock holder after failover: %+v", status.Locks[0])}
+	}
+
+	return TestResult{Passed: true, Message: "Leader Election Test Succeeded"}
+}
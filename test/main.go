@@ -95,6 +95,10 @@ func runAllTests(ctx *TestContext) []TestResult {
 		{"Condition Expression - Belong To Fallback Test", testBelongToFallbackToOriginal},
 		{"Condition Expression - Belong To No Employee Number Test", testBelongToWithNoEmployeeNumber},
 		{"Condition Expression - Belong To Multiple Organizations Test", testBelongToMultipleOrgs},
+		{"Condition Expression - Recharged Test", testRechargedCondition},
+		{"Condition Expression - Usage Conditions Test", testUsageConditions},
+		{"Condition Expression - Relative Time Test", testRelativeTimeConditions},
+		{"Condition Expression - Field Conditions Test", testFieldConditions},
 		{"Condition Expression - AND Nesting Test", testAndCondition},
 		{"Condition Expression - OR Nesting Test", testOrCondition},
 		{"Condition Expression - NOT Nesting Test", testNotCondition},
@@ -117,6 +121,20 @@ func runAllTests(ctx *TestContext) []TestResult {
 		{"Quota Expiry Test", testQuotaExpiry},
 		{"Quota Audit Records Test", testQuotaAuditRecords},
 		{"Strategy with Expiry Date Test", testStrategyWithExpiryDate},
+		{"Strategy Expiry Policy Test", testStrategyExpiryPolicy},
+		{"Strategy Preview Test", testStrategyPreview},
+		{"Strategy Runs Test", testStrategyRuns},
+		{"Strategy Parallel Execution Test", testStrategyParallelExecution},
+		{"Strategy Overlapping Runs Test", testStrategyOverlappingRuns},
+		{"Strategy Execution Retry Test", testStrategyExecutionRetry},
+		{"Strategy Window and Budget Test", testStrategyWindowAndBudget},
+		{"Strategy Budget Computed Amounts Test", testStrategyBudgetComputedAmounts},
+		{"Strategy Versions Test", testStrategyVersions},
+		{"Strategy Events Test", testStrategyEvents},
+		{"Strategy Amount Expression Test", testStrategyAmountExpr},
+		{"Strategy Exclusion Test", testStrategyExclusion},
+		{"Strategy Misfire Test", testStrategyMisfire},
+		{"Strategy Misfire Bounds Test", testStrategyMisfireBounds},
 		{"Multiple Operations Accuracy Test", testMultipleOperationsAccuracy},
 		{"User Quota Consumption Order Test", testUserQuotaConsumptionOrder},
 		{"Quota Transfer Out Test", testQuotaTransferOut},
@@ -137,6 +155,8 @@ func runAllTests(ctx *TestContext) []TestResult {
 		{"Expired Quota Greater Than Used Quota Test", testExpireQuotasTask_ExpiredQuotaGreaterThanUsedQuota},
 		{"Expired Quota Less Than Used Quota Test", testExpireQuotasTask_ExpiredQuotaLessThanUsedQuota},
 		{"Mixed Consumption and Expiry Scenarios Test", testExpireQuotasTask_MixedConsumptionAndExpiry},
+		{"Resume Interrupted Expiry Run Test", testExpireQuotasTask_ResumeInterruptedRun},
+		{"Mid-Month Expiry Keeps Used Quota Test", testExpireQuotasTask_MidMonthExpiryKeepsUsedQuota},
 
 		// Transfer Tests
 		{"Transfer In Status Cases Test", testTransferInStatusCases},
@@ -182,9 +202,20 @@ func runAllTests(ctx *TestContext) []TestResult {
 		{"API Invalid Strategy ID", testAPIInvalidStrategyID},
 		{"API Get Strategies", testAPIGetStrategies},
 		{"API Quota Unauthorized", testAPIQuotaUnauthorized},
+		{"API Quota Invalid Token", testAPIQuotaInvalidToken},
+		{"API Role Authorization", testAPIRoleAuthorization},
+		{"API Key Authorization", testAPIKeyAuthorization},
+		{"Deduct Quota Idempotency", testDeductQuotaIdempotency},
+		{"Quota Reservation", testQuotaReservation},
+		{"Model Pool Reservation", testModelPoolReservation},
+		{"Model Quota Pools", testModelQuotaPools},
+		{"Admin Quota Grant and Adjust", testAdminQuotaGrantAndAdjust},
 
 		// Sanity Tests
 		{"Concurrent Operations Test", testConcurrentOperations},
+		{"Leader Election Test", testLeaderElection},
+		{"Scheduler Jobs Test", testSchedulerJobs},
+		{"Scan Jobs Test", testScanJobs},
 
 		// Permission Management Tests
 		{"User Whitelist Management Test", testUserWhitelistManagement},
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"sync"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -288,6 +289,9 @@ func (m *MockQuotaStore) ClearData() {
 	m.quotaCheckData = make(map[string]bool)
 }
 
+// mockStoreMu guards mockStore against concurrent mock server requests
+var mockStoreMu sync.Mutex
+
 var mockStore = &MockQuotaStore{
 	data:                 make(map[string]float64),
 	usedData:             make(map[string]float64),
@@ -308,6 +312,13 @@ func createMockServer(shouldFail bool) *httptest.Server {
 	gin.SetMode(gin.TestMode)
 	router := gin.New()
 
+	// Serialize requests, strategies recharge users concurrently and the mock store is not thread-safe
+	router.Use(func(c *gin.Context) {
+		mockStoreMu.Lock()
+		defer mockStoreMu.Unlock()
+		c.Next()
+	})
+
 	// Middleware: validate Authorization
 	authMiddleware := func(c *gin.Context) {
 		auth := c.GetHeader("x-admin-key")
@@ -52,7 +52,7 @@ func testPeriodicStrategyExecution(ctx *TestContext) TestResult {
 	}
 
 	// Test disable and enable functionality
-	if err := ctx.StrategyService.DisableStrategy(strategy.ID); err != nil {
+	if err := ctx.StrategyService.DisableStrategy(strategy.ID, ""); err != nil {
 		return TestResult{Passed: false, Message: fmt.Sprintf("Disable strategy failed: %v", err)}
 	}
 
@@ -73,7 +73,7 @@ func testPeriodicStrategyExecution(ctx *TestContext) TestResult {
 	}
 
 	// Re-enable and test
-	if err := ctx.StrategyService.EnableStrategy(strategy.ID); err != nil {
+	if err := ctx.StrategyService.EnableStrategy(strategy.ID, ""); err != nil {
 		return TestResult{Passed: false, Message: fmt.Sprintf("Enable strategy failed: %v", err)}
 	}
 
@@ -275,7 +275,7 @@ func testPeriodicStrategyCRUDOperations(ctx *TestContext) TestResult {
 	}
 
 	// Test DISABLE operation
-	if err := ctx.StrategyService.DisableStrategy(strategy.ID); err != nil {
+	if err := ctx.StrategyService.DisableStrategy(strategy.ID, ""); err != nil {
 		return TestResult{Passed: false, Message: fmt.Sprintf("Disable strategy failed: %v", err)}
 	}
 
@@ -291,7 +291,7 @@ func testPeriodicStrategyCRUDOperations(ctx *TestContext) TestResult {
 	}
 
 	// Test ENABLE operation
-	if err := ctx.StrategyService.EnableStrategy(strategy.ID); err != nil {
+	if err := ctx.StrategyService.EnableStrategy(strategy.ID, ""); err != nil {
 		return TestResult{Passed: false, Message: fmt.Sprintf("Enable strategy failed: %v", err)}
 	}
 
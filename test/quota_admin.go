@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"quota-manager/internal/models"
+	"quota-manager/internal/response"
+	"quota-manager/internal/services"
+)
+
+// postAdminQuota sends a grant or adjust request authenticated as the test administrator
+func postAdminQuota(apiCtx *APITestContext, action string, body map[string]interface{}) (*httptest.ResponseRecorder, *services.AdminQuotaResponse) {
+	jsonData, _ := json.Marshal(body)
+	req, _ := http.NewRequest("POST", "/quota-manager/api/v1/admin/quota/"+action, bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+createTestJWTToken(testUserClaims(testAdminUserID)))
+	w := httptest.NewRecorder()
+	apiCtx.Router.ServeHTTP(w, req)
+
+	var resp struct {
+		response.ResponseData
+		Data *services.AdminQuotaResponse `json:"data"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	return w, resp.Data
+}
+
+// testAdminQuotaGrantAndAdjust tests manual grants and adjustments by administrators
+func testAdminQuotaGrantAndAdjust(ctx *TestContext) TestResult {
+	cleanupMockQuotaStore(ctx)
+	apiCtx := setupAPITestContext(ctx)
+
+	users := make([]*models.UserInfo, 3)
+	userIDs := make([]string, len(users))
+	for i := range users {
+		users[i] = createTestUser(fmt.Sprintf("admin_quota_user_%d", i), "Admin Quota User", 0)
+		if err := ctx.DB.AuthDB.Create(users[i]).Error; err != nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Create user failed: %v", err)}
+		}
+		userIDs[i] = users[i].ID
+		ctx.MockQuotaStore.SetQuota(users[i].ID, 0)
+		ctx.MockQuotaStore.SetUsed(users[i].ID, 0)
+	}
+	expiryDate := time.Now().Truncate(time.Second).Add(30 * 24 * time.Hour)
+
+	// The reason is required
+	if w, _ := postAdminQuota(apiCtx, "grant", map[string]interface{}{
+		"user_id":     userIDs[0],
+		"amount":      50,
+		"expiry_date": expiryDate,
+	}); w.Code != http.StatusBadRequest {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected 400 without reason, got %d", w.Code)}
+	}
+
+	// Grants must be positive and expire in the future
+	for name, body := range map[string]map[string]interface{}{
+		"negative grant": {"user_id": userIDs[0], "amount": -10, "expiry_date": expiryDate, "reason": "compensation"},
+		"past expiry":    {"user_id": userIDs[0], "amount": 10, "expiry_date": time.Now().Add(-time.Hour), "reason": "compensation"},
+		"no users":       {"amount": 10, "expiry_date": expiryDate, "reason": "compensation"},
+	} {
+		if w, _ := postAdminQuota(apiCtx, "grant", body); w.Code != http.StatusBadRequest {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Expected 400 for %s, got %d", name, w.Code)}
+		}
+	}
+
+	// Bulk grant to all users, the duplicate user is granted once
+	w, granted := postAdminQuota(apiCtx, "grant", map[string]interface{}{
+		"user_id":     userIDs[0],
+		"user_ids":    userIDs,
+		"amount":      50,
+		"expiry_date": expiryDate,
+		"reason":      "Service outage compensation",
+	})
+	if w.Code != http.StatusOK || granted == nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Grant failed with status %d: %s", w.Code, w.Body.String())}
+	}
+	if granted.SuccessCount != 3 || granted.FailedCount != 0 || granted.Operation != models.OperationAdminGrant {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Unexpected grant result: %+v", granted)}
+	}
+	for _, userID := range userIDs {
+		if err := verifyUserQuotaAmountByStatus(ctx, userID, models.StatusValid, 50); err != nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Grant quota verification failed: %v", err)}
+		}
+		if err := verifyMockQuotaStoreTotalQuota(ctx, userID, 50); err != nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("AiGateway quota verification failed: %v", err)}
+		}
+	}
+
+	// A negative adjustment removes quota of the expiry date, but not more than is left after usage
+	ctx.MockQuotaStore.SetUsed(userIDs[0], 20)
+	w, adjusted := postAdminQuota(apiCtx, "adjust", map[string]interface{}{
+		"user_ids":    []string{userIDs[0], userIDs[1]},
+		"amount":      -40,
+		"expiry_date": expiryDate,
+		"reason":      "Granted by mistake",
+	})
+	if w.Code != http.StatusOK || adjusted == nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Adjust failed with status %d: %s", w.Code, w.Body.String())}
+	}
+	if adjusted.SuccessCount != 1 || adjusted.FailedCount != 1 || adjusted.Results[0].Success || !adjusted.Results[1].Success {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Only the second user should be adjusted: %+v", adjusted)}
+	}
+	if err := verifyUserQuotaAmountByStatus(ctx, userIDs[0], models.StatusValid, 50); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Failed adjustment should not change quota: %v", err)}
+	}
+	if err := verifyUserQuotaAmountByStatus(ctx, userIDs[1], models.StatusValid, 10); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Adjusted quota verification failed: %v", err)}
+	}
+	if err := verifyMockQuotaStoreTotalQuota(ctx, userIDs[1], 10); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("AiGateway quota verification failed: %v", err)}
+	}
+
+	// A positive adjustment adds quota like a grant
+	if w, adjusted := postAdminQuota(apiCtx, "adjust", map[string]interface{}{
+		"user_id":     userIDs[2],
+		"amount":      5,
+		"expiry_date": expiryDate,
+		"reason":      "Rounding correction",
+	}); w.Code != http.StatusOK || adjusted == nil || adjusted.SuccessCount != 1 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Positive adjust failed with status %d: %s", w.Code, w.Body.String())}
+	}
+	if err := verifyUserQuotaAmountByStatus(ctx, userIDs[2], models.StatusValid, 55); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Positive adjustment verification failed: %v", err)}
+	}
+
+	// Audit records note the operation, the operator and the reason
+	for _, tc := range []struct {
+		userID    string
+		operation string
+		count     int64
+	}{
+		{userIDs[0], models.OperationAdminGrant, 1},
+		{userIDs[0], models.OperationAdminAdjust, 0},
+		{userIDs[1], models.OperationAdminAdjust, 1},
+		{userIDs[2], models.OperationAdminAdjust, 1},
+	} {
+		var count int64
+		ctx.DB.Model(&models.QuotaAudit{}).Where("user_id = ? AND operation = ?", tc.userID, tc.operation).Count(&count)
+		if count != tc.count {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Expected %d %s audit records, got %d", tc.count, tc.operation, count)}
+		}
+	}
+
+	var audit models.QuotaAudit
+	if err := ctx.DB.Where("user_id = ? AND operation = ?", userIDs[1], models.OperationAdminAdjust).First(&audit).Error; err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Load adjust audit failed: %v", err)}
+	}
+	details, err := audit.UnmarshalDetails()
+	if err != nil || details == nil || details.Reason != "Granted by mistake" {
+		return TestResult{Passed: false, Message: "Adjust audit details should contain the reason"}
+	}
+	if audit.Operator != testAdminUserID || audit.Amount != -40 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Unexpected adjust audit record: %+v", audit)}
+	}
+
+	// Non-admin callers are rejected
+	req, _ := http.NewRequest("POST", "/quota-manager/api/v1/admin/quota/grant", bytes.NewBufferString(`{}`))
+	req.Header.Set("Authorization", "Bearer "+createTestJWTToken(testUserClaims(userIDs[0])))
+	w = httptest.NewRecorder()
+	apiCtx.Router.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected 403 for a non-admin caller, got %d", w.Code)}
+	}
+
+	return TestResult{Passed: true, Message: "Admin Quota Grant and Adjust Test Succeeded"}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"quota-manager/internal/models"
+	"quota-manager/internal/response"
+	"quota-manager/internal/services"
+)
+
+// postDeductQuota sends a deduct request authenticated with the given API key
+func postDeductQuota(apiCtx *APITestContext, apiKey string, body map[string]interface{}) (*httptest.ResponseRecorder, *services.DeductQuotaResponse) {
+	jsonData, _ := json.Marshal(body)
+	req, _ := http.NewRequest("POST", "/quota-manager/api/v1/quota/deduct", bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-Key", apiKey)
+	w := httptest.NewRecorder()
+	apiCtx.Router.ServeHTTP(w, req)
+
+	var resp struct {
+		response.ResponseData
+		Data *services.DeductQuotaResponse `json:"data"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	return w, resp.Data
+}
+
+// testDeductQuotaIdempotency tests POST /quota/deduct and its reference_id idempotency
+func testDeductQuotaIdempotency(ctx *TestContext) TestResult {
+	cleanupMockQuotaStore(ctx)
+	apiCtx := setupAPITestContext(ctx)
+
+	user := createTestUser("deduct_user", "Deduct User", 0)
+	if err := ctx.DB.AuthDB.Create(user).Error; err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Create user failed: %v", err)}
+	}
+
+	quota := &models.Quota{
+		UserID:     user.ID,
+		Amount:     100,
+		ExpiryDate: time.Now().Truncate(time.Second).Add(30 * 24 * time.Hour),
+		Status:     models.StatusValid,
+	}
+	if err := ctx.DB.Create(quota).Error; err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Create quota failed: %v", err)}
+	}
+	ctx.MockQuotaStore.SetQuota(user.ID, 100)
+	ctx.MockQuotaStore.SetUsed(user.ID, 0)
+
+	key, err := services.NewAPIKeyService(ctx.DB).CreateAPIKey(&services.CreateAPIKeyRequest{
+		Name:   "billing-job",
+		Scopes: []string{"quota:deduct"},
+	}, testAdminUserID)
+	if err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Create API key failed: %v", err)}
+	}
+
+	request := map[string]interface{}{
+		"user_id":      user.ID,
+		"amount":       30,
+		"reason":       "billing",
+		"reference_id": "invoice-001",
+	}
+
+	// First call deducts
+	w, first := postDeductQuota(apiCtx, key.Key, request)
+	if w.Code != http.StatusOK || first == nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("First deduction failed with status %d: %s", w.Code, w.Body.String())}
+	}
+	if first.Duplicate || first.RemainingQuota != 70 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Unexpected first deduction result: %+v", first)}
+	}
+
+	// Repeating the call returns the original result without deducting again
+	w, second := postDeductQuota(apiCtx, key.Key, request)
+	if w.Code != http.StatusOK || second == nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Repeated deduction failed with status %d: %s", w.Code, w.Body.String())}
+	}
+	if !second.Duplicate || second.RemainingQuota != first.RemainingQuota {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Repeated deduction should return the original result: %+v", second)}
+	}
+
+	// Reusing the reference ID for a different deduction is rejected
+	conflicting := map[string]interface{}{
+		"user_id":      user.ID,
+		"amount":       50,
+		"reference_id": "invoice-001",
+	}
+	if w, _ := postDeductQuota(apiCtx, key.Key, conflicting); w.Code != http.StatusConflict {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected 409 for reused reference_id, got %d", w.Code)}
+	}
+
+	// The reference ID is required
+	missingReference := map[string]interface{}{
+		"user_id": user.ID,
+		"amount":  10,
+	}
+	if w, _ := postDeductQuota(apiCtx, key.Key, missingReference); w.Code != http.StatusBadRequest {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected 400 without reference_id, got %d", w.Code)}
+	}
+
+	// A failed deduction does not consume the reference ID
+	tooMuch := map[string]interface{}{
+		"user_id":      user.ID,
+		"amount":       1000,
+		"reference_id": "invoice-002",
+	}
+	if w, _ := postDeductQuota(apiCtx, key.Key, tooMuch); w.Code != http.StatusBadRequest {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected 400 for insufficient quota, got %d", w.Code)}
+	}
+	var failedCount int64
+	ctx.DB.Model(&models.QuotaDeduction{}).Where("reference_id = ?", "invoice-002").Count(&failedCount)
+	if failedCount != 0 {
+		return TestResult{Passed: false, Message: "Failed deduction should not record its reference_id"}
+	}
+
+	// Concurrent calls with the same reference ID deduct once
+	concurrent := map[string]interface{}{
+		"user_id":      user.ID,
+		"amount":       10,
+		"reference_id": "invoice-003",
+	}
+	var wg sync.WaitGroup
+	statuses := make([]int, 5)
+	for i := range statuses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w, _ := postDeductQuota(apiCtx, key.Key, concurrent)
+			statuses[i] = w.Code
+		}(i)
+	}
+	wg.Wait()
+	for i, status := range statuses {
+		if status != http.StatusOK {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Concurrent deduction %d returned status %d", i, status)}
+		}
+	}
+
+	var remaining models.Quota
+	if err := ctx.DB.Where("id = ?", quota.ID).First(&remaining).Error; err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Load quota failed: %v", err)}
+	}
+	if remaining.Amount != 60 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected remaining quota 60, got %g", remaining.Amount)}
+	}
+
+	// Amounts are charged in cents, so a retry of an amount with more decimals replays
+	fractional := map[string]interface{}{
+		"user_id":      user.ID,
+		"amount":       1.005,
+		"reference_id": "invoice-004",
+	}
+	w, charged := postDeductQuota(apiCtx, key.Key, fractional)
+	if w.Code != http.StatusOK || charged == nil || charged.Duplicate {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Fractional deduction failed with status %d: %s", w.Code, w.Body.String())}
+	}
+	w, retried := postDeductQuota(apiCtx, key.Key, fractional)
+	if w.Code != http.StatusOK || retried == nil || !retried.Duplicate || retried.Amount != charged.Amount {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Retried fractional deduction should replay, got %d: %s", w.Code, w.Body.String())}
+	}
+
+	// Audit records note the API key and carry the reference ID in their details
+	var audits []models.QuotaAudit
+	if err := ctx.DB.Where("user_id = ? AND operation = ?", user.ID, models.OperationDeduct).Find(&audits).Error; err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Load audit records failed: %v", err)}
+	}
+	if len(audits) != 3 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected 3 deduct audit records, got %d", len(audits))}
+	}
+	for _, audit := range audits {
+		if audit.APIKeyID == nil || *audit.APIKeyID != key.APIKey.ID {
+			return TestResult{Passed: false, Message: "Deduct audit record should note the API key"}
+		}
+		if audit.RelatedUser != "" {
+			return TestResult{Passed: false, Message: "Reference ID should no longer be stored in related_user"}
+		}
+		details, err := audit.UnmarshalDetails()
+		if err != nil || details == nil || details.ReferenceID == "" {
+			return TestResult{Passed: false, Message: "Deduct audit details should contain the reference ID"}
+		}
+	}
+
+	return TestResult{Passed: true, Message: "Deduct Quota Idempotency Test Succeeded"}
+}
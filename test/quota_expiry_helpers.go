@@ -543,8 +543,11 @@ func createTestUserWithQuota(ctx *TestContext, userName, displayName string, quo
 	return user, quota, nil
 }
 
-// 执行 expireQuotasTask 并处理错误
+// 执行 expireQuotasTask 并处理错误（与月初调度顺序一致，先记录月度使用量再执行过期）
 func executeExpireQuotasTask(ctx *TestContext) error {
+	if err := ctx.QuotaService.RecordMonthlyUsedQuota(); err != nil {
+		return fmt.Errorf("record monthly used quota failed: %v", err)
+	}
 	if err := ctx.QuotaService.ExpireQuotas(); err != nil {
 		return fmt.Errorf("expire quotas task failed: %v", err)
 	}
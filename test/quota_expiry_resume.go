@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"quota-manager/internal/models"
+)
+
+// testExpireQuotasTask_ResumeInterruptedRun tests mid-month expiry in user chunks and resuming an interrupted run
+func testExpireQuotasTask_ResumeInterruptedRun(ctx *TestContext) TestResult {
+	startTime := time.Now()
+
+	result := testClearData(ctx)
+	if !result.Passed {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Clear test data failed: %s", result.Message)}
+	}
+	cleanupMockQuotaStore(ctx)
+
+	// Process one user per chunk
+	schedulerConfig := &ctx.QuotaService.GetConfigManager().GetDirect().Scheduler
+	originalBatchSize := schedulerConfig.ExpiryBatchSize
+	schedulerConfig.ExpiryBatchSize = 1
+	defer func() { schedulerConfig.ExpiryBatchSize = originalBatchSize }()
+
+	// Quota that expired an hour ago is expired without waiting for the month end
+	hourAgo := time.Now().Add(-time.Hour).Truncate(time.Second)
+	users := make([]*models.UserInfo, 3)
+	for i := range users {
+		users[i] = createTestUser(fmt.Sprintf("test_user_expiry_resume_%d", i), "Test User Expiry Resume", 0)
+		if err := ctx.DB.AuthDB.Create(users[i]).Error; err != nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Create user failed: %v", err)}
+		}
+		if _, err := createTestQuota(ctx, users[i].ID, 50.0, models.StatusValid, hourAgo); err != nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Create expired quota failed: %v", err)}
+		}
+		if _, err := createValidTestQuota(ctx, users[i].ID, 30.0); err != nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Create valid quota failed: %v", err)}
+		}
+		ctx.MockQuotaStore.SetQuota(users[i].ID, 80.0)
+		ctx.MockQuotaStore.SetUsed(users[i].ID, 20.0)
+	}
+
+	// Runs process users in user_id order
+	var userIDs []string
+	if err := ctx.DB.Model(&models.Quota{}).Distinct("user_id").Order("user_id ASC").Pluck("user_id", &userIDs).Error; err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Load user IDs failed: %v", err)}
+	}
+	if len(userIDs) != len(users) {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected %d users with quota, got %d", len(users), len(userIDs))}
+	}
+
+	// Simulate a run that crashed after expiring the first user but before adjusting AiGateway
+	if err := ctx.DB.Model(&models.Quota{}).
+		Where("user_id = ? AND expiry_date = ?", userIDs[0], hourAgo).
+		Update("status", models.StatusExpired).Error; err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expire first user quota failed: %v", err)}
+	}
+	interrupted := &models.QuotaExpiryRun{
+		Cutoff:            time.Now().Truncate(time.Second),
+		Status:            models.ExpiryRunStatusRunning,
+		LastUserID:        userIDs[0],
+		PendingUserID:     userIDs[0],
+		PendingUsedDelta:  -20.0,
+		PendingQuotaDelta: -50.0,
+		ProcessedUsers:    1,
+		ExpiredAmount:     50.0,
+	}
+	if err := ctx.DB.Create(interrupted).Error; err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Create interrupted run failed: %v", err)}
+	}
+
+	if err := ctx.QuotaService.ExpireQuotas(); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Resume expiry run failed: %v", err)}
+	}
+
+	// The interrupted run is resumed and completed instead of starting a new one
+	var runs []models.QuotaExpiryRun
+	if err := ctx.DB.Order("id ASC").Find(&runs).Error; err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Load expiry runs failed: %v", err)}
+	}
+	if len(runs) != 1 || runs[0].ID != interrupted.ID {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected only the interrupted run, got %d runs", len(runs))}
+	}
+	run := runs[0]
+	if run.Status != models.ExpiryRunStatusCompleted || run.FinishTime == nil || run.PendingUserID != "" {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Run should be completed without pending work: %+v", run)}
+	}
+	if run.ProcessedUsers != 3 || run.ExpiredAmount != 150.0 || run.LastUserID != userIDs[2] {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Unexpected run progress: %+v", run)}
+	}
+
+	// Every user's AiGateway quota is adjusted exactly once: used reset, total capped at the remaining 30
+	expectedDeltaCalls := make([]MockQuotaStoreDeltaCall, 0, len(userIDs))
+	expectedUsedDeltaCalls := make([]MockQuotaStoreUsedDeltaCall, 0, len(userIDs))
+	for _, userID := range userIDs {
+		expectedDeltaCalls = append(expectedDeltaCalls, MockQuotaStoreDeltaCall{EmployeeNumber: userID, Delta: -50.0})
+		expectedUsedDeltaCalls = append(expectedUsedDeltaCalls, MockQuotaStoreUsedDeltaCall{EmployeeNumber: userID, Delta: -20.0})
+	}
+	if err := verifyMockQuotaStoreDeltaCalls(ctx, expectedDeltaCalls); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("MockQuotaStore delta calls verification failed: %v", err)}
+	}
+	if err := verifyMockQuotaStoreUsedDeltaCalls(ctx, expectedUsedDeltaCalls); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("MockQuotaStore used delta calls verification failed: %v", err)}
+	}
+
+	for _, userID := range userIDs {
+		if err := verifyUserQuotaAmountByStatus(ctx, userID, models.StatusValid, 30.0); err != nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Valid quota verification failed: %v", err)}
+		}
+		if err := verifyMockQuotaStoreTotalQuota(ctx, userID, 30.0); err != nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("MockQuotaStore total quota verification failed: %v", err)}
+		}
+	}
+
+	// Users expired by the resumed run get an audit record, the interrupted user is not expired twice
+	var auditCount int64
+	ctx.DB.Model(&models.QuotaAudit{}).Where("operation = ?", "EXPIRE").Count(&auditCount)
+	if auditCount != 2 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected 2 expiry audit records, got %d", auditCount)}
+	}
+
+	// Nothing is left to expire, so no new run is started
+	if err := ctx.QuotaService.ExpireQuotas(); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Repeated expiry failed: %v", err)}
+	}
+	var runCount int64
+	ctx.DB.Model(&models.QuotaExpiryRun{}).Count(&runCount)
+	if runCount != 1 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected no new expiry run, got %d runs", runCount)}
+	}
+
+	return TestResult{
+		Passed:    true,
+		Message:   "Resume Interrupted Expiry Run Test Succeeded",
+		Duration:  time.Since(startTime),
+		TestName:  "testExpireQuotasTask_ResumeInterruptedRun",
+		StartTime: startTime,
+	}
+}
+
+// testExpireQuotasTask_MidMonthExpiryKeepsUsedQuota tests that mid-month expiry only takes back the used quota the expired quota covered
+func testExpireQuotasTask_MidMonthExpiryKeepsUsedQuota(ctx *TestContext) TestResult {
+	startTime := time.Now()
+
+	result := testClearData(ctx)
+	if !result.Passed {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Clear test data failed: %s", result.Message)}
+	}
+	cleanupMockQuotaStore(ctx)
+
+	user := createTestUser("test_user_expiry_mid_month", "Test User Expiry Mid Month", 0)
+	if err := ctx.DB.AuthDB.Create(user).Error; err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Create user failed: %v", err)}
+	}
+
+	// Quota of a relative expiry policy that expired an hour ago, and quota that stays valid
+	hourAgo := time.Now().Add(-time.Hour).Truncate(time.Minute)
+	if _, err := createTestQuota(ctx, user.ID, 20.0, models.StatusValid, hourAgo); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Create expired quota failed: %v", err)}
+	}
+	if _, err := createValidTestQuota(ctx, user.ID, 100.0); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Create valid quota failed: %v", err)}
+	}
+	ctx.MockQuotaStore.SetQuota(user.ID, 120.0)
+	ctx.MockQuotaStore.SetUsed(user.ID, 50.0)
+
+	if err := ctx.QuotaService.ExpireQuotas(); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expire quotas failed: %v", err)}
+	}
+
+	// The expired 20 covered 20 of the used 50, the other 30 stays used against the valid quota
+	if err := verifyUserQuotaAmountByStatus(ctx, user.ID, models.StatusExpired, 20.0); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expired quota verification failed: %v", err)}
+	}
+	if err := verifyMockQuotaStoreUsedQuota(ctx, user.ID, 30.0); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Used quota should survive mid-month expiry: %v", err)}
+	}
+	if err := verifyMockQuotaStoreTotalQuota(ctx, user.ID, 100.0); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("MockQuotaStore total quota verification failed: %v", err)}
+	}
+
+	// The remaining quota is what is left of the valid quota
+	quotaInfo, err := ctx.QuotaService.GetUserQuota(user.ID)
+	if err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Get user quota failed: %v", err)}
+	}
+	if len(quotaInfo.QuotaList) != 1 || quotaInfo.QuotaList[0].Amount != 70.0 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected 70 remaining of the valid quota, got %+v", quotaInfo.QuotaList)}
+	}
+
+	return TestResult{
+		Passed:    true,
+		Message:   "Mid-Month Expiry Keeps Used Quota Test Succeeded",
+		Duration:  time.Since(startTime),
+		TestName:  "testExpireQuotasTask_MidMonthExpiryKeepsUsedQuota",
+		StartTime: startTime,
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"quota-manager/internal/condition"
+	"quota-manager/internal/models"
+	"quota-manager/internal/services"
+)
+
+// testModelQuotaPools tests that model strategies grant pooled quota which deductions and quota-le honor
+func testModelQuotaPools(ctx *TestContext) TestResult {
+	cleanupMockQuotaStore(ctx)
+
+	user := createTestUser("model_pool_user", "Model Pool User", 0)
+	if err := ctx.DB.AuthDB.Create(user).Error; err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Create user failed: %v", err)}
+	}
+	ctx.MockQuotaStore.SetQuota(user.ID, 0)
+	ctx.MockQuotaStore.SetUsed(user.ID, 0)
+
+	// A strategy with a model grants quota to that model's pool
+	strategy := &models.QuotaStrategy{
+		Name:      "model-pool-test",
+		Title:     "Model Pool Test",
+		Type:      "single",
+		Amount:    40,
+		Model:     "deepseek-v3",
+		Condition: "true()",
+		Status:    true,
+	}
+	if err := ctx.StrategyService.CreateStrategy(strategy); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Create strategy failed: %v", err)}
+	}
+	ctx.StrategyService.ExecStrategy(strategy, []models.UserInfo{*user})
+
+	// General quota and another model's quota
+	if err := ctx.QuotaService.AddQuotaForStrategy(user.ID, 20, 0, "general-strategy"); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Add general quota failed: %v", err)}
+	}
+	if err := ctx.QuotaService.AddModelQuotaForStrategy(user.ID, "qwen-max", 30, time.Now().Add(30*24*time.Hour).Truncate(time.Second), 0, "qwen-strategy"); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Add qwen quota failed: %v", err)}
+	}
+
+	quotaInfo, err := ctx.QuotaService.GetUserQuota(user.ID)
+	if err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Get user quota failed: %v", err)}
+	}
+	if quotaInfo.ModelQuotas["deepseek-v3"] != 40 || quotaInfo.ModelQuotas["qwen-max"] != 30 || len(quotaInfo.ModelQuotas) != 2 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Unexpected model breakdown: %v", quotaInfo.ModelQuotas)}
+	}
+
+	// quota-le with a model compares against the model pool plus the general pool
+	evalCtx := &condition.EvaluationContext{ModelQuotaQuerier: ctx.QuotaService}
+	for _, tc := range []struct {
+		condition string
+		expected  bool
+	}{
+		{`quota-le("deepseek-v3", 60)`, true},
+		{`quota-le("deepseek-v3", 59)`, false},
+		{`quota-le("gpt-4o", 20)`, true},
+	} {
+		match, err := condition.CalcCondition(user, tc.condition, evalCtx)
+		if err != nil || match != tc.expected {
+			return TestResult{Passed: false, Message: fmt.Sprintf("%s expected %v, got %v (%v)", tc.condition, tc.expected, match, err)}
+		}
+	}
+
+	// A model deduction drains the model pool before the general pool
+	if _, err := ctx.QuotaService.DeductQuota(&services.DeductQuotaRequest{
+		UserID:      user.ID,
+		Amount:      50,
+		Model:       "deepseek-v3",
+		ReferenceID: "model-pool-001",
+	}, nil); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Model deduction failed: %v", err)}
+	}
+
+	pools := make(map[string]float64)
+	var rows []models.Quota
+	if err := ctx.DB.Where("user_id = ? AND status = ?", user.ID, models.StatusValid).Find(&rows).Error; err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Load quota failed: %v", err)}
+	}
+	for _, row := range rows {
+		pools[row.Model] += row.Amount
+	}
+	if pools["deepseek-v3"] != 0 || pools[""] != 10 || pools["qwen-max"] != 30 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Unexpected pools after model deduction: %v", pools)}
+	}
+
+	// A deduction without a model cannot use another model's pool
+	if _, err := ctx.QuotaService.DeductQuota(&services.DeductQuotaRequest{
+		UserID:      user.ID,
+		Amount:      15,
+		ReferenceID: "model-pool-002",
+	}, nil); err == nil {
+		return TestResult{Passed: false, Message: "General deduction should not draw from the qwen-max pool"}
+	}
+
+	// Recharge audit records carry the model
+	var audit models.QuotaAudit
+	if err := ctx.DB.Where("user_id = ? AND operation = ? AND strategy_name = ?", user.ID, models.OperationRecharge, strategy.Name).
+		First(&audit).Error; err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Load recharge audit failed: %v", err)}
+	}
+	details, err := audit.UnmarshalDetails()
+	if err != nil || details == nil || details.Model != "deepseek-v3" {
+		return TestResult{Passed: false, Message: "Recharge audit details should contain the model"}
+	}
+
+	return TestResult{Passed: true, Message: "Model Quota Pools Test Succeeded"}
+}
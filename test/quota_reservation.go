@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"quota-manager/internal/models"
+	"quota-manager/internal/response"
+	"quota-manager/internal/services"
+)
+
+// postReservation sends a reservation request authenticated with the given API key
+func postReservation(apiCtx *APITestContext, apiKey, path string, body map[string]interface{}) (*httptest.ResponseRecorder, *services.ReservationResponse) {
+	jsonData, _ := json.Marshal(body)
+	req, _ := http.NewRequest("POST", "/quota-manager/api/v1/quota/reservations"+path, bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-Key", apiKey)
+	w := httptest.NewRecorder()
+	apiCtx.Router.ServeHTTP(w, req)
+
+	var resp struct {
+		response.ResponseData
+		Data *services.ReservationResponse `json:"data"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	return w, resp.Data
+}
+
+// testQuotaReservation tests holding, committing, releasing and expiring quota reservations
+func testQuotaReservation(ctx *TestContext) TestResult {
+	cleanupMockQuotaStore(ctx)
+	apiCtx := setupAPITestContext(ctx)
+
+	user := createTestUser("reservation_user", "Reservation User", 0)
+	if err := ctx.DB.AuthDB.Create(user).Error; err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Create user failed: %v", err)}
+	}
+
+	// Two quota rows, the earliest expiring one is held first
+	earlyExpiry := time.Now().Truncate(time.Second).Add(10 * 24 * time.Hour)
+	lateExpiry := time.Now().Truncate(time.Second).Add(40 * 24 * time.Hour)
+	quotas := []*models.Quota{
+		{UserID: user.ID, Amount: 50, ExpiryDate: earlyExpiry, Status: models.StatusValid},
+		{UserID: user.ID, Amount: 50, ExpiryDate: lateExpiry, Status: models.StatusValid},
+	}
+	for _, quota := range quotas {
+		if err := ctx.DB.Create(quota).Error; err != nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Create quota failed: %v", err)}
+		}
+	}
+	ctx.MockQuotaStore.SetQuota(user.ID, 100)
+	ctx.MockQuotaStore.SetUsed(user.ID, 0)
+
+	key, err := services.NewAPIKeyService(ctx.DB).CreateAPIKey(&services.CreateAPIKeyRequest{
+		Name:   "inference-gateway",
+		Scopes: []string{"quota:deduct"},
+	}, testAdminUserID)
+	if err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Create API key failed: %v", err)}
+	}
+
+	// Hold 70, taking all of the early row and 20 of the late row
+	w, held := postReservation(apiCtx, key.Key, "", map[string]interface{}{
+		"user_id":      user.ID,
+		"amount":       70,
+		"reference_id": "request-001",
+		"reason":       "inference",
+	})
+	if w.Code != http.StatusOK || held == nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Reservation failed with status %d: %s", w.Code, w.Body.String())}
+	}
+	if held.Status != models.ReservationStatusHeld || held.Duplicate {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Unexpected reservation: %+v", held.QuotaReservation)}
+	}
+
+	quotaInfo, err := ctx.QuotaService.GetUserQuota(user.ID)
+	if err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Get user quota failed: %v", err)}
+	}
+	if quotaInfo.HeldQuota != 70 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected held quota 70, got %g", quotaInfo.HeldQuota)}
+	}
+	if len(quotaInfo.QuotaList) != 1 || quotaInfo.QuotaList[0].Amount != 30 || !quotaInfo.QuotaList[0].ExpiryDate.Equal(lateExpiry) {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Held quota should be taken from the earliest expiry first: %+v", quotaInfo.QuotaList)}
+	}
+
+	// Held quota cannot be deducted again
+	if w, _ := postDeductQuota(apiCtx, key.Key, map[string]interface{}{
+		"user_id":      user.ID,
+		"amount":       40,
+		"reference_id": "invoice-held",
+	}); w.Code != http.StatusBadRequest {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected 400 when deducting held quota, got %d", w.Code)}
+	}
+
+	// Repeating the reservation is idempotent
+	if w, again := postReservation(apiCtx, key.Key, "", map[string]interface{}{
+		"user_id":      user.ID,
+		"amount":       70,
+		"reference_id": "request-001",
+		"reason":       "inference",
+	}); w.Code != http.StatusOK || again == nil || !again.Duplicate || again.ID != held.ID {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Repeated reservation should return the original, got %d", w.Code)}
+	}
+
+	// Committing more than held is rejected
+	idPath := fmt.Sprintf("/%d", held.ID)
+	if w, _ := postReservation(apiCtx, key.Key, idPath+"/commit", map[string]interface{}{"amount": 80}); w.Code != http.StatusBadRequest {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected 400 for over-commit, got %d", w.Code)}
+	}
+
+	// Commit 60, returning 10 to the late row
+	w, committed := postReservation(apiCtx, key.Key, idPath+"/commit", map[string]interface{}{"amount": 60})
+	if w.Code != http.StatusOK || committed == nil || committed.Status != models.ReservationStatusCommitted {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Commit failed with status %d: %s", w.Code, w.Body.String())}
+	}
+
+	var lateQuota models.Quota
+	if err := ctx.DB.Where("id = ?", quotas[1].ID).First(&lateQuota).Error; err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Load quota failed: %v", err)}
+	}
+	if lateQuota.Amount != 40 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected 40 left after commit, got %g", lateQuota.Amount)}
+	}
+
+	// Releasing a committed reservation conflicts
+	if w, _ := postReservation(apiCtx, key.Key, idPath+"/release", nil); w.Code != http.StatusConflict {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected 409 when releasing a committed reservation, got %d", w.Code)}
+	}
+
+	// A released reservation returns the whole hold, amounts are held in cents so a retry with more decimals replays
+	secondRequest := map[string]interface{}{
+		"user_id":      user.ID,
+		"amount":       15.005,
+		"reference_id": "request-002",
+	}
+	w, second := postReservation(apiCtx, key.Key, "", secondRequest)
+	if w.Code != http.StatusOK || second == nil || second.Amount != 15 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Second reservation failed with status %d: %s", w.Code, w.Body.String())}
+	}
+	if w, again := postReservation(apiCtx, key.Key, "", secondRequest); w.Code != http.StatusOK || again == nil || !again.Duplicate || again.ID != second.ID {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Retried fractional reservation should replay, got %d: %s", w.Code, w.Body.String())}
+	}
+	if w, released := postReservation(apiCtx, key.Key, fmt.Sprintf("/%d/release", second.ID), nil); w.Code != http.StatusOK || released == nil || released.Status != models.ReservationStatusReleased {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Release failed with status %d: %s", w.Code, w.Body.String())}
+	}
+	if err := ctx.DB.Where("id = ?", quotas[1].ID).First(&lateQuota).Error; err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Load quota failed: %v", err)}
+	}
+	if lateQuota.Amount != 40 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected 40 after release, got %g", lateQuota.Amount)}
+	}
+
+	// Expired holds are released by the scheduler job
+	w, third := postReservation(apiCtx, key.Key, "", map[string]interface{}{
+		"user_id":      user.ID,
+		"amount":       25,
+		"reference_id": "request-003",
+		"ttl_seconds":  1,
+	})
+	if w.Code != http.StatusOK || third == nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Third reservation failed with status %d: %s", w.Code, w.Body.String())}
+	}
+	if err := ctx.DB.Model(&models.QuotaReservation{}).Where("id = ?", third.ID).
+		Update("expires_at", time.Now().Add(-time.Minute)).Error; err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expire reservation failed: %v", err)}
+	}
+	if w, _ := postReservation(apiCtx, key.Key, fmt.Sprintf("/%d/commit", third.ID), map[string]interface{}{"amount": 5}); w.Code != http.StatusConflict {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected 409 when committing an expired reservation, got %d", w.Code)}
+	}
+	released, err := ctx.QuotaService.ReleaseExpiredReservations()
+	if err != nil || released != 1 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected 1 expired reservation released, got %d (%v)", released, err)}
+	}
+	expired, err := ctx.QuotaService.GetReservation(third.ID)
+	if err != nil || expired.Status != models.ReservationStatusExpired {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Reservation should be expired: %+v (%v)", expired, err)}
+	}
+	if err := ctx.DB.Where("id = ?", quotas[1].ID).First(&lateQuota).Error; err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Load quota failed: %v", err)}
+	}
+	if lateQuota.Amount != 40 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected 40 after expiry release, got %g", lateQuota.Amount)}
+	}
+
+	// Each step is audited
+	for _, operation := range []string{models.OperationReserve, models.OperationReserveCommit, models.OperationReserveRelease} {
+		var count int64
+		ctx.DB.Model(&models.QuotaAudit{}).Where("user_id = ? AND operation = ?", user.ID, operation).Count(&count)
+		if count == 0 {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Missing %s audit record", operation)}
+		}
+	}
+
+	return TestResult{Passed: true, Message: "Quota Reservation Test Succeeded"}
+}
+
+// testModelPoolReservation tests that the unused part of a model pool reservation returns to the model pool
+func testModelPoolReservation(ctx *TestContext) TestResult {
+	cleanupMockQuotaStore(ctx)
+	apiCtx := setupAPITestContext(ctx)
+
+	user := createTestUser("reservation_model_user", "Reservation Model User", 0)
+	if err := ctx.DB.AuthDB.Create(user).Error; err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Create user failed: %v", err)}
+	}
+
+	// A model pool row and a general pool row expiring at the same time
+	expiry := time.Now().Truncate(time.Second).Add(20 * 24 * time.Hour)
+	modelQuota := &models.Quota{UserID: user.ID, Model: "deepseek-v3", Amount: 30, ExpiryDate: expiry, Status: models.StatusValid}
+	generalQuota := &models.Quota{UserID: user.ID, Amount: 50, ExpiryDate: expiry, Status: models.StatusValid}
+	for _, quota := range []*models.Quota{modelQuota, generalQuota} {
+		if err := ctx.DB.Create(quota).Error; err != nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Create quota failed: %v", err)}
+		}
+	}
+	ctx.MockQuotaStore.SetQuota(user.ID, 80)
+	ctx.MockQuotaStore.SetUsed(user.ID, 0)
+
+	key, err := services.NewAPIKeyService(ctx.DB).CreateAPIKey(&services.CreateAPIKeyRequest{
+		Name:   "inference-gateway-model",
+		Scopes: []string{"quota:deduct"},
+	}, testAdminUserID)
+	if err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Create API key failed: %v", err)}
+	}
+
+	// Hold 20 of the model pool and commit 5 of it
+	w, held := postReservation(apiCtx, key.Key, "", map[string]interface{}{
+		"user_id":      user.ID,
+		"amount":       20,
+		"model":        "deepseek-v3",
+		"reference_id": "request-model-001",
+	})
+	if w.Code != http.StatusOK || held == nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Reservation failed with status %d: %s", w.Code, w.Body.String())}
+	}
+	w, committed := postReservation(apiCtx, key.Key, fmt.Sprintf("/%d/commit", held.ID), map[string]interface{}{"amount": 5})
+	if w.Code != http.StatusOK || committed == nil || committed.Status != models.ReservationStatusCommitted {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Commit failed with status %d: %s", w.Code, w.Body.String())}
+	}
+
+	// The 15 not committed goes back to the model pool, the general pool is untouched
+	for _, tc := range []struct {
+		quota    *models.Quota
+		expected float64
+	}{{modelQuota, 25}, {generalQuota, 50}} {
+		var quota models.Quota
+		if err := ctx.DB.Where("id = ?", tc.quota.ID).First(&quota).Error; err != nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Load quota failed: %v", err)}
+		}
+		if quota.Amount != tc.expected {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Expected %g in the %q pool after commit, got %g", tc.expected, quota.Model, quota.Amount)}
+		}
+	}
+
+	return TestResult{Passed: true, Message: "Model Pool Reservation Test Succeeded"}
+}
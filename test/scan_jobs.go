@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"time"
+
+	"quota-manager/internal/handlers"
+	"quota-manager/internal/models"
+	"quota-manager/internal/response"
+	"quota-manager/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// serveScanJobs sends a request to the scan and job routes of the scan job service
+func serveScanJobs(ctx *TestContext, scanJobs *services.ScanJobService, method, path string, body interface{}) *httptest.ResponseRecorder {
+	router := gin.New()
+	handler := handlers.NewScanHandler(ctx.StrategyService, nil, nil, ctx.QuotaService, scanJobs)
+	router.POST("/scan", handler.TriggerScan)
+	router.GET("/jobs", handler.ListJobs)
+	router.GET("/jobs/:id", handler.GetJob)
+
+	var reqBody bytes.Buffer
+	if body != nil {
+		_ = json.NewEncoder(&reqBody).Encode(body)
+	}
+	req, _ := http.NewRequest(method, path, &reqBody)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// waitForScanJob polls a job through the API until it is no longer running
+func waitForScanJob(ctx *TestContext, scanJobs *services.ScanJobService, id int) (*models.ScanJob, error) {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		w := serveScanJobs(ctx, scanJobs, "GET", "/jobs/"+strconv.Itoa(id), nil)
+		if w.Code != http.StatusOK {
+			return nil, fmt.Errorf("status %d: %s", w.Code, w.Body.String())
+		}
+		var resp struct {
+			response.ResponseData
+			Data models.ScanJob `json:"data"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			return nil, err
+		}
+		if resp.Data.Status != models.ScanJobStatusRunning {
+			return &resp.Data, nil
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return nil, fmt.Errorf("job %d is still running", id)
+}
+
+// testScanJobs tests that triggered scans run as tracked jobs and that a job type runs once at a time
+func testScanJobs(ctx *TestContext) TestResult {
+	scanJobs := services.NewScanJobService(ctx.DB, "scan-jobs-test")
+
+	// A running job reports its progress and blocks a second job of its type
+	release := make(chan struct{})
+	running, err := scanJobs.StartJob(models.ScanJobTypeStrategy, "tester", func(progress *services.ScanJobProgress) error {
+		progress.AddTotal(2)
+		progress.Processed()
+		progress.Failed()
+		<-release
+		return nil
+	})
+	if err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Start job failed: %v", err)}
+	}
+
+	w := serveScanJobs(ctx, scanJobs, "POST", "/scan", map[string]string{"type": models.ScanJobTypeStrategy})
+	if w.Code != http.StatusConflict {
+		close(release)
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected 409 while a strategy scan runs, got %d: %s", w.Code, w.Body.String())}
+	}
+
+	close(release)
+	job, err := waitForScanJob(ctx, scanJobs, running.ID)
+	if err != nil || job.Status != models.ScanJobStatusSucceeded || job.Total != 2 || job.Processed != 1 || job.Failed != 1 ||
+		job.EndTime == nil || job.TriggeredBy != "tester" || job.Instance != "scan-jobs-test" {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Unexpected finished job: %+v, %v", job, err)}
+	}
+
+	// Once the first job finished, the scan can be triggered and returns its job
+	w = serveScanJobs(ctx, scanJobs, "POST", "/scan", map[string]string{"type": models.ScanJobTypeStrategy})
+	var started struct {
+		response.ResponseData
+		Data models.ScanJob `json:"data"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &started)
+	if w.Code != http.StatusAccepted || started.Data.ID == 0 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected 202 with a job, got %d: %s", w.Code, w.Body.String())}
+	}
+	if job, err = waitForScanJob(ctx, scanJobs, started.Data.ID); err != nil || job.Status != models.ScanJobStatusSucceeded {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Triggered scan should succeed: %+v, %v", job, err)}
+	}
+
+	// A failing job records its error
+	failing, err := scanJobs.StartJob(models.ScanJobTypeExpireQuotas, "", func(*services.ScanJobProgress) error {
+		return errors.New("expiry failed")
+	})
+	if err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Start failing job failed: %v", err)}
+	}
+	if job, err = waitForScanJob(ctx, scanJobs, failing.ID); err != nil || job.Status != models.ScanJobStatusFailed || job.Error != "expiry failed" {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected a failed job: %+v, %v", job, err)}
+	}
+
+	// Scheduled jobs run in place and are skipped while a job of their type runs
+	release = make(chan struct{})
+	blocking, err := scanJobs.StartJob(models.ScanJobTypeExpireQuotas, "tester", func(*services.ScanJobProgress) error {
+		<-release
+		return nil
+	})
+	if err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Start blocking job failed: %v", err)}
+	}
+	scheduledRuns := 0
+	err = scanJobs.RunJob(models.ScanJobTypeExpireQuotas, "scheduler", func(*services.ScanJobProgress) error {
+		scheduledRuns++
+		return nil
+	})
+	var serviceErr *services.ServiceError
+	if !errors.As(err, &serviceErr) || serviceErr.Code != services.ErrorConflict || scheduledRuns != 0 {
+		close(release)
+		return TestResult{Passed: false, Message: fmt.Sprintf("Scheduled job should conflict with a running one, ran %d times: %v", scheduledRuns, err)}
+	}
+	close(release)
+	if _, err := waitForScanJob(ctx, scanJobs, blocking.ID); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Wait for job failed: %v", err)}
+	}
+	err = scanJobs.RunJob(models.ScanJobTypeExpireQuotas, "scheduler", func(progress *services.ScanJobProgress) error {
+		scheduledRuns++
+		progress.AddTotal(1)
+		progress.Processed()
+		return nil
+	})
+	var scheduled models.ScanJob
+	if err != nil || scheduledRuns != 1 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Scheduled job should run once the other finished, ran %d times: %v", scheduledRuns, err)}
+	}
+	if err := ctx.DB.Where("type = ? AND triggered_by = ?", models.ScanJobTypeExpireQuotas, "scheduler").First(&scheduled).Error; err != nil ||
+		scheduled.Status != models.ScanJobStatusSucceeded || scheduled.Processed != 1 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Unexpected scheduled job: %+v, %v", scheduled, err)}
+	}
+
+	// A running job that stopped reporting progress no longer blocks its type
+	stale := &models.ScanJob{Type: models.ScanJobTypeSyncQuotas, Status: models.ScanJobStatusRunning, StartTime: time.Now().Add(-time.Hour)}
+	if err := ctx.DB.Create(stale).Error; err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Create stale job failed: %v", err)}
+	}
+	if err := ctx.DB.Model(stale).UpdateColumn("update_time", time.Now().Add(-time.Hour)).Error; err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Backdate stale job failed: %v", err)}
+	}
+	resumed, err := scanJobs.StartJob(models.ScanJobTypeSyncQuotas, "", func(*services.ScanJobProgress) error { return nil })
+	if err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Stale job should not block a new one: %v", err)}
+	}
+	if _, err := waitForScanJob(ctx, scanJobs, resumed.ID); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Wait for job failed: %v", err)}
+	}
+	if err := ctx.DB.First(stale, stale.ID).Error; err != nil || stale.Status != models.ScanJobStatusFailed {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Stale job should be marked failed: %+v, %v", stale, err)}
+	}
+
+	// Jobs are listed latest first and filtered by type
+	w = serveScanJobs(ctx, scanJobs, "GET", "/jobs?type="+models.ScanJobTypeStrategy, nil)
+	var listed struct {
+		response.ResponseData
+		Data struct {
+			Total   int64            `json:"total"`
+			Records []models.ScanJob `json:"records"`
+		} `json:"data"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &listed)
+	if w.Code != http.StatusOK || listed.Data.Total < 2 || len(listed.Data.Records) < 2 || listed.Data.Records[0].ID != started.Data.ID {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Unexpected job listing, status %d: %s", w.Code, w.Body.String())}
+	}
+
+	if w := serveScanJobs(ctx, scanJobs, "GET", "/jobs/999999", nil); w.Code != http.StatusNotFound {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected 404 for unknown job, got %d", w.Code)}
+	}
+
+	return TestResult{Passed: true, Message: "Scan Jobs Test Succeeded"}
+}
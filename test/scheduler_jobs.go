@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"quota-manager/internal/handlers"
+	"quota-manager/internal/models"
+	"quota-manager/internal/response"
+	"quota-manager/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/robfig/cron/v3"
+)
+
+// serveSchedulerJobs sends a request to the scheduler job routes of the registry
+func serveSchedulerJobs(jobs *services.JobRegistry, method, path string) *httptest.ResponseRecorder {
+	router := gin.New()
+	handler := handlers.NewSchedulerHandler(nil, jobs)
+	router.GET("/scheduler/jobs", handler.ListJobs)
+	router.POST("/scheduler/jobs/:name/pause", handler.PauseJob)
+	router.POST("/scheduler/jobs/:name/resume", handler.ResumeJob)
+
+	req, _ := http.NewRequest(method, path, nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// listSchedulerJobs lists the jobs of the registry through the API
+func listSchedulerJobs(jobs *services.JobRegistry) (map[string]services.SchedulerJobInfo, error) {
+	w := serveSchedulerJobs(jobs, "GET", "/scheduler/jobs")
+	if w.Code != http.StatusOK {
+		return nil, fmt.Errorf("status %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		response.ResponseData
+		Data []services.SchedulerJobInfo `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		return nil, err
+	}
+	byName := make(map[string]services.SchedulerJobInfo, len(resp.Data))
+	for _, job := range resp.Data {
+		byName[job.Name] = job
+	}
+	return byName, nil
+}
+
+// testSchedulerJobs tests listing, pausing and resuming scheduled jobs and previewing cron expressions
+func testSchedulerJobs(ctx *TestContext) TestResult {
+	apiCtx := setupAPITestContext(ctx)
+
+	// The preview lists the next fire times of an expression
+	body, _ := json.Marshal(map[string]interface{}{"expression": "0 0 0 1 * *", "count": 3})
+	req, _ := http.NewRequest("POST", "/quota-manager/api/v1/strategies/cron-preview", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	apiCtx.Router.ServeHTTP(w, req)
+	var previewResp struct {
+		response.ResponseData
+		Data services.CronPreview `json:"data"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &previewResp)
+	if w.Code != http.StatusOK || len(previewResp.Data.FireTimes) != 3 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Unexpected cron preview, status %d: %s", w.Code, w.Body.String())}
+	}
+	for i, fire := range previewResp.Data.FireTimes {
+		if !fire.After(time.Now()) || (i > 0 && !fire.After(previewResp.Data.FireTimes[i-1])) {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Fire times should be future and increasing: %v", previewResp.Data.FireTimes)}
+		}
+	}
+
+	body, _ = json.Marshal(map[string]interface{}{"expression": "0 0 0 32 * *"})
+	req, _ = http.NewRequest("POST", "/quota-manager/api/v1/strategies/cron-preview", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	apiCtx.Router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected 400 for invalid expression, got %d", w.Code)}
+	}
+
+	// A tracked job is listed with its fire times
+	const jobName = "scheduler-jobs-test"
+	defer ctx.DB.Where("name = ?", jobName).Delete(&models.SchedulerJob{})
+
+	jobs := services.NewJobRegistry(ctx.DB, ctx.QuotaService.GetConfigManager().GetDirect())
+	runs := 0
+	var jobErr error
+	c := cron.New(cron.WithSeconds())
+	entryID, err := c.AddFunc("0 0 0 1 1 *", jobs.Wrap(jobName, func() error {
+		runs++
+		return jobErr
+	}))
+	if err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Add cron job failed: %v", err)}
+	}
+	jobs.Track(jobName, "0 0 0 1 1 *", c, entryID)
+	runJob := c.Entry(entryID).Job.Run
+
+	listed, err := listSchedulerJobs(jobs)
+	if err != nil || listed[jobName].NextRun == nil || listed[jobName].PreviousRun == nil || listed[jobName].Paused || listed[jobName].LastRun != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Unexpected job listing: %+v, %v", listed, err)}
+	}
+
+	runJob()
+	listed, _ = listSchedulerJobs(jobs)
+	if runs != 1 || listed[jobName].LastRun == nil || listed[jobName].LastRun.Outcome != models.SchedulerJobOutcomeSucceeded {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected a succeeded run: %+v", listed[jobName])}
+	}
+
+	// Paused jobs are skipped until resumed
+	if w := serveSchedulerJobs(jobs, "POST", "/scheduler/jobs/"+jobName+"/pause"); w.Code != http.StatusOK {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Pause failed with status %d: %s", w.Code, w.Body.String())}
+	}
+	runJob()
+	if runs != 1 {
+		return TestResult{Passed: false, Message: "Paused job should not run"}
+	}
+
+	if w := serveSchedulerJobs(jobs, "POST", "/scheduler/jobs/"+jobName+"/resume"); w.Code != http.StatusOK {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Resume failed with status %d: %s", w.Code, w.Body.String())}
+	}
+	jobErr = errors.New("job failed")
+	runJob()
+	listed, _ = listSchedulerJobs(jobs)
+	if runs != 2 || listed[jobName].Paused || listed[jobName].LastRun == nil ||
+		listed[jobName].LastRun.Outcome != models.SchedulerJobOutcomeFailed || listed[jobName].LastRun.Error != "job failed" {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected a failed run after resuming: %+v", listed[jobName])}
+	}
+
+	if w := serveSchedulerJobs(jobs, "POST", "/scheduler/jobs/unknown-job/pause"); w.Code != http.StatusNotFound {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected 404 for unknown job, got %d", w.Code)}
+	}
+
+	return TestResult{Passed: true, Message: "Scheduler Jobs Test Succeeded"}
+}
@@ -145,7 +145,7 @@ func testStrategyStatusControl(ctx *TestContext) TestResult {
 		return TestResult{Passed: false, Message: fmt.Sprintf("Create strategy failed: %v", err)}
 	}
 	// Then disable it
-	if err := ctx.StrategyService.DisableStrategy(strategy.ID); err != nil {
+	if err := ctx.StrategyService.DisableStrategy(strategy.ID, ""); err != nil {
 		return TestResult{Passed: false, Message: fmt.Sprintf("Disable strategy failed: %v", err)}
 	}
 
@@ -165,7 +165,7 @@ func testStrategyStatusControl(ctx *TestContext) TestResult {
 	}
 
 	// Enable strategy
-	if err := ctx.StrategyService.EnableStrategy(strategy.ID); err != nil {
+	if err := ctx.StrategyService.EnableStrategy(strategy.ID, ""); err != nil {
 		return TestResult{Passed: false, Message: fmt.Sprintf("Enable strategy failed: %v", err)}
 	}
 
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"quota-manager/internal/models"
+	"quota-manager/internal/utils"
+)
+
+// testStrategyAmountExpr tests that strategies with an amount expression grant amounts computed per user
+func testStrategyAmountExpr(ctx *TestContext) TestResult {
+	cleanupMockQuotaStore(ctx)
+	apiCtx := setupAPITestContext(ctx)
+
+	vipUser := createTestUser("amount_expr_vip", "Amount Expr VIP", 3)
+	heavyUser := createTestUser("amount_expr_heavy", "Amount Expr Heavy", 0)
+	idleUser := createTestUser("amount_expr_idle", "Amount Expr Idle", 0)
+	for _, u := range []*models.UserInfo{vipUser, heavyUser, idleUser} {
+		if err := ctx.DB.AuthDB.Create(u).Error; err != nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Create user failed: %v", err)}
+		}
+	}
+
+	// Last month's usage of the heavy user, 1.2 times of it exceeds the cap
+	now := utils.NowInConfigTimezone(ctx.QuotaService.GetConfigManager().GetDirect())
+	lastMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()).AddDate(0, -1, 0)
+	usage := &models.MonthlyQuotaUsage{UserID: heavyUser.ID, YearMonth: lastMonth.Format("2006-01"), UsedQuota: 4500, RecordTime: now}
+	if err := ctx.DB.Create(usage).Error; err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Create monthly usage failed: %v", err)}
+	}
+	defer ctx.DB.Delete(usage)
+
+	// Invalid expressions are rejected on creation
+	body, _ := json.Marshal(map[string]interface{}{
+		"name":        "amount-expr-invalid",
+		"title":       "Amount Expr Invalid",
+		"type":        "single",
+		"amount":      10,
+		"amount_expr": "vip * ",
+		"condition":   "true()",
+	})
+	req, _ := http.NewRequest("POST", "/quota-manager/api/v1/strategies", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	apiCtx.Router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected 400 for invalid amount expression, got %d: %s", w.Code, w.Body.String())}
+	}
+
+	strategy := &models.QuotaStrategy{
+		Name:       "amount-expr-strategy",
+		Title:      "Amount Expr Strategy",
+		Type:       "single",
+		Amount:     1,
+		AmountExpr: "vip * 100 + min(monthly-usage() * 1.2, 5000)",
+		Condition:  fmt.Sprintf(`match-user("%s", "%s", "%s")`, vipUser.ID, heavyUser.ID, idleUser.ID),
+		Status:     true,
+	}
+	if err := ctx.StrategyService.CreateStrategy(strategy); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Create strategy failed: %v", err)}
+	}
+	expected := map[string]float64{vipUser.ID: 300, heavyUser.ID: 5000}
+
+	// The preview shows the amount computed for each user, users computed nothing are not granted
+	w, preview := postStrategyPreview(apiCtx, map[string]interface{}{"strategy_id": strategy.ID})
+	if w.Code != http.StatusOK || preview == nil || preview.MatchedUsers != 3 || preview.TotalAmount != 5300 || len(preview.Samples) != 2 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Unexpected preview, status %d: %s", w.Code, w.Body.String())}
+	}
+	for _, sample := range preview.Samples {
+		if sample.Amount != expected[sample.UserID] {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Unexpected preview amount for %s: %v", sample.UserID, sample.Amount)}
+		}
+	}
+
+	ctx.StrategyService.ExecStrategy(strategy, []models.UserInfo{*vipUser, *heavyUser, *idleUser})
+
+	for userID, amount := range expected {
+		if err := verifyUserQuotaAmountByStatus(ctx, userID, models.StatusValid, amount); err != nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Quota verification failed: %v", err)}
+		}
+
+		var execute models.QuotaExecute
+		if err := ctx.DB.Where("strategy_id = ? AND user_id = ?", strategy.ID, userID).First(&execute).Error; err != nil || execute.Amount != amount {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Expected execution of %v: %+v, %v", amount, execute, err)}
+		}
+
+		var audit models.QuotaAudit
+		if err := ctx.DB.Where("user_id = ? AND strategy_id = ?", userID, strategy.ID).First(&audit).Error; err != nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Query audit record failed: %v", err)}
+		}
+		details, err := audit.UnmarshalDetails()
+		if err != nil || audit.Amount != amount || details.AmountExpr != strategy.AmountExpr {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Unexpected audit record: %+v, %+v, %v", audit, details, err)}
+		}
+	}
+
+	var idleCount int64
+	ctx.DB.Model(&models.QuotaExecute{}).Where("strategy_id = ? AND user_id = ?", strategy.ID, idleUser.ID).Count(&idleCount)
+	if idleCount != 0 {
+		return TestResult{Passed: false, Message: "Users computed a zero amount should not be granted"}
+	}
+
+	var run models.StrategyRun
+	if err := ctx.DB.Where("strategy_id = ?", strategy.ID).First(&run).Error; err != nil ||
+		run.UsersMatched != 3 || run.UsersGranted != 2 || run.TotalAmount != 5300 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Unexpected run statistics: %+v, %v", run, err)}
+	}
+
+	return TestResult{Passed: true, Message: "Strategy Amount Expression Test Succeeded"}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"quota-manager/internal/models"
+	"quota-manager/internal/response"
+)
+
+// testStrategyWindowAndBudget tests that strategies grant nothing outside their validity window
+// and stop granting once their total budget is used up
+func testStrategyWindowAndBudget(ctx *TestContext) TestResult {
+	cleanupMockQuotaStore(ctx)
+	apiCtx := setupAPITestContext(ctx)
+
+	users := make([]models.UserInfo, 4)
+	for i := range users {
+		users[i] = *createTestUser(fmt.Sprintf("strategy_budget_user_%d", i), "Strategy Budget User", 0)
+		if err := ctx.DB.AuthDB.Create(&users[i]).Error; err != nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Create user failed: %v", err)}
+		}
+	}
+
+	// Strategies outside their window grant nothing
+	future := time.Now().Add(24 * time.Hour)
+	past := time.Now().Add(-time.Hour)
+	notStarted := &models.QuotaStrategy{
+		Name:      "window-not-started",
+		Title:     "Window Not Started",
+		Type:      "single",
+		Amount:    10,
+		Condition: "true()",
+		StartTime: &future,
+		Status:    true,
+	}
+	ended := &models.QuotaStrategy{
+		Name:      "window-ended",
+		Title:     "Window Ended",
+		Type:      "single",
+		Amount:    10,
+		Condition: "true()",
+		EndTime:   &past,
+		Status:    true,
+	}
+	for _, strategy := range []*models.QuotaStrategy{notStarted, ended} {
+		if err := ctx.StrategyService.CreateStrategy(strategy); err != nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Create strategy failed: %v", err)}
+		}
+		ctx.StrategyService.ExecStrategy(strategy, users)
+
+		var executeCount int64
+		ctx.DB.Model(&models.QuotaExecute{}).Where("strategy_id = ?", strategy.ID).Count(&executeCount)
+		if executeCount != 0 {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Strategy %s outside its window executed %d times", strategy.Name, executeCount)}
+		}
+	}
+
+	// A window ending before it starts is rejected
+	body, _ := json.Marshal(map[string]interface{}{
+		"name":       "window-invalid",
+		"title":      "Window Invalid",
+		"type":       "single",
+		"amount":     10,
+		"condition":  "true()",
+		"start_time": future,
+		"end_time":   past,
+	})
+	req, _ := http.NewRequest("POST", "/quota-manager/api/v1/strategies", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	apiCtx.Router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected 400 for invalid window, got %d: %s", w.Code, w.Body.String())}
+	}
+
+	// Grants stop once the total budget is used up
+	budgeted := &models.QuotaStrategy{
+		Name:        "budget-capped",
+		Title:       "Budget Capped",
+		Type:        "single",
+		Amount:      10,
+		Condition:   "true()",
+		StartTime:   &past,
+		EndTime:     &future,
+		TotalBudget: 25,
+		Status:      true,
+	}
+	if err := ctx.StrategyService.CreateStrategy(budgeted); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Create budgeted strategy failed: %v", err)}
+	}
+	ctx.StrategyService.ExecStrategy(budgeted, users)
+
+	var granted int64
+	ctx.DB.Model(&models.QuotaExecute{}).Where("strategy_id = ? AND status = ?", budgeted.ID, models.ExecuteStatusCompleted).Count(&granted)
+	if granted != 2 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected 2 grants within the budget, got %d", granted)}
+	}
+
+	var run models.StrategyRun
+	if err := ctx.DB.Where("strategy_id = ?", budgeted.ID).First(&run).Error; err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Query strategy run failed: %v", err)}
+	}
+	if run.UsersGranted != 2 || run.TotalAmount != 20 || run.SkippedBudget < 1 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Unexpected budget run statistics: %+v", run)}
+	}
+
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/quota-manager/api/v1/strategies/%d", budgeted.ID), nil)
+	w = httptest.NewRecorder()
+	apiCtx.Router.ServeHTTP(w, req)
+	var resp struct {
+		response.ResponseData
+		Data models.QuotaStrategy `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Get strategy failed with status %d: %s", w.Code, w.Body.String())}
+	}
+	if resp.Data.UsedBudget != 20 || resp.Data.RemainingBudget == nil || *resp.Data.RemainingBudget != 5 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Unexpected budget in strategy: %s", w.Body.String())}
+	}
+
+	// A failed grant returns its reservation
+	restoreFunc := ctx.UseFailServer()
+	ctx.StrategyService.ExecStrategy(budgeted, users)
+	restoreFunc()
+	if err := ctx.DB.First(budgeted, budgeted.ID).Error; err != nil || budgeted.UsedBudget != 20 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Failed grants should not use the budget, used %v: %v", budgeted.UsedBudget, err)}
+	}
+
+	return TestResult{Passed: true, Message: "Strategy Window and Budget Test Succeeded"}
+}
+
+// testStrategyBudgetComputedAmounts tests that a computed amount exceeding the remaining budget
+// skips only its user, smaller amounts of the same run are still granted
+func testStrategyBudgetComputedAmounts(ctx *TestContext) TestResult {
+	cleanupMockQuotaStore(ctx)
+
+	// The large amount goes first and does not fit the budget on its own
+	users := []models.UserInfo{
+		*createTestUser("budget_expr_large", "Budget Expr Large", 5),
+		*createTestUser("budget_expr_small_1", "Budget Expr Small 1", 1),
+		*createTestUser("budget_expr_small_2", "Budget Expr Small 2", 1),
+	}
+	for i := range users {
+		if err := ctx.DB.AuthDB.Create(&users[i]).Error; err != nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Create user failed: %v", err)}
+		}
+	}
+
+	strategy := &models.QuotaStrategy{
+		Name:        "budget-computed-amounts",
+		Title:       "Budget Computed Amounts",
+		Type:        "single",
+		Amount:      1,
+		AmountExpr:  "vip * 10",
+		Condition:   "true()",
+		TotalBudget: 25,
+		Status:      true,
+	}
+	if err := ctx.StrategyService.CreateStrategy(strategy); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Create strategy failed: %v", err)}
+	}
+	ctx.StrategyService.ExecStrategy(strategy, users)
+
+	for _, user := range users[1:] {
+		if err := verifyUserQuotaAmountByStatus(ctx, user.ID, models.StatusValid, 10); err != nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Small amount should be granted within the budget: %v", err)}
+		}
+	}
+	var executeCount int64
+	ctx.DB.Model(&models.QuotaExecute{}).Where("strategy_id = ? AND user_id = ?", strategy.ID, users[0].ID).Count(&executeCount)
+	if executeCount != 0 {
+		return TestResult{Passed: false, Message: "Amount exceeding the budget should not be granted"}
+	}
+
+	var run models.StrategyRun
+	if err := ctx.DB.Where("strategy_id = ?", strategy.ID).First(&run).Error; err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Query strategy run failed: %v", err)}
+	}
+	if run.UsersGranted != 2 || run.TotalAmount != 20 || run.SkippedBudget != 1 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Unexpected budget run statistics: %+v", run)}
+	}
+	if err := ctx.DB.First(strategy, strategy.ID).Error; err != nil || strategy.UsedBudget != 20 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected used budget 20, got %v: %v", strategy.UsedBudget, err)}
+	}
+
+	return TestResult{Passed: true, Message: "Strategy Budget Computed Amounts Test Succeeded"}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"quota-manager/internal/models"
+	"quota-manager/internal/response"
+	"quota-manager/internal/services"
+)
+
+// publishStrategyEvent publishes a user event through the API
+func publishStrategyEvent(apiCtx *APITestContext, event map[string]interface{}) (*httptest.ResponseRecorder, services.StrategyEventResult) {
+	body, _ := json.Marshal(event)
+	req, _ := http.NewRequest("POST", "/quota-manager/api/v1/events", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	apiCtx.Router.ServeHTTP(w, req)
+
+	var resp struct {
+		response.ResponseData
+		Data services.StrategyEventResult `json:"data"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	return w, resp.Data
+}
+
+// testStrategyEvents tests that event-triggered strategies are evaluated for the user of the event only
+func testStrategyEvents(ctx *TestContext) TestResult {
+	cleanupMockQuotaStore(ctx)
+	apiCtx := setupAPITestContext(ctx)
+
+	user := createTestUser("strategy_event_user", "Strategy Event User", 0)
+	other := createTestUser("strategy_event_other", "Strategy Event Other", 0)
+	for _, u := range []*models.UserInfo{user, other} {
+		if err := ctx.DB.AuthDB.Create(u).Error; err != nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Create user failed: %v", err)}
+		}
+	}
+
+	welcome := &models.QuotaStrategy{
+		Name:         "event-welcome",
+		Title:        "Event Welcome",
+		Type:         "single",
+		Amount:       15,
+		Condition:    "true()",
+		TriggerEvent: models.StrategyEventUserRegistered,
+		Status:       true,
+	}
+	starred := &models.QuotaStrategy{
+		Name:         "event-star",
+		Title:        "Event Star",
+		Type:         "single",
+		Amount:       5,
+		Condition:    `match-user("nobody")`,
+		TriggerEvent: models.StrategyEventGithubStarAdded,
+		Status:       true,
+	}
+	for _, strategy := range []*models.QuotaStrategy{welcome, starred} {
+		if err := ctx.StrategyService.CreateStrategy(strategy); err != nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Create strategy failed: %v", err)}
+		}
+	}
+
+	// Only single strategies can be triggered by events
+	periodic := &models.QuotaStrategy{
+		Name:         "event-periodic",
+		Title:        "Event Periodic",
+		Type:         "periodic",
+		Amount:       5,
+		PeriodicExpr: "0 0 0 1 1 *",
+		Condition:    "true()",
+		TriggerEvent: models.StrategyEventUserRegistered,
+	}
+	if err := ctx.StrategyService.CreateStrategy(periodic); err == nil {
+		return TestResult{Passed: false, Message: "Periodic strategy with a trigger event should be rejected"}
+	}
+	unknown := &models.QuotaStrategy{
+		Name:         "event-unknown",
+		Title:        "Event Unknown",
+		Type:         "single",
+		Amount:       5,
+		Condition:    "true()",
+		TriggerEvent: "user_deleted",
+	}
+	if err := ctx.StrategyService.CreateStrategy(unknown); err == nil {
+		return TestResult{Passed: false, Message: "Strategy with an unknown trigger event should be rejected"}
+	}
+
+	// The event grants the user right away, the other user is not evaluated
+	w, result := publishStrategyEvent(apiCtx, map[string]interface{}{"event": "user_registered", "user_id": user.ID})
+	if w.Code != http.StatusOK || len(result.Strategies) != 1 || result.Strategies[0].StrategyID != welcome.ID ||
+		result.Strategies[0].Outcome != services.StrategyEventOutcomeGranted {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Unexpected event result, status %d: %s", w.Code, w.Body.String())}
+	}
+	if err := verifyUserQuotaAmountByStatus(ctx, user.ID, models.StatusValid, 15); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Quota verification failed: %v", err)}
+	}
+
+	var run models.StrategyRun
+	if err := ctx.DB.Where("strategy_id = ?", welcome.ID).First(&run).Error; err != nil ||
+		run.Trigger != models.StrategyRunTriggerEvent || run.UsersEvaluated != 1 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Unexpected event run: %+v, %v", run, err)}
+	}
+
+	// Scans catch up users whose event was missed without granting the event user again
+	ctx.StrategyService.TraverseSingleStrategies()
+	if err := verifyUserQuotaAmountByStatus(ctx, other.ID, models.StatusValid, 15); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Scan should grant the user whose event was missed: %v", err)}
+	}
+	var executeCount int64
+	ctx.DB.Model(&models.QuotaExecute{}).Where("strategy_id = ? AND user_id = ?", welcome.ID, user.ID).Count(&executeCount)
+	if executeCount != 1 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected 1 execution for the event user, got %d", executeCount)}
+	}
+
+	// A repeated event does not grant twice
+	w, result = publishStrategyEvent(apiCtx, map[string]interface{}{"event": "user_registered", "employee_number": user.EmployeeNumber})
+	if w.Code != http.StatusOK || len(result.Strategies) != 1 || result.Strategies[0].Outcome != services.StrategyEventOutcomeAlreadyGranted {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Unexpected repeated event result, status %d: %s", w.Code, w.Body.String())}
+	}
+
+	w, result = publishStrategyEvent(apiCtx, map[string]interface{}{"event": "github_star_added", "github_id": user.GithubID})
+	if w.Code != http.StatusOK || len(result.Strategies) != 1 || result.Strategies[0].Outcome != services.StrategyEventOutcomeNotMatched {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Unexpected star event result, status %d: %s", w.Code, w.Body.String())}
+	}
+
+	// Unknown users and invalid events are rejected
+	if w, _ := publishStrategyEvent(apiCtx, map[string]interface{}{"event": "user_registered", "employee_number": "EMP_UNKNOWN"}); w.Code != http.StatusNotFound {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected 404 for unknown user, got %d", w.Code)}
+	}
+	if w, _ := publishStrategyEvent(apiCtx, map[string]interface{}{"event": "user_deleted", "user_id": user.ID}); w.Code != http.StatusBadRequest {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected 400 for unknown event, got %d", w.Code)}
+	}
+	if w, _ := publishStrategyEvent(apiCtx, map[string]interface{}{"event": "user_registered"}); w.Code != http.StatusBadRequest {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected 400 for event without user, got %d", w.Code)}
+	}
+
+	return TestResult{Passed: true, Message: "Strategy Events Test Succeeded"}
+}
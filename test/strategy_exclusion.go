@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"quota-manager/internal/models"
+)
+
+// testStrategyExclusion tests that a user gets at most one grant per exclusion group and period, chosen by priority
+func testStrategyExclusion(ctx *TestContext) TestResult {
+	cleanupMockQuotaStore(ctx)
+	apiCtx := setupAPITestContext(ctx)
+
+	vipUser := createTestUser("exclusion_vip", "Exclusion VIP", 3)
+	plainUser := createTestUser("exclusion_plain", "Exclusion Plain", 0)
+	for _, u := range []*models.UserInfo{vipUser, plainUser} {
+		if err := ctx.DB.AuthDB.Create(u).Error; err != nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Create user failed: %v", err)}
+		}
+	}
+
+	// The lower priority strategy is created first, priority decides the order
+	employee := &models.QuotaStrategy{
+		Name:           "exclusion-employee-monthly",
+		Title:          "Exclusion Employee Monthly",
+		Type:           "single",
+		Amount:         50,
+		Condition:      fmt.Sprintf(`match-user("%s", "%s")`, vipUser.ID, plainUser.ID),
+		Priority:       5,
+		ExclusionGroup: "exclusion-monthly",
+		Status:         true,
+	}
+	vip := &models.QuotaStrategy{
+		Name:           "exclusion-vip-monthly",
+		Title:          "Exclusion VIP Monthly",
+		Type:           "single",
+		Amount:         100,
+		Condition:      fmt.Sprintf(`match-user("%s")`, vipUser.ID),
+		Priority:       10,
+		ExclusionGroup: "exclusion-monthly",
+		Status:         true,
+	}
+	for _, strategy := range []*models.QuotaStrategy{employee, vip} {
+		if err := ctx.StrategyService.CreateStrategy(strategy); err != nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Create strategy failed: %v", err)}
+		}
+	}
+
+	ctx.StrategyService.TraverseSingleStrategies()
+
+	if err := verifyUserQuotaAmountByStatus(ctx, vipUser.ID, models.StatusValid, 100); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("VIP user should only get the higher priority grant: %v", err)}
+	}
+	if err := verifyUserQuotaAmountByStatus(ctx, plainUser.ID, models.StatusValid, 50); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Plain user should get the employee grant: %v", err)}
+	}
+
+	// The resolved conflict is recorded in the run of the skipped strategy
+	runs, _, err := ctx.StrategyService.GetStrategyRuns(employee.ID, 1, 10)
+	if err != nil || len(runs) != 1 || runs[0].UsersGranted != 1 || runs[0].SkippedExclusion != 1 || len(runs[0].Conflicts) != 1 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Unexpected employee runs: %+v, %v", runs, err)}
+	}
+	if conflict := runs[0].Conflicts[0]; conflict.UserID != vipUser.ID || conflict.StrategyID != vip.ID || conflict.Priority != 10 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Unexpected exclusion conflict: %+v", conflict)}
+	}
+
+	// Periodic members skip users granted by the group within the current period
+	periodic := &models.QuotaStrategy{
+		Name:           "exclusion-periodic-monthly",
+		Title:          "Exclusion Periodic Monthly",
+		Type:           "periodic",
+		Amount:         30,
+		PeriodicExpr:   "0 0 0 1 * *",
+		Condition:      "true()",
+		ExclusionGroup: "exclusion-monthly",
+		Status:         true,
+	}
+	if err := ctx.StrategyService.CreateStrategy(periodic); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Create periodic strategy failed: %v", err)}
+	}
+	defer ctx.StrategyService.DeleteStrategy(periodic.ID)
+
+	w, preview := postStrategyPreview(apiCtx, map[string]interface{}{"strategy_id": periodic.ID})
+	if w.Code != http.StatusOK || preview == nil || preview.SkippedExclusion != 2 || preview.MatchedUsers != 0 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Unexpected preview, status %d: %s", w.Code, w.Body.String())}
+	}
+
+	ctx.StrategyService.ExecStrategy(periodic, []models.UserInfo{*vipUser, *plainUser})
+	var executeCount int64
+	ctx.DB.Model(&models.QuotaExecute{}).Where("strategy_id = ?", periodic.ID).Count(&executeCount)
+	if executeCount != 0 {
+		return TestResult{Passed: false, Message: "Periodic strategy should skip users granted by its exclusion group"}
+	}
+
+	// Leaving the group removes the restriction
+	if err := ctx.StrategyService.UpdateStrategy(periodic.ID, map[string]interface{}{"exclusion_group": ""}); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Update exclusion group failed: %v", err)}
+	}
+	current, err := ctx.StrategyService.GetStrategy(periodic.ID)
+	if err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Get strategy failed: %v", err)}
+	}
+	ctx.StrategyService.ExecStrategy(current, []models.UserInfo{*vipUser, *plainUser})
+	if err := verifyUserQuotaAmountByStatus(ctx, plainUser.ID, models.StatusValid, 80); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Strategy outside the group should grant: %v", err)}
+	}
+
+	return TestResult{Passed: true, Message: "Strategy Exclusion Test Succeeded"}
+}
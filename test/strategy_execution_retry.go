@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"quota-manager/internal/models"
+	"quota-manager/internal/response"
+	"quota-manager/internal/services"
+)
+
+// retryStrategyExecutions retries the failed executions of a strategy through the API
+func retryStrategyExecutions(apiCtx *APITestContext, strategyID int) (*httptest.ResponseRecorder, services.ExecutionRetryResult) {
+	req, _ := http.NewRequest("POST", fmt.Sprintf("/quota-manager/api/v1/strategies/%d/executions/retry", strategyID), nil)
+	w := httptest.NewRecorder()
+	apiCtx.Router.ServeHTTP(w, req)
+
+	var resp struct {
+		response.ResponseData
+		Data services.ExecutionRetryResult `json:"data"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	return w, resp.Data
+}
+
+// testStrategyExecutionRetry tests that failed and interrupted executions are reconciled without double grants
+func testStrategyExecutionRetry(ctx *TestContext) TestResult {
+	cleanupMockQuotaStore(ctx)
+	apiCtx := setupAPITestContext(ctx)
+
+	schedulerConfig := &ctx.QuotaService.GetConfigManager().GetDirect().Scheduler
+	originalMaxAttempts := schedulerConfig.ExecutionMaxAttempts
+	schedulerConfig.ExecutionMaxAttempts = 2
+	defer func() { schedulerConfig.ExecutionMaxAttempts = originalMaxAttempts }()
+
+	users := make([]models.UserInfo, 3)
+	for i := range users {
+		users[i] = *createTestUser(fmt.Sprintf("execution_retry_user_%d", i), "Execution Retry User", 0)
+		if err := ctx.DB.AuthDB.Create(&users[i]).Error; err != nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Create user failed: %v", err)}
+		}
+	}
+
+	strategy := &models.QuotaStrategy{
+		Name:      "execution-retry-strategy",
+		Title:     "Execution Retry Strategy",
+		Type:      "single",
+		Amount:    10,
+		Condition: "true()",
+		Status:    true,
+	}
+	if err := ctx.StrategyService.CreateStrategy(strategy); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Create strategy failed: %v", err)}
+	}
+
+	// A failed recharge leaves a failed execution waiting for its backoff
+	restoreFunc := ctx.UseFailServer()
+	ctx.StrategyService.ExecStrategy(strategy, users[:1])
+	restoreFunc()
+
+	var execute models.QuotaExecute
+	if err := ctx.DB.Where("strategy_id = ? AND user_id = ?", strategy.ID, users[0].ID).First(&execute).Error; err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Query execution failed: %v", err)}
+	}
+	if execute.Status != models.ExecuteStatusFailed || execute.Attempts != 1 || execute.NextRetryTime == nil || execute.LastError == "" {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Unexpected failed execution: %+v", execute)}
+	}
+
+	// A manual retry ignores the backoff
+	w, result := retryStrategyExecutions(apiCtx, strategy.ID)
+	if w.Code != http.StatusOK || result.Checked != 1 || result.Succeeded != 1 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Unexpected retry result, status %d: %s", w.Code, w.Body.String())}
+	}
+	ctx.DB.First(&execute, execute.ID)
+	if execute.Status != models.ExecuteStatusCompleted || execute.Attempts != 2 || execute.NextRetryTime != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Unexpected retried execution: %+v", execute)}
+	}
+	if err := verifyUserQuotaAmountByStatus(ctx, users[0].ID, models.StatusValid, 10); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Quota verification failed: %v", err)}
+	}
+	if err := verifyMockQuotaStoreTotalQuota(ctx, users[0].ID, 10); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("AiGateway quota verification failed: %v", err)}
+	}
+
+	// An execution interrupted after its recharge committed is recovered without a second grant
+	ctx.StrategyService.ExecStrategy(strategy, users[1:2])
+	if err := ctx.DB.Model(&models.QuotaExecute{}).
+		Where("strategy_id = ? AND user_id = ?", strategy.ID, users[1].ID).
+		UpdateColumns(map[string]interface{}{
+			"status":      models.ExecuteStatusProcessing,
+			"update_time": time.Now().Add(-time.Hour),
+		}).Error; err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Mark execution stale failed: %v", err)}
+	}
+
+	w, result = retryStrategyExecutions(apiCtx, strategy.ID)
+	if w.Code != http.StatusOK || result.Checked != 1 || result.Recovered != 1 || result.Retried != 0 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Unexpected recovery result, status %d: %s", w.Code, w.Body.String())}
+	}
+	if err := verifyUserQuotaAmountByStatus(ctx, users[1].ID, models.StatusValid, 10); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Recovered quota verification failed: %v", err)}
+	}
+	if err := verifyMockQuotaStoreTotalQuota(ctx, users[1].ID, 10); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Recovered AiGateway quota verification failed: %v", err)}
+	}
+
+	// Executions are given up after the maximum number of attempts
+	restoreFunc = ctx.UseFailServer()
+	ctx.StrategyService.ExecStrategy(strategy, users[2:])
+	w, result = retryStrategyExecutions(apiCtx, strategy.ID)
+	restoreFunc()
+	if w.Code != http.StatusOK || result.Checked != 1 || result.Exhausted != 1 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Unexpected exhausted result, status %d: %s", w.Code, w.Body.String())}
+	}
+
+	w, result = retryStrategyExecutions(apiCtx, strategy.ID)
+	if w.Code != http.StatusOK || result.Checked != 0 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Exhausted execution should not be retried, status %d: %s", w.Code, w.Body.String())}
+	}
+	if err := verifyUserQuotaAmountByStatus(ctx, users[2].ID, models.StatusValid, 0); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Exhausted quota verification failed: %v", err)}
+	}
+
+	w, _ = retryStrategyExecutions(apiCtx, 999999)
+	if w.Code != http.StatusNotFound {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected 404 for unknown strategy, got %d", w.Code)}
+	}
+
+	return TestResult{Passed: true, Message: "Strategy Execution Retry Test Succeeded"}
+}
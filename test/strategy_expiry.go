@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"quota-manager/internal/models"
+	"quota-manager/internal/utils"
+	"quota-manager/internal/validation"
+)
+
+// testStrategyExpiryPolicy tests that strategies grant quota with the expiry date of their expiry policy
+func testStrategyExpiryPolicy(ctx *TestContext) TestResult {
+	fixedDate := time.Now().Truncate(time.Second).Add(45 * 24 * time.Hour)
+
+	testCases := []struct {
+		name     string
+		strategy models.QuotaStrategy
+		expected func(now time.Time) time.Time
+	}{
+		{
+			name:     "end-of-month",
+			strategy: models.QuotaStrategy{ExpiryPolicy: models.ExpiryPolicyEndOfMonth},
+			expected: func(now time.Time) time.Time {
+				return time.Date(now.Year(), now.Month()+1, 0, 23, 59, 59, 0, now.Location())
+			},
+		},
+		{
+			name:     "end-of-next-month",
+			strategy: models.QuotaStrategy{ExpiryPolicy: models.ExpiryPolicyEndOfNextMonth},
+			expected: func(now time.Time) time.Time {
+				return time.Date(now.Year(), now.Month()+2, 0, 23, 59, 59, 0, now.Location())
+			},
+		},
+		{
+			name:     "relative",
+			strategy: models.QuotaStrategy{ExpiryPolicy: models.ExpiryPolicyRelative, ExpiryDays: 90, ExpiryHours: 12},
+			expected: func(now time.Time) time.Time {
+				return now.Add(90*24*time.Hour + 12*time.Hour)
+			},
+		},
+		{
+			name:     "fixed-date",
+			strategy: models.QuotaStrategy{ExpiryPolicy: models.ExpiryPolicyFixedDate, FixedExpiryDate: &fixedDate},
+			expected: func(now time.Time) time.Time { return fixedDate },
+		},
+		{
+			name:     "never",
+			strategy: models.QuotaStrategy{ExpiryPolicy: models.ExpiryPolicyNever},
+			expected: func(now time.Time) time.Time { return models.NeverExpiryDate },
+		},
+	}
+
+	for _, tc := range testCases {
+		user := createTestUser("expiry_policy_"+tc.name, "Expiry Policy User", 0)
+		if err := ctx.DB.AuthDB.Create(user).Error; err != nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Create user failed: %v", err)}
+		}
+
+		strategy := tc.strategy
+		strategy.Name = "expiry-policy-" + tc.name
+		strategy.Title = "Expiry Policy " + tc.name
+		strategy.Type = "single"
+		strategy.Amount = 10
+		strategy.Condition = "true()"
+		strategy.Status = true
+		if err := ctx.StrategyService.CreateStrategy(&strategy); err != nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Create %s strategy failed: %v", tc.name, err)}
+		}
+
+		now := utils.NowInConfigTimezone(ctx.QuotaService.GetConfigManager().GetDirect()).Truncate(time.Second)
+		ctx.StrategyService.ExecStrategy(&strategy, []models.UserInfo{*user})
+
+		var quota models.Quota
+		if err := ctx.DB.Where("user_id = ?", user.ID).First(&quota).Error; err != nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Get %s quota failed: %v", tc.name, err)}
+		}
+
+		// Relative expiry depends on the execution time, allow a few seconds of drift
+		expected := tc.expected(now)
+		if diff := quota.ExpiryDate.Sub(expected); diff < 0 || diff > 5*time.Second {
+			return TestResult{Passed: false, Message: fmt.Sprintf("%s: expected expiry %v, got %v", tc.name, expected, quota.ExpiryDate)}
+		}
+	}
+
+	// The default policy is end_of_month
+	defaultStrategy := &models.QuotaStrategy{
+		Name:      "expiry-policy-default",
+		Title:     "Expiry Policy Default",
+		Type:      "single",
+		Amount:    10,
+		Condition: "true()",
+		Status:    true,
+	}
+	if err := ctx.StrategyService.CreateStrategy(defaultStrategy); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Create default strategy failed: %v", err)}
+	}
+	if defaultStrategy.ExpiryPolicy != models.ExpiryPolicyEndOfMonth {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected default expiry policy end_of_month, got %q", defaultStrategy.ExpiryPolicy)}
+	}
+
+	// Invalid policies are rejected by the schema and by the policy settings check
+	invalidPolicy := models.QuotaStrategy{Name: "invalid", Title: "Invalid", Type: "single", ExpiryPolicy: "end_of_year"}
+	if err := validation.ValidateStruct(&invalidPolicy); err == nil {
+		return TestResult{Passed: false, Message: "Unknown expiry policy should fail schema validation"}
+	}
+
+	pastDate := time.Now().Add(-time.Hour)
+	for _, invalid := range []models.QuotaStrategy{
+		{Name: "invalid-relative", Title: "Invalid", Type: "single", ExpiryPolicy: models.ExpiryPolicyRelative},
+		{Name: "invalid-fixed", Title: "Invalid", Type: "single", ExpiryPolicy: models.ExpiryPolicyFixedDate},
+		{Name: "invalid-fixed-past", Title: "Invalid", Type: "single", ExpiryPolicy: models.ExpiryPolicyFixedDate, FixedExpiryDate: &pastDate},
+	} {
+		invalid := invalid
+		if err := ctx.StrategyService.CreateStrategy(&invalid); err == nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Strategy %s should be rejected", invalid.Name)}
+		}
+	}
+
+	// Switching to relative without a lifetime is rejected on update
+	if err := ctx.StrategyService.UpdateStrategy(defaultStrategy.ID, map[string]interface{}{"expiry_policy": models.ExpiryPolicyRelative}); err == nil {
+		return TestResult{Passed: false, Message: "Update to relative policy without expiry_days should be rejected"}
+	}
+	if err := ctx.StrategyService.UpdateStrategy(defaultStrategy.ID, map[string]interface{}{
+		"expiry_policy": models.ExpiryPolicyRelative,
+		"expiry_days":   7,
+	}); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Update to relative policy failed: %v", err)}
+	}
+
+	// A fixed-date strategy whose date has passed can still be disabled and edited
+	passing := &models.QuotaStrategy{
+		Name:            "expiry-policy-fixed-passed",
+		Title:           "Expiry Policy Fixed Passed",
+		Type:            "single",
+		Amount:          10,
+		Condition:       "true()",
+		Status:          true,
+		ExpiryPolicy:    models.ExpiryPolicyFixedDate,
+		FixedExpiryDate: &fixedDate,
+	}
+	if err := ctx.StrategyService.CreateStrategy(passing); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Create fixed-date strategy failed: %v", err)}
+	}
+	if err := ctx.DB.Model(passing).UpdateColumn("fixed_expiry_date", pastDate).Error; err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Backdate fixed expiry date failed: %v", err)}
+	}
+	if err := ctx.StrategyService.DisableStrategy(passing.ID, "tester"); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Disable strategy with a passed fixed date failed: %v", err)}
+	}
+	if err := ctx.StrategyService.UpdateStrategy(passing.ID, map[string]interface{}{"title": "Expiry Policy Fixed Passed Renamed"}); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Edit strategy with a passed fixed date failed: %v", err)}
+	}
+	if err := ctx.StrategyService.UpdateStrategy(passing.ID, map[string]interface{}{"fixed_expiry_date": pastDate.Add(-time.Hour)}); err == nil {
+		return TestResult{Passed: false, Message: "Changing the fixed expiry date to a passed date should be rejected"}
+	}
+
+	return TestResult{Passed: true, Message: "Strategy Expiry Policy Test Succeeded"}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"quota-manager/internal/models"
+)
+
+// testStrategyMisfire tests that periodic runs missed while the service was down are caught up by misfire policy
+func testStrategyMisfire(ctx *TestContext) TestResult {
+	cleanupMockQuotaStore(ctx)
+
+	users := map[string]*models.UserInfo{
+		models.MisfirePolicySkip:    createTestUser("misfire_skip", "Misfire Skip", 0),
+		models.MisfirePolicyRunOnce: createTestUser("misfire_run_once", "Misfire Run Once", 0),
+		models.MisfirePolicyRunAll:  createTestUser("misfire_run_all", "Misfire Run All", 0),
+	}
+	// Daily at midnight, three fire times were missed since the strategies were created
+	created := time.Now().Add(-72 * time.Hour)
+	for policy, user := range users {
+		if err := ctx.DB.AuthDB.Create(user).Error; err != nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Create user failed: %v", err)}
+		}
+		strategy := &models.QuotaStrategy{
+			Name:          "misfire-" + policy,
+			Title:         "Misfire " + policy,
+			Type:          "periodic",
+			Amount:        10,
+			PeriodicExpr:  "0 0 0 * * *",
+			Condition:     fmt.Sprintf(`match-user("%s")`, user.ID),
+			MisfirePolicy: policy,
+			Status:        true,
+		}
+		if err := ctx.StrategyService.CreateStrategy(strategy); err != nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Create strategy failed: %v", err)}
+		}
+		defer ctx.StrategyService.DeleteStrategy(strategy.ID)
+		if err := ctx.DB.Model(strategy).Update("create_time", created).Error; err != nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Backdate strategy failed: %v", err)}
+		}
+	}
+
+	ctx.StrategyService.CatchUpMisfiredStrategies(time.Now())
+
+	expected := map[string]float64{
+		models.MisfirePolicySkip:    0,
+		models.MisfirePolicyRunOnce: 10,
+		models.MisfirePolicyRunAll:  30,
+	}
+	for policy, amount := range expected {
+		if err := verifyUserQuotaAmountByStatus(ctx, users[policy].ID, models.StatusValid, amount); err != nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Unexpected catch-up for %s: %v", policy, err)}
+		}
+	}
+
+	var run models.StrategyRun
+	if err := ctx.DB.Joins("JOIN quota_strategy ON quota_strategy.id = strategy_run.strategy_id").
+		Where("quota_strategy.name = ?", "misfire-"+models.MisfirePolicyRunOnce).
+		First(&run).Error; err != nil || run.Trigger != models.StrategyRunTriggerMisfire {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected a misfire run: %+v, %v", run, err)}
+	}
+
+	// Each missed run of run_all is recorded as its own run
+	var batches []string
+	if err := ctx.DB.Model(&models.StrategyRun{}).
+		Joins("JOIN quota_strategy ON quota_strategy.id = strategy_run.strategy_id").
+		Where("quota_strategy.name = ?", "misfire-"+models.MisfirePolicyRunAll).
+		Distinct().Pluck("strategy_run.batch_number", &batches).Error; err != nil || len(batches) != 3 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected 3 run_all catch-up runs, got %v: %v", batches, err)}
+	}
+
+	// Runs since the last completed run are not missed, a second catch-up grants nothing
+	ctx.StrategyService.CatchUpMisfiredStrategies(time.Now())
+	if err := verifyUserQuotaAmountByStatus(ctx, users[models.MisfirePolicyRunAll].ID, models.StatusValid, 30); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Second catch-up should grant nothing: %v", err)}
+	}
+
+	return TestResult{Passed: true, Message: "Strategy Misfire Test Succeeded"}
+}
+
+// testStrategyMisfireBounds tests that catch-up skips fire times while a strategy was disabled and
+// runs the latest missed fire times when more were missed than are caught up
+func testStrategyMisfireBounds(ctx *TestContext) TestResult {
+	cleanupMockQuotaStore(ctx)
+
+	reenabledUser := createTestUser("misfire_reenabled", "Misfire Reenabled", 0)
+	backlogUser := createTestUser("misfire_backlog", "Misfire Backlog", 0)
+	for _, user := range []*models.UserInfo{reenabledUser, backlogUser} {
+		if err := ctx.DB.AuthDB.Create(user).Error; err != nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Create user failed: %v", err)}
+		}
+	}
+
+	// Daily at midnight, created ten days ago but only enabled again two days ago
+	reenabled := &models.QuotaStrategy{
+		Name:          "misfire-reenabled",
+		Title:         "Misfire Reenabled",
+		Type:          "periodic",
+		Amount:        10,
+		PeriodicExpr:  "0 0 0 * * *",
+		Condition:     fmt.Sprintf(`match-user("%s")`, reenabledUser.ID),
+		MisfirePolicy: models.MisfirePolicyRunAll,
+		Status:        true,
+	}
+	// Hourly, created long enough ago to miss more runs than are caught up
+	backlog := &models.QuotaStrategy{
+		Name:          "misfire-backlog",
+		Title:         "Misfire Backlog",
+		Type:          "periodic",
+		Amount:        1,
+		PeriodicExpr:  "0 0 * * * *",
+		Condition:     fmt.Sprintf(`match-user("%s")`, backlogUser.ID),
+		MisfirePolicy: models.MisfirePolicyRunAll,
+		Status:        true,
+	}
+	for strategy, created := range map[*models.QuotaStrategy]time.Time{
+		reenabled: time.Now().Add(-10 * 24 * time.Hour),
+		backlog:   time.Now().Add(-200 * time.Hour),
+	} {
+		if err := ctx.StrategyService.CreateStrategy(strategy); err != nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Create strategy failed: %v", err)}
+		}
+		defer ctx.StrategyService.DeleteStrategy(strategy.ID)
+		if err := ctx.DB.Model(strategy).Update("create_time", created).Error; err != nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Backdate strategy failed: %v", err)}
+		}
+	}
+
+	if err := ctx.StrategyService.DisableStrategy(reenabled.ID, "tester"); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Disable strategy failed: %v", err)}
+	}
+	if err := ctx.StrategyService.EnableStrategy(reenabled.ID, "tester"); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Enable strategy failed: %v", err)}
+	}
+	if err := ctx.DB.Model(&models.StrategyVersion{}).
+		Where("strategy_id = ? AND version = (SELECT MAX(version) FROM strategy_version WHERE strategy_id = ?)", reenabled.ID, reenabled.ID).
+		UpdateColumn("create_time", time.Now().Add(-48*time.Hour)).Error; err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Backdate enabling version failed: %v", err)}
+	}
+
+	ctx.StrategyService.CatchUpMisfiredStrategies(time.Now())
+
+	// Only the two fire times since the strategy was enabled again were missed
+	if err := verifyUserQuotaAmountByStatus(ctx, reenabledUser.ID, models.StatusValid, 20); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Disabled period should not be caught up: %v", err)}
+	}
+
+	// The latest 100 of the 200 missed hours are caught up, up to the last full hour
+	var batches []string
+	if err := ctx.DB.Model(&models.StrategyRun{}).Where("strategy_id = ?", backlog.ID).
+		Order("batch_number").Pluck("batch_number", &batches).Error; err != nil || len(batches) != 100 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected 100 catch-up runs, got %d: %v", len(batches), err)}
+	}
+	latest, err := time.ParseInLocation("20060102150405", batches[len(batches)-1], time.Local)
+	if err != nil || time.Since(latest) > time.Hour {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Latest catch-up run should be the last missed hour, got %s: %v", batches[len(batches)-1], err)}
+	}
+	if err := verifyUserQuotaAmountByStatus(ctx, backlogUser.ID, models.StatusValid, 100); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Unexpected backlog catch-up: %v", err)}
+	}
+
+	return TestResult{Passed: true, Message: "Strategy Misfire Bounds Test Succeeded"}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"quota-manager/internal/models"
+	"quota-manager/internal/response"
+	"quota-manager/internal/services"
+)
+
+// postStrategyPreview sends a strategy preview request
+func postStrategyPreview(apiCtx *APITestContext, body map[string]interface{}) (*httptest.ResponseRecorder, *services.StrategyPreviewResult) {
+	jsonData, _ := json.Marshal(body)
+	req, _ := http.NewRequest("POST", "/quota-manager/api/v1/strategies/preview", bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	apiCtx.Router.ServeHTTP(w, req)
+
+	var resp struct {
+		response.ResponseData
+		Data *services.StrategyPreviewResult `json:"data"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	return w, resp.Data
+}
+
+// testStrategyPreview tests dry runs of strategy definitions and existing strategies
+func testStrategyPreview(ctx *TestContext) TestResult {
+	cleanupMockQuotaStore(ctx)
+	apiCtx := setupAPITestContext(ctx)
+
+	users := make([]*models.UserInfo, 3)
+	for i := range users {
+		users[i] = createTestUser(fmt.Sprintf("preview_user_%d", i), "Preview User", 0)
+		if err := ctx.DB.AuthDB.Create(users[i]).Error; err != nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Create user failed: %v", err)}
+		}
+	}
+	matchCondition := fmt.Sprintf(`match-user("%s", "%s")`, users[0].ID, users[1].ID)
+
+	// Previewing a definition reports the matched users and the amount without granting anything
+	w, preview := postStrategyPreview(apiCtx, map[string]interface{}{
+		"strategy": map[string]interface{}{
+			"name":      "preview-definition",
+			"title":     "Preview Definition",
+			"type":      "single",
+			"amount":    25,
+			"condition": matchCondition,
+		},
+		"sample_size": 1,
+	})
+	if w.Code != http.StatusOK || preview == nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Preview failed with status %d: %s", w.Code, w.Body.String())}
+	}
+	if preview.MatchedUsers != 2 || preview.TotalAmount != 50 || len(preview.SampleUserIDs) != 1 || preview.ErrorCount != 0 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Unexpected definition preview: %+v", preview)}
+	}
+
+	var executeCount, quotaCount int64
+	ctx.DB.Model(&models.QuotaExecute{}).Where("user_id IN ?", []string{users[0].ID, users[1].ID}).Count(&executeCount)
+	ctx.DB.Model(&models.Quota{}).Where("user_id IN ?", []string{users[0].ID, users[1].ID}).Count(&quotaCount)
+	if executeCount != 0 || quotaCount != 0 {
+		return TestResult{Passed: false, Message: "Preview must not write execution or quota records"}
+	}
+
+	// Previewing an existing strategy skips the users it was already executed for
+	strategy := &models.QuotaStrategy{
+		Name:      "preview-existing",
+		Title:     "Preview Existing",
+		Type:      "single",
+		Amount:    25,
+		Condition: matchCondition,
+		Status:    true,
+	}
+	if err := ctx.StrategyService.CreateStrategy(strategy); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Create strategy failed: %v", err)}
+	}
+	ctx.StrategyService.ExecStrategy(strategy, []models.UserInfo{*users[0]})
+
+	w, preview = postStrategyPreview(apiCtx, map[string]interface{}{"strategy_id": strategy.ID})
+	if w.Code != http.StatusOK || preview == nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Existing preview failed with status %d: %s", w.Code, w.Body.String())}
+	}
+	if preview.StrategyID != strategy.ID || preview.MatchedUsers != 1 || preview.SkippedExecuted != 1 || preview.TotalAmount != 25 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Unexpected existing strategy preview: %+v", preview)}
+	}
+	if len(preview.SampleUserIDs) != 1 || preview.SampleUserIDs[0] != users[1].ID {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected sample %s, got %v", users[1].ID, preview.SampleUserIDs)}
+	}
+
+	// Evaluation errors are reported per user
+	restoreFunc := ctx.UseFailServer()
+	w, preview = postStrategyPreview(apiCtx, map[string]interface{}{
+		"strategy": map[string]interface{}{
+			"name":      "preview-errors",
+			"title":     "Preview Errors",
+			"type":      "single",
+			"amount":    10,
+			"condition": fmt.Sprintf(`or(match-user("%s"), quota-le("", 10))`, users[2].ID),
+		},
+	})
+	restoreFunc()
+	if w.Code != http.StatusOK || preview == nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Error preview failed with status %d: %s", w.Code, w.Body.String())}
+	}
+	if preview.MatchedUsers != 1 || preview.ErrorCount == 0 || len(preview.Errors) == 0 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected evaluation errors in preview: %+v", preview)}
+	}
+	for _, previewErr := range preview.Errors {
+		if previewErr.UserID == users[2].ID {
+			return TestResult{Passed: false, Message: "Matched user should not report an evaluation error"}
+		}
+	}
+
+	// Invalid requests are rejected
+	for name, body := range map[string]map[string]interface{}{
+		"no strategy":       {},
+		"both":              {"strategy_id": strategy.ID, "strategy": map[string]interface{}{"name": "x", "title": "x", "type": "single"}},
+		"invalid condition": {"strategy": map[string]interface{}{"name": "x", "title": "x", "type": "single", "condition": "unknown-fn()"}},
+	} {
+		if w, _ := postStrategyPreview(apiCtx, body); w.Code != http.StatusBadRequest {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Expected 400 for %s, got %d", name, w.Code)}
+		}
+	}
+	if w, _ := postStrategyPreview(apiCtx, map[string]interface{}{"strategy_id": 999999}); w.Code != http.StatusNotFound {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected 404 for unknown strategy, got %d", w.Code)}
+	}
+
+	return TestResult{Passed: true, Message: "Strategy Preview Test Succeeded"}
+}
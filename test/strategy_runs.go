@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"quota-manager/internal/models"
+	"quota-manager/internal/response"
+)
+
+// getStrategyRuns lists the runs of a strategy through the API
+func getStrategyRuns(apiCtx *APITestContext, strategyID int) (*httptest.ResponseRecorder, []models.StrategyRun, int64) {
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/quota-manager/api/v1/strategies/%d/runs", strategyID), nil)
+	w := httptest.NewRecorder()
+	apiCtx.Router.ServeHTTP(w, req)
+
+	var resp struct {
+		response.ResponseData
+		Data struct {
+			Total   int64                `json:"total"`
+			Records []models.StrategyRun `json:"records"`
+		} `json:"data"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	return w, resp.Data.Records, resp.Data.Total
+}
+
+// testStrategyRuns tests that strategy executions are recorded as runs with summary statistics
+func testStrategyRuns(ctx *TestContext) TestResult {
+	cleanupMockQuotaStore(ctx)
+	apiCtx := setupAPITestContext(ctx)
+
+	users := make([]models.UserInfo, 3)
+	for i := range users {
+		users[i] = *createTestUser(fmt.Sprintf("strategy_run_user_%d", i), "Strategy Run User", 0)
+		if err := ctx.DB.AuthDB.Create(&users[i]).Error; err != nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Create user failed: %v", err)}
+		}
+	}
+
+	// A manual execution is recorded as one run
+	single := &models.QuotaStrategy{
+		Name:      "strategy-run-single",
+		Title:     "Strategy Run Single",
+		Type:      "single",
+		Amount:    10,
+		Condition: fmt.Sprintf(`match-user("%s", "%s")`, users[0].ID, users[1].ID),
+		Status:    true,
+	}
+	if err := ctx.StrategyService.CreateStrategy(single); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Create strategy failed: %v", err)}
+	}
+	ctx.StrategyService.ExecStrategy(single, users)
+
+	w, runs, total := getStrategyRuns(apiCtx, single.ID)
+	if w.Code != http.StatusOK || total != 1 || len(runs) != 1 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected 1 run, got status %d: %s", w.Code, w.Body.String())}
+	}
+	run := runs[0]
+	if run.Trigger != models.StrategyRunTriggerManual || run.Status != models.StrategyRunStatusCompleted || run.EndTime == nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Unexpected run state: %+v", run)}
+	}
+	if run.UsersEvaluated != 3 || run.UsersMatched != 2 || run.UsersGranted != 2 || run.TotalAmount != 20 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Unexpected run statistics: %+v", run)}
+	}
+
+	// The run shares its batch number with the execution records
+	var executeCount int64
+	ctx.DB.Model(&models.QuotaExecute{}).Where("strategy_id = ? AND batch_number = ?", single.ID, run.BatchNumber).Count(&executeCount)
+	if executeCount != 2 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected 2 execution records in batch %s, got %d", run.BatchNumber, executeCount)}
+	}
+
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/quota-manager/api/v1/strategies/%d/runs/%s", single.ID, run.BatchNumber), nil)
+	w = httptest.NewRecorder()
+	apiCtx.Router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Get run failed with status %d: %s", w.Code, w.Body.String())}
+	}
+
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/quota-manager/api/v1/strategies/%d/runs/19700101000000", single.ID), nil)
+	w = httptest.NewRecorder()
+	apiCtx.Router.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected 404 for unknown batch, got %d", w.Code)}
+	}
+
+	// Skips, condition errors and recharge failures are counted
+	periodic := &models.QuotaStrategy{
+		Name:           "strategy-run-periodic",
+		Title:          "Strategy Run Periodic",
+		Type:           "periodic",
+		Amount:         5,
+		PeriodicExpr:   "0 0 0 1 1 *",
+		Condition:      fmt.Sprintf(`or(match-user("%s", "%s"), quota-le("", 10))`, users[0].ID, users[1].ID),
+		MaxExecPerUser: 1,
+		Status:         true,
+	}
+	if err := ctx.StrategyService.CreateStrategy(periodic); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Create periodic strategy failed: %v", err)}
+	}
+	defer ctx.StrategyService.DeleteStrategy(periodic.ID)
+
+	ctx.StrategyService.ExecStrategy(periodic, users[:1])
+	// Executions in the same second share a batch number, wait for the next one
+	time.Sleep(1100 * time.Millisecond)
+
+	restoreFunc := ctx.UseFailServer()
+	ctx.StrategyService.ExecStrategy(periodic, users)
+	restoreFunc()
+
+	w, runs, total = getStrategyRuns(apiCtx, periodic.ID)
+	if w.Code != http.StatusOK || total != 2 || len(runs) != 2 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected 2 periodic runs, got status %d: %s", w.Code, w.Body.String())}
+	}
+	latest := runs[0]
+	if latest.SkippedMaxExec != 1 || latest.UsersEvaluated != 2 || latest.UsersMatched != 1 ||
+		latest.RechargeFailures != 1 || latest.ConditionErrors != 1 || latest.UsersGranted != 0 || latest.TotalAmount != 0 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Unexpected failing run statistics: %+v", latest)}
+	}
+	if runs[1].UsersGranted != 1 || runs[1].TotalAmount != 5 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Unexpected first periodic run statistics: %+v", runs[1])}
+	}
+
+	return TestResult{Passed: true, Message: "Strategy Runs Test Succeeded"}
+}
+
+// testStrategyParallelExecution tests that a worker pool recharges every matching user exactly once
+func testStrategyParallelExecution(ctx *TestContext) TestResult {
+	cleanupMockQuotaStore(ctx)
+
+	schedulerConfig := &ctx.QuotaService.GetConfigManager().GetDirect().Scheduler
+	originalWorkers := schedulerConfig.StrategyWorkers
+	schedulerConfig.StrategyWorkers = 4
+	defer func() { schedulerConfig.StrategyWorkers = originalWorkers }()
+
+	users := make([]models.UserInfo, 20)
+	userIDs := make([]string, len(users))
+	for i := range users {
+		users[i] = *createTestUser(fmt.Sprintf("parallel_strategy_user_%d", i), "Parallel Strategy User", 0)
+		if err := ctx.DB.AuthDB.Create(&users[i]).Error; err != nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Create user failed: %v", err)}
+		}
+		userIDs[i] = users[i].ID
+	}
+
+	strategy := &models.QuotaStrategy{
+		Name:      "parallel-strategy",
+		Title:     "Parallel Strategy",
+		Type:      "single",
+		Amount:    3,
+		Condition: "true()",
+		Status:    true,
+	}
+	if err := ctx.StrategyService.CreateStrategy(strategy); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Create strategy failed: %v", err)}
+	}
+
+	ctx.StrategyService.ExecStrategy(strategy, users)
+
+	var runs []models.StrategyRun
+	if err := ctx.DB.Where("strategy_id = ?", strategy.ID).Find(&runs).Error; err != nil || len(runs) != 1 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected one strategy run: %v", err)}
+	}
+	if runs[0].UsersGranted != len(users) || runs[0].TotalAmount != float64(3*len(users)) || runs[0].UsersPerSecond <= 0 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Unexpected run statistics: %+v", runs[0])}
+	}
+
+	// A second execution finds every user already executed
+	ctx.StrategyService.ExecStrategy(strategy, users)
+
+	for _, userID := range userIDs {
+		if err := verifyUserQuotaAmountByStatus(ctx, userID, models.StatusValid, 3); err != nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Quota verification failed: %v", err)}
+		}
+		if err := verifyMockQuotaStoreTotalQuota(ctx, userID, 3); err != nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("AiGateway quota verification failed: %v", err)}
+		}
+	}
+
+	var executeCount int64
+	ctx.DB.Model(&models.QuotaExecute{}).Where("strategy_id = ? AND status = ?", strategy.ID, "completed").Count(&executeCount)
+	if executeCount != int64(len(users)) {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected %d completed executions, got %d", len(users), executeCount)}
+	}
+
+	return TestResult{Passed: true, Message: "Strategy Parallel Execution Test Succeeded"}
+}
+
+// testStrategyOverlappingRuns tests that overlapping executions of a single strategy grant each user once
+func testStrategyOverlappingRuns(ctx *TestContext) TestResult {
+	cleanupMockQuotaStore(ctx)
+
+	users := make([]models.UserInfo, 10)
+	for i := range users {
+		users[i] = *createTestUser(fmt.Sprintf("overlapping_strategy_user_%d", i), "Overlapping Strategy User", 0)
+		if err := ctx.DB.AuthDB.Create(&users[i]).Error; err != nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("Create user failed: %v", err)}
+		}
+	}
+
+	strategy := &models.QuotaStrategy{
+		Name:      "overlapping-strategy",
+		Title:     "Overlapping Strategy",
+		Type:      "single",
+		Amount:    5,
+		Condition: "true()",
+		Status:    true,
+	}
+	if err := ctx.StrategyService.CreateStrategy(strategy); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Create strategy failed: %v", err)}
+	}
+
+	// Runs started together, like a scan overlapping an event or a catch-up, all see no execution yet
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx.StrategyService.ExecStrategy(strategy, users)
+		}()
+	}
+	wg.Wait()
+
+	for _, user := range users {
+		var executeCount int64
+		ctx.DB.Model(&models.QuotaExecute{}).Where("strategy_id = ? AND user_id = ? AND status = ?", strategy.ID, user.ID, "completed").Count(&executeCount)
+		if executeCount != 1 {
+			return TestResult{Passed: false, Message: fmt.Sprintf("User %s expected 1 completed execution, got %d", user.ID, executeCount)}
+		}
+		if err := verifyMockQuotaStoreTotalQuota(ctx, user.ID, 5); err != nil {
+			return TestResult{Passed: false, Message: fmt.Sprintf("AiGateway quota verification failed: %v", err)}
+		}
+	}
+
+	return TestResult{Passed: true, Message: "Strategy Overlapping Runs Test Succeeded"}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"quota-manager/internal/models"
+	"quota-manager/internal/response"
+	"quota-manager/internal/services"
+)
+
+// getStrategyVersions lists the versions of a strategy through the API
+func getStrategyVersions(apiCtx *APITestContext, strategyID int) (*httptest.ResponseRecorder, []services.StrategyVersionRecord) {
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/quota-manager/api/v1/strategies/%d/versions", strategyID), nil)
+	w := httptest.NewRecorder()
+	apiCtx.Router.ServeHTTP(w, req)
+
+	var resp struct {
+		response.ResponseData
+		Data struct {
+			Total   int64                            `json:"total"`
+			Records []services.StrategyVersionRecord `json:"records"`
+		} `json:"data"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	return w, resp.Data.Records
+}
+
+// updateStrategyThroughAPI updates a strategy through the API
+func updateStrategyThroughAPI(apiCtx *APITestContext, strategyID int, updates map[string]interface{}) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(updates)
+	req, _ := http.NewRequest("PUT", fmt.Sprintf("/quota-manager/api/v1/strategies/%d", strategyID), bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	apiCtx.Router.ServeHTTP(w, req)
+	return w
+}
+
+// testStrategyVersions tests that strategy changes are versioned, diffed and rolled back
+func testStrategyVersions(ctx *TestContext) TestResult {
+	cleanupMockQuotaStore(ctx)
+	apiCtx := setupAPITestContext(ctx)
+
+	user := createTestUser("strategy_version_user", "Strategy Version User", 0)
+	if err := ctx.DB.AuthDB.Create(user).Error; err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Create user failed: %v", err)}
+	}
+
+	strategy := &models.QuotaStrategy{
+		Name:         "versioned-strategy",
+		Title:        "Versioned Strategy",
+		Type:         "periodic",
+		Amount:       10,
+		PeriodicExpr: "0 0 0 1 1 *",
+		Condition:    "true()",
+		Status:       true,
+		UpdatedBy:    "strategy-admin",
+	}
+	if err := ctx.StrategyService.CreateStrategy(strategy); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Create strategy failed: %v", err)}
+	}
+	defer ctx.StrategyService.DeleteStrategy(strategy.ID)
+
+	w, versions := getStrategyVersions(apiCtx, strategy.ID)
+	if w.Code != http.StatusOK || len(versions) != 1 || versions[0].Version != 1 ||
+		versions[0].Action != models.StrategyVersionActionCreate || versions[0].Author != "strategy-admin" {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Unexpected initial versions, status %d: %s", w.Code, w.Body.String())}
+	}
+
+	// Every change is a new version with its diff, unchanged updates are not
+	if w := updateStrategyThroughAPI(apiCtx, strategy.ID, map[string]interface{}{"amount": 20, "condition": `match-user("nobody")`}); w.Code != http.StatusOK {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Update strategy failed with status %d: %s", w.Code, w.Body.String())}
+	}
+	if w := updateStrategyThroughAPI(apiCtx, strategy.ID, map[string]interface{}{"amount": 20}); w.Code != http.StatusOK {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Unchanged update failed with status %d: %s", w.Code, w.Body.String())}
+	}
+	_, versions = getStrategyVersions(apiCtx, strategy.ID)
+	if len(versions) != 2 || versions[0].Version != 2 || len(versions[0].Changes) != 2 ||
+		versions[0].Changes[0].Field != "amount" || versions[0].Changes[1].Field != "condition" {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Unexpected versions after update: %+v", versions)}
+	}
+
+	// Executions and audit records reference the version that granted the quota
+	if err := ctx.StrategyService.UpdateStrategy(strategy.ID, map[string]interface{}{"condition": "true()"}); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Update condition failed: %v", err)}
+	}
+	current, err := ctx.StrategyService.GetStrategy(strategy.ID)
+	if err != nil || current.Version != 3 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected strategy version 3: %+v, %v", current, err)}
+	}
+	ctx.StrategyService.ExecStrategy(current, []models.UserInfo{*user})
+
+	var execute models.QuotaExecute
+	if err := ctx.DB.Where("strategy_id = ? AND user_id = ?", strategy.ID, user.ID).First(&execute).Error; err != nil || execute.StrategyVersion != 3 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected execution of version 3: %+v, %v", execute, err)}
+	}
+	var audit models.QuotaAudit
+	if err := ctx.DB.Where("user_id = ? AND strategy_id = ?", user.ID, strategy.ID).First(&audit).Error; err != nil || audit.StrategyVersion != 3 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected audit record of version 3: %+v, %v", audit, err)}
+	}
+
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/quota-manager/api/v1/strategies/%d/versions/diff?from=1&to=3", strategy.ID), nil)
+	w = httptest.NewRecorder()
+	apiCtx.Router.ServeHTTP(w, req)
+	var diffResp struct {
+		response.ResponseData
+		Data services.StrategyVersionDiff `json:"data"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &diffResp)
+	if w.Code != http.StatusOK || len(diffResp.Data.Changes) != 1 || diffResp.Data.Changes[0].Field != "amount" {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Unexpected diff, status %d: %s", w.Code, w.Body.String())}
+	}
+
+	// Rolling back restores the old definition as a new version
+	if w := updateStrategyThroughAPI(apiCtx, strategy.ID, map[string]interface{}{"periodic_expr": "0 0 0 1 2 *"}); w.Code != http.StatusOK {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Update periodic expression failed with status %d: %s", w.Code, w.Body.String())}
+	}
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/quota-manager/api/v1/strategies/%d/versions/1/rollback", strategy.ID), nil)
+	w = httptest.NewRecorder()
+	apiCtx.Router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Rollback failed with status %d: %s", w.Code, w.Body.String())}
+	}
+	current, err = ctx.StrategyService.GetStrategy(strategy.ID)
+	if err != nil || current.Version != 5 || current.Amount != 10 || current.PeriodicExpr != "0 0 0 1 1 *" || current.Condition != "true()" {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Unexpected strategy after rollback: %+v, %v", current, err)}
+	}
+	_, versions = getStrategyVersions(apiCtx, strategy.ID)
+	if len(versions) != 5 || versions[0].Action != models.StrategyVersionActionRollback ||
+		versions[0].SourceVersion == nil || *versions[0].SourceVersion != 1 {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Unexpected rollback version: %+v", versions[0])}
+	}
+
+	// Rolling back a disabled strategy keeps it disabled
+	if err := ctx.StrategyService.DisableStrategy(strategy.ID, "strategy-admin"); err != nil {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Disable strategy failed: %v", err)}
+	}
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/quota-manager/api/v1/strategies/%d/versions/4/rollback", strategy.ID), nil)
+	w = httptest.NewRecorder()
+	apiCtx.Router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Rollback of disabled strategy failed with status %d: %s", w.Code, w.Body.String())}
+	}
+	current, err = ctx.StrategyService.GetStrategy(strategy.ID)
+	if err != nil || current.Status || current.PeriodicExpr != "0 0 0 1 2 *" {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Rollback should restore the definition and keep the strategy disabled: %+v, %v", current, err)}
+	}
+
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/quota-manager/api/v1/strategies/%d/versions/99/rollback", strategy.ID), nil)
+	w = httptest.NewRecorder()
+	apiCtx.Router.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected 404 for unknown version, got %d", w.Code)}
+	}
+
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/quota-manager/api/v1/strategies/%d/versions/diff?from=1", strategy.ID), nil)
+	w = httptest.NewRecorder()
+	apiCtx.Router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		return TestResult{Passed: false, Message: fmt.Sprintf("Expected 400 for missing to version, got %d", w.Code)}
+	}
+
+	return TestResult{Passed: true, Message: "Strategy Versions Test Succeeded"}
+}
@@ -215,8 +215,8 @@ func testAPIValidationCreateStrategy(ctx *TestContext) TestResult {
 func testAPIValidationTransferOut(ctx *TestContext) TestResult {
 	apiCtx := setupAPITestContext(ctx)
 
-	// Test JWT token with valid user ID (complete JWT format: header.payload.signature)
-	testToken := "eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9.eyJ1bml2ZXJzYWxfaWQiOiJ1c2VyMDAxIiwibmFtZSI6IkpvaG4gRG9lIiwic3RhZmZJRCI6ImVtcDAwMSIsImdpdGh1YiI6ImpvaG5kb2UiLCJwaG9uZSI6IjEzODAwMTM4MDAxIn0.signature"
+	// Test JWT token with valid user ID signed with the test secret
+	testToken := createTestJWTToken(testUserClaims("user001"))
 
 	testCases := []struct {
 		name           string
@@ -350,6 +350,9 @@ func testAPIValidationTransferOut(ctx *TestContext) TestResult {
 func testAPIValidationUserID(ctx *TestContext) TestResult {
 	apiCtx := setupAPITestContext(ctx)
 
+	// Admin audit routes require the viewer role
+	adminToken := createTestJWTToken(testUserClaims(testAdminUserID))
+
 	testCases := []struct {
 		name           string
 		userID         string
@@ -384,6 +387,7 @@ func testAPIValidationUserID(ctx *TestContext) TestResult {
 		}
 
 		req, _ := http.NewRequest("GET", url, nil)
+		req.Header.Set("Authorization", "Bearer "+adminToken)
 		w := httptest.NewRecorder()
 
 		apiCtx.Router.ServeHTTP(w, req)